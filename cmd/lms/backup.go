@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"lms/internal/backup"
+	"lms/internal/database"
+	"path/filepath"
+)
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := fs.String("o", "", "write the backup to this file instead of the backup directory (gzip-compressed if it ends in .gz)")
+	cfg, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// An explicit file is written as is, without rotation.
+	if *output != "" {
+		if err := database.Backup(ctx, db, *output); err != nil {
+			return err
+		}
+		fmt.Printf("Backup written to %s.\n", *output)
+		return nil
+	}
+
+	dir := cfg.Backup.Dir
+	if dir == "" {
+		dir = "."
+	}
+	f, err := backup.NewManager(db, dir, cfg.Backup.Keep, cfg.Backup.Gzip).Create(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup written to %s.\n", filepath.Join(dir, f.Name))
+	return nil
+}
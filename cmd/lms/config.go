@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: lms config print [flags]")
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	// Never print secrets such as database passwords.
+	return cfg.Redacted().Write(os.Stdout)
+}
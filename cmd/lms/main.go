@@ -1,138 +1,141 @@
+// Command lms runs the LMS web server and its administrative tasks.
 package main
 
 import (
+	"bufio"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"lms/internal/config"
 	"lms/internal/database"
-	"lms/internal/handlers"
-	"lms/internal/middleware"
-	"log"
-	"time"
+	"lms/migrations"
+	"os"
+	"strings"
 
-	"net/http"
-
-	"github.com/alexedwards/scs/sqlite3store"
-	"github.com/alexedwards/scs/v2"
-	"github.com/go-chi/chi/v5"
-	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	// Embed the timezone database: the container image has none, and
+	// user profiles are validated against it.
+	_ "time/tzdata"
 )
 
-// Application struct holds the dependencies for the Application.
-type Application struct {
-	db             *sql.DB
-	sessionManager *scs.SessionManager
-	handlers       *handlers.Handlers
-	middleware     *middleware.Middleware
-}
+const usage = `Usage: lms <command> [arguments]
 
-func (app *Application) Routes() http.Handler {
-	mux := chi.NewRouter()
-
-	// Register global middleware
-	mux.Use(chiMiddleware.Logger)
-	mux.Use(chiMiddleware.Recoverer)
-	mux.Use(app.sessionManager.LoadAndSave)
-
-	// Public routes
-	mux.Group(func(r chi.Router) {
-		r.Get("/", app.handlers.Dashboard)
-		r.Get("/courses/{courseID}", app.handlers.ShowCourse)
-		r.Get("/lessons/{lessonID}", app.handlers.ShowLesson)
-		r.Get("/register", app.handlers.RegisterForm)
-		r.Post("/register", app.handlers.Register)
-		r.Get("/login", app.handlers.LoginForm)
-		r.Post("/login", app.handlers.Login)
-		r.Post("/logout", app.handlers.Logout)
-		r.Get("/certificates/{token}", app.handlers.ViewCertificate)
-
-		// Serve static files
-		fs := http.FileServer(http.Dir("./web/static/"))
-		r.Handle("/static/*", http.StripPrefix("/static/", fs))
-
-	})
-	// Protected routes for authenticated users
-	mux.Group(func(r chi.Router) {
-		r.Use(app.middleware.RequireAuthentication)
-
-		r.Post("/mcqs/{mcqID}/submit", app.handlers.SubmitMCQ)
-		r.Post("/lessons/{lessonID}/complete", app.handlers.MarkLessonComplete)
-	})
-
-	// Admin routes
-	mux.Route("/admin", func(r chi.Router) {
-		r.Use(app.middleware.RequireAuthentication)
-		r.Use(app.middleware.RequireAdmin)
-
-		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("Welcome to the Admin Dashboard"))
-		})
-		r.Get("/courses/new", app.handlers.CreateCourseForm)
-		r.Post("/courses/new", app.handlers.CreateCourse)
-		r.Get("/courses/{courseID}", app.handlers.ShowCourseAdmin)
-		r.Post("/courses/{courseID}/lessons", app.handlers.CreateLesson)
-		r.Get("/lessons/{lessonID}", app.handlers.ShowLessonAdmin)
-		r.Post("/lessons/{lessonID}/content", app.handlers.AddContent)
-		r.Get("/users", app.handlers.ListUsers)
-		r.Get("/users/{userID}", app.handlers.ShowUser)
-		r.Post("/users/{userID}/enroll", app.handlers.EnrollUser)
-		r.Post("/users/{userID}/courses/{courseID}/generate-certificate", app.handlers.GenerateCertificate)
-	})
-
-	return mux
-}
+Commands:
+  serve                    Run the web server
+  migrate up               Apply pending migrations
+  migrate down             Roll back migrations
+  migrate status           List migrations and whether they are applied
+  user create              Create a user (e.g. the first admin)
+  user set-password        Change a user's password
+  user reset-2fa           Turn off a user's two-factor authentication
+  backup                   Write a snapshot of the database
+  restore                  Replace the database with a backup (stop the server first)
+  config print             Print the effective configuration
+
+Every command accepts -config and the configuration flags listed by -h.
+Settings can also be given as LMS_* environment variables.
+
+Run "lms <command> -h" for the flags of a command.
+`
+
+// errUsage is returned by commands invoked with invalid arguments.
+var errUsage = errors.New("invalid arguments")
 
 func main() {
-	// Define the Data Source Name (DSN) for the SQLite database.
-	const dsn = "lms.db"
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 
-	// Establish a connection to the database.
-	db, err := database.NewDB(dsn)
-	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	case "user":
+		err = runUser(os.Args[2:])
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "lms: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
 	}
-	defer db.Close()
 
-	log.Println("Successfully connected to the database.")
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lms %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
 
-	// Apply database migrations.
-	if err := database.ApplyMigrations(db, "migrations"); err != nil {
-		log.Fatalf("failed to apply migrations: %v", err)
+// parseFlags registers the configuration flags on fs, parses args
+// and returns the resulting configuration.
+func parseFlags(fs *flag.FlagSet, args []string) (*config.Config, error) {
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "lms: unexpected argument %q\n", fs.Arg(0))
+		return nil, errUsage
 	}
+	return flags.Load()
+}
 
-	log.Println("Database migrations applied successfully.")
+// assetFS returns dir on disk for development, or the copy embedded in the binary when dir is empty.
+func assetFS(dir string, embedded fs.FS) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return os.DirFS(dir)
+}
 
-	// Initialize a new session manager and configure it.
-	sessionManager := scs.New()
-	sessionManager.Store = sqlite3store.New(db)
-	sessionManager.Lifetime = 24 * time.Hour
-	sessionManager.IdleTimeout = 20 * time.Minute
-	sessionManager.Cookie.Persist = true
-	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
-	sessionManager.Cookie.Secure = false
+// migrationsFor returns the migrations for the backend db is connected to,
+// read from dir when it is set.
+func migrationsFor(db *sql.DB, dir string) fs.FS {
+	embedded := migrations.SQLite()
+	if database.DialectOf(db) == database.Postgres {
+		embedded = migrations.Postgres()
+	}
+	return assetFS(dir, embedded)
+}
 
-	// Create a new Handlers struct, which now includes the template cache.
-	h, err := handlers.NewHandlers(db, sessionManager)
+// openDatabase connects to the configured database.
+func openDatabase(cfg *config.Config) (*sql.DB, error) {
+	db, err := database.NewDB(cfg.DSN, dbOptions(cfg))
 	if err != nil {
-		log.Fatalf("failed to create handlers: %v", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	return db, nil
+}
 
-	// Create a new Middleware struct.
-	mw := middleware.NewMiddleware(sessionManager)
-
-	// Create an instance of the application struct.
-	app := &Application{
-		db:             db,
-		sessionManager: sessionManager,
-		handlers:       h,
-		middleware:     mw,
+// dbOptions returns the connection pool settings of cfg.
+func dbOptions(cfg *config.Config) database.Options {
+	return database.Options{
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		BusyTimeout:     cfg.Database.BusyTimeout,
 	}
+}
 
-	// Set up the HTTP server.
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: app.Routes(), // Use the chi router
+// readPassword reads a password from standard input when it wasn't given as a flag.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
 	}
-
-	log.Printf("Starting server on %s", srv.Addr)
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	return strings.TrimRight(line, "\r\n"), nil
 }
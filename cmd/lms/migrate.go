@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/fs"
+	"lms/internal/database"
+	"os"
+	"text/tabwriter"
+)
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lms migrate up|down|status [flags]")
+		return errUsage
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	to := fs.Int64("to", -1, "roll back to this version (down only; default: the previous version)")
+	cfg, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dir := migrationsFor(db, cfg.Paths.Migrations)
+
+	switch action {
+	case "up":
+		if err := database.ApplyMigrations(db, dir); err != nil {
+			return err
+		}
+		fmt.Println("Database migrations applied successfully.")
+
+	case "down":
+		target := *to
+		if target < 0 {
+			// Default to undoing only the most recent migration.
+			target, err = previousVersion(db, dir)
+			if err != nil {
+				return err
+			}
+		}
+		if err := database.RollbackMigrations(db, dir, target); err != nil {
+			return err
+		}
+		fmt.Printf("Database rolled back to version %d.\n", target)
+
+	case "status":
+		statuses, err := database.GetMigrationStatus(db, dir)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			if s.Applied {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state = "modified"
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return tw.Flush()
+
+	default:
+		fmt.Fprintf(os.Stderr, "lms migrate: unknown action %q\n", action)
+		return errUsage
+	}
+
+	return nil
+}
+
+// previousVersion returns the version before the most recently applied migration.
+func previousVersion(db *sql.DB, dir fs.FS) (int64, error) {
+	statuses, err := database.GetMigrationStatus(db, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Statuses are sorted by version, so the last two applied ones are what we need.
+	var latest, previous int64
+	for _, s := range statuses {
+		if s.Applied {
+			previous, latest = latest, s.Version
+		}
+	}
+	return previous, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"lms/internal/database"
+	"lms/migrations"
+	"os"
+)
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	input := fs.String("i", "", "backup file to restore, optionally gzip-compressed")
+	cfg, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "lms restore: -i is required")
+		return errUsage
+	}
+
+	// The backup's schema is checked against the migrations of this binary.
+	kept, err := database.Restore(context.Background(), *input, cfg.DSN, assetFS(cfg.Paths.Migrations, migrations.SQLite()))
+	if err != nil {
+		return err
+	}
+
+	if kept != "" {
+		fmt.Printf("The previous database was saved as %s.\n", kept)
+	}
+	fmt.Printf("Restored %s from %s.\n", database.SQLitePath(cfg.DSN), *input)
+	return nil
+}
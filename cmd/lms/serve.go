@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"lms/internal/auth"
+	"lms/internal/backup"
+	"lms/internal/config"
+	"lms/internal/database"
+	"lms/internal/handlers"
+	"lms/internal/lifecycle"
+	"lms/internal/mail"
+	"lms/internal/middleware"
+	"lms/internal/oidc"
+	"lms/internal/rbac"
+	"lms/internal/throttle"
+	"lms/internal/webauthn"
+	"lms/web"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"net/http"
+
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Application struct holds the dependencies for the Application.
+type Application struct {
+	config         *config.Config
+	static         fs.FS
+	lifecycle      *lifecycle.Manager
+	db             *sql.DB
+	sessionManager *scs.SessionManager
+	handlers       *handlers.Handlers
+	middleware     *middleware.Middleware
+}
+
+func (app *Application) Routes() http.Handler {
+	root := chi.NewRouter()
+
+	// Register global middleware
+	if app.config.HTTP.BehindProxy {
+		root.Use(chiMiddleware.RealIP)
+	}
+	root.Use(chiMiddleware.Logger)
+	root.Use(chiMiddleware.Recoverer)
+
+	// The SCIM API is called by an identity platform with a bearer token,
+	// not by browsers, so it has neither sessions nor CSRF tokens.
+	if app.handlers.SCIMToken != "" {
+		root.Route("/scim/v2", func(r chi.Router) {
+			r.Use(app.handlers.RequireSCIMToken)
+			r.Get("/ServiceProviderConfig", app.handlers.SCIMServiceProviderConfig)
+			r.Get("/Users", app.handlers.ListSCIMUsers)
+			r.Post("/Users", app.handlers.CreateSCIMUser)
+			r.Get("/Users/{id}", app.handlers.GetSCIMUser)
+			r.Put("/Users/{id}", app.handlers.ReplaceSCIMUser)
+			r.Patch("/Users/{id}", app.handlers.PatchSCIMUser)
+			r.Delete("/Users/{id}", app.handlers.DeleteSCIMUser)
+			r.Get("/Groups", app.handlers.ListSCIMGroups)
+			r.Post("/Groups", app.handlers.CreateSCIMGroup)
+			r.Get("/Groups/{id}", app.handlers.GetSCIMGroup)
+			r.Put("/Groups/{id}", app.handlers.ReplaceSCIMGroup)
+			r.Patch("/Groups/{id}", app.handlers.PatchSCIMGroup)
+			r.Delete("/Groups/{id}", app.handlers.DeleteSCIMGroup)
+		})
+	}
+
+	// Everything else is used by browsers.
+	mux := chi.NewRouter()
+	mux.Use(app.middleware.LoadSession)
+	mux.Use(app.middleware.VerifyCSRF(http.HandlerFunc(app.handlers.CSRFFailure)))
+	mux.Use(app.middleware.BlockImpersonatedWrites)
+	mux.Use(app.middleware.TrackSession)
+
+	// Public routes
+	mux.Group(func(r chi.Router) {
+		r.Get("/", app.handlers.Dashboard)
+		r.Get("/courses/{courseID}", app.handlers.ShowCourse)
+		r.Get("/lessons/{lessonID}", app.handlers.ShowLesson)
+		r.Get("/register", app.handlers.RegisterForm)
+		r.Post("/register", app.handlers.Register)
+		r.Get("/login", app.handlers.LoginForm)
+		r.Post("/login", app.handlers.Login)
+		r.Get("/login/two-factor", app.handlers.LoginTwoFactorForm)
+		r.Post("/login/two-factor", app.handlers.LoginTwoFactor)
+		r.Post("/login/passkey", app.handlers.LoginPasskey)
+		r.Get("/login/sso", app.handlers.LoginSSO)
+		r.Get("/login/sso/callback", app.handlers.SSOCallback)
+		r.Get("/forgot-password", app.handlers.ForgotPasswordForm)
+		r.Post("/forgot-password", app.handlers.ForgotPassword)
+		r.Get("/reset-password", app.handlers.ResetPasswordForm)
+		r.Post("/reset-password", app.handlers.ResetPassword)
+		r.Get("/verify-email", app.handlers.VerifyEmail)
+		r.Post("/logout", app.handlers.Logout)
+		r.Get("/certificates/{token}", app.handlers.ViewCertificate)
+
+		// Serve static files
+		fs := http.FileServer(http.FS(app.static))
+		r.Handle("/static/*", http.StripPrefix("/static/", fs))
+
+	})
+	// Protected routes for authenticated users
+	mux.Group(func(r chi.Router) {
+		r.Use(app.middleware.RequireAuthentication)
+
+		r.Post("/mcqs/{mcqID}/submit", app.handlers.SubmitMCQ)
+		r.Post("/lessons/{lessonID}/complete", app.handlers.MarkLessonComplete)
+		r.Get("/profile", app.handlers.Profile)
+		r.Post("/profile", app.handlers.UpdateProfile)
+		r.Post("/profile/verify-email", app.handlers.ResendEmailVerification)
+		r.Post("/impersonation/stop", app.handlers.StopImpersonation)
+
+		// Logins are managed in a browser, not with an API token, and only
+		// by the user themselves, not an admin impersonating them.
+		r.Group(func(r chi.Router) {
+			r.Use(app.middleware.RequireSession)
+
+			r.Get("/profile/security", app.handlers.SecuritySettings)
+			r.Get("/profile/sessions", app.handlers.Sessions)
+			r.Post("/profile/sessions/revoke-all", app.handlers.RevokeAllSessions)
+			r.Post("/profile/sessions/{sessionID}/revoke", app.handlers.RevokeSession)
+			r.Post("/profile/security/passkeys", app.handlers.AddPasskey)
+			r.Post("/profile/security/passkeys/{passkeyID}/delete", app.handlers.DeletePasskey)
+			r.Post("/profile/security/sso", app.handlers.LinkSSO)
+			r.Post("/profile/security/sso/{identityID}/delete", app.handlers.UnlinkSSO)
+			r.Get("/profile/two-factor", app.handlers.TwoFactorSettings)
+			r.Post("/profile/two-factor/setup", app.handlers.SetUpTwoFactor)
+			r.Post("/profile/two-factor/confirm", app.handlers.ConfirmTwoFactor)
+			r.Post("/profile/two-factor/recovery-codes", app.handlers.RegenerateRecoveryCodes)
+			r.Post("/profile/two-factor/disable", app.handlers.DisableTwoFactor)
+			r.Get("/profile/api-tokens", app.handlers.APITokenSettings)
+			r.Post("/profile/api-tokens", app.handlers.CreateAPIToken)
+			r.Post("/profile/api-tokens/{tokenID}/delete", app.handlers.DeleteAPIToken)
+		})
+	})
+
+	// Admin routes
+	mux.Route("/admin", func(r chi.Router) {
+		r.Use(app.middleware.RequireAuthentication)
+
+		// Courses are managed by their staff as well as by admins.
+		r.Get("/courses", app.handlers.ListCourses)
+		r.With(app.middleware.RequirePermission(rbac.CourseCreate)).Get("/courses/new", app.handlers.CreateCourseForm)
+		r.With(app.middleware.RequirePermission(rbac.CourseCreate)).Post("/courses/new", app.handlers.CreateCourse)
+		r.With(app.middleware.RequireCoursePermission(rbac.CourseView)).Get("/courses/{courseID}", app.handlers.ShowCourseAdmin)
+		r.With(app.middleware.RequireCoursePermission(rbac.CourseEdit)).Post("/courses/{courseID}/lessons", app.handlers.CreateLesson)
+		r.With(app.middleware.RequireCoursePermission(rbac.EnrollmentManage)).Post("/courses/{courseID}/enrollments", app.handlers.EnrollInCourse)
+		r.With(app.middleware.RequireCoursePermission(rbac.CertificateIssue)).Post("/courses/{courseID}/students/{userID}/certificate", app.handlers.IssueCertificate)
+		r.With(app.middleware.RequireCoursePermission(rbac.StaffManage)).Post("/courses/{courseID}/staff", app.handlers.AddCourseStaff)
+		r.With(app.middleware.RequireCoursePermission(rbac.StaffManage)).Post("/courses/{courseID}/staff/{userID}/delete", app.handlers.RemoveCourseStaff)
+		r.With(app.middleware.RequireCoursePermission(rbac.CourseView)).Get("/lessons/{lessonID}", app.handlers.ShowLessonAdmin)
+		r.With(app.middleware.RequireCoursePermission(rbac.CourseEdit)).Post("/lessons/{lessonID}/content", app.handlers.AddContent)
+
+		// Everything else is for admins only.
+		r.Group(func(r chi.Router) {
+			r.Use(app.middleware.RequireAdmin)
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Welcome to the Admin Dashboard"))
+			})
+			r.Get("/users", app.handlers.ListUsers)
+			r.Get("/users/new", app.handlers.CreateUserForm)
+			r.Post("/users/new", app.handlers.CreateUser)
+			r.Get("/users/{userID}", app.handlers.ShowUser)
+			r.Post("/users/{userID}", app.handlers.UpdateUser)
+			r.Post("/users/{userID}/deactivate", app.handlers.DeactivateUser)
+			r.Post("/users/{userID}/reactivate", app.handlers.ReactivateUser)
+			r.Get("/users/{userID}/delete", app.handlers.DeleteUserForm)
+			r.Post("/users/{userID}/delete", app.handlers.DeleteUser)
+			r.Post("/users/{userID}/sessions/end", app.handlers.EndUserSessions)
+			r.With(app.middleware.RequireSession).Post("/users/{userID}/impersonate", app.handlers.StartImpersonation)
+			r.Post("/users/{userID}/enroll", app.handlers.EnrollUser)
+			r.Post("/users/{userID}/unlock", app.handlers.UnlockUser)
+			r.Post("/users/{userID}/password-reset", app.handlers.AdminSendPasswordReset)
+			r.Post("/users/{userID}/two-factor/reset", app.handlers.AdminResetTwoFactor)
+			r.Post("/users/{userID}/api-tokens/{tokenID}/delete", app.handlers.AdminDeleteAPIToken)
+			r.Post("/users/{userID}/courses/{courseID}/generate-certificate", app.handlers.GenerateCertificate)
+			r.Get("/groups", app.handlers.ListGroups)
+			r.Get("/groups/{groupID}", app.handlers.ShowGroup)
+			r.Post("/groups/{groupID}/courses", app.handlers.AddGroupCourse)
+			r.Post("/groups/{groupID}/courses/{courseID}/delete", app.handlers.RemoveGroupCourse)
+			r.Get("/invitations", app.handlers.ListInvitations)
+			r.Post("/invitations", app.handlers.CreateInvitations)
+			r.Post("/invitations/{invitationID}/delete", app.handlers.RevokeInvitation)
+			r.Get("/audit", app.handlers.AuditLog)
+			r.Get("/backups", app.handlers.ListBackups)
+			r.Post("/backups", app.handlers.CreateBackup)
+			r.Post("/backups/snapshot", app.handlers.DownloadSnapshot)
+			r.Get("/backups/{name}", app.handlers.DownloadBackup)
+		})
+	})
+
+	root.Mount("/", mux)
+	return root
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// Establish a connection to the database.
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	// The database is left open if background workers are still using
+	// it after shutdown; the process is about to exit anyway.
+	workersRunning := false
+	defer func() {
+		if !workersRunning {
+			db.Close()
+		}
+	}()
+
+	// SQLite allows a single writer, so the store sends its writes
+	// through a pool with one connection.
+	var writer *sql.DB
+	if database.DialectOf(db) == database.SQLite {
+		writer, err = database.NewWriteDB(cfg.DSN, dbOptions(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer func() {
+			if !workersRunning {
+				writer.Close()
+			}
+		}()
+	}
+
+	log.Println("Successfully connected to the database.")
+
+	// Apply database migrations.
+	if err := database.ApplyMigrations(db, migrationsFor(db, cfg.Paths.Migrations)); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	log.Println("Database migrations applied successfully.")
+
+	// Initialize a new session manager and configure it. Its store is set
+	// below, with the other background workers.
+	sessionManager := scs.New()
+	sessionManager.Lifetime = cfg.Session.Lifetime
+	sessionManager.IdleTimeout = cfg.Session.IdleTimeout
+	sessionManager.Cookie.Persist = true
+	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
+	sessionManager.Cookie.Secure = cfg.Session.CookieSecure
+
+	// Create a new Handlers struct, which now includes the template cache.
+	store := database.NewSQLStore(db, writer)
+	h, err := handlers.NewHandlers(store, sessionManager, assetFS(cfg.Paths.Templates, web.Templates()))
+	if err != nil {
+		return fmt.Errorf("failed to create handlers: %w", err)
+	}
+
+	// Lock out usernames and IPs that keep failing to log in.
+	h.Throttle = throttle.NewLimiter(store, throttle.Policy{
+		MaxFailures:      cfg.Login.MaxFailures,
+		MaxFailuresPerIP: cfg.Login.MaxFailuresPerIP,
+		LockoutBase:      cfg.Login.LockoutBase,
+		LockoutMax:       cfg.Login.LockoutMax,
+	})
+
+	// Send password reset and email verification links with the configured
+	// mailer.
+	h.Mailer = newMailer(cfg)
+	h.PublicURL = strings.TrimSuffix(cfg.HTTP.PublicURL, "/")
+	h.SCIMToken = cfg.SCIM.Token
+	h.SCIMManageAdmins = cfg.SCIM.ManageAdmins
+	h.ResetTokenTTL = cfg.Login.ResetTokenLifetime
+	h.VerifyTokenTTL = cfg.Login.VerifyTokenLifetime
+	// Registration is limited by registration.mode, and invitations let
+	// people register anyway unless it is closed.
+	h.RegistrationMode = cfg.Registration.Mode
+	for _, d := range strings.Split(cfg.Registration.AllowedDomains, ",") {
+		if d = strings.TrimPrefix(strings.TrimSpace(d), "@"); d != "" {
+			h.AllowedDomains = append(h.AllowedDomains, d)
+		}
+	}
+	h.InvitationTTL = cfg.Registration.InvitationLifetime
+	// Passkeys are bound to the host name of the public URL, and only work
+	// on pages served from it.
+	h.WebAuthn, err = webauthn.NewRelyingParty("LMS", h.PublicURL)
+	if err != nil {
+		return err
+	}
+	// Users may log in with the OpenID Connect provider, if one is set.
+	if cfg.OIDC.Issuer != "" {
+		h.SSO = &handlers.SingleSignOn{
+			Provider: oidc.NewProvider(cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret,
+				h.PublicURL+"/login/sso/callback", strings.Fields(cfg.OIDC.Scopes)),
+			Name:          cfg.OIDC.Name,
+			UsernameClaim: cfg.OIDC.UsernameClaim,
+			LinkExisting:  cfg.OIDC.LinkExisting,
+			TrustAMR:      cfg.OIDC.TrustAMR,
+			RoleClaim:     cfg.OIDC.RoleClaim,
+		}
+		for _, v := range strings.Split(cfg.OIDC.AdminValues, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				h.SSO.AdminValues = append(h.SSO.AdminValues, v)
+			}
+		}
+	}
+	// Passwords are checked against the LDAP directory, if one is set, and
+	// then against the local accounts ldap.fallback allows.
+	var dir *auth.Directory
+	if cfg.LDAP.URL != "" {
+		dir, err = newDirectory(cfg, store)
+		if err != nil {
+			return err
+		}
+		chain := auth.Chain{dir}
+		if local := dir.LocalFallback(cfg.LDAP.Fallback); local != nil {
+			chain = append(chain, local)
+		}
+		h.Auth = chain
+	}
+	// Backups are only available for SQLite.
+	if database.DialectOf(db) == database.SQLite {
+		h.Backups = backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, cfg.Backup.Gzip)
+	} else if cfg.Backup.Interval > 0 {
+		return errors.New("scheduled backups are only supported for SQLite; use pg_dump for PostgreSQL")
+	}
+
+	// Background workers start from here on, once nothing can fail, so
+	// that they are always stopped by shutdown. They are stopped before
+	// the deferred db.Close runs; if they don't stop in time, the database
+	// isn't closed at all.
+	lc := lifecycle.New()
+
+	// The session store deletes expired sessions in the background until
+	// shutdown.
+	sessionStore := newSessionStore(db, writer)
+	lc.OnStop("session cleanup", func(ctx context.Context) error {
+		sessionStore.StopCleanup()
+		return nil
+	})
+	sessionManager.Store = sessionStore
+
+	// Periodically delete the login failures that have been forgotten,
+	// the links that have expired, and the records of sessions the
+	// session store has expired, which the sessions page would list.
+	lc.Every("login throttle cleanup", time.Hour, func(ctx context.Context) error {
+		_, err := h.Throttle.Cleanup(ctx)
+		return err
+	})
+	lc.Every("session record cleanup", time.Hour, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		signedInBefore := now.Add(-cfg.Session.Lifetime)
+		seenBefore := signedInBefore
+		if cfg.Session.IdleTimeout > 0 {
+			seenBefore = now.Add(-cfg.Session.IdleTimeout)
+		}
+		_, err := store.DeleteExpiredUserSessions(ctx, signedInBefore, seenBefore)
+		return err
+	})
+	lc.Every("expired link cleanup", time.Hour, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		if _, err := store.DeleteExpiredPasswordResets(ctx, now); err != nil {
+			return err
+		}
+		_, err := store.DeleteExpiredEmailVerifications(ctx, now)
+		return err
+	})
+	if dir != nil && cfg.LDAP.SyncInterval > 0 {
+		lc.Every("directory sync", cfg.LDAP.SyncInterval, func(ctx context.Context) error {
+			_, err := dir.Sync(ctx)
+			return err
+		})
+	}
+	// The server takes a backup every backup.interval when it is set.
+	if h.Backups != nil && cfg.Backup.Interval > 0 {
+		lc.Every("backup", cfg.Backup.Interval, func(ctx context.Context) error {
+			f, err := h.Backups.Create(ctx)
+			if err != nil {
+				return err
+			}
+			log.Printf("Backup written to %s.", f.Name)
+			return nil
+		})
+	}
+
+	// Create a new Middleware struct, which checks API tokens against the
+	// store. Admins may be required to use two-factor authentication.
+	mw := middleware.NewMiddleware(sessionManager)
+	mw.RequireAdmin2FA = cfg.Login.RequireAdmin2FA
+	mw.APITokens = store
+	mw.Users = store
+	mw.Courses = store
+	mw.Staff = store
+	mw.UserSessions = store
+	h.RequireAdmin2FA = cfg.Login.RequireAdmin2FA
+
+	// Create an instance of the application struct.
+	app := &Application{
+		config:         cfg,
+		lifecycle:      lc,
+		static:         assetFS(cfg.Paths.Static, web.Static()),
+		db:             db,
+		sessionManager: sessionManager,
+		handlers:       h,
+		middleware:     mw,
+	}
+
+	// Set up the HTTP server.
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           app.Routes(), // Use the chi router
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+
+	// Stop on SIGINT (Ctrl-C) or SIGTERM (docker stop).
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", srv.Addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	// Block until we are told to stop or the server fails to start.
+	select {
+	case err = <-serverErr:
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+	}
+
+	err = shutdown(srv, lc, cfg.HTTP.ShutdownTimeout, err)
+	workersRunning = errors.Is(err, lifecycle.ErrWorkersRunning)
+	return err
+}
+
+// newMailer returns the mailer selected by mail.driver.
+func newMailer(cfg *config.Config) mail.Mailer {
+	switch cfg.Mail.Driver {
+	case "smtp":
+		return mail.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword, cfg.Mail.From)
+	case "file":
+		return mail.NewFileMailer(cfg.Mail.Dir, cfg.Mail.From)
+	default:
+		log.Println("Emails are written to the log (mail.driver is log); do not use this in production.")
+		return mail.NewLogMailer(cfg.Mail.From)
+	}
+}
+
+// newDirectory returns the LDAP directory set by the ldap.* settings.
+func newDirectory(cfg *config.Config, store database.Store) (*auth.Directory, error) {
+	if strings.HasPrefix(cfg.LDAP.URL, "ldap://") && !cfg.LDAP.StartTLS {
+		log.Println("Passwords are sent to the LDAP directory unencrypted; use ldaps:// or ldap.start_tls in production.")
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.LDAP.CAFile != "" {
+		pem, err := os.ReadFile(cfg.LDAP.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldap.ca_file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap.ca_file: no certificates in %s", cfg.LDAP.CAFile)
+		}
+		tlsConfig = &tls.Config{RootCAs: roots}
+	}
+
+	var adminGroups []string
+	for _, g := range strings.Split(cfg.LDAP.AdminGroups, ";") {
+		if g = strings.TrimSpace(g); g != "" {
+			adminGroups = append(adminGroups, g)
+		}
+	}
+
+	return auth.NewDirectory(store, auth.DirectoryConfig{
+		URL:               cfg.LDAP.URL,
+		StartTLS:          cfg.LDAP.StartTLS,
+		TLSConfig:         tlsConfig,
+		BindDN:            cfg.LDAP.BindDN,
+		BindPassword:      cfg.LDAP.BindPassword,
+		BaseDN:            cfg.LDAP.BaseDN,
+		UserFilter:        cfg.LDAP.UserFilter,
+		UsernameAttribute: cfg.LDAP.UsernameAttribute,
+		NameAttribute:     cfg.LDAP.NameAttribute,
+		EmailAttribute:    cfg.LDAP.EmailAttribute,
+		GroupAttribute:    cfg.LDAP.GroupAttribute,
+		AdminGroups:       adminGroups,
+		Timeout:           cfg.LDAP.Timeout,
+	})
+}
+
+// sessionStore is an scs store with a background cleanup goroutine.
+type sessionStore interface {
+	scs.Store
+	StopCleanup()
+}
+
+// newSessionStore returns the session store for the backend db is connected to.
+// On SQLite, sessions are saved, deleted and cleaned up through writer, like
+// the store's writes, and only looked up through db.
+func newSessionStore(db, writer *sql.DB) sessionStore {
+	if database.DialectOf(db) == database.Postgres {
+		return postgresstore.New(db)
+	}
+	return &sqliteSessionStore{
+		SQLite3Store: sqlite3store.New(writer),
+		read:         sqlite3store.NewWithCleanupInterval(db, 0),
+	}
+}
+
+// sqliteSessionStore is an sqlite3store that reads from the read pool.
+type sqliteSessionStore struct {
+	*sqlite3store.SQLite3Store                            // On the write connection.
+	read                       *sqlite3store.SQLite3Store // On the read pool, without cleanup.
+}
+
+func (s *sqliteSessionStore) Find(token string) ([]byte, bool, error) {
+	return s.read.Find(token)
+}
+
+func (s *sqliteSessionStore) All() (map[string][]byte, error) {
+	return s.read.All()
+}
+
+// shutdown lets in-flight requests finish, then drains the background workers.
+// Each stage gets its own timeout, so slow requests don't cut the workers
+// short. serverErr is the error the server stopped with, if it stopped on
+// its own.
+func shutdown(srv *http.Server, lc *lifecycle.Manager, timeout time.Duration, serverErr error) error {
+	var errs []error
+	if serverErr != nil && !errors.Is(serverErr, http.ErrServerClosed) {
+		errs = append(errs, serverErr)
+	}
+
+	srvCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(srvCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop server: %w", err))
+	}
+
+	lcCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := lc.Shutdown(lcCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop workers: %w", err))
+	}
+
+	if len(errs) == 0 {
+		log.Println("Server stopped.")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"lms/internal/config"
+	"lms/internal/database"
+	"lms/internal/rbac"
+	"os"
+)
+
+func runUser(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lms user create|set-password|reset-2fa [flags]")
+		return errUsage
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	username := fs.String("username", "", "username of the account")
+	password := fs.String("password", "", "password to set (read from stdin if empty)")
+	role := fs.String("role", "student", "role of the new user: student, instructor or admin (create only)")
+	cfg, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if *username == "" {
+		fmt.Fprintln(os.Stderr, "lms user: -username is required")
+		return errUsage
+	}
+
+	if action != "create" && action != "set-password" && action != "reset-2fa" {
+		fmt.Fprintf(os.Stderr, "lms user: unknown action %q\n", action)
+		return errUsage
+	}
+
+	if action == "create" && !rbac.ValidRole(*role) {
+		fmt.Fprintf(os.Stderr, "lms user: invalid role %q\n", *role)
+		return errUsage
+	}
+
+	// Turning off two-factor authentication needs no password.
+	if action == "reset-2fa" {
+		return resetTwoFactor(cfg, *username)
+	}
+
+	if *password == "" {
+		p, err := readPassword()
+		if err != nil {
+			return err
+		}
+		*password = p
+	}
+	if *password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	store := database.NewSQLStore(db, nil)
+	ctx := context.Background()
+
+	switch action {
+	case "create":
+		user, err := store.CreateUser(ctx, *username, *password, *role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s %q with ID %d.\n", user.Role, user.Username, user.ID)
+
+	case "set-password":
+		user, err := store.GetUserByUsername(ctx, *username)
+		if err != nil {
+			return err
+		}
+		// The old password may have been compromised, so the sessions and
+		// API tokens it could have opened are ended too.
+		err = store.WithTx(ctx, func(tx database.Store) error {
+			if err := tx.SetUserPassword(ctx, user.ID, *password); err != nil {
+				return err
+			}
+			apiTokens, err := tx.GetAPITokensForUser(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			for _, t := range apiTokens {
+				if err := tx.DeleteAPIToken(ctx, user.ID, t.ID); err != nil {
+					return err
+				}
+			}
+			return tx.DeleteUserSessionsForUser(ctx, user.ID)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Password updated for %q; their sessions and API tokens have been revoked.\n", user.Username)
+	}
+
+	return nil
+}
+
+// resetTwoFactor turns off two-factor authentication for a user who lost
+// their app and recovery codes, such as the only admin.
+func resetTwoFactor(cfg *config.Config, username string) error {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	store := database.NewSQLStore(db, nil)
+	ctx := context.Background()
+
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteTOTP(ctx, user.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Two-factor authentication turned off for %q.\n", user.Username)
+	return nil
+}
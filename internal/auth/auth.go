@@ -0,0 +1,70 @@
+// Package auth checks the usernames and passwords users log in with,
+// against the local accounts and optionally an LDAP directory.
+package auth
+
+import (
+	"context"
+	"errors"
+	"lms/internal/database"
+	"lms/internal/models"
+)
+
+// Authenticator checks a username and password.
+type Authenticator interface {
+	// Authenticate returns the user the credentials belong to. It returns
+	// database.ErrUserNotFound if they are wrong, and another error if
+	// they couldn't be checked.
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+}
+
+// Chain tries each authenticator in turn until one accepts the credentials.
+// If none does, it returns the first error other than
+// database.ErrUserNotFound, so that an unreachable directory isn't taken
+// for a wrong password.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	var firstErr error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, database.ErrUserNotFound) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, database.ErrUserNotFound
+}
+
+// Local checks passwords against the hashes of the local accounts.
+type Local struct {
+	users database.UserStore
+	// Allow, if set, limits who may log in with their local password.
+	Allow func(ctx context.Context, user *models.User) (bool, error)
+}
+
+// NewLocal creates a new Local authenticator.
+func NewLocal(users database.UserStore) *Local {
+	return &Local{users: users}
+}
+
+func (l *Local) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := l.users.AuthenticateUser(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if l.Allow != nil {
+		ok, err := l.Allow(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, database.ErrUserNotFound
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"lms/internal/database"
+	"lms/internal/database/memstore"
+	"lms/internal/ldap/ldaptest"
+	"testing"
+	"time"
+)
+
+const (
+	testBaseDN = "ou=people,dc=example,dc=com"
+	testAdmins = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+var (
+	annEntry = ldaptest.Entry{
+		DN: "uid=ann,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"ann"},
+			"cn":          {"Ann Smith"},
+			"mail":        {"ann@example.com"},
+		},
+		Password: "secret",
+	}
+	rootEntry = ldaptest.Entry{
+		DN: "uid=root,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"root"},
+			"memberOf":    {"CN=Admins, OU=Groups, DC=example, DC=com"},
+		},
+		Password: "toor",
+	}
+	serviceEntry = ldaptest.Entry{DN: "cn=lms,ou=services,dc=example,dc=com", Password: "service"}
+)
+
+// newTestDirectory returns a directory backed by an in-process LDAP server
+// with the given entries, and the store of its local users: "admin", an
+// admin, and "carol", a student, both with the password "local".
+func newTestDirectory(t *testing.T, entries ...ldaptest.Entry) (*Directory, *ldaptest.Server, database.Store) {
+	t.Helper()
+	srv := ldaptest.NewServer(append([]ldaptest.Entry{serviceEntry}, entries...)...)
+	t.Cleanup(srv.Close)
+
+	store := memstore.New()
+	ctx := context.Background()
+	if _, err := store.CreateUser(ctx, "admin", "local", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser(ctx, "carol", "local", "student"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := NewDirectory(store, DirectoryConfig{
+		URL:               srv.URL,
+		BindDN:            serviceEntry.DN,
+		BindPassword:      serviceEntry.Password,
+		BaseDN:            testBaseDN,
+		UserFilter:        "(&(objectClass=person)(uid={username}))",
+		UsernameAttribute: "uid",
+		NameAttribute:     "cn",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		AdminGroups:       []string{testAdmins},
+		Timeout:           time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, srv, store
+}
+
+func TestDirectoryAuthenticate(t *testing.T) {
+	dir, _, _ := newTestDirectory(t, annEntry, rootEntry)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantUser string
+		wantRole string
+		wantErr  error
+	}{
+		{name: "bind success", username: "ann", password: "secret", wantUser: "ann", wantRole: "student"},
+		{name: "username case", username: "ANN", password: "secret", wantUser: "ann", wantRole: "student"},
+		{name: "admin group", username: "root", password: "toor", wantUser: "root", wantRole: "admin"},
+		{name: "wrong password", username: "ann", password: "Secret", wantErr: database.ErrUserNotFound},
+		{name: "empty password", username: "ann", password: "", wantErr: database.ErrUserNotFound},
+		{name: "user not found", username: "eve", password: "secret", wantErr: database.ErrUserNotFound},
+		{name: "filter injection", username: "*", password: "secret", wantErr: database.ErrUserNotFound},
+		{name: "local user", username: "carol", password: "local", wantErr: database.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := dir.Authenticate(context.Background(), tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Username != tt.wantUser || user.Role != tt.wantRole {
+				t.Errorf("got %s (%s), want %s (%s)", user.Username, user.Role, tt.wantUser, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestDirectoryLinksUsers(t *testing.T) {
+	dir, srv, store := newTestDirectory(t, annEntry)
+	ctx := context.Background()
+
+	first, err := dir.Authenticate(ctx, "ann", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.FullName != "Ann Smith" || first.Email != "ann@example.com" {
+		t.Errorf("got name %q and email %q from the entry", first.FullName, first.Email)
+	}
+	identity, err := store.GetUserIdentity(ctx, dir.Issuer(), annEntry.DN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != first.ID {
+		t.Errorf("entry linked to user %d, want %d", identity.UserID, first.ID)
+	}
+
+	// The entry is renamed: the linked user follows it.
+	renamed := annEntry
+	renamed.Attributes = map[string][]string{"objectClass": {"person"}, "uid": {"ann"}, "cn": {"Ann Jones"}}
+	srv.SetEntries(serviceEntry, renamed)
+	again, err := dir.Authenticate(ctx, "ann", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || again.FullName != "Ann Jones" {
+		t.Errorf("got user %d named %q, want %d named Ann Jones", again.ID, again.FullName, first.ID)
+	}
+
+	// Deactivated users can't log in, even with the right password.
+	if err := store.DeactivateUser(ctx, first.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Authenticate(ctx, "ann", "secret"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("deactivated user: got error %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestDirectoryErrors(t *testing.T) {
+	t.Run("directory down", func(t *testing.T) {
+		dir, srv, _ := newTestDirectory(t, annEntry)
+		srv.Close()
+		_, err := dir.Authenticate(context.Background(), "ann", "secret")
+		if err == nil || errors.Is(err, database.ErrUserNotFound) {
+			t.Errorf("got error %v, want a connection error", err)
+		}
+	})
+
+	t.Run("wrong service password", func(t *testing.T) {
+		dir, _, _ := newTestDirectory(t, annEntry)
+		dir.config.BindPassword = "wrong"
+		_, err := dir.Authenticate(context.Background(), "ann", "secret")
+		if err == nil || errors.Is(err, database.ErrUserNotFound) {
+			t.Errorf("got error %v, want a bind error", err)
+		}
+	})
+
+	t.Run("ambiguous username", func(t *testing.T) {
+		twin := annEntry
+		twin.DN = "uid=ann,ou=staff,ou=people,dc=example,dc=com"
+		dir, _, _ := newTestDirectory(t, annEntry, twin)
+		_, err := dir.Authenticate(context.Background(), "ann", "secret")
+		if err == nil || errors.Is(err, database.ErrUserNotFound) {
+			t.Errorf("got error %v, want an error about several entries", err)
+		}
+	})
+}
+
+// errDirectory stands for any error from an unreachable directory.
+var errDirectory = errors.New("directory error")
+
+func TestLocalFallback(t *testing.T) {
+	tests := []struct {
+		mode     string
+		down     bool
+		username string
+		password string
+		wantErr  error // nil for success; errDirectory for a connection error.
+	}{
+		{mode: "admins", username: "ann", password: "secret"},
+		{mode: "admins", username: "admin", password: "local"},
+		{mode: "admins", username: "admin", password: "wrong", wantErr: database.ErrUserNotFound},
+		{mode: "admins", username: "carol", password: "local", wantErr: database.ErrUserNotFound},
+		{mode: "admins", username: "ann", password: "local", wantErr: database.ErrUserNotFound},
+		{mode: "local", username: "carol", password: "local"},
+		{mode: "local", username: "admin", password: "local"},
+		// Ann is linked to the directory, so her local password is refused.
+		{mode: "local", username: "ann", password: "local", wantErr: database.ErrUserNotFound},
+		{mode: "none", username: "admin", password: "local", wantErr: database.ErrUserNotFound},
+		{mode: "none", username: "ann", password: "secret"},
+
+		// With the directory down, the users the fallback allows can still
+		// log in, and the others get an error instead of a wrong password.
+		{mode: "admins", down: true, username: "admin", password: "local"},
+		{mode: "admins", down: true, username: "ann", password: "secret", wantErr: errDirectory},
+		{mode: "admins", down: true, username: "carol", password: "local", wantErr: errDirectory},
+		{mode: "local", down: true, username: "carol", password: "local"},
+		{mode: "local", down: true, username: "ann", password: "local", wantErr: errDirectory},
+		{mode: "none", down: true, username: "admin", password: "local", wantErr: errDirectory},
+	}
+	for _, tt := range tests {
+		name := tt.mode + "/" + tt.username + "/" + tt.password
+		if tt.down {
+			name += "/down"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir, srv, store := newTestDirectory(t, annEntry)
+			ctx := context.Background()
+			// Link ann's entry, and give her a local password too.
+			ann, err := dir.Authenticate(ctx, "ann", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetUserPassword(ctx, ann.ID, "local"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.down {
+				srv.Close()
+			}
+
+			chain := Chain{dir}
+			if local := dir.LocalFallback(tt.mode); local != nil {
+				chain = append(chain, local)
+			}
+			user, err := chain.Authenticate(ctx, tt.username, tt.password)
+			switch {
+			case tt.wantErr == nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if user.Username != tt.username {
+					t.Errorf("got user %s", user.Username)
+				}
+			case tt.wantErr == errDirectory:
+				if err == nil || errors.Is(err, database.ErrUserNotFound) {
+					t.Errorf("got error %v, want a connection error", err)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir, srv, store := newTestDirectory(t, annEntry, rootEntry)
+	ctx := context.Background()
+	root, err := dir.Authenticate(ctx, "root", "toor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Authenticate(ctx, "ann", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Root leaves the directory, and Ann changes her email address.
+	moved := annEntry
+	moved.Attributes = map[string][]string{"objectClass": {"person"}, "uid": {"ann"}, "mail": {"ann@example.org"}}
+	srv.SetEntries(serviceEntry, moved)
+	synced, err := dir.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced != 1 {
+		t.Errorf("synced %d users, want 1", synced)
+	}
+
+	ann, err := store.GetUserByUsername(ctx, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann.Email != "ann@example.org" {
+		t.Errorf("ann's email is %q, want ann@example.org", ann.Email)
+	}
+	root, err = store.GetUserByID(ctx, root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Role != "student" {
+		t.Errorf("root is %s, want student", root.Role)
+	}
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/ldap"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// DirectoryConfig describes an LDAP directory and how its entries map to
+// users.
+type DirectoryConfig struct {
+	URL       string // ldap:// or ldaps://
+	StartTLS  bool
+	TLSConfig *tls.Config // nil to verify the server with the system roots.
+	// BindDN and BindPassword are the service account that looks up users.
+	// An empty BindDN searches anonymously.
+	BindDN       string
+	BindPassword string
+	// Users are searched for below BaseDN with UserFilter, in which
+	// {username} stands for the username typed on the login page.
+	BaseDN     string
+	UserFilter string
+	// UsernameAttribute holds the username of the local user an entry is
+	// linked to on its first login. The name and email attributes are
+	// copied to the user's profile.
+	UsernameAttribute string
+	NameAttribute     string
+	EmailAttribute    string
+	// GroupAttribute lists the DNs of the groups of an entry. Members of
+	// one of AdminGroups are admins, everyone else is a student. Empty
+	// AdminGroups leaves roles alone.
+	GroupAttribute string
+	AdminGroups    []string
+	Timeout        time.Duration
+}
+
+// Directory checks passwords by binding to an LDAP directory as the user.
+// Each entry is linked to a local user, created on its first login, whose
+// name, email address and role follow the entry.
+type Directory struct {
+	store  database.Store
+	config DirectoryConfig
+}
+
+// NewDirectory creates a new Directory. It fails if the user filter is invalid.
+func NewDirectory(store database.Store, config DirectoryConfig) (*Directory, error) {
+	if !strings.Contains(config.UserFilter, "{username}") {
+		return nil, errors.New("ldap: the user filter must contain {username}")
+	}
+	if err := ldap.CompileFilter(userFilter(config.UserFilter, "username")); err != nil {
+		return nil, err
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return &Directory{store: store, config: config}, nil
+}
+
+// Issuer is the issuer of the identities that link users to their entries.
+func (d *Directory) Issuer() string {
+	return d.config.URL
+}
+
+func (d *Directory) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	// An empty password would make an anonymous bind, which succeeds.
+	if username == "" || password == "" {
+		return nil, database.ErrUserNotFound
+	}
+
+	conn, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     d.config.BaseDN,
+		Scope:      ldap.ScopeSubtree,
+		Filter:     userFilter(d.config.UserFilter, username),
+		Attributes: d.attributes(),
+		SizeLimit:  2,
+	})
+	if ldap.IsResult(err, ldap.ResultSizeLimitExceeded) || len(entries) > 1 {
+		return nil, fmt.Errorf("ldap: several entries match the username %q", username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, database.ErrUserNotFound
+	}
+	entry := entries[0]
+
+	// The password is right if the server lets us bind with it.
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsResult(err, ldap.ResultInvalidCredentials) {
+		return nil, database.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, identity, err := d.localUser(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active() {
+		return nil, database.ErrUserNotFound
+	}
+	if err := d.store.UseUserIdentity(ctx, identity.ID, entry.Value(d.config.UsernameAttribute), time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if err := d.update(ctx, user, entry); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Sync updates the users linked to the directory from their entries, and
+// returns how many it updated. Users whose entry is gone lose the admin
+// role it gave them.
+func (d *Directory) Sync(ctx context.Context) (int, error) {
+	identities, err := d.store.GetUserIdentitiesByIssuer(ctx, d.Issuer())
+	if err != nil || len(identities) == 0 {
+		return 0, err
+	}
+
+	conn, err := d.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	synced := 0
+	for _, identity := range identities {
+		entries, err := conn.Search(&ldap.SearchRequest{
+			BaseDN:     identity.Subject,
+			Scope:      ldap.ScopeBase,
+			Filter:     "(objectClass=*)",
+			Attributes: d.attributes(),
+		})
+		if err != nil && !ldap.IsResult(err, ldap.ResultNoSuchObject) {
+			return synced, err
+		}
+		user, err := d.store.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return synced, err
+		}
+
+		if len(entries) == 0 {
+			if len(d.config.AdminGroups) > 0 && user.Role == "admin" {
+				if err := d.store.SetUserRole(ctx, user.ID, "student"); err != nil {
+					return synced, err
+				}
+				log.Printf("User %d (%s) is no longer in the directory and is now a student", user.ID, user.Username)
+			}
+			continue
+		}
+		if err := d.update(ctx, user, entries[0]); err != nil {
+			return synced, err
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+// LocalFallback returns the authenticator to try after the directory, for
+// users it doesn't know or when it is unreachable. It lets in, with their
+// local password, the admins for mode "admins", and also the users who
+// aren't linked to the directory for mode "local". Mode "none" returns nil.
+func (d *Directory) LocalFallback(mode string) Authenticator {
+	local := NewLocal(d.store)
+	switch mode {
+	case "admins":
+		local.Allow = func(ctx context.Context, user *models.User) (bool, error) {
+			return user.Role == "admin", nil
+		}
+	case "local":
+		local.Allow = func(ctx context.Context, user *models.User) (bool, error) {
+			if user.Role == "admin" {
+				return true, nil
+			}
+			identities, err := d.store.GetUserIdentitiesForUser(ctx, user.ID)
+			if err != nil {
+				return false, err
+			}
+			for _, i := range identities {
+				if i.Issuer == d.Issuer() {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	default:
+		return nil
+	}
+	return local
+}
+
+// connect opens a connection, secured if configured, and binds as the
+// service account.
+func (d *Directory) connect(ctx context.Context) (*ldap.Conn, error) {
+	conn, err := ldap.Dial(ctx, d.config.URL, d.config.TLSConfig, d.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.config.StartTLS {
+		if err := conn.StartTLS(d.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: failed to bind as the service account: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// attributes are the attributes read from user entries.
+func (d *Directory) attributes() []string {
+	var attrs []string
+	for _, a := range []string{d.config.UsernameAttribute, d.config.NameAttribute, d.config.EmailAttribute, d.config.GroupAttribute} {
+		if a != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// localUser returns the user the entry is linked to. On the entry's first
+// login it is linked to the user with the same username, or else to a new
+// student.
+func (d *Directory) localUser(ctx context.Context, entry *ldap.Entry) (*models.User, *models.UserIdentity, error) {
+	identity, err := d.store.GetUserIdentity(ctx, d.Issuer(), entry.DN)
+	if err == nil {
+		user, err := d.store.GetUserByID(ctx, identity.UserID)
+		return user, identity, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	username := entry.Value(d.config.UsernameAttribute)
+	if username == "" {
+		return nil, nil, fmt.Errorf("ldap: entry %q has no %s", entry.DN, d.config.UsernameAttribute)
+	}
+
+	var user *models.User
+	err = d.store.WithTx(ctx, func(tx database.Store) error {
+		var err error
+		user, err = tx.GetUserByUsername(ctx, username)
+		if errors.Is(err, database.ErrUserNotFound) {
+			// The random password can't be guessed, and local passwords
+			// are only accepted as configured by LocalFallback.
+			user, err = tx.CreateUser(ctx, username, token.New(), "student")
+			if err == nil {
+				log.Printf("Created user %d (%s) on their first login with %s", user.ID, username, d.Issuer())
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		identity, err = tx.CreateUserIdentity(ctx, user.ID, d.Issuer(), entry.DN, username)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, identity, nil
+}
+
+// update copies the name, email address and role of the entry to user.
+func (d *Directory) update(ctx context.Context, user *models.User, entry *ldap.Entry) error {
+	if name := entry.Value(d.config.NameAttribute); d.config.NameAttribute != "" && name != "" && name != user.FullName {
+		if err := d.store.UpdateUserProfile(ctx, user.ID, name, user.DisplayName, user.Timezone); err != nil {
+			return err
+		}
+		user.FullName = name
+	}
+
+	// Skip addresses another user already has.
+	if email := entry.Value(d.config.EmailAttribute); d.config.EmailAttribute != "" && validEmail(email) && email != user.Email {
+		err := d.store.SetUserEmail(ctx, user.ID, email)
+		if errors.Is(err, database.ErrEmailTaken) {
+			log.Printf("Email address of user %d (%s) not updated from the directory: %s belongs to another user", user.ID, user.Username, email)
+		} else if err != nil {
+			return err
+		} else {
+			user.Email = email
+		}
+	}
+
+	if len(d.config.AdminGroups) == 0 {
+		return nil
+	}
+	// The groups only decide who is an admin: other users keep their role,
+	// so that instructors stay instructors.
+	role := user.Role
+	if role == "admin" {
+		role = "student"
+	}
+	for _, group := range entry.Values(d.config.GroupAttribute) {
+		for _, admins := range d.config.AdminGroups {
+			if ldap.EqualDN(group, admins) {
+				role = "admin"
+			}
+		}
+	}
+	if role == user.Role {
+		return nil
+	}
+	if err := d.store.SetUserRole(ctx, user.ID, role); err != nil {
+		return err
+	}
+	log.Printf("User %d (%s) is now %s, as set by their directory groups", user.ID, user.Username, role)
+	user.Role = role
+	return nil
+}
+
+// userFilter returns the user filter for a username.
+func userFilter(filter, username string) string {
+	return strings.ReplaceAll(filter, "{username}", ldap.EscapeFilter(username))
+}
+
+// validEmail reports whether email is a bare address such as "ann@example.com".
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
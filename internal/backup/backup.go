@@ -0,0 +1,203 @@
+// Package backup keeps a rotated set of database backups in a directory.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"lms/internal/database"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoDir is returned when backups are requested but no directory is configured.
+var ErrNoDir = errors.New("no backup directory is configured")
+
+// ErrNotFound is returned by Open for names that aren't backups in the directory.
+var ErrNotFound = errors.New("backup not found")
+
+const (
+	namePrefix = "lms-backup-"
+	timeFormat = "20060102T150405Z"
+)
+
+// File describes a backup in the directory.
+type File struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Manager creates backups of a SQLite database in a directory and deletes
+// the oldest ones beyond the number to keep.
+type Manager struct {
+	db   *sql.DB
+	dir  string
+	keep int
+	gzip bool
+}
+
+// NewManager creates a new Manager. dir may be empty, in which case only
+// Snapshot is available. keep is the number of backups to retain, 0 for all.
+func NewManager(db *sql.DB, dir string, keep int, gzip bool) *Manager {
+	return &Manager{db: db, dir: dir, keep: keep, gzip: gzip}
+}
+
+// Dir returns the backup directory.
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Create writes a new backup to the directory and deletes the backups
+// that exceed the retention limit.
+func (m *Manager) Create(ctx context.Context) (*File, error) {
+	if m.dir == "" {
+		return nil, ErrNoDir
+	}
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := namePrefix + now.Format(timeFormat) + ".db"
+	if m.gzip {
+		name += ".gz"
+	}
+
+	// A backup taken in the same second, e.g. by the schedule, is just as fresh.
+	path := filepath.Join(m.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		if err := database.Backup(ctx, m.db, path); err != nil {
+			return nil, err
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.prune(); err != nil {
+		return nil, err
+	}
+	return &File{Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// List returns the backups in the directory, newest first.
+func (m *Manager) List() ([]*File, error) {
+	if m.dir == "" {
+		return nil, ErrNoDir
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, e := range entries {
+		createdAt, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &File{Name: e.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files, nil
+}
+
+// Open opens the backup called name for reading.
+func (m *Manager) Open(name string) (*os.File, error) {
+	if m.dir == "" {
+		return nil, ErrNoDir
+	}
+	// Only accept names we generate, which also rules out paths.
+	if _, ok := parseName(name); !ok {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(m.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Snapshot writes a fresh gzip-compressed backup to w without keeping it.
+func (m *Manager) Snapshot(ctx context.Context, w io.Writer) error {
+	// Stage the snapshot in the backup directory when there is one: the
+	// container image has no /tmp.
+	if m.dir != "" {
+		if err := os.MkdirAll(m.dir, 0o750); err != nil {
+			return err
+		}
+	}
+	tmpDir, err := os.MkdirTemp(m.dir, ".lms-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "snapshot.db.gz")
+	if err := database.Backup(ctx, m.db, path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// prune deletes the oldest backups beyond the retention limit.
+func (m *Manager) prune() error {
+	if m.keep <= 0 {
+		return nil
+	}
+
+	files, err := m.List()
+	if err != nil {
+		return err
+	}
+	for i := m.keep; i < len(files); i++ {
+		if err := os.Remove(filepath.Join(m.dir, files[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseName reports whether name is the name of a backup and when it was taken.
+func parseName(name string) (time.Time, bool) {
+	ts, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if t, ok := strings.CutSuffix(ts, ".db.gz"); ok {
+		ts = t
+	} else if t, ok := strings.CutSuffix(ts, ".db"); ok {
+		ts = t
+	} else {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(timeFormat, ts)
+	return t, err == nil
+}
@@ -0,0 +1,163 @@
+package backup_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"lms/internal/backup"
+	"lms/internal/database"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "lms.db"), database.Options{MaxOpenConns: 1, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE notes (body TEXT NOT NULL); INSERT INTO notes VALUES ('hello')"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// names returns the names of the backups m lists.
+func names(t *testing.T, m *backup.Manager) []string {
+	t.Helper()
+	files, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestCreateKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	// Older backups, and files that aren't backups and must be left alone.
+	for _, name := range []string{
+		"lms-backup-20240101T000000Z.db",
+		"lms-backup-20240301T000000Z.db.gz",
+		"lms-backup-20240201T000000Z.db",
+		"lms-backup-latest.db",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := backup.NewManager(newTestDB(t), dir, 3, false)
+	f, err := m.Create(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(f.Name, ".db") || f.Size == 0 {
+		t.Errorf("created %+v", f)
+	}
+
+	want := []string{f.Name, "lms-backup-20240301T000000Z.db.gz", "lms-backup-20240201T000000Z.db"}
+	if got := names(t, m); !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+	for _, name := range []string{"lms-backup-latest.db", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was deleted: %v", name, err)
+		}
+	}
+
+	// Another backup still leaves three, whether or not it reused the
+	// first one by landing in the same second.
+	if _, err := m.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(t, m); len(got) != 3 || got[0] < f.Name {
+		t.Errorf("backups = %v after a second backup", got)
+	}
+}
+
+func TestCreateGzip(t *testing.T) {
+	m := backup.NewManager(newTestDB(t), filepath.Join(t.TempDir(), "backups"), 0, true)
+	f, err := m.Create(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(f.Name, ".db.gz") {
+		t.Fatalf("created %s, want a .db.gz file", f.Name)
+	}
+
+	r, err := m.Open(f.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		t.Error("backup is not a SQLite database")
+	}
+}
+
+func TestOpenOnlyBackups(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := backup.NewManager(newTestDB(t), dir, 0, false)
+	for _, name := range []string{"secret.txt", "../lms.db", "lms-backup-20240101T000000Z.db"} {
+		if _, err := m.Open(name); !errors.Is(err, backup.ErrNotFound) {
+			t.Errorf("Open(%q) returned %v, want ErrNotFound", name, err)
+		}
+	}
+
+	if _, err := backup.NewManager(newTestDB(t), "", 0, false).Create(context.Background()); !errors.Is(err, backup.ErrNoDir) {
+		t.Errorf("Create without a directory returned %v, want ErrNoDir", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	m := backup.NewManager(newTestDB(t), dir, 0, false)
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		t.Error("snapshot is not a SQLite database")
+	}
+
+	// The snapshot isn't kept.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("backup directory has %d entries after a snapshot", len(entries))
+	}
+}
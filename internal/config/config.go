@@ -0,0 +1,433 @@
+// Package config loads the application configuration.
+//
+// Settings are layered with the following precedence, highest first:
+// command-line flags, LMS_* environment variables, the YAML config file
+// and finally the built-in defaults.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds all the settings of the application.
+type Config struct {
+	DSN          string             `yaml:"dsn"`
+	Database     DatabaseConfig     `yaml:"database"`
+	HTTP         HTTPConfig         `yaml:"http"`
+	Session      SessionConfig      `yaml:"session"`
+	Login        LoginConfig        `yaml:"login"`
+	Registration RegistrationConfig `yaml:"registration"`
+	LDAP         LDAPConfig         `yaml:"ldap"`
+	OIDC         OIDCConfig         `yaml:"oidc"`
+	SCIM         SCIMConfig         `yaml:"scim"`
+	Mail         MailConfig         `yaml:"mail"`
+	Paths        PathsConfig        `yaml:"paths"`
+	Backup       BackupConfig       `yaml:"backup"`
+}
+
+// DatabaseConfig holds the connection pool settings.
+type DatabaseConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// BusyTimeout is how long SQLite waits for a lock before giving up.
+	BusyTimeout time.Duration `yaml:"busy_timeout"`
+}
+
+// HTTPConfig holds the web server settings.
+type HTTPConfig struct {
+	Addr              string        `yaml:"addr"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests, then background
+	// workers, are each given to finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// BehindProxy trusts the X-Forwarded-For and X-Real-IP headers for the
+	// client address. Only enable it when a reverse proxy sets them.
+	BehindProxy bool `yaml:"behind_proxy"`
+	// PublicURL is the address users reach the server at. Links in emails
+	// are built from it rather than from the Host header of the request.
+	PublicURL string `yaml:"public_url"`
+}
+
+// SessionConfig holds the session manager settings.
+type SessionConfig struct {
+	Lifetime     time.Duration `yaml:"lifetime"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	CookieSecure bool          `yaml:"cookie_secure"`
+}
+
+// LoginConfig holds the limits on failed logins.
+type LoginConfig struct {
+	MaxFailures      int           `yaml:"max_failures"`
+	MaxFailuresPerIP int           `yaml:"max_failures_per_ip"`
+	LockoutBase      time.Duration `yaml:"lockout_base"`
+	LockoutMax       time.Duration `yaml:"lockout_max"`
+	// ResetTokenLifetime is how long a password reset link stays valid.
+	ResetTokenLifetime time.Duration `yaml:"reset_token_lifetime"`
+	// VerifyTokenLifetime is how long an email verification link stays valid.
+	VerifyTokenLifetime time.Duration `yaml:"verify_token_lifetime"`
+	// RequireAdmin2FA makes admins set up two-factor authentication before
+	// they can use the admin pages.
+	RequireAdmin2FA bool `yaml:"require_admin_2fa"`
+}
+
+// RegistrationConfig holds the settings of who may create an account from
+// the registration page.
+type RegistrationConfig struct {
+	// Mode is "open" for anyone, "invite" for people with an invitation,
+	// "domain" for people with an invitation or an email address at one
+	// of AllowedDomains, or "closed" for nobody.
+	Mode string `yaml:"mode"`
+	// AllowedDomains are separated by commas, such as "example.com".
+	AllowedDomains string `yaml:"allowed_domains"`
+	// InvitationLifetime is how long an invitation link stays valid.
+	InvitationLifetime time.Duration `yaml:"invitation_lifetime"`
+}
+
+// LDAPConfig holds the settings of checking passwords against an LDAP
+// directory such as Active Directory.
+type LDAPConfig struct {
+	// URL of the server, ldap:// or ldaps://. Empty disables the directory.
+	URL string `yaml:"url"`
+	// StartTLS secures an ldap:// connection before sending passwords.
+	StartTLS bool `yaml:"start_tls"`
+	// CAFile holds the PEM certificates the server's is checked against,
+	// instead of the system's.
+	CAFile string `yaml:"ca_file"`
+	// BindDN and BindPassword are the service account that looks up users.
+	// Users are searched for anonymously if BindDN is empty.
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+	// Users are searched for below BaseDN with UserFilter, in which
+	// {username} stands for the username typed on the login page.
+	BaseDN     string `yaml:"base_dn"`
+	UserFilter string `yaml:"user_filter"`
+	// UsernameAttribute names the local user a directory user is linked
+	// to; the name and email attributes are copied to their profile.
+	UsernameAttribute string `yaml:"username_attribute"`
+	NameAttribute     string `yaml:"name_attribute"`
+	EmailAttribute    string `yaml:"email_attribute"`
+	// GroupAttribute lists the DNs of the groups of a user. Members of one
+	// of AdminGroups, DNs separated by semicolons, are admins and everyone
+	// else is a student. Empty AdminGroups leaves roles alone.
+	GroupAttribute string `yaml:"group_attribute"`
+	AdminGroups    string `yaml:"admin_groups"`
+	// Fallback is who may still log in with a local password: "admins",
+	// "local" for admins and the users not from the directory, or "none".
+	Fallback string `yaml:"fallback"`
+	// SyncInterval between updates of all the users from the directory, 0
+	// to only update them when they log in.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// OIDCConfig holds the settings of single sign-on with an OpenID Connect
+// identity provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider. Empty disables single sign-on.
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Scopes are requested from the provider, separated by spaces.
+	Scopes string `yaml:"scopes"`
+	// Name is the name of the provider on the login page.
+	Name string `yaml:"name"`
+	// UsernameClaim is the claim new users get their username from.
+	UsernameClaim string `yaml:"username_claim"`
+	// LinkExisting links a provider account, when it is first used, to the
+	// user whose verified email address is the account's verified email.
+	// Admins are never linked this way. Otherwise users link accounts from
+	// their security settings.
+	LinkExisting bool `yaml:"link_existing"`
+	// TrustAMR skips the second factor of users whose provider reports in
+	// the "amr" claim that they used one there.
+	TrustAMR bool `yaml:"trust_amr"`
+	// RoleClaim, when set, lets the provider decide the role of its users
+	// at each login: admins are those whose claim contains one of the
+	// comma-separated AdminValues, everyone else is a student.
+	RoleClaim   string `yaml:"role_claim"`
+	AdminValues string `yaml:"admin_values"`
+}
+
+// SCIMConfig holds the settings of the SCIM API, through which an identity
+// platform provisions users and groups.
+type SCIMConfig struct {
+	// Token is the bearer token the platform authenticates with. Empty
+	// disables the API.
+	Token string `yaml:"token"`
+	// ManageAdmins lets the API change the password and email address of
+	// admins, which could otherwise be taken over by the platform.
+	ManageAdmins bool `yaml:"manage_admins"`
+}
+
+// MailConfig holds the settings for sending email.
+type MailConfig struct {
+	// Driver is "smtp" to send email, or "file" or "log" to write it to
+	// Dir or the log during development.
+	Driver       string `yaml:"driver"`
+	From         string `yaml:"from"`
+	SMTPAddr     string `yaml:"smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	Dir          string `yaml:"dir"`
+}
+
+// PathsConfig holds the locations of files read at runtime.
+// An empty path means the copy embedded in the binary is used.
+type PathsConfig struct {
+	Migrations string `yaml:"migrations"`
+	Templates  string `yaml:"templates"`
+	Static     string `yaml:"static"`
+}
+
+// BackupConfig holds the settings of the SQLite backups.
+type BackupConfig struct {
+	Dir string `yaml:"dir"`
+	// Interval between scheduled backups taken by the server, 0 to disable them.
+	Interval time.Duration `yaml:"interval"`
+	// Keep is the number of backups to retain in Dir, 0 for all.
+	Keep int  `yaml:"keep"`
+	Gzip bool `yaml:"gzip"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		DSN: "lms.db",
+		Database: DatabaseConfig{
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			BusyTimeout:     5 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			PublicURL:         "http://localhost:8080",
+		},
+		Session: SessionConfig{
+			Lifetime:     24 * time.Hour,
+			IdleTimeout:  20 * time.Minute,
+			CookieSecure: false,
+		},
+		Login: LoginConfig{
+			MaxFailures:         5,
+			MaxFailuresPerIP:    20,
+			LockoutBase:         time.Minute,
+			LockoutMax:          time.Hour,
+			ResetTokenLifetime:  time.Hour,
+			VerifyTokenLifetime: 48 * time.Hour,
+			RequireAdmin2FA:     false,
+		},
+		Registration: RegistrationConfig{
+			Mode:               "open",
+			InvitationLifetime: 7 * 24 * time.Hour,
+		},
+		LDAP: LDAPConfig{
+			UserFilter:        "(uid={username})",
+			UsernameAttribute: "uid",
+			NameAttribute:     "cn",
+			EmailAttribute:    "mail",
+			GroupAttribute:    "memberOf",
+			Fallback:          "admins",
+			SyncInterval:      time.Hour,
+			Timeout:           10 * time.Second,
+		},
+		OIDC: OIDCConfig{
+			Scopes:        "openid profile email",
+			Name:          "single sign-on",
+			UsernameClaim: "preferred_username",
+		},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "LMS <lms@localhost>",
+		},
+		Backup: BackupConfig{
+			Keep: 7,
+		},
+	}
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.DSN == "" {
+		errs = append(errs, errors.New("dsn must not be empty"))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database connection limits must not be negative"))
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.BusyTimeout < 0 {
+		errs = append(errs, errors.New("database timeouts must not be negative"))
+	}
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr must not be empty"))
+	}
+	if c.HTTP.ReadTimeout < 0 || c.HTTP.ReadHeaderTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0 {
+		errs = append(errs, errors.New("http timeouts must not be negative"))
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("http.shutdown_timeout must be positive"))
+	}
+	if u, err := url.Parse(c.HTTP.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, errors.New("http.public_url must be an absolute http or https URL"))
+	}
+	if c.Session.Lifetime <= 0 {
+		errs = append(errs, errors.New("session.lifetime must be positive"))
+	}
+	if c.Session.IdleTimeout < 0 {
+		errs = append(errs, errors.New("session.idle_timeout must not be negative"))
+	}
+	if c.Session.IdleTimeout > c.Session.Lifetime {
+		errs = append(errs, errors.New("session.idle_timeout must not exceed session.lifetime"))
+	}
+	if c.Login.MaxFailures < 0 || c.Login.MaxFailuresPerIP < 0 {
+		errs = append(errs, errors.New("login.max_failures and login.max_failures_per_ip must not be negative"))
+	}
+	if c.Login.LockoutBase <= 0 || c.Login.LockoutMax < c.Login.LockoutBase {
+		errs = append(errs, errors.New("login.lockout_base must be positive and not exceed login.lockout_max"))
+	}
+	if c.Login.ResetTokenLifetime <= 0 || c.Login.VerifyTokenLifetime <= 0 {
+		errs = append(errs, errors.New("login.reset_token_lifetime and login.verify_token_lifetime must be positive"))
+	}
+	if !slices.Contains([]string{"open", "invite", "domain", "closed"}, c.Registration.Mode) {
+		errs = append(errs, fmt.Errorf("registration.mode: unknown mode %q (want open, invite, domain or closed)", c.Registration.Mode))
+	}
+	if c.Registration.Mode == "domain" && strings.TrimSpace(c.Registration.AllowedDomains) == "" {
+		errs = append(errs, errors.New("registration.allowed_domains must be set for the domain mode"))
+	}
+	if c.Registration.InvitationLifetime <= 0 {
+		errs = append(errs, errors.New("registration.invitation_lifetime must be positive"))
+	}
+	if c.LDAP.URL != "" {
+		if u, err := url.Parse(c.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			errs = append(errs, errors.New("ldap.url must be an ldap:// or ldaps:// URL"))
+		} else if c.LDAP.StartTLS && u.Scheme == "ldaps" {
+			errs = append(errs, errors.New("ldap.start_tls only applies to ldap:// URLs"))
+		}
+		if c.LDAP.BaseDN == "" {
+			errs = append(errs, errors.New("ldap.base_dn must be set with ldap.url"))
+		}
+		if !strings.Contains(c.LDAP.UserFilter, "{username}") {
+			errs = append(errs, errors.New("ldap.user_filter must contain {username}"))
+		}
+		if c.LDAP.UsernameAttribute == "" {
+			errs = append(errs, errors.New("ldap.username_attribute must not be empty"))
+		}
+		if c.LDAP.AdminGroups != "" && c.LDAP.GroupAttribute == "" {
+			errs = append(errs, errors.New("ldap.admin_groups needs ldap.group_attribute"))
+		}
+		if c.LDAP.BindPassword != "" && c.LDAP.BindDN == "" {
+			errs = append(errs, errors.New("ldap.bind_password needs ldap.bind_dn"))
+		}
+		if !slices.Contains([]string{"admins", "local", "none"}, c.LDAP.Fallback) {
+			errs = append(errs, fmt.Errorf("ldap.fallback: unknown value %q (want admins, local or none)", c.LDAP.Fallback))
+		}
+		if c.LDAP.SyncInterval < 0 || c.LDAP.Timeout <= 0 {
+			errs = append(errs, errors.New("ldap.sync_interval must not be negative and ldap.timeout must be positive"))
+		}
+	}
+	if c.OIDC.Issuer != "" {
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("oidc.issuer must be an absolute http or https URL"))
+		}
+		if c.OIDC.ClientID == "" {
+			errs = append(errs, errors.New("oidc.client_id must be set with oidc.issuer"))
+		}
+		if !slices.Contains(strings.Fields(c.OIDC.Scopes), "openid") {
+			errs = append(errs, errors.New("oidc.scopes must include openid"))
+		}
+		if c.OIDC.UsernameClaim == "" {
+			errs = append(errs, errors.New("oidc.username_claim must not be empty"))
+		}
+		if c.OIDC.AdminValues != "" && c.OIDC.RoleClaim == "" {
+			errs = append(errs, errors.New("oidc.admin_values needs oidc.role_claim"))
+		}
+	}
+	if c.SCIM.Token != "" && len(c.SCIM.Token) < 32 {
+		errs = append(errs, errors.New("scim.token must be at least 32 characters long"))
+	}
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("mail.dir must be set for the file driver"))
+		}
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			errs = append(errs, errors.New("mail.smtp_addr must be a host:port address"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver: unknown driver %q (want smtp, file or log)", c.Mail.Driver))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from: %w", err))
+	}
+	if c.Backup.Interval < 0 || c.Backup.Keep < 0 {
+		errs = append(errs, errors.New("backup.interval and backup.keep must not be negative"))
+	}
+	if c.Backup.Interval > 0 && c.Backup.Dir == "" {
+		errs = append(errs, errors.New("backup.dir must be set for scheduled backups"))
+	}
+	for _, p := range []struct{ key, dir string }{
+		{"paths.migrations", c.Paths.Migrations},
+		{"paths.templates", c.Paths.Templates},
+		{"paths.static", c.Paths.Static},
+	} {
+		if p.dir == "" {
+			continue
+		}
+		if info, err := os.Stat(p.dir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", p.key, p.dir))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.DSN = redactDSN(c.DSN)
+	if r.Mail.SMTPPassword != "" {
+		r.Mail.SMTPPassword = "xxxxx"
+	}
+	if r.LDAP.BindPassword != "" {
+		r.LDAP.BindPassword = "xxxxx"
+	}
+	if r.OIDC.ClientSecret != "" {
+		r.OIDC.ClientSecret = "xxxxx"
+	}
+	if r.SCIM.Token != "" {
+		r.SCIM.Token = "xxxxx"
+	}
+	return &r
+}
+
+// redactDSN hides the password of URL-style data source names.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return dsn
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting describes one configuration value that can be overridden
+// by an environment variable and a command-line flag.
+type setting struct {
+	key   string // Dotted path in the config file.
+	env   string
+	flag  string
+	usage string
+	field func(c *Config) any // Returns a pointer to the field.
+}
+
+var settings = []setting{
+	{"dsn", "LMS_DSN", "dsn", "database: a SQLite file path or a postgres:// URL", func(c *Config) any { return &c.DSN }},
+	{"database.max_open_conns", "LMS_DATABASE_MAX_OPEN_CONNS", "db-max-open-conns", "maximum number of open database connections, 0 for unlimited", func(c *Config) any { return &c.Database.MaxOpenConns }},
+	{"database.max_idle_conns", "LMS_DATABASE_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum number of idle database connections", func(c *Config) any { return &c.Database.MaxIdleConns }},
+	{"database.conn_max_lifetime", "LMS_DATABASE_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum time a database connection is reused, 0 for forever", func(c *Config) any { return &c.Database.ConnMaxLifetime }},
+	{"database.busy_timeout", "LMS_DATABASE_BUSY_TIMEOUT", "db-busy-timeout", "how long SQLite waits for a locked database", func(c *Config) any { return &c.Database.BusyTimeout }},
+	{"http.addr", "LMS_HTTP_ADDR", "addr", "HTTP listen address", func(c *Config) any { return &c.HTTP.Addr }},
+	{"http.read_timeout", "LMS_HTTP_READ_TIMEOUT", "read-timeout", "maximum duration for reading a request, 0 for none", func(c *Config) any { return &c.HTTP.ReadTimeout }},
+	{"http.read_header_timeout", "LMS_HTTP_READ_HEADER_TIMEOUT", "read-header-timeout", "maximum duration for reading request headers, 0 for none", func(c *Config) any { return &c.HTTP.ReadHeaderTimeout }},
+	{"http.write_timeout", "LMS_HTTP_WRITE_TIMEOUT", "write-timeout", "maximum duration for writing a response, 0 for none", func(c *Config) any { return &c.HTTP.WriteTimeout }},
+	{"http.idle_timeout", "LMS_HTTP_IDLE_TIMEOUT", "idle-timeout", "maximum time to keep idle connections open, 0 for none", func(c *Config) any { return &c.HTTP.IdleTimeout }},
+	{"http.shutdown_timeout", "LMS_HTTP_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed for requests, then workers, to finish on shutdown", func(c *Config) any { return &c.HTTP.ShutdownTimeout }},
+	{"http.behind_proxy", "LMS_HTTP_BEHIND_PROXY", "behind-proxy", "take client addresses from X-Forwarded-For and X-Real-IP", func(c *Config) any { return &c.HTTP.BehindProxy }},
+	{"http.public_url", "LMS_HTTP_PUBLIC_URL", "public-url", "URL users reach the server at, used for links in emails", func(c *Config) any { return &c.HTTP.PublicURL }},
+	{"session.lifetime", "LMS_SESSION_LIFETIME", "session-lifetime", "absolute session lifetime", func(c *Config) any { return &c.Session.Lifetime }},
+	{"session.idle_timeout", "LMS_SESSION_IDLE_TIMEOUT", "session-idle-timeout", "session idle timeout", func(c *Config) any { return &c.Session.IdleTimeout }},
+	{"session.cookie_secure", "LMS_SESSION_COOKIE_SECURE", "cookie-secure", "only send the session cookie over HTTPS", func(c *Config) any { return &c.Session.CookieSecure }},
+	{"login.max_failures", "LMS_LOGIN_MAX_FAILURES", "login-max-failures", "failed logins allowed per username before it is locked, 0 for no limit", func(c *Config) any { return &c.Login.MaxFailures }},
+	{"login.max_failures_per_ip", "LMS_LOGIN_MAX_FAILURES_PER_IP", "login-max-failures-per-ip", "failed logins allowed per client IP before it is locked, 0 for no limit", func(c *Config) any { return &c.Login.MaxFailuresPerIP }},
+	{"login.lockout_base", "LMS_LOGIN_LOCKOUT_BASE", "login-lockout-base", "duration of the first lockout, doubled on each further failure", func(c *Config) any { return &c.Login.LockoutBase }},
+	{"login.lockout_max", "LMS_LOGIN_LOCKOUT_MAX", "login-lockout-max", "longest lockout; failures are forgotten after this long", func(c *Config) any { return &c.Login.LockoutMax }},
+	{"login.reset_token_lifetime", "LMS_LOGIN_RESET_TOKEN_LIFETIME", "reset-token-lifetime", "how long a password reset link stays valid", func(c *Config) any { return &c.Login.ResetTokenLifetime }},
+	{"login.verify_token_lifetime", "LMS_LOGIN_VERIFY_TOKEN_LIFETIME", "verify-token-lifetime", "how long an email verification link stays valid", func(c *Config) any { return &c.Login.VerifyTokenLifetime }},
+	{"login.require_admin_2fa", "LMS_LOGIN_REQUIRE_ADMIN_2FA", "require-admin-2fa", "make admins use two-factor authentication to reach the admin pages", func(c *Config) any { return &c.Login.RequireAdmin2FA }},
+	{"registration.mode", "LMS_REGISTRATION_MODE", "registration-mode", "who may register: open, invite, domain or closed", func(c *Config) any { return &c.Registration.Mode }},
+	{"registration.allowed_domains", "LMS_REGISTRATION_ALLOWED_DOMAINS", "registration-allowed-domains", "comma-separated email domains that may register in the domain mode", func(c *Config) any { return &c.Registration.AllowedDomains }},
+	{"registration.invitation_lifetime", "LMS_REGISTRATION_INVITATION_LIFETIME", "invitation-lifetime", "how long an invitation link stays valid", func(c *Config) any { return &c.Registration.InvitationLifetime }},
+	{"ldap.url", "LMS_LDAP_URL", "ldap-url", "URL of the LDAP directory that checks passwords (ldap:// or ldaps://), empty to disable", func(c *Config) any { return &c.LDAP.URL }},
+	{"ldap.start_tls", "LMS_LDAP_START_TLS", "ldap-start-tls", "secure ldap:// connections with StartTLS", func(c *Config) any { return &c.LDAP.StartTLS }},
+	{"ldap.ca_file", "LMS_LDAP_CA_FILE", "ldap-ca-file", "PEM file of the certificates the directory's is checked against, empty for the system's", func(c *Config) any { return &c.LDAP.CAFile }},
+	{"ldap.bind_dn", "LMS_LDAP_BIND_DN", "ldap-bind-dn", "DN of the service account that looks up users, empty to search anonymously", func(c *Config) any { return &c.LDAP.BindDN }},
+	{"ldap.bind_password", "LMS_LDAP_BIND_PASSWORD", "ldap-bind-password", "password of the service account", func(c *Config) any { return &c.LDAP.BindPassword }},
+	{"ldap.base_dn", "LMS_LDAP_BASE_DN", "ldap-base-dn", "DN users are searched for below", func(c *Config) any { return &c.LDAP.BaseDN }},
+	{"ldap.user_filter", "LMS_LDAP_USER_FILTER", "ldap-user-filter", "filter that finds a user, with {username} for the username typed", func(c *Config) any { return &c.LDAP.UserFilter }},
+	{"ldap.username_attribute", "LMS_LDAP_USERNAME_ATTRIBUTE", "ldap-username-attribute", "attribute holding the username of the local user", func(c *Config) any { return &c.LDAP.UsernameAttribute }},
+	{"ldap.name_attribute", "LMS_LDAP_NAME_ATTRIBUTE", "ldap-name-attribute", "attribute copied to the full name of users, empty to leave it alone", func(c *Config) any { return &c.LDAP.NameAttribute }},
+	{"ldap.email_attribute", "LMS_LDAP_EMAIL_ATTRIBUTE", "ldap-email-attribute", "attribute copied to the email address of users, empty to leave it alone", func(c *Config) any { return &c.LDAP.EmailAttribute }},
+	{"ldap.group_attribute", "LMS_LDAP_GROUP_ATTRIBUTE", "ldap-group-attribute", "attribute listing the group DNs of a user", func(c *Config) any { return &c.LDAP.GroupAttribute }},
+	{"ldap.admin_groups", "LMS_LDAP_ADMIN_GROUPS", "ldap-admin-groups", "semicolon-separated DNs of the groups whose members are admins, empty to leave roles alone", func(c *Config) any { return &c.LDAP.AdminGroups }},
+	{"ldap.fallback", "LMS_LDAP_FALLBACK", "ldap-fallback", "who may still log in with a local password: admins, local or none", func(c *Config) any { return &c.LDAP.Fallback }},
+	{"ldap.sync_interval", "LMS_LDAP_SYNC_INTERVAL", "ldap-sync-interval", "interval between updates of the users from the directory, 0 to disable", func(c *Config) any { return &c.LDAP.SyncInterval }},
+	{"ldap.timeout", "LMS_LDAP_TIMEOUT", "ldap-timeout", "timeout of each request to the directory", func(c *Config) any { return &c.LDAP.Timeout }},
+	{"oidc.issuer", "LMS_OIDC_ISSUER", "oidc-issuer", "URL of the OpenID Connect provider for single sign-on, empty to disable", func(c *Config) any { return &c.OIDC.Issuer }},
+	{"oidc.client_id", "LMS_OIDC_CLIENT_ID", "oidc-client-id", "client ID registered with the OpenID Connect provider", func(c *Config) any { return &c.OIDC.ClientID }},
+	{"oidc.client_secret", "LMS_OIDC_CLIENT_SECRET", "oidc-client-secret", "client secret, empty for a public client", func(c *Config) any { return &c.OIDC.ClientSecret }},
+	{"oidc.scopes", "LMS_OIDC_SCOPES", "oidc-scopes", "scopes requested from the provider, separated by spaces", func(c *Config) any { return &c.OIDC.Scopes }},
+	{"oidc.name", "LMS_OIDC_NAME", "oidc-name", "name of the provider on the login page", func(c *Config) any { return &c.OIDC.Name }},
+	{"oidc.username_claim", "LMS_OIDC_USERNAME_CLAIM", "oidc-username-claim", "claim new users get their username from", func(c *Config) any { return &c.OIDC.UsernameClaim }},
+	{"oidc.link_existing", "LMS_OIDC_LINK_EXISTING", "oidc-link-existing", "link provider accounts to the non-admin user with the same verified email", func(c *Config) any { return &c.OIDC.LinkExisting }},
+	{"oidc.trust_amr", "LMS_OIDC_TRUST_AMR", "oidc-trust-amr", "skip the second factor of users who used one with the provider", func(c *Config) any { return &c.OIDC.TrustAMR }},
+	{"oidc.role_claim", "LMS_OIDC_ROLE_CLAIM", "oidc-role-claim", "claim that decides the role of users at each login, empty to leave roles alone", func(c *Config) any { return &c.OIDC.RoleClaim }},
+	{"oidc.admin_values", "LMS_OIDC_ADMIN_VALUES", "oidc-admin-values", "comma-separated values of the role claim that make a user an admin", func(c *Config) any { return &c.OIDC.AdminValues }},
+	{"scim.token", "LMS_SCIM_TOKEN", "scim-token", "bearer token of the SCIM provisioning API, empty to disable", func(c *Config) any { return &c.SCIM.Token }},
+	{"scim.manage_admins", "LMS_SCIM_MANAGE_ADMINS", "scim-manage-admins", "let the SCIM API change the password and email address of admins", func(c *Config) any { return &c.SCIM.ManageAdmins }},
+	{"mail.driver", "LMS_MAIL_DRIVER", "mail-driver", "how to send email: smtp, or file or log for development", func(c *Config) any { return &c.Mail.Driver }},
+	{"mail.from", "LMS_MAIL_FROM", "mail-from", "sender address of emails", func(c *Config) any { return &c.Mail.From }},
+	{"mail.smtp_addr", "LMS_MAIL_SMTP_ADDR", "smtp-addr", "SMTP server host:port", func(c *Config) any { return &c.Mail.SMTPAddr }},
+	{"mail.smtp_username", "LMS_MAIL_SMTP_USERNAME", "smtp-username", "SMTP username, empty to send without authenticating", func(c *Config) any { return &c.Mail.SMTPUsername }},
+	{"mail.smtp_password", "LMS_MAIL_SMTP_PASSWORD", "smtp-password", "SMTP password", func(c *Config) any { return &c.Mail.SMTPPassword }},
+	{"mail.dir", "LMS_MAIL_DIR", "mail-dir", "directory the file driver writes emails to", func(c *Config) any { return &c.Mail.Dir }},
+	{"paths.migrations", "LMS_PATHS_MIGRATIONS", "migrations", "directory containing the migration files (default: embedded)", func(c *Config) any { return &c.Paths.Migrations }},
+	{"paths.templates", "LMS_PATHS_TEMPLATES", "templates", "directory containing the HTML templates (default: embedded)", func(c *Config) any { return &c.Paths.Templates }},
+	{"paths.static", "LMS_PATHS_STATIC", "static", "directory containing the static assets (default: embedded)", func(c *Config) any { return &c.Paths.Static }},
+	{"backup.dir", "LMS_BACKUP_DIR", "backup-dir", "directory for rotated backups", func(c *Config) any { return &c.Backup.Dir }},
+	{"backup.interval", "LMS_BACKUP_INTERVAL", "backup-interval", "interval between scheduled backups taken by the server, 0 to disable", func(c *Config) any { return &c.Backup.Interval }},
+	{"backup.keep", "LMS_BACKUP_KEEP", "backup-keep", "number of backups to retain, 0 for all", func(c *Config) any { return &c.Backup.Keep }},
+	{"backup.gzip", "LMS_BACKUP_GZIP", "backup-gzip", "compress backups with gzip", func(c *Config) any { return &c.Backup.Gzip }},
+}
+
+// Flags holds the configuration flags registered on a FlagSet.
+type Flags struct {
+	path   string
+	values map[string]string // Raw values of the flags that were set, by flag name.
+}
+
+// RegisterFlags adds the -config flag and one flag per setting to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+
+	fs.StringVar(&f.path, "config", "", "path to a YAML config file (env LMS_CONFIG)")
+	for _, s := range settings {
+		fs.Var(&settingFlag{flags: f, setting: s}, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	return f
+}
+
+// settingFlag is the flag.Value of a setting. It records the raw value
+// so that it can be applied after the file and the environment.
+type settingFlag struct {
+	flags   *Flags
+	setting setting
+}
+
+// String returns the default value, which the flag package shows in the usage.
+func (sf *settingFlag) String() string {
+	if sf.flags == nil {
+		return ""
+	}
+	return fmt.Sprint(reflect.ValueOf(sf.setting.field(Default())).Elem())
+}
+
+func (sf *settingFlag) Set(v string) error {
+	// Check the value now so mistakes are reported as flag errors.
+	if err := setValue(sf.setting.field(Default()), v); err != nil {
+		return err
+	}
+	sf.flags.values[sf.setting.flag] = v
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as a bare -flag.
+func (sf *settingFlag) IsBoolFlag() bool {
+	_, ok := sf.setting.field(Default()).(*bool)
+	return ok
+}
+
+// Load builds the configuration from the defaults, the config file,
+// the environment and the flags, in that order, and validates it.
+// It must be called after the FlagSet has been parsed.
+func (f *Flags) Load() (*Config, error) {
+	cfg := Default()
+
+	// The config file comes from the flag, or else the environment.
+	path := f.path
+	if path == "" {
+		path = os.Getenv("LMS_CONFIG")
+	}
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment variables override the file.
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := setValue(s.field(cfg), v); err != nil {
+				return nil, fmt.Errorf("%s (%s): %w", s.env, s.key, err)
+			}
+		}
+	}
+
+	// Flags override everything.
+	for _, s := range settings {
+		if v, ok := f.values[s.flag]; ok {
+			if err := setValue(s.field(cfg), v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML config file on top of cfg.
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Reject unknown keys so typos don't go unnoticed.
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// setValue parses raw into the field pointed to by ptr.
+func setValue(ptr any, raw string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = raw
+	case *bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		*p = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// Write prints the configuration as YAML.
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
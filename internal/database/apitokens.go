@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"strings"
+	"time"
+)
+
+// apiTokenColumns are the columns scanned by scanAPIToken.
+const apiTokenColumns = "id, user_id, name, token_hash, scopes, two_factor_verified, created_at, expires_at, last_used_at"
+
+// scanAPIToken scans a row selected with apiTokenColumns.
+func scanAPIToken(row interface{ Scan(...any) error }) (*models.APIToken, error) {
+	t := &models.APIToken{}
+	var scopes string
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.TwoFactorVerified, &t.CreatedAt, &t.ExpiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	t.LastUsedAt = lastUsedAt.Time
+	return t, nil
+}
+
+// CreateAPIToken stores the hash of a new API token of the user.
+func (s *SQLStore) CreateAPIToken(ctx context.Context, userID int64, name, tokenHash string, scopes []string, twoFactorVerified bool, expiresAt time.Time) (*models.APIToken, error) {
+	id, err := s.insert(ctx,
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, two_factor_verified, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, name, tokenHash, strings.Join(scopes, " "), twoFactorVerified, expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.APIToken{
+		ID:                id,
+		UserID:            userID,
+		Name:              name,
+		TokenHash:         tokenHash,
+		Scopes:            scopes,
+		TwoFactorVerified: twoFactorVerified,
+		CreatedAt:         time.Now().UTC(),
+		ExpiresAt:         expiresAt,
+	}, nil
+}
+
+// GetAPITokensForUser retrieves the API tokens of a user, expired ones
+// included, oldest first.
+func (s *SQLStore) GetAPITokensForUser(ctx context.Context, userID int64) ([]*models.APIToken, error) {
+	rows, err := s.query(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAPITokenByHash retrieves the API token with the given hash, even if it
+// has expired.
+func (s *SQLStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	row := s.queryRow(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash)
+	return scanAPIToken(row)
+}
+
+// UseAPIToken records when the token was last used.
+func (s *SQLStore) UseAPIToken(ctx context.Context, id int64, now time.Time) error {
+	result, err := s.exec(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAPIToken revokes an API token of the user. It returns sql.ErrNoRows
+// if the user has no token with that ID.
+func (s *SQLStore) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+)
+
+// AddAuditEntry records an entry in the audit log. Its ID and time are set
+// by the database.
+func (s *SQLStore) AddAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO audit_log (actor_id, actor_name, action, target_id, target_name, details)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		nullID(entry.ActorID), entry.ActorName, entry.Action, nullID(entry.TargetID), entry.TargetName, entry.Details,
+	)
+	return err
+}
+
+// GetAuditEntries retrieves the latest entries of the audit log, newest
+// first.
+func (s *SQLStore) GetAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, created_at, actor_id, actor_name, action, target_id, target_name, details
+		FROM audit_log
+		ORDER BY id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		e := &models.AuditEntry{}
+		var actorID, targetID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &actorID, &e.ActorName, &e.Action, &targetID, &e.TargetName, &e.Details); err != nil {
+			return nil, err
+		}
+		e.ActorID = actorID.Int64
+		e.TargetID = targetID.Int64
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// nullID stores a zero ID as NULL, for columns that refer to rows that may
+// not exist.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
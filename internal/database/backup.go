@@ -0,0 +1,274 @@
+package database
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBackupUnsupported is returned when backing up a non-SQLite database.
+var ErrBackupUnsupported = errors.New("backups are only supported for SQLite; use pg_dump for PostgreSQL")
+
+// ErrBackupExists is returned when the backup destination already exists.
+var ErrBackupExists = errors.New("backup file already exists")
+
+// ErrDatabaseInUse is returned by Restore when another process, such as
+// the server, has the database open.
+var ErrDatabaseInUse = errors.New("database is in use; stop the server before restoring")
+
+// ErrSchemaTooNew is returned by Restore when the backup contains migrations
+// this version of the application doesn't know about.
+var ErrSchemaTooNew = errors.New("backup was made by a newer version of the application")
+
+// Backup writes a consistent snapshot of the database to dest using VACUUM INTO.
+// The snapshot is gzip-compressed when dest ends in ".gz".
+// It is safe to call while the server is running.
+func Backup(ctx context.Context, db *sql.DB, dest string) error {
+	if DialectOf(db) != SQLite {
+		return ErrBackupUnsupported
+	}
+
+	// VACUUM INTO refuses to overwrite a file, but give a clearer error.
+	if _, err := os.Stat(dest); err == nil {
+		return ErrBackupExists
+	}
+
+	// Write the snapshot in a hidden directory next to dest and move it into
+	// place once it is complete, so a half-written backup is never mistaken
+	// for a good one.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dest), ".lms-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, "snapshot.db")
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", snapshot); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(dest, ".gz") {
+		compressed := snapshot + ".gz"
+		if err := gzipFile(snapshot, compressed); err != nil {
+			return err
+		}
+		snapshot = compressed
+	}
+
+	return os.Rename(snapshot, dest)
+}
+
+// gzipFile writes a gzip-compressed copy of src to dest.
+func gzipFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Restore replaces the SQLite database at dsn with the backup in src, which
+// may be gzip-compressed. fsys holds the migrations of this version of the
+// application.
+//
+// The backup is checked with PRAGMA integrity_check and its schema version is
+// compared with fsys before anything is replaced. An older schema is fine: the
+// pending migrations are applied the next time the server starts. The current
+// database is kept next to the restored one with a .pre-restore suffix.
+//
+// Restore refuses to run while another process has the database open.
+func Restore(ctx context.Context, src, dsn string, fsys fs.FS) (string, error) {
+	if DialectFromDSN(dsn) != SQLite {
+		return "", ErrBackupUnsupported
+	}
+	path := SQLitePath(dsn)
+
+	// Unpack the backup next to the database so the final rename is atomic.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".lms-restore-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	candidate := filepath.Join(tmpDir, "restore.db")
+	if err := copyBackup(src, candidate); err != nil {
+		return "", fmt.Errorf("failed to read backup: %w", err)
+	}
+	if err := checkBackup(ctx, candidate, fsys); err != nil {
+		return "", err
+	}
+
+	// Keep the current database, along with its write-ahead log.
+	var kept string
+	if _, err := os.Stat(path); err == nil {
+		if err := checkNotInUse(ctx, path); err != nil {
+			return "", err
+		}
+
+		kept = fmt.Sprintf("%s.pre-restore-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+		if _, err := os.Stat(kept); err == nil {
+			return "", fmt.Errorf("%s already exists", kept)
+		}
+		if err := moveDatabase(path, kept); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Rename(candidate, path); err != nil {
+		if kept != "" {
+			// Put the current database back rather than leave none.
+			if err := moveDatabase(kept, path); err != nil {
+				return "", fmt.Errorf("failed to move %s back: %w", kept, err)
+			}
+		}
+		return "", err
+	}
+	return kept, nil
+}
+
+// moveDatabase renames the SQLite database at from, along with its
+// write-ahead log and shared memory files, to to. If a rename fails, the
+// files already renamed are moved back, so the database is never split
+// from its log.
+func moveDatabase(from, to string) error {
+	var moved []string
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(from+suffix, to+suffix)
+		if suffix != "" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for i := len(moved) - 1; i >= 0; i-- {
+				if rerr := os.Rename(to+moved[i], from+moved[i]); rerr != nil {
+					return fmt.Errorf("%w; moving %s back also failed: %v", err, to+moved[i], rerr)
+				}
+			}
+			return err
+		}
+		moved = append(moved, suffix)
+	}
+	return nil
+}
+
+// SQLitePath returns the file path of the SQLite database in dsn.
+func SQLitePath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "sqlite://")
+	path, _, _ = strings.Cut(path, "?")
+	return strings.TrimPrefix(path, "file:")
+}
+
+// copyBackup copies the backup in src to dest, decompressing it if needed.
+func copyBackup(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Detect gzip by its magic number rather than the file name.
+	br := bufio.NewReader(in)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// checkBackup verifies that the SQLite database at path is intact and that
+// its schema is one this version of the application can use.
+func checkBackup(ctx context.Context, path string, fsys fs.FS) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("backup is not a valid SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup failed the integrity check: %s", result)
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+	known := make(map[int64]*Migration)
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("backup has no schema version: %w", err)
+	}
+	for version, a := range applied {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w (schema version %d)", ErrSchemaTooNew, version)
+		}
+		if m.Checksum != a.checksum {
+			return fmt.Errorf("%w: migration %d in the backup", ErrChecksumMismatch, version)
+		}
+	}
+	return nil
+}
+
+// checkNotInUse returns ErrDatabaseInUse if another connection has the
+// SQLite database at path open.
+func checkNotInUse(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", path+"?_locking_mode=EXCLUSIVE&_txlock=exclusive&_busy_timeout=0")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// An exclusive transaction can't start while anyone else has the database
+	// open in WAL mode, and with no busy timeout it fails straight away.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w (%v)", ErrDatabaseInUse, err)
+	}
+	return tx.Rollback()
+}
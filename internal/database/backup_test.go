@@ -0,0 +1,162 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/database"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// newBackupSource returns a migrated SQLite database with one author in it.
+func newBackupSource(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestSQLite(t)
+	if err := database.ApplyMigrations(db, testMigrations()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO authors (id, name) VALUES (1, 'Ann')"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// authorName returns the name of author 1 in the SQLite database at path.
+func authorName(t *testing.T, path string) string {
+	t.Helper()
+	db, err := database.NewDB(path, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.QueryRow("SELECT name FROM authors WHERE id = 1").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	db := newBackupSource(t)
+	dir := t.TempDir()
+
+	for _, name := range []string{"lms.db", "lms.db.gz"} {
+		dest := filepath.Join(dir, name)
+		if err := database.Backup(ctx, db, dest); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := database.Backup(ctx, db, dest); !errors.Is(err, database.ErrBackupExists) {
+			t.Errorf("%s: second backup returned %v, want ErrBackupExists", name, err)
+		}
+	}
+
+	// Only the backups are left; the snapshots are staged elsewhere.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("backup directory has %d entries, want 2", len(entries))
+	}
+
+	gz, err := os.ReadFile(filepath.Join(dir, "lms.db.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gz) < 2 || gz[0] != 0x1f || gz[1] != 0x8b {
+		t.Error("lms.db.gz is not gzip-compressed")
+	}
+	if got := authorName(t, filepath.Join(dir, "lms.db")); got != "Ann" {
+		t.Errorf("backup has author %q, want Ann", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range []string{"backup.db", "backup.db.gz"} {
+		t.Run(name, func(t *testing.T) {
+			db := newBackupSource(t)
+			backup := filepath.Join(t.TempDir(), name)
+			if err := database.Backup(ctx, db, backup); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			// Change the database after the backup, then restore it.
+			dsn := filepath.Join(t.TempDir(), "lms.db")
+			current, err := database.NewDB(dsn, testOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := database.ApplyMigrations(current, testMigrations()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := current.Exec("INSERT INTO authors (id, name) VALUES (1, 'Bob')"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := database.Restore(ctx, backup, dsn, testMigrations()); !errors.Is(err, database.ErrDatabaseInUse) {
+				t.Fatalf("restore while open returned %v, want ErrDatabaseInUse", err)
+			}
+			current.Close()
+
+			kept, err := database.Restore(ctx, backup, dsn, testMigrations())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := authorName(t, dsn); got != "Ann" {
+				t.Errorf("restored database has author %q, want Ann", got)
+			}
+			if got := authorName(t, kept); got != "Bob" {
+				t.Errorf("kept database has author %q, want Bob", got)
+			}
+		})
+	}
+}
+
+func TestRestoreChecksBackup(t *testing.T) {
+	ctx := context.Background()
+	db := newBackupSource(t)
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	if err := database.Backup(ctx, db, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	older := migrationsUpTo(t, testMigrations(), 2)
+	changed := testMigrations()
+	changed["002_create_books.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE books (id INTEGER PRIMARY KEY);")}
+
+	tests := []struct {
+		name    string
+		src     string
+		fsys    fstest.MapFS
+		wantErr error
+	}{
+		{"not a database", garbage, testMigrations(), nil},
+		{"newer schema", backup, older, database.ErrSchemaTooNew},
+		{"changed migration", backup, changed, database.ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := filepath.Join(t.TempDir(), "lms.db")
+			_, err := database.Restore(ctx, tt.src, dsn, tt.fsys)
+			if err == nil {
+				t.Fatal("restore succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("restore returned %v, want %v", err, tt.wantErr)
+			}
+			// Nothing is written when the backup is refused.
+			if _, err := os.Stat(dsn); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s was created", dsn)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"lms/internal/database"
+	"lms/migrations"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchStudents is the number of students submitting answers. Each
+// goroutine of the benchmark picks the next one in turn.
+const benchStudents = 16
+
+// BenchmarkParallelSubmissions measures how many MCQ submissions, each
+// followed by the completion of its lesson as the /mcqs/{id}/submit and
+// /lessons/{id}/complete handlers do, a WAL database handles when students
+// submit at the same time.
+//
+// The writer case sends writes through the single-connection pool of
+// NewWriteDB, as the server does; the shared-pool case sends them through
+// the read pool, where SQLite makes concurrent writers fail with "database
+// is locked". Failed submissions are reported as errors/op.
+//
+// Compare the two with, for example:
+//
+//	go test ./internal/database -run '^$' -bench ParallelSubmissions -cpu 1,4,16
+func BenchmarkParallelSubmissions(b *testing.B) {
+	b.Run("writer", func(b *testing.B) { benchmarkSubmissions(b, true) })
+	b.Run("shared-pool", func(b *testing.B) { benchmarkSubmissions(b, false) })
+}
+
+func benchmarkSubmissions(b *testing.B, separateWriter bool) {
+	ctx := context.Background()
+	dsn := filepath.Join(b.TempDir(), "bench.db")
+	// The defaults of the database.* settings.
+	opts := database.Options{
+		MaxOpenConns:    25,
+		MaxIdleConns:    10,
+		ConnMaxLifetime: time.Hour,
+		BusyTimeout:     5 * time.Second,
+	}
+
+	db, err := database.NewDB(dsn, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	var writer *sql.DB
+	if separateWriter {
+		writer, err = database.NewWriteDB(dsn, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer writer.Close()
+	}
+	if err := database.ApplyMigrations(db, migrations.SQLite()); err != nil {
+		b.Fatal(err)
+	}
+	store := database.NewSQLStore(db, writer)
+
+	var userIDs []int64
+	for i := range benchStudents {
+		user, err := store.CreateUser(ctx, fmt.Sprintf("student%d", i+1), "password", "student")
+		if err != nil {
+			b.Fatal(err)
+		}
+		userIDs = append(userIDs, user.ID)
+	}
+
+	// Each student answers an MCQ once, so every run gets a new course
+	// with enough lessons for b.N submissions.
+	type benchMCQ struct{ id, lessonID int64 }
+	var mcqs []benchMCQ
+	err = store.WithTx(ctx, func(tx database.Store) error {
+		course, err := tx.CreateCourse(ctx, "Benchmark", "Scratch course")
+		if err != nil {
+			return err
+		}
+		for i := range b.N/benchStudents + 1 {
+			lesson, err := tx.CreateLesson(ctx, course.ID, fmt.Sprintf("Lesson %d", i+1), i+1)
+			if err != nil {
+				return err
+			}
+			mcq, err := tx.CreateMCQ(ctx, lesson.ID, "Pick one", []string{"a", "b", "c", "d"}, 0)
+			if err != nil {
+				return err
+			}
+			mcqs = append(mcqs, benchMCQ{mcq.ID, lesson.ID})
+		}
+		for _, userID := range userIDs {
+			if err := tx.EnrollStudentInCourse(ctx, userID, course.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var next, failed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1) - 1
+			userID, mcq := userIDs[n%benchStudents], mcqs[n/benchStudents]
+			_, err := store.SubmitMCQ(ctx, userID, mcq.id, 0)
+			if err == nil {
+				err = store.MarkLessonAsComplete(ctx, userID, mcq.lessonID)
+			}
+			if err != nil {
+				failed.Add(1)
+			}
+		}
+	})
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N-int(failed.Load()))/b.Elapsed().Seconds(), "submissions/s")
+	b.ReportMetric(float64(failed.Load())/float64(b.N), "errors/op")
+}
@@ -1,7 +1,7 @@
 package database
 
 import (
-	"database/sql"
+	"context"
 	"lms/internal/models"
 	"time"
 
@@ -11,12 +11,8 @@ import (
 // --- Course Functions ---
 
 // CreateCourse creates a new course in the database.
-func CreateCourse(db *sql.DB, title, description string) (*models.Course, error) {
-	result, err := db.Exec("INSERT INTO courses (title, description) VALUES (?, ?)", title, description)
-	if err != nil {
-		return nil, err
-	}
-	id, err := result.LastInsertId()
+func (s *SQLStore) CreateCourse(ctx context.Context, title, description string) (*models.Course, error) {
+	id, err := s.insert(ctx, "INSERT INTO courses (title, description) VALUES (?, ?)", title, description)
 	if err != nil {
 		return nil, err
 	}
@@ -24,8 +20,8 @@ func CreateCourse(db *sql.DB, title, description string) (*models.Course, error)
 }
 
 // GetCourse retrieves a single course by its ID.
-func GetCourse(db *sql.DB, id int64) (*models.Course, error) {
-	row := db.QueryRow("SELECT id, title, description FROM courses WHERE id = ?", id)
+func (s *SQLStore) GetCourse(ctx context.Context, id int64) (*models.Course, error) {
+	row := s.queryRow(ctx, "SELECT id, title, description FROM courses WHERE id = ?", id)
 	course := &models.Course{}
 	err := row.Scan(&course.ID, &course.Title, &course.Description)
 	if err != nil {
@@ -35,8 +31,8 @@ func GetCourse(db *sql.DB, id int64) (*models.Course, error) {
 }
 
 // GetAllCourses retrieves all courses from the database.
-func GetAllCourses(db *sql.DB) ([]*models.Course, error) {
-	rows, err := db.Query("SELECT id, title, description FROM courses")
+func (s *SQLStore) GetAllCourses(ctx context.Context) ([]*models.Course, error) {
+	rows, err := s.query(ctx, "SELECT id, title, description FROM courses")
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +52,8 @@ func GetAllCourses(db *sql.DB) ([]*models.Course, error) {
 // --- Lesson Functions ---
 
 // CreateLesson creates a new lesson for a course.
-func CreateLesson(db *sql.DB, courseID int64, title string, position int) (*models.Lesson, error) {
-	result, err := db.Exec("INSERT INTO lessons (course_id, title, position) VALUES (?, ?, ?)", courseID, title, position)
-	if err != nil {
-		return nil, err
-	}
-	id, err := result.LastInsertId()
+func (s *SQLStore) CreateLesson(ctx context.Context, courseID int64, title string, position int) (*models.Lesson, error) {
+	id, err := s.insert(ctx, "INSERT INTO lessons (course_id, title, position) VALUES (?, ?, ?)", courseID, title, position)
 	if err != nil {
 		return nil, err
 	}
@@ -69,8 +61,8 @@ func CreateLesson(db *sql.DB, courseID int64, title string, position int) (*mode
 }
 
 // GetLessonsForCourse retrieves all lessons for a given course, ordered by position.
-func GetLessonsForCourse(db *sql.DB, courseID int64) ([]*models.Lesson, error) {
-	rows, err := db.Query("SELECT id, course_id, title, position FROM lessons WHERE course_id = ? ORDER BY position ASC", courseID)
+func (s *SQLStore) GetLessonsForCourse(ctx context.Context, courseID int64) ([]*models.Lesson, error) {
+	rows, err := s.query(ctx, "SELECT id, course_id, title, position FROM lessons WHERE course_id = ? ORDER BY position ASC", courseID)
 	if err != nil {
 		return nil, err
 	}
@@ -90,14 +82,14 @@ func GetLessonsForCourse(db *sql.DB, courseID int64) ([]*models.Lesson, error) {
 // --- Enrollment Functions ---
 
 // EnrollStudentInCourse enrolls a student in a course.
-func EnrollStudentInCourse(db *sql.DB, userID, courseID int64) error {
-	_, err := db.Exec("INSERT INTO enrollments (user_id, course_id) VALUES (?, ?)", userID, courseID)
+func (s *SQLStore) EnrollStudentInCourse(ctx context.Context, userID, courseID int64) error {
+	_, err := s.exec(ctx, "INSERT INTO enrollments (user_id, course_id) VALUES (?, ?)", userID, courseID)
 	return err
 }
 
 // GetEnrolledCoursesForStudent retrieves all courses a student is enrolled in.
-func GetEnrolledCoursesForStudent(db *sql.DB, userID int64) ([]*models.Course, error) {
-	rows, err := db.Query(`
+func (s *SQLStore) GetEnrolledCoursesForStudent(ctx context.Context, userID int64) ([]*models.Course, error) {
+	rows, err := s.query(ctx, `
 		SELECT c.id, c.title, c.description
 		FROM courses c
 		JOIN enrollments e ON c.id = e.course_id
@@ -118,26 +110,57 @@ func GetEnrolledCoursesForStudent(db *sql.DB, userID int64) ([]*models.Course, e
 	return courses, nil
 }
 
-
-// --- Certificate Functions ---
-
-// CreateCertificate generates a new unique certificate for a user and course.
-func CreateCertificate(db *sql.DB, userID, courseID int64) (*models.Certificate, error) {
-	token := uuid.New().String()
-	result, err := db.Exec("INSERT INTO certificates (user_id, course_id, token) VALUES (?, ?, ?)", userID, courseID, token)
+// GetEnrolledStudents retrieves the users enrolled in a course, by username.
+func (s *SQLStore) GetEnrolledStudents(ctx context.Context, courseID int64) ([]*models.User, error) {
+	rows, err := s.query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id IN (SELECT user_id FROM enrollments WHERE course_id = ?)
+		ORDER BY username`, courseID)
 	if err != nil {
 		return nil, err
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
 	}
+	return users, rows.Err()
+}
+
 
-	// We need to get the issued_at timestamp from the database.
-	// Let's retrieve the certificate we just created.
+// --- Certificate Functions ---
+
+// CreateCertificate generates a new unique certificate for a user and course.
+// The certificate keeps the user's current full name, so later changes to the
+// profile don't alter it. Everything runs in one transaction.
+func (s *SQLStore) CreateCertificate(ctx context.Context, userID, courseID int64) (*models.Certificate, error) {
 	cert := &models.Certificate{}
-	row := db.QueryRow("SELECT id, user_id, course_id, token, issued_at FROM certificates WHERE id = ?", id)
-	err = row.Scan(&cert.ID, &cert.UserID, &cert.CourseID, &cert.Token, &cert.IssuedAt)
+	err := s.withTx(ctx, func(tx *SQLStore) error {
+		user, err := tx.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		token := uuid.New().String()
+		id, err := tx.insert(ctx,
+			"INSERT INTO certificates (user_id, course_id, token, student_name) VALUES (?, ?, ?, ?)",
+			userID, courseID, token, user.CertificateName(),
+		)
+		if err != nil {
+			return err
+		}
+
+		// We need to get the issued_at timestamp from the database.
+		// Let's retrieve the certificate we just created.
+		row := tx.queryRow(ctx, "SELECT id, user_id, course_id, token, issued_at, student_name FROM certificates WHERE id = ?", id)
+		return row.Scan(&cert.ID, &cert.UserID, &cert.CourseID, &cert.Token, &cert.IssuedAt, &cert.StudentName)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -147,14 +170,14 @@ func CreateCertificate(db *sql.DB, userID, courseID int64) (*models.Certificate,
 // --- Lesson Completion Functions ---
 
 // MarkLessonAsComplete records that a user has completed a lesson.
-func MarkLessonAsComplete(db *sql.DB, userID, lessonID int64) error {
-	_, err := db.Exec("INSERT INTO lesson_completions (user_id, lesson_id) VALUES (?, ?)", userID, lessonID)
+func (s *SQLStore) MarkLessonAsComplete(ctx context.Context, userID, lessonID int64) error {
+	_, err := s.exec(ctx, "INSERT INTO lesson_completions (user_id, lesson_id) VALUES (?, ?)", userID, lessonID)
 	return err
 }
 
 // GetCompletedLessonsForUser returns a map of completed lesson IDs for a user in a specific course.
-func GetCompletedLessonsForUser(db *sql.DB, userID, courseID int64) (map[int64]bool, error) {
-	rows, err := db.Query(`
+func (s *SQLStore) GetCompletedLessonsForUser(ctx context.Context, userID, courseID int64) (map[int64]bool, error) {
+	rows, err := s.query(ctx, `
 		SELECT lc.lesson_id
 		FROM lesson_completions lc
 		JOIN lessons l ON lc.lesson_id = l.id
@@ -176,9 +199,9 @@ func GetCompletedLessonsForUser(db *sql.DB, userID, courseID int64) (map[int64]b
 }
 
 // IsCourseComplete checks if a user has completed all lessons in a course.
-func IsCourseComplete(db *sql.DB, userID, courseID int64) (bool, error) {
+func (s *SQLStore) IsCourseComplete(ctx context.Context, userID, courseID int64) (bool, error) {
 	// Get all lesson IDs for the course.
-	rows, err := db.Query("SELECT id FROM lessons WHERE course_id = ?", courseID)
+	rows, err := s.query(ctx, "SELECT id FROM lessons WHERE course_id = ?", courseID)
 	if err != nil {
 		return false, err
 	}
@@ -197,7 +220,7 @@ func IsCourseComplete(db *sql.DB, userID, courseID int64) (bool, error) {
 	}
 
 	// Get all completed lesson IDs for the user in this course.
-	completedLessons, err := GetCompletedLessonsForUser(db, userID, courseID)
+	completedLessons, err := s.GetCompletedLessonsForUser(ctx, userID, courseID)
 	if err != nil {
 		return false, err
 	}
@@ -216,8 +239,8 @@ func IsCourseComplete(db *sql.DB, userID, courseID int64) (bool, error) {
 	return true, nil
 }
 
-func GetLesson(db *sql.DB, id int64) (*models.Lesson, error) {
-	row := db.QueryRow("SELECT id, course_id, title, position FROM lessons WHERE id = ?", id)
+func (s *SQLStore) GetLesson(ctx context.Context, id int64) (*models.Lesson, error) {
+	row := s.queryRow(ctx, "SELECT id, course_id, title, position FROM lessons WHERE id = ?", id)
 	lesson := &models.Lesson{}
 	err := row.Scan(&lesson.ID, &lesson.CourseID, &lesson.Title, &lesson.Position)
 	if err != nil {
@@ -227,9 +250,9 @@ func GetLesson(db *sql.DB, id int64) (*models.Lesson, error) {
 }
 
 // IsLessonComplete checks if a user has completed a specific lesson.
-func IsLessonComplete(db *sql.DB, userID, lessonID int64) (bool, error) {
+func (s *SQLStore) IsLessonComplete(ctx context.Context, userID, lessonID int64) (bool, error) {
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM lesson_completions WHERE user_id = ? AND lesson_id = ?)", userID, lessonID).Scan(&exists)
+	err := s.queryRow(ctx, "SELECT EXISTS(SELECT 1 FROM lesson_completions WHERE user_id = ? AND lesson_id = ?)", userID, lessonID).Scan(&exists)
 	return exists, err
 }
 
@@ -237,15 +260,14 @@ func IsLessonComplete(db *sql.DB, userID, lessonID int64) (bool, error) {
 type CertificateDetails struct {
 	Token       string
 	IssuedAt    time.Time
-	StudentName string
+	StudentName string // As it was when the certificate was issued.
 	CourseTitle string
 }
 
-func GetCertificateDetailsByToken(db *sql.DB, token string) (*CertificateDetails, error) {
-	row := db.QueryRow(`
-        SELECT c.token, c.issued_at, u.username, co.title
+func (s *SQLStore) GetCertificateDetailsByToken(ctx context.Context, token string) (*CertificateDetails, error) {
+	row := s.queryRow(ctx, `
+        SELECT c.token, c.issued_at, c.student_name, co.title
         FROM certificates c
-        JOIN users u ON c.user_id = u.id
         JOIN courses co ON c.course_id = co.id
         WHERE c.token = ?`, token)
 
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+)
+
+// AddCourseStaff puts a user on the staff of a course with the given role,
+// or changes their role if they already are.
+func (s *SQLStore) AddCourseStaff(ctx context.Context, courseID, userID int64, role string) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO course_staff (course_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT (course_id, user_id) DO UPDATE SET role = excluded.role`,
+		courseID, userID, role,
+	)
+	return err
+}
+
+// GetCourseStaff retrieves the staff of a course, instructors first, then
+// by username.
+func (s *SQLStore) GetCourseStaff(ctx context.Context, courseID int64) ([]*models.CourseStaff, error) {
+	rows, err := s.query(ctx, `
+		SELECT cs.course_id, cs.user_id, u.username, cs.role, cs.created_at
+		FROM course_staff cs
+		JOIN users u ON u.id = cs.user_id
+		WHERE cs.course_id = ?
+		ORDER BY cs.role = 'ta', u.username`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var staff []*models.CourseStaff
+	for rows.Next() {
+		m := &models.CourseStaff{}
+		if err := rows.Scan(&m.CourseID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		staff = append(staff, m)
+	}
+	return staff, rows.Err()
+}
+
+// GetCourseStaffMember retrieves a user's place on the staff of a course.
+func (s *SQLStore) GetCourseStaffMember(ctx context.Context, courseID, userID int64) (*models.CourseStaff, error) {
+	m := &models.CourseStaff{}
+	err := s.queryRow(ctx, `
+		SELECT cs.course_id, cs.user_id, u.username, cs.role, cs.created_at
+		FROM course_staff cs
+		JOIN users u ON u.id = cs.user_id
+		WHERE cs.course_id = ? AND cs.user_id = ?`, courseID, userID,
+	).Scan(&m.CourseID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetStaffCourses retrieves the courses a user is on the staff of, by title.
+func (s *SQLStore) GetStaffCourses(ctx context.Context, userID int64) ([]*models.Course, error) {
+	rows, err := s.query(ctx, `
+		SELECT c.id, c.title, c.description
+		FROM courses c
+		JOIN course_staff cs ON cs.course_id = c.id
+		WHERE cs.user_id = ?
+		ORDER BY c.title`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []*models.Course
+	for rows.Next() {
+		course := &models.Course{}
+		if err := rows.Scan(&course.ID, &course.Title, &course.Description); err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+// RemoveCourseStaff takes a user off the staff of a course. It returns
+// sql.ErrNoRows if they aren't on its staff.
+func (s *SQLStore) RemoveCourseStaff(ctx context.Context, courseID, userID int64) error {
+	result, err := s.exec(ctx, "DELETE FROM course_staff WHERE course_id = ? AND user_id = ?", courseID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
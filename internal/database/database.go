@@ -2,55 +2,150 @@ package database
 
 import (
 	"database/sql"
-	"os"
-	"path/filepath"
-	"sort"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3" // Import the sqlite3 driver
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib" // Import the pgx driver, registered as "pgx"
+	"github.com/mattn/go-sqlite3"    // Import the sqlite3 driver
 )
 
-// NewDB creates a new database connection.
-func NewDB(dsn string) (*sql.DB, error) {
-	// Open the database connection.
-	// The DSN (Data Source Name) for SQLite is just the file path.
-	db, err := sql.Open("sqlite3", dsn)
+// Dialect identifies the SQL database backend.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Options tunes the connection pools opened by NewDB and NewWriteDB.
+type Options struct {
+	MaxOpenConns    int           // 0 means unlimited.
+	MaxIdleConns    int           // 0 means no idle connections are kept.
+	ConnMaxLifetime time.Duration // 0 means connections are reused forever.
+	// BusyTimeout is how long a SQLite connection waits for a lock held by
+	// another connection before failing with "database is locked".
+	BusyTimeout time.Duration
+}
+
+// DialectFromDSN chooses the backend from the scheme of the DSN.
+// postgres:// and postgresql:// URLs select PostgreSQL; anything else,
+// such as a plain file path, is a SQLite database.
+func DialectFromDSN(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
+	}
+	return SQLite
+}
+
+// DialectOf reports which backend db is connected to.
+func DialectOf(db *sql.DB) Dialect {
+	if _, ok := db.Driver().(*stdlib.Driver); ok {
+		return Postgres
+	}
+	return SQLite
+}
+
+// NewDB creates a new database connection pool.
+//
+// SQLite databases are opened in WAL mode, so readers don't block the writer,
+// with foreign keys enforced and opts.BusyTimeout applied to every connection.
+func NewDB(dsn string, opts Options) (*sql.DB, error) {
+	if DialectFromDSN(dsn) == SQLite {
+		dsn = sqliteDSN(dsn, opts, nil)
+	}
+	db, err := open(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ping the database to verify the connection is alive.
-	if err := db.Ping(); err != nil {
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	return db, nil
+}
+
+// NewWriteDB opens a pool with a single connection to the SQLite database at dsn.
+//
+// SQLite allows one writer at a time. Funnelling all writes through one
+// connection makes them queue in Go instead of failing with "database is
+// locked", and its transactions take the write lock up front (BEGIN IMMEDIATE)
+// so they can't deadlock with each other. PostgreSQL doesn't need this.
+func NewWriteDB(dsn string, opts Options) (*sql.DB, error) {
+	if DialectFromDSN(dsn) != SQLite {
+		return nil, fmt.Errorf("a separate write pool is only used with SQLite")
+	}
+	db, err := open(sqliteDSN(dsn, opts, url.Values{"_txlock": {"immediate"}}))
+	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
 	return db, nil
 }
 
-// ApplyMigrations reads all .up.sql files from a directory and applies them to the database.
-func ApplyMigrations(db *sql.DB, dir string) error {
-	// Find all migration files.
-	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
-	if err != nil {
-		return err
+// sqliteDSN turns the file path or sqlite:// URL in dsn into a go-sqlite3 DSN
+// with our connection settings. Parameters already in dsn take precedence.
+func sqliteDSN(dsn string, opts Options, extra url.Values) string {
+	// The DSN (Data Source Name) for SQLite is just the file path,
+	// optionally written as a sqlite:// URL.
+	dsn = strings.TrimPrefix(dsn, "sqlite://")
+
+	params := url.Values{
+		"_journal_mode": {"WAL"},
+		"_busy_timeout": {fmt.Sprint(opts.BusyTimeout.Milliseconds())},
+		"_foreign_keys": {"on"},
+		// NORMAL is durable in WAL mode except for the last transactions before a power loss.
+		"_synchronous": {"NORMAL"},
+	}
+	for k, v := range extra {
+		params[k] = v
 	}
 
-	// Sort the files to ensure they are applied in the correct order.
-	sort.Strings(files)
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + params.Encode()
+}
 
-	// Loop through the files and execute them.
-	for _, file := range files {
-		// Read the content of the migration file.
-		content, err := os.ReadFile(file)
-		if err != nil {
-			return err
-		}
+// open opens and pings a database, choosing the driver from the DSN.
+func open(dsn string) (*sql.DB, error) {
+	driverName := "sqlite3"
+	if DialectFromDSN(dsn) == Postgres {
+		driverName = "pgx"
+	}
 
-		// Execute the SQL script.
-		// We don't expect any rows in return from a migration.
-		if _, err := db.Exec(string(content)); err != nil {
-			return err
-		}
+	// Open the database connection.
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// Ping the database to verify the connection is alive.
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// isUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY constraint
+// violation, on either backend.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	return false
 }
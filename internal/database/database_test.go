@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{SQLite, "SELECT * FROM users WHERE id = ? AND role = ?", "SELECT * FROM users WHERE id = ? AND role = ?"},
+		{Postgres, "SELECT * FROM users WHERE id = ? AND role = ?", "SELECT * FROM users WHERE id = $1 AND role = $2"},
+		{Postgres, "INSERT INTO t (a, b, c, d, e, f, g, h, i, j) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			"INSERT INTO t (a, b, c, d, e, f, g, h, i, j) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"},
+		{Postgres, "SELECT 'é' FROM users", "SELECT 'é' FROM users"},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.dialect, tt.query); got != tt.want {
+			t.Errorf("rebind(%s, %q) = %q, want %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDialectFromDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want Dialect
+	}{
+		{"lms.db", SQLite},
+		{"/data/lms.db?_busy_timeout=1000", SQLite},
+		{"sqlite:///data/lms.db", SQLite},
+		{"postgres://lms@localhost/lms", Postgres},
+		{"postgresql://lms@localhost/lms?sslmode=disable", Postgres},
+	}
+	for _, tt := range tests {
+		if got := DialectFromDSN(tt.dsn); got != tt.want {
+			t.Errorf("DialectFromDSN(%q) = %s, want %s", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestMoveDatabase(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "lms.db"), filepath.Join(dir, "kept.db")
+	for _, name := range []string{from, from + "-wal"} {
+		if err := os.WriteFile(name, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The log can't be moved onto a directory, so the database is moved back.
+	if err := os.MkdirAll(filepath.Join(to+"-wal", "busy"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveDatabase(from, to); err == nil {
+		t.Fatal("moveDatabase succeeded")
+	}
+	for _, name := range []string{from, from + "-wal"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s was not moved back: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(to); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s exists after the failed move", to)
+	}
+
+	// Without the obstacle, both files move; there is no -shm to move.
+	if err := os.RemoveAll(to + "-wal"); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveDatabase(from, to); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{to, to + "-wal"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreateEmailVerification stores a new token that verifies email for a user.
+// Earlier tokens of the user stop working.
+func (s *SQLStore) CreateEmailVerification(ctx context.Context, userID int64, email, tokenHash string, expiresAt time.Time) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.exec(ctx, "DELETE FROM email_verifications WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx,
+			"INSERT INTO email_verifications (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)",
+			userID, email, tokenHash, expiresAt,
+		)
+		return err
+	})
+}
+
+// UseEmailVerification deletes the token and marks the address it was sent
+// to as verified, returning the ID of its user. Users awaiting verification
+// may log in from then on. It returns sql.ErrNoRows if the token doesn't
+// exist or has expired, or if the user has changed their address since it
+// was sent.
+func (s *SQLStore) UseEmailVerification(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+	var userID int64
+	err := s.withTx(ctx, func(tx *SQLStore) error {
+		var email string
+		err := tx.writeRow(ctx,
+			"DELETE FROM email_verifications WHERE token_hash = ? AND expires_at > ? RETURNING user_id, email",
+			tokenHash, now,
+		).Scan(&userID, &email)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.exec(ctx, "UPDATE users SET email_verified_at = ?, awaiting_verification = FALSE WHERE id = ? AND email = ?", now, userID, email)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	return userID, err
+}
+
+// DeleteExpiredEmailVerifications deletes the tokens that expired before
+// the given time and returns how many were deleted.
+func (s *SQLStore) DeleteExpiredEmailVerifications(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.exec(ctx, "DELETE FROM email_verifications WHERE expires_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
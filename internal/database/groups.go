@@ -0,0 +1,230 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/models"
+	"time"
+)
+
+// ErrGroupExists is returned when another group has the same name.
+var ErrGroupExists = errors.New("a group with that name already exists")
+
+// CreateGroup creates an empty group. It returns ErrGroupExists if the name
+// is taken.
+func (s *SQLStore) CreateGroup(ctx context.Context, name string) (*models.Group, error) {
+	id, err := s.insert(ctx, "INSERT INTO user_groups (name) VALUES (?)", name)
+	if isUniqueViolation(err) {
+		return nil, ErrGroupExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.Group{ID: id, Name: name, CreatedAt: time.Now().UTC()}, nil
+}
+
+// GetGroup retrieves a group by its ID.
+func (s *SQLStore) GetGroup(ctx context.Context, id int64) (*models.Group, error) {
+	g := &models.Group{}
+	err := s.queryRow(ctx, "SELECT id, name, created_at FROM user_groups WHERE id = ?", id).Scan(&g.ID, &g.Name, &g.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// GetAllGroups retrieves all groups, oldest first.
+func (s *SQLStore) GetAllGroups(ctx context.Context) ([]*models.Group, error) {
+	rows, err := s.query(ctx, "SELECT id, name, created_at FROM user_groups ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*models.Group
+	for rows.Next() {
+		g := &models.Group{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// RenameGroup changes the name of a group. It returns ErrGroupExists if
+// another group has the name.
+func (s *SQLStore) RenameGroup(ctx context.Context, id int64, name string) error {
+	result, err := s.exec(ctx, "UPDATE user_groups SET name = ? WHERE id = ?", name, id)
+	if isUniqueViolation(err) {
+		return ErrGroupExists
+	}
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteGroup deletes a group. Its members stay enrolled in its courses.
+func (s *SQLStore) DeleteGroup(ctx context.Context, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM user_groups WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetGroupMembers retrieves the members of a group, by username.
+func (s *SQLStore) GetGroupMembers(ctx context.Context, groupID int64) ([]*models.User, error) {
+	rows, err := s.query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id IN (SELECT user_id FROM group_members WHERE group_id = ?)
+		ORDER BY username`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetGroupsForUser retrieves the groups a user is a member of, by name.
+func (s *SQLStore) GetGroupsForUser(ctx context.Context, userID int64) ([]*models.Group, error) {
+	rows, err := s.query(ctx, `
+		SELECT g.id, g.name, g.created_at
+		FROM user_groups g
+		JOIN group_members m ON g.id = m.group_id
+		WHERE m.user_id = ?
+		ORDER BY g.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*models.Group
+	for rows.Next() {
+		g := &models.Group{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroupMember adds a user to a group and enrolls them in the group's
+// courses. Adding a member again does nothing.
+func (s *SQLStore) AddGroupMember(ctx context.Context, groupID, userID int64) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		_, err := tx.exec(ctx, "INSERT INTO group_members (group_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING", groupID, userID)
+		if err != nil {
+			return err
+		}
+		// SQLite needs the WHERE clause to tell the upsert from a join.
+		_, err = tx.exec(ctx, `
+			INSERT INTO enrollments (user_id, course_id)
+			SELECT ?, course_id FROM group_courses WHERE group_id = ?
+			ON CONFLICT DO NOTHING`, userID, groupID)
+		return err
+	})
+}
+
+// RemoveGroupMember removes a user from a group. They stay enrolled in the
+// group's courses, to keep their progress. It returns sql.ErrNoRows if the
+// user isn't a member.
+func (s *SQLStore) RemoveGroupMember(ctx context.Context, groupID, userID int64) error {
+	result, err := s.exec(ctx, "DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetGroupCourses retrieves the courses the members of a group are enrolled in.
+func (s *SQLStore) GetGroupCourses(ctx context.Context, groupID int64) ([]*models.Course, error) {
+	rows, err := s.query(ctx, `
+		SELECT c.id, c.title, c.description
+		FROM courses c
+		JOIN group_courses gc ON c.id = gc.course_id
+		WHERE gc.group_id = ?
+		ORDER BY c.title`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []*models.Course
+	for rows.Next() {
+		course := &models.Course{}
+		if err := rows.Scan(&course.ID, &course.Title, &course.Description); err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+// AddGroupCourse enrolls the members of a group, present and future, in a
+// course. Adding a course again does nothing.
+func (s *SQLStore) AddGroupCourse(ctx context.Context, groupID, courseID int64) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		_, err := tx.exec(ctx, "INSERT INTO group_courses (group_id, course_id) VALUES (?, ?) ON CONFLICT DO NOTHING", groupID, courseID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(ctx, `
+			INSERT INTO enrollments (user_id, course_id)
+			SELECT user_id, ? FROM group_members WHERE group_id = ?
+			ON CONFLICT DO NOTHING`, courseID, groupID)
+		return err
+	})
+}
+
+// RemoveGroupCourse stops enrolling the members of a group in a course.
+// Those already enrolled stay enrolled. It returns sql.ErrNoRows if the
+// course isn't one of the group's.
+func (s *SQLStore) RemoveGroupCourse(ctx context.Context, groupID, courseID int64) error {
+	result, err := s.exec(ctx, "DELETE FROM group_courses WHERE group_id = ? AND course_id = ?", groupID, courseID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/models"
+	"time"
+)
+
+// ErrIdentityLinked is returned when an account at an identity provider is
+// already linked to a user.
+var ErrIdentityLinked = errors.New("identity is already linked to a user")
+
+// identityColumns are the columns scanned by scanIdentity.
+const identityColumns = "id, user_id, issuer, subject, login, created_at, last_login_at"
+
+// scanIdentity scans a row selected with identityColumns.
+func scanIdentity(row interface{ Scan(...any) error }) (*models.UserIdentity, error) {
+	i := &models.UserIdentity{}
+	var lastLoginAt sql.NullTime
+	if err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Login, &i.CreatedAt, &lastLoginAt); err != nil {
+		return nil, err
+	}
+	i.LastLoginAt = lastLoginAt.Time
+	return i, nil
+}
+
+// CreateUserIdentity links the account subject at the provider issuer to
+// the user. It returns ErrIdentityLinked if the account is already linked.
+func (s *SQLStore) CreateUserIdentity(ctx context.Context, userID int64, issuer, subject, login string) (*models.UserIdentity, error) {
+	id, err := s.insert(ctx,
+		"INSERT INTO user_identities (user_id, issuer, subject, login) VALUES (?, ?, ?, ?)",
+		userID, issuer, subject, login,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrIdentityLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UserIdentity{
+		ID:        id,
+		UserID:    userID,
+		Issuer:    issuer,
+		Subject:   subject,
+		Login:     login,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// GetUserIdentity retrieves the link of the account subject at the provider issuer.
+func (s *SQLStore) GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	row := s.queryRow(ctx, "SELECT "+identityColumns+" FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject)
+	return scanIdentity(row)
+}
+
+// GetUserIdentitiesForUser retrieves the accounts linked to a user, oldest first.
+func (s *SQLStore) GetUserIdentitiesForUser(ctx context.Context, userID int64) ([]*models.UserIdentity, error) {
+	rows, err := s.query(ctx, "SELECT "+identityColumns+" FROM user_identities WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*models.UserIdentity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// GetUserIdentitiesByIssuer retrieves the accounts at the provider issuer that
+// are linked to users, oldest first.
+func (s *SQLStore) GetUserIdentitiesByIssuer(ctx context.Context, issuer string) ([]*models.UserIdentity, error) {
+	rows, err := s.query(ctx, "SELECT "+identityColumns+" FROM user_identities WHERE issuer = ? ORDER BY id", issuer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*models.UserIdentity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// UseUserIdentity records a login with the linked account, whose username
+// at the provider may have changed since the last one.
+func (s *SQLStore) UseUserIdentity(ctx context.Context, id int64, login string, now time.Time) error {
+	result, err := s.exec(ctx, "UPDATE user_identities SET login = ?, last_login_at = ? WHERE id = ?", login, now, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserIdentity unlinks an account from the user. It returns
+// sql.ErrNoRows if the user has no linked account with that ID.
+func (s *SQLStore) DeleteUserIdentity(ctx context.Context, userID, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM user_identities WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"time"
+)
+
+// invitationQuery selects the columns scanned by scanInvitation.
+const invitationQuery = `
+	SELECT i.id, i.token_hash, i.email, i.role, i.created_by, COALESCE(c.username, ''), i.created_at,
+		i.expires_at, i.accepted_at, i.user_id, COALESCE(u.username, '')
+	FROM invitations i
+	LEFT JOIN users c ON c.id = i.created_by
+	LEFT JOIN users u ON u.id = i.user_id`
+
+// scanInvitation scans a row selected with invitationQuery.
+func scanInvitation(row interface{ Scan(...any) error }) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	var createdBy, userID sql.NullInt64
+	var acceptedAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.TokenHash, &inv.Email, &inv.Role, &createdBy, &inv.CreatedByName, &inv.CreatedAt,
+		&inv.ExpiresAt, &acceptedAt, &userID, &inv.Username)
+	if err != nil {
+		return nil, err
+	}
+	inv.CreatedBy = createdBy.Int64
+	inv.AcceptedAt = acceptedAt.Time
+	inv.UserID = userID.Int64
+	return inv, nil
+}
+
+// CreateInvitation stores a new invitation, and sets its ID and creation
+// time.
+func (s *SQLStore) CreateInvitation(ctx context.Context, inv *models.Invitation) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		id, err := tx.insert(ctx,
+			"INSERT INTO invitations (token_hash, email, role, created_by, expires_at) VALUES (?, ?, ?, ?, ?)",
+			inv.TokenHash, inv.Email, inv.Role, nullID(inv.CreatedBy), inv.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+		for _, courseID := range inv.CourseIDs {
+			if _, err := tx.exec(ctx,
+				"INSERT INTO invitation_courses (invitation_id, course_id) VALUES (?, ?)",
+				id, courseID,
+			); err != nil {
+				return err
+			}
+		}
+		inv.ID = id
+		inv.CreatedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// GetInvitations retrieves every invitation, the newest first.
+func (s *SQLStore) GetInvitations(ctx context.Context) ([]*models.Invitation, error) {
+	rows, err := s.query(ctx, invitationQuery+" ORDER BY i.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*models.Invitation
+	byID := make(map[int64]*models.Invitation)
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+		byID[inv.ID] = inv
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	courseRows, err := s.query(ctx, "SELECT invitation_id, course_id FROM invitation_courses ORDER BY course_id")
+	if err != nil {
+		return nil, err
+	}
+	defer courseRows.Close()
+	for courseRows.Next() {
+		var invitationID, courseID int64
+		if err := courseRows.Scan(&invitationID, &courseID); err != nil {
+			return nil, err
+		}
+		if inv := byID[invitationID]; inv != nil {
+			inv.CourseIDs = append(inv.CourseIDs, courseID)
+		}
+	}
+	return invitations, courseRows.Err()
+}
+
+// GetInvitationByHash retrieves an invitation by the hash of its token,
+// whatever its status.
+func (s *SQLStore) GetInvitationByHash(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	inv, err := scanInvitation(s.queryRow(ctx, invitationQuery+" WHERE i.token_hash = ?", tokenHash))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, "SELECT course_id FROM invitation_courses WHERE invitation_id = ? ORDER BY course_id", inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var courseID int64
+		if err := rows.Scan(&courseID); err != nil {
+			return nil, err
+		}
+		inv.CourseIDs = append(inv.CourseIDs, courseID)
+	}
+	return inv, rows.Err()
+}
+
+// AcceptInvitation records that the user has registered with the invitation.
+// It returns sql.ErrNoRows if the invitation doesn't exist, has expired or
+// has already been accepted, so each invitation works only once. The user's
+// email address is marked as verified if the invitation was sent to it.
+func (s *SQLStore) AcceptInvitation(ctx context.Context, tokenHash string, userID int64, now time.Time) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		var email string
+		err := tx.writeRow(ctx,
+			"UPDATE invitations SET accepted_at = ?, user_id = ? WHERE token_hash = ? AND accepted_at IS NULL AND expires_at > ? RETURNING email",
+			now, userID, tokenHash, now,
+		).Scan(&email)
+		if err != nil {
+			return err
+		}
+		if email == "" {
+			return nil
+		}
+		_, err = tx.exec(ctx, "UPDATE users SET email_verified_at = ? WHERE id = ? AND email = ?", now, userID, email)
+		return err
+	})
+}
+
+// DeleteInvitation deletes an invitation that hasn't been accepted, so that
+// its link stops working. It returns sql.ErrNoRows if there is no such
+// invitation.
+func (s *SQLStore) DeleteInvitation(ctx context.Context, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM invitations WHERE id = ? AND accepted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,1863 @@
+// Package memstore provides an in-memory implementation of database.Store.
+//
+// It is meant for tests: it needs no SQLite file and mimics the behaviour of
+// the SQL store, including sql.ErrNoRows for missing records and errors for
+// duplicates that the schema would reject.
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/database"
+	"lms/internal/models"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCode identifies a recovery code of a user by its hash.
+type recoveryCode struct {
+	userID int64
+	hash   string
+}
+
+// ErrDuplicate is returned where the SQL schema has a UNIQUE or PRIMARY KEY constraint.
+var ErrDuplicate = errors.New("memstore: duplicate record")
+
+// Store is an in-memory database.Store. The zero value is not usable; call New.
+type Store struct {
+	mu   sync.Mutex
+	txMu sync.Mutex // Serialises transactions.
+
+	nextID       int64
+	users        map[int64]*models.User
+	courses      map[int64]*models.Course
+	lessons      map[int64]*models.Lesson
+	enrollments  map[[2]int64]bool                // {userID, courseID}
+	courseStaff  map[[2]int64]*models.CourseStaff // {courseID, userID}
+	completions  map[[2]int64]bool                // {userID, lessonID}
+	videos       map[int64]*models.Video
+	texts        map[int64]*models.Text
+	mcqs         map[int64]*models.MCQ
+	submissions  map[[2]int64]*models.MCQSubmission // {userID, mcqID}
+	certificates map[int64]*models.Certificate
+	throttles    map[string]*models.LoginThrottle
+	resets       map[int64]*models.PasswordReset
+	verifs       map[int64]*models.EmailVerification
+	totps        map[int64]*models.TOTP // By user ID.
+	recovery     map[recoveryCode]bool
+	passkeys     map[int64]*models.Passkey
+	identities   map[int64]*models.UserIdentity
+	apiTokens    map[int64]*models.APIToken
+	sessions     map[string]*models.UserSession
+	groups       map[int64]*models.Group
+	members      map[[2]int64]bool // {groupID, userID}
+	groupCourses map[[2]int64]bool // {groupID, courseID}
+	audit        map[int64]*models.AuditEntry
+	invitations  map[int64]*models.Invitation
+}
+
+// New creates an empty Store.
+func New() *Store {
+	return &Store{
+		users:        make(map[int64]*models.User),
+		courses:      make(map[int64]*models.Course),
+		lessons:      make(map[int64]*models.Lesson),
+		enrollments:  make(map[[2]int64]bool),
+		courseStaff:  make(map[[2]int64]*models.CourseStaff),
+		completions:  make(map[[2]int64]bool),
+		videos:       make(map[int64]*models.Video),
+		texts:        make(map[int64]*models.Text),
+		mcqs:         make(map[int64]*models.MCQ),
+		submissions:  make(map[[2]int64]*models.MCQSubmission),
+		certificates: make(map[int64]*models.Certificate),
+		throttles:    make(map[string]*models.LoginThrottle),
+		resets:       make(map[int64]*models.PasswordReset),
+		verifs:       make(map[int64]*models.EmailVerification),
+		totps:        make(map[int64]*models.TOTP),
+		recovery:     make(map[recoveryCode]bool),
+		passkeys:     make(map[int64]*models.Passkey),
+		identities:   make(map[int64]*models.UserIdentity),
+		apiTokens:    make(map[int64]*models.APIToken),
+		sessions:     make(map[string]*models.UserSession),
+		groups:       make(map[int64]*models.Group),
+		members:      make(map[[2]int64]bool),
+		groupCourses: make(map[[2]int64]bool),
+		audit:        make(map[int64]*models.AuditEntry),
+		invitations:  make(map[int64]*models.Invitation),
+	}
+}
+
+// Make sure Store satisfies the interface.
+var _ database.Store = (*Store)(nil)
+
+// WithTx implements database.Transactor.
+//
+// Transactions are serialised and rolled back by restoring a snapshot taken
+// when they began, so writes made outside the transaction while it runs are
+// lost on rollback. That is good enough for tests.
+func (s *Store) WithTx(ctx context.Context, fn func(tx database.Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	snapshot := s.clone()
+	s.mu.Unlock()
+
+	err := fn(txStore{s})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.restore(snapshot)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// txStore is the Store passed to WithTx callbacks. Nested calls join the transaction.
+type txStore struct {
+	*Store
+}
+
+func (t txStore) WithTx(ctx context.Context, fn func(tx database.Store) error) error {
+	return fn(t)
+}
+
+// clone returns a deep copy of the data. The caller must hold the lock.
+func (s *Store) clone() *Store {
+	return &Store{
+		nextID:       s.nextID,
+		users:        cloneMap(s.users),
+		courses:      cloneMap(s.courses),
+		lessons:      cloneMap(s.lessons),
+		enrollments:  maps.Clone(s.enrollments),
+		courseStaff:  cloneMap(s.courseStaff),
+		completions:  maps.Clone(s.completions),
+		videos:       cloneMap(s.videos),
+		texts:        cloneMap(s.texts),
+		mcqs:         cloneMap(s.mcqs),
+		submissions:  cloneMap(s.submissions),
+		certificates: cloneMap(s.certificates),
+		throttles:    cloneMap(s.throttles),
+		resets:       cloneMap(s.resets),
+		verifs:       cloneMap(s.verifs),
+		totps:        cloneMap(s.totps),
+		recovery:     maps.Clone(s.recovery),
+		passkeys:     cloneMap(s.passkeys),
+		identities:   cloneMap(s.identities),
+		apiTokens:    cloneMap(s.apiTokens),
+		sessions:     cloneMap(s.sessions),
+		groups:       cloneMap(s.groups),
+		members:      maps.Clone(s.members),
+		groupCourses: maps.Clone(s.groupCourses),
+		audit:        cloneMap(s.audit),
+		invitations:  cloneMap(s.invitations),
+	}
+}
+
+// restore replaces the data with a snapshot. The caller must hold the lock.
+func (s *Store) restore(snapshot *Store) {
+	s.nextID = snapshot.nextID
+	s.users = snapshot.users
+	s.courses = snapshot.courses
+	s.lessons = snapshot.lessons
+	s.enrollments = snapshot.enrollments
+	s.courseStaff = snapshot.courseStaff
+	s.completions = snapshot.completions
+	s.videos = snapshot.videos
+	s.texts = snapshot.texts
+	s.mcqs = snapshot.mcqs
+	s.submissions = snapshot.submissions
+	s.certificates = snapshot.certificates
+	s.throttles = snapshot.throttles
+	s.resets = snapshot.resets
+	s.verifs = snapshot.verifs
+	s.totps = snapshot.totps
+	s.recovery = snapshot.recovery
+	s.passkeys = snapshot.passkeys
+	s.identities = snapshot.identities
+	s.apiTokens = snapshot.apiTokens
+	s.sessions = snapshot.sessions
+	s.groups = snapshot.groups
+	s.members = snapshot.members
+	s.groupCourses = snapshot.groupCourses
+	s.audit = snapshot.audit
+	s.invitations = snapshot.invitations
+}
+
+// cloneMap copies a map of records, copying the records too.
+func cloneMap[K comparable, V any](m map[K]*V) map[K]*V {
+	c := make(map[K]*V, len(m))
+	for k, v := range m {
+		copied := *v
+		c[k] = &copied
+	}
+	return c
+}
+
+// id returns a new unique ID. The caller must hold the lock.
+func (s *Store) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// --- Users ---
+
+func (s *Store) CreateUser(ctx context.Context, username, password, role string) (*models.User, error) {
+	// The minimum cost keeps tests fast; the hash format is the same.
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			return nil, database.ErrUsernameTaken
+		}
+	}
+
+	user := &models.User{ID: s.id(), Username: username, PasswordHash: string(hash), Role: role, Timezone: "UTC"}
+	s.users[user.ID] = user
+	copied := *user
+	return &copied, nil
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	// Like the SQL store, don't hand out the password hash.
+	return withoutHash(u), nil
+}
+
+func (s *Store) GetAllUsers(ctx context.Context) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []*models.User
+	for _, u := range s.users {
+		users = append(users, withoutHash(u))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *Store) AuthenticateUser(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := s.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || !user.Active() {
+		return nil, database.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *Store) SetUserPassword(ctx context.Context, id int64, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if email != "" && u.Email == email {
+			return withoutHash(u), nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (s *Store) SetUserEmail(ctx context.Context, id int64, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	for _, other := range s.users {
+		if email != "" && other.ID != id && other.Email == email {
+			return database.ErrEmailTaken
+		}
+	}
+	if u.Email != email {
+		u.EmailVerifiedAt = time.Time{}
+	}
+	u.Email = email
+	return nil
+}
+
+func (s *Store) UpdateUserProfile(ctx context.Context, id int64, fullName, displayName, timezone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	u.FullName = fullName
+	u.DisplayName = displayName
+	u.Timezone = timezone
+	return nil
+}
+
+func (s *Store) SetUserRole(ctx context.Context, id int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+func (s *Store) SetUsername(ctx context.Context, id int64, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	for _, other := range s.users {
+		if other.ID != id && other.Username == username {
+			return database.ErrUsernameTaken
+		}
+	}
+	u.Username = username
+	return nil
+}
+
+func (s *Store) DeactivateUser(ctx context.Context, id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	if u.DeactivatedAt.IsZero() {
+		u.DeactivatedAt = now
+	}
+	return nil
+}
+
+func (s *Store) HoldForVerification(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	u.AwaitingVerification = true
+	return nil
+}
+
+func (s *Store) ReactivateUser(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	u.DeactivatedAt = time.Time{}
+	u.AwaitingVerification = false
+	return nil
+}
+
+func (s *Store) CountUserRecords(ctx context.Context, id int64) (*database.UserRecords, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := &database.UserRecords{}
+	for key := range s.enrollments {
+		if key[0] == id {
+			records.Enrollments++
+		}
+	}
+	for key := range s.completions {
+		if key[0] == id {
+			records.Completions++
+		}
+	}
+	for key := range s.submissions {
+		if key[0] == id {
+			records.Submissions++
+		}
+	}
+	for _, c := range s.certificates {
+		if c.UserID == id {
+			records.Certificates++
+		}
+	}
+	for key := range s.courseStaff {
+		if key[1] == id {
+			records.CourseStaff++
+		}
+	}
+	for _, t := range s.apiTokens {
+		if t.UserID == id {
+			records.APITokens++
+		}
+	}
+	for _, p := range s.passkeys {
+		if p.UserID == id {
+			records.Passkeys++
+		}
+	}
+	return records, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return database.ErrUserNotFound
+	}
+	delete(s.users, id)
+
+	// Delete what the foreign keys of the SQL schema would cascade to.
+	for key := range s.enrollments {
+		if key[0] == id {
+			delete(s.enrollments, key)
+		}
+	}
+	for key := range s.completions {
+		if key[0] == id {
+			delete(s.completions, key)
+		}
+	}
+	for key := range s.submissions {
+		if key[0] == id {
+			delete(s.submissions, key)
+		}
+	}
+	for key := range s.courseStaff {
+		if key[1] == id {
+			delete(s.courseStaff, key)
+		}
+	}
+	for key := range s.members {
+		if key[1] == id {
+			delete(s.members, key)
+		}
+	}
+	for key := range s.recovery {
+		if key.userID == id {
+			delete(s.recovery, key)
+		}
+	}
+	delete(s.totps, id)
+	maps.DeleteFunc(s.certificates, func(_ int64, c *models.Certificate) bool { return c.UserID == id })
+	maps.DeleteFunc(s.resets, func(_ int64, r *models.PasswordReset) bool { return r.UserID == id })
+	maps.DeleteFunc(s.verifs, func(_ int64, v *models.EmailVerification) bool { return v.UserID == id })
+	maps.DeleteFunc(s.passkeys, func(_ int64, p *models.Passkey) bool { return p.UserID == id })
+	maps.DeleteFunc(s.identities, func(_ int64, i *models.UserIdentity) bool { return i.UserID == id })
+	maps.DeleteFunc(s.apiTokens, func(_ int64, t *models.APIToken) bool { return t.UserID == id })
+	maps.DeleteFunc(s.sessions, func(_ string, us *models.UserSession) bool {
+		return us.UserID == id || us.ImpersonatorID == id
+	})
+	for _, e := range s.audit {
+		if e.ActorID == id {
+			e.ActorID = 0
+		}
+		if e.TargetID == id {
+			e.TargetID = 0
+		}
+	}
+	for _, inv := range s.invitations {
+		if inv.CreatedBy == id {
+			inv.CreatedBy = 0
+		}
+		if inv.UserID == id {
+			inv.UserID = 0
+		}
+	}
+	return nil
+}
+
+// withoutHash returns a copy of u without the password hash.
+func withoutHash(u *models.User) *models.User {
+	copied := *u
+	copied.PasswordHash = ""
+	return &copied
+}
+
+// --- Courses and lessons ---
+
+func (s *Store) CreateCourse(ctx context.Context, title, description string) (*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	course := &models.Course{ID: s.id(), Title: title, Description: description}
+	s.courses[course.ID] = course
+	copied := *course
+	return &copied, nil
+}
+
+func (s *Store) GetCourse(ctx context.Context, id int64) (*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.courses[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (s *Store) GetAllCourses(ctx context.Context) ([]*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var courses []*models.Course
+	for _, c := range s.courses {
+		copied := *c
+		courses = append(courses, &copied)
+	}
+	sort.Slice(courses, func(i, j int) bool { return courses[i].ID < courses[j].ID })
+	return courses, nil
+}
+
+func (s *Store) CreateLesson(ctx context.Context, courseID int64, title string, position int) (*models.Lesson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lesson := &models.Lesson{ID: s.id(), CourseID: courseID, Title: title, Position: position}
+	s.lessons[lesson.ID] = lesson
+	copied := *lesson
+	return &copied, nil
+}
+
+func (s *Store) GetLesson(ctx context.Context, id int64) (*models.Lesson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.lessons[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *l
+	return &copied, nil
+}
+
+func (s *Store) GetLessonsForCourse(ctx context.Context, courseID int64) ([]*models.Lesson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lessons []*models.Lesson
+	for _, l := range s.lessons {
+		if l.CourseID == courseID {
+			copied := *l
+			lessons = append(lessons, &copied)
+		}
+	}
+	sort.Slice(lessons, func(i, j int) bool { return lessons[i].Position < lessons[j].Position })
+	return lessons, nil
+}
+
+func (s *Store) EnrollStudentInCourse(ctx context.Context, userID, courseID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{userID, courseID}
+	if s.enrollments[key] {
+		return ErrDuplicate
+	}
+	s.enrollments[key] = true
+	return nil
+}
+
+func (s *Store) GetEnrolledCoursesForStudent(ctx context.Context, userID int64) ([]*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var courses []*models.Course
+	for key := range s.enrollments {
+		if c, ok := s.courses[key[1]]; ok && key[0] == userID {
+			copied := *c
+			courses = append(courses, &copied)
+		}
+	}
+	sort.Slice(courses, func(i, j int) bool { return courses[i].ID < courses[j].ID })
+	return courses, nil
+}
+
+func (s *Store) GetEnrolledStudents(ctx context.Context, courseID int64) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []*models.User
+	for key := range s.enrollments {
+		if u, ok := s.users[key[0]]; ok && key[1] == courseID {
+			users = append(users, withoutHash(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// --- Course staff ---
+
+func (s *Store) AddCourseStaff(ctx context.Context, courseID, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{courseID, userID}
+	if m, ok := s.courseStaff[key]; ok {
+		m.Role = role
+		return nil
+	}
+	s.courseStaff[key] = &models.CourseStaff{CourseID: courseID, UserID: userID, Role: role, CreatedAt: time.Now().UTC()}
+	return nil
+}
+
+func (s *Store) GetCourseStaff(ctx context.Context, courseID int64) ([]*models.CourseStaff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var staff []*models.CourseStaff
+	for key, m := range s.courseStaff {
+		if key[0] == courseID {
+			staff = append(staff, s.staffMember(m))
+		}
+	}
+	sort.Slice(staff, func(i, j int) bool {
+		if staff[i].Role != staff[j].Role {
+			return staff[j].Role == "ta"
+		}
+		return staff[i].Username < staff[j].Username
+	})
+	return staff, nil
+}
+
+func (s *Store) GetCourseStaffMember(ctx context.Context, courseID, userID int64) (*models.CourseStaff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.courseStaff[[2]int64{courseID, userID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.staffMember(m), nil
+}
+
+func (s *Store) GetStaffCourses(ctx context.Context, userID int64) ([]*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var courses []*models.Course
+	for key := range s.courseStaff {
+		if c, ok := s.courses[key[0]]; ok && key[1] == userID {
+			copied := *c
+			courses = append(courses, &copied)
+		}
+	}
+	sort.Slice(courses, func(i, j int) bool { return courses[i].Title < courses[j].Title })
+	return courses, nil
+}
+
+func (s *Store) RemoveCourseStaff(ctx context.Context, courseID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{courseID, userID}
+	if _, ok := s.courseStaff[key]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.courseStaff, key)
+	return nil
+}
+
+// staffMember returns a copy of m with the username of its user. The caller
+// must hold the lock.
+func (s *Store) staffMember(m *models.CourseStaff) *models.CourseStaff {
+	copied := *m
+	if u, ok := s.users[m.UserID]; ok {
+		copied.Username = u.Username
+	}
+	return &copied
+}
+
+// --- Content ---
+
+func (s *Store) CreateVideo(ctx context.Context, lessonID int64, title, url string) (*models.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.videos[lessonID]; ok {
+		return nil, ErrDuplicate
+	}
+	video := &models.Video{ID: s.id(), LessonID: lessonID, Title: title, VideoURL: url}
+	s.videos[lessonID] = video
+	copied := *video
+	return &copied, nil
+}
+
+func (s *Store) GetVideoByLessonID(ctx context.Context, lessonID int64) (*models.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.videos[lessonID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *v
+	return &copied, nil
+}
+
+func (s *Store) CreateText(ctx context.Context, lessonID int64, title, content string) (*models.Text, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.texts[lessonID]; ok {
+		return nil, ErrDuplicate
+	}
+	text := &models.Text{ID: s.id(), LessonID: lessonID, Title: title, Content: content}
+	s.texts[lessonID] = text
+	copied := *text
+	return &copied, nil
+}
+
+func (s *Store) GetTextByLessonID(ctx context.Context, lessonID int64) (*models.Text, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.texts[lessonID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *Store) CreateMCQ(ctx context.Context, lessonID int64, question string, options []string, correctOptionIndex int) (*models.MCQ, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.mcqs {
+		if m.LessonID == lessonID {
+			return nil, ErrDuplicate
+		}
+	}
+	mcq := &models.MCQ{
+		ID:                 s.id(),
+		LessonID:           lessonID,
+		Question:           question,
+		Options:            append([]string(nil), options...),
+		CorrectOptionIndex: correctOptionIndex,
+	}
+	s.mcqs[mcq.ID] = mcq
+	return copyMCQ(mcq), nil
+}
+
+func (s *Store) GetMCQByLessonID(ctx context.Context, lessonID int64) (*models.MCQ, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.mcqs {
+		if m.LessonID == lessonID {
+			return copyMCQ(m), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) GetMCQByID(ctx context.Context, id int64) (*models.MCQ, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mcqs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyMCQ(m), nil
+}
+
+func copyMCQ(m *models.MCQ) *models.MCQ {
+	copied := *m
+	copied.Options = append([]string(nil), m.Options...)
+	return &copied
+}
+
+// --- Progress ---
+
+func (s *Store) MarkLessonAsComplete(ctx context.Context, userID, lessonID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{userID, lessonID}
+	if s.completions[key] {
+		return ErrDuplicate
+	}
+	s.completions[key] = true
+	return nil
+}
+
+func (s *Store) IsLessonComplete(ctx context.Context, userID, lessonID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.completions[[2]int64{userID, lessonID}], nil
+}
+
+func (s *Store) GetCompletedLessonsForUser(ctx context.Context, userID, courseID int64) (map[int64]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	completed := make(map[int64]bool)
+	for key := range s.completions {
+		if l, ok := s.lessons[key[1]]; ok && key[0] == userID && l.CourseID == courseID {
+			completed[l.ID] = true
+		}
+	}
+	return completed, nil
+}
+
+func (s *Store) IsCourseComplete(ctx context.Context, userID, courseID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for _, l := range s.lessons {
+		if l.CourseID != courseID {
+			continue
+		}
+		total++
+		if !s.completions[[2]int64{userID, l.ID}] {
+			return false, nil
+		}
+	}
+	// A course with no lessons cannot be completed.
+	return total > 0, nil
+}
+
+func (s *Store) SubmitMCQ(ctx context.Context, userID, mcqID int64, selectedOptionIndex int) (*models.MCQSubmission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mcqs[mcqID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	key := [2]int64{userID, mcqID}
+	if _, ok := s.submissions[key]; ok {
+		return nil, ErrDuplicate
+	}
+
+	sub := &models.MCQSubmission{
+		ID:                  s.id(),
+		UserID:              userID,
+		MCQID:               mcqID,
+		SelectedOptionIndex: selectedOptionIndex,
+		IsCorrect:           selectedOptionIndex == m.CorrectOptionIndex,
+		SubmittedAt:         time.Now().UTC(),
+	}
+	s.submissions[key] = sub
+	copied := *sub
+	return &copied, nil
+}
+
+// --- Certificates ---
+
+func (s *Store) CreateCertificate(ctx context.Context, userID, courseID int64) (*models.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	cert := &models.Certificate{
+		ID:          s.id(),
+		UserID:      userID,
+		CourseID:    courseID,
+		Token:       uuid.New().String(),
+		IssuedAt:    time.Now().UTC(),
+		StudentName: user.CertificateName(),
+	}
+	s.certificates[cert.ID] = cert
+	copied := *cert
+	return &copied, nil
+}
+
+func (s *Store) GetCertificateDetailsByToken(ctx context.Context, token string) (*database.CertificateDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.certificates {
+		if c.Token != token {
+			continue
+		}
+		course, ok := s.courses[c.CourseID]
+		if !ok {
+			break
+		}
+		return &database.CertificateDetails{
+			Token:       c.Token,
+			IssuedAt:    c.IssuedAt,
+			StudentName: c.StudentName,
+			CourseTitle: course.Title,
+		}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// --- Login throttles ---
+
+func (s *Store) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.throttles[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, key string, now, forgetBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.throttles[key]
+	if !ok {
+		t = &models.LoginThrottle{Key: key}
+		s.throttles[key] = t
+	}
+	if t.LastFailureAt.Before(forgetBefore) {
+		t.Failures = 0
+	}
+	t.Failures++
+	t.LastFailureAt = now
+	return t.Failures, nil
+}
+
+func (s *Store) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.throttles[key]; ok {
+		t.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *Store) DeleteLoginThrottle(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.throttles, key)
+	return nil
+}
+
+func (s *Store) DeleteStaleLoginThrottles(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, t := range s.throttles {
+		if t.LastFailureAt.Before(before) && t.LockedUntil.Before(before) {
+			delete(s.throttles, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Password resets ---
+
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, pr := range s.resets {
+		if pr.UserID == userID {
+			delete(s.resets, id)
+		}
+	}
+	pr := &models.PasswordReset{ID: s.id(), UserID: userID, TokenHash: tokenHash, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	s.resets[pr.ID] = pr
+	return nil
+}
+
+func (s *Store) GetPasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pr := range s.resets {
+		if pr.TokenHash == tokenHash {
+			copied := *pr
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) UsePasswordReset(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pr := range s.resets {
+		if pr.TokenHash == tokenHash && pr.UsedAt.IsZero() && pr.ExpiresAt.After(now) {
+			pr.UsedAt = now
+			return pr.UserID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *Store) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, pr := range s.resets {
+		if pr.ExpiresAt.Before(before) {
+			delete(s.resets, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Email verifications ---
+
+func (s *Store) CreateEmailVerification(ctx context.Context, userID int64, email, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, v := range s.verifs {
+		if v.UserID == userID {
+			delete(s.verifs, id)
+		}
+	}
+	v := &models.EmailVerification{ID: s.id(), UserID: userID, Email: email, TokenHash: tokenHash, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	s.verifs[v.ID] = v
+	return nil
+}
+
+func (s *Store) UseEmailVerification(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, v := range s.verifs {
+		if v.TokenHash != tokenHash || !v.ExpiresAt.After(now) {
+			continue
+		}
+		u, ok := s.users[v.UserID]
+		if !ok || u.Email != v.Email {
+			break
+		}
+		delete(s.verifs, id)
+		u.EmailVerifiedAt = now
+		u.AwaitingVerification = false
+		return u.ID, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *Store) DeleteExpiredEmailVerifications(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, v := range s.verifs {
+		if v.ExpiresAt.Before(before) {
+			delete(s.verifs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Two-factor authentication ---
+
+func (s *Store) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *Store) SetPendingTOTP(ctx context.Context, userID int64, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.totps[userID]; ok && t.Enabled() {
+		return ErrDuplicate
+	}
+	if _, ok := s.users[userID]; !ok {
+		return errors.New("memstore: no such user")
+	}
+	s.totps[userID] = &models.TOTP{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+func (s *Store) ConfirmTOTP(ctx context.Context, userID, step int64, codeHashes []string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok || t.Enabled() || t.LastUsedStep >= step {
+		return sql.ErrNoRows
+	}
+	t.ConfirmedAt = now
+	t.LastUsedStep = step
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok || !t.Enabled() || t.LastUsedStep >= step {
+		return sql.ErrNoRows
+	}
+	t.LastUsedStep = step
+	return nil
+}
+
+func (s *Store) DeleteTOTP(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totps, userID)
+	s.replaceRecoveryCodes(userID, nil)
+	return nil
+}
+
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+// replaceRecoveryCodes is ReplaceRecoveryCodes for callers that hold the lock.
+func (s *Store) replaceRecoveryCodes(userID int64, codeHashes []string) {
+	for c := range s.recovery {
+		if c.userID == userID {
+			delete(s.recovery, c)
+		}
+	}
+	for _, h := range codeHashes {
+		s.recovery[recoveryCode{userID, h}] = true
+	}
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := recoveryCode{userID, codeHash}
+	if !s.recovery[c] {
+		return sql.ErrNoRows
+	}
+	delete(s.recovery, c)
+	return nil
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for c := range s.recovery {
+		if c.userID == userID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Passkeys ---
+
+func (s *Store) CreatePasskey(ctx context.Context, userID int64, credentialID, publicKey []byte, signCount uint32, name string) (*models.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return nil, database.ErrPasskeyExists
+		}
+	}
+	p := &models.Passkey{
+		ID:           s.id(),
+		UserID:       userID,
+		CredentialID: bytes.Clone(credentialID),
+		PublicKey:    bytes.Clone(publicKey),
+		SignCount:    signCount,
+		Name:         name,
+		CreatedAt:    time.Now(),
+	}
+	s.passkeys[p.ID] = p
+	copied := *p
+	return &copied, nil
+}
+
+func (s *Store) GetPasskeysForUser(ctx context.Context, userID int64) ([]*models.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var passkeys []*models.Passkey
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			copied := *p
+			passkeys = append(passkeys, &copied)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].ID < passkeys[j].ID })
+	return passkeys, nil
+}
+
+func (s *Store) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*models.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			copied := *p
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) UsePasskey(ctx context.Context, id int64, signCount uint32, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.passkeys[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	p.SignCount = signCount
+	p.LastUsedAt = now
+	return nil
+}
+
+func (s *Store) DeletePasskey(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.passkeys[id]
+	if !ok || p.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(s.passkeys, id)
+	return nil
+}
+
+// --- Linked identities ---
+
+func (s *Store) CreateUserIdentity(ctx context.Context, userID int64, issuer, subject, login string) (*models.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			return nil, database.ErrIdentityLinked
+		}
+	}
+	i := &models.UserIdentity{
+		ID:        s.id(),
+		UserID:    userID,
+		Issuer:    issuer,
+		Subject:   subject,
+		Login:     login,
+		CreatedAt: time.Now(),
+	}
+	s.identities[i.ID] = i
+	copied := *i
+	return &copied, nil
+}
+
+func (s *Store) GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			copied := *i
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) GetUserIdentitiesForUser(ctx context.Context, userID int64) ([]*models.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var identities []*models.UserIdentity
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			copied := *i
+			identities = append(identities, &copied)
+		}
+	}
+	sort.Slice(identities, func(a, b int) bool { return identities[a].ID < identities[b].ID })
+	return identities, nil
+}
+
+func (s *Store) GetUserIdentitiesByIssuer(ctx context.Context, issuer string) ([]*models.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var identities []*models.UserIdentity
+	for _, i := range s.identities {
+		if i.Issuer == issuer {
+			copied := *i
+			identities = append(identities, &copied)
+		}
+	}
+	sort.Slice(identities, func(a, b int) bool { return identities[a].ID < identities[b].ID })
+	return identities, nil
+}
+
+func (s *Store) UseUserIdentity(ctx context.Context, id int64, login string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.identities[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	i.Login = login
+	i.LastLoginAt = now
+	return nil
+}
+
+func (s *Store) DeleteUserIdentity(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.identities[id]
+	if !ok || i.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(s.identities, id)
+	return nil
+}
+
+// --- API tokens ---
+
+func (s *Store) CreateAPIToken(ctx context.Context, userID int64, name, tokenHash string, scopes []string, twoFactorVerified bool, expiresAt time.Time) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := &models.APIToken{
+		ID:                s.id(),
+		UserID:            userID,
+		Name:              name,
+		TokenHash:         tokenHash,
+		Scopes:            slices.Clone(scopes),
+		TwoFactorVerified: twoFactorVerified,
+		CreatedAt:         time.Now(),
+		ExpiresAt:         expiresAt,
+	}
+	s.apiTokens[t.ID] = t
+	copied := *t
+	return &copied, nil
+}
+
+func (s *Store) GetAPITokensForUser(ctx context.Context, userID int64) ([]*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []*models.APIToken
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			copied := *t
+			tokens = append(tokens, &copied)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (s *Store) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.apiTokens {
+		if t.TokenHash == tokenHash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) UseAPIToken(ctx context.Context, id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.apiTokens[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	t.LastUsedAt = now
+	return nil
+}
+
+func (s *Store) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.apiTokens[id]
+	if !ok || t.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+// --- User sessions ---
+
+func (s *Store) CreateUserSession(ctx context.Context, session *models.UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := *session
+	stored.ImpersonatorName = ""
+	s.sessions[stored.ID] = &stored
+	return nil
+}
+
+func (s *Store) GetUserSession(ctx context.Context, id string) (*models.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.userSessionCopy(session), nil
+}
+
+func (s *Store) GetUserSessionsForUser(ctx context.Context, userID int64) ([]*models.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []*models.UserSession
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, s.userSessionCopy(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].SignedInAt.After(sessions[j].SignedInAt)
+	})
+	return sessions, nil
+}
+
+func (s *Store) TouchUserSession(ctx context.Context, id, userAgent, ip string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	session.UserAgent, session.IP, session.LastSeenAt = userAgent, ip, now
+	return nil
+}
+
+func (s *Store) DeleteUserSession(ctx context.Context, userID int64, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *Store) DeleteUserSessionsForUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maps.DeleteFunc(s.sessions, func(_ string, session *models.UserSession) bool { return session.UserID == userID })
+	return nil
+}
+
+func (s *Store) DeleteExpiredUserSessions(ctx context.Context, signedInBefore, seenBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.sessions)
+	maps.DeleteFunc(s.sessions, func(_ string, session *models.UserSession) bool {
+		return session.SignedInAt.Before(signedInBefore) || session.LastSeenAt.Before(seenBefore)
+	})
+	return int64(n - len(s.sessions)), nil
+}
+
+// userSessionCopy returns a copy of session with the name of its
+// impersonator, as the SQL store joins it. The caller must hold the lock.
+func (s *Store) userSessionCopy(session *models.UserSession) *models.UserSession {
+	copied := *session
+	if u, ok := s.users[session.ImpersonatorID]; ok {
+		copied.ImpersonatorName = u.Username
+	}
+	return &copied
+}
+
+// --- Groups ---
+
+func (s *Store) CreateGroup(ctx context.Context, name string) (*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.groups {
+		if g.Name == name {
+			return nil, database.ErrGroupExists
+		}
+	}
+	g := &models.Group{ID: s.id(), Name: name, CreatedAt: time.Now()}
+	s.groups[g.ID] = g
+	copied := *g
+	return &copied, nil
+}
+
+func (s *Store) GetGroup(ctx context.Context, id int64) (*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.groups[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *g
+	return &copied, nil
+}
+
+func (s *Store) GetAllGroups(ctx context.Context) ([]*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []*models.Group
+	for _, g := range s.groups {
+		copied := *g
+		groups = append(groups, &copied)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups, nil
+}
+
+func (s *Store) RenameGroup(ctx context.Context, id int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.groups[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, other := range s.groups {
+		if other.ID != id && other.Name == name {
+			return database.ErrGroupExists
+		}
+	}
+	g.Name = name
+	return nil
+}
+
+func (s *Store) DeleteGroup(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.groups, id)
+	for key := range s.members {
+		if key[0] == id {
+			delete(s.members, key)
+		}
+	}
+	for key := range s.groupCourses {
+		if key[0] == id {
+			delete(s.groupCourses, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetGroupMembers(ctx context.Context, groupID int64) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []*models.User
+	for key := range s.members {
+		if u, ok := s.users[key[1]]; ok && key[0] == groupID {
+			users = append(users, withoutHash(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (s *Store) GetGroupsForUser(ctx context.Context, userID int64) ([]*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []*models.Group
+	for key := range s.members {
+		if g, ok := s.groups[key[0]]; ok && key[1] == userID {
+			copied := *g
+			groups = append(groups, &copied)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (s *Store) AddGroupMember(ctx context.Context, groupID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members[[2]int64{groupID, userID}] = true
+	for key := range s.groupCourses {
+		if key[0] == groupID {
+			s.enrollments[[2]int64{userID, key[1]}] = true
+		}
+	}
+	return nil
+}
+
+func (s *Store) RemoveGroupMember(ctx context.Context, groupID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{groupID, userID}
+	if !s.members[key] {
+		return sql.ErrNoRows
+	}
+	delete(s.members, key)
+	return nil
+}
+
+func (s *Store) GetGroupCourses(ctx context.Context, groupID int64) ([]*models.Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var courses []*models.Course
+	for key := range s.groupCourses {
+		if c, ok := s.courses[key[1]]; ok && key[0] == groupID {
+			copied := *c
+			courses = append(courses, &copied)
+		}
+	}
+	sort.Slice(courses, func(i, j int) bool { return courses[i].Title < courses[j].Title })
+	return courses, nil
+}
+
+func (s *Store) AddGroupCourse(ctx context.Context, groupID, courseID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.groupCourses[[2]int64{groupID, courseID}] = true
+	for key := range s.members {
+		if key[0] == groupID {
+			s.enrollments[[2]int64{key[1], courseID}] = true
+		}
+	}
+	return nil
+}
+
+func (s *Store) RemoveGroupCourse(ctx context.Context, groupID, courseID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int64{groupID, courseID}
+	if !s.groupCourses[key] {
+		return sql.ErrNoRows
+	}
+	delete(s.groupCourses, key)
+	return nil
+}
+
+// --- Audit log ---
+
+func (s *Store) AddAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := *entry
+	e.ID = s.id()
+	e.CreatedAt = time.Now().UTC()
+	s.audit[e.ID] = &e
+	return nil
+}
+
+func (s *Store) GetAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*models.AuditEntry
+	for _, e := range s.audit {
+		copied := *e
+		entries = append(entries, &copied)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// --- Invitations ---
+
+func (s *Store) CreateInvitation(ctx context.Context, inv *models.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.invitations {
+		if other.TokenHash == inv.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	inv.ID = s.id()
+	inv.CreatedAt = time.Now().UTC()
+	stored := *inv
+	stored.CourseIDs = slices.Clone(inv.CourseIDs)
+	s.invitations[stored.ID] = &stored
+	return nil
+}
+
+func (s *Store) GetInvitations(ctx context.Context) ([]*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var invitations []*models.Invitation
+	for _, inv := range s.invitations {
+		invitations = append(invitations, s.invitationCopy(inv))
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID > invitations[j].ID })
+	return invitations, nil
+}
+
+func (s *Store) GetInvitationByHash(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.invitations {
+		if inv.TokenHash == tokenHash {
+			return s.invitationCopy(inv), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) AcceptInvitation(ctx context.Context, tokenHash string, userID int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.invitations {
+		if inv.TokenHash != tokenHash || !inv.AcceptedAt.IsZero() || !inv.ExpiresAt.After(now) {
+			continue
+		}
+		inv.AcceptedAt = now
+		inv.UserID = userID
+		if u, ok := s.users[userID]; ok && inv.Email != "" && u.Email == inv.Email {
+			u.EmailVerifiedAt = now
+		}
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (s *Store) DeleteInvitation(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invitations[id]
+	if !ok || !inv.AcceptedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+// invitationCopy returns a copy of inv with the names of its users, as the
+// SQL store joins them. The caller must hold the lock.
+func (s *Store) invitationCopy(inv *models.Invitation) *models.Invitation {
+	copied := *inv
+	copied.CourseIDs = slices.Clone(inv.CourseIDs)
+	if u, ok := s.users[inv.CreatedBy]; ok {
+		copied.CreatedByName = u.Username
+	}
+	if u, ok := s.users[inv.UserID]; ok {
+		copied.Username = u.Username
+	}
+	return &copied
+}
@@ -0,0 +1,363 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when an applied migration file has been edited.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// ErrMissingDownMigration is returned when a rollback needs a .down.sql file that does not exist.
+var ErrMissingDownMigration = errors.New("missing down migration")
+
+// Migration is a single versioned migration loaded from disk.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string // Empty if there is no .down.sql file.
+	Checksum string // SHA-256 of the up script.
+}
+
+// MigrationStatus describes the state of a migration in the database.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // The up script changed after it was applied.
+}
+
+// LoadMigrations reads all migrations from the root of fsys.
+// Files must be named NNN_description.up.sql, with an optional NNN_description.down.sql.
+func LoadMigrations(fsys fs.FS) ([]*Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		// Split "002_create_courses.up.sql" into the version and the name.
+		base := strings.TrimSuffix(path.Base(file), "."+direction+".sql")
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: file name must be NNN_description", file)
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", file, err)
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			sum := sha256.Sum256(content)
+			m.Up = string(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d: missing .up.sql file", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+
+	// Sort the migrations to ensure they are applied in the correct order.
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// ensureMigrationsTable creates the schema_migrations table if needed.
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	return err
+}
+
+// getAppliedMigrations returns the applied migrations keyed by version.
+func getAppliedMigrations(db *sql.DB) (map[int64]appliedMigration, error) {
+	rows, err := db.Query("SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// adoptLegacySchema records the original migrations as applied for databases
+// created before schema_migrations existed. Those databases already contain
+// every table, so re-running the scripts would fail on CREATE TABLE.
+func adoptLegacySchema(db *sql.DB, migrations []*Migration, applied map[int64]appliedMigration) error {
+	// Only SQLite databases predate the migration engine.
+	if len(applied) > 0 || DialectOf(db) != SQLite {
+		return nil
+	}
+
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'sessions')").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	for _, m := range migrations {
+		// 006 was the last migration applied by the old glob-based runner.
+		if m.Version > 6 {
+			break
+		}
+		if _, err := db.Exec(
+			"INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)",
+			m.Version, m.Name, m.Checksum,
+		); err != nil {
+			return err
+		}
+		applied[m.Version] = appliedMigration{checksum: m.Checksum, appliedAt: time.Now()}
+	}
+	return nil
+}
+
+// ApplyMigrations applies every pending migration in fsys, in order.
+// Each migration runs in its own transaction and is recorded in schema_migrations,
+// so it is only ever applied once. It fails if an applied migration was edited.
+func ApplyMigrations(db *sql.DB, fsys fs.FS) error {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if err := adoptLegacySchema(db, migrations, applied); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok {
+			// Already applied. Make sure nobody edited it afterwards.
+			if a.checksum != m.Checksum {
+				return fmt.Errorf("%w: %03d_%s", ErrChecksumMismatch, m.Version, m.Name)
+			}
+			continue
+		}
+
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs one up script and records it, atomically.
+func applyMigration(db *sql.DB, m *Migration) error {
+	return migrationTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(
+			rebind(DialectOf(db), "INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)"),
+			m.Version, m.Name, m.Checksum,
+		)
+		return err
+	})
+}
+
+// migrationTx runs fn in a transaction.
+//
+// On SQLite, foreign keys are off while it runs, so that a migration can
+// rebuild a table that other tables refer to, the only way to change most
+// constraints there (https://www.sqlite.org/lang_altertable.html#otheralter).
+// Dropping the old table would otherwise delete the rows referring to it.
+// The foreign keys are checked before committing instead.
+func migrationTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sqlite := DialectOf(db) == SQLite
+	if sqlite {
+		// The pragma is ignored inside a transaction, so it is set first.
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if sqlite {
+		rows, err := tx.Query("PRAGMA foreign_key_check")
+		if err != nil {
+			return err
+		}
+		broken := rows.Next()
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if broken {
+			return errors.New("rows refer to missing rows after the migration")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RollbackMigrations reverts applied migrations newer than target, newest first.
+// A target of 0 reverts everything.
+func RollbackMigrations(db *sql.DB, fsys fs.FS, target int64) error {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	// Walk the migrations from newest to oldest.
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("%w: %03d_%s", ErrMissingDownMigration, m.Version, m.Name)
+		}
+
+		if err := revertMigration(db, m); err != nil {
+			return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// revertMigration runs one down script and removes its record, atomically.
+func revertMigration(db *sql.DB, m *Migration) error {
+	return migrationTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(rebind(DialectOf(db), "DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+		return err
+	})
+}
+
+// GetMigrationStatus reports which migrations in fsys have been applied.
+func GetMigrationStatus(db *sql.DB, fsys fs.FS) ([]*MigrationStatus, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, m := range migrations {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.appliedAt
+			status.Modified = a.checksum != m.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,345 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"lms/internal/database"
+	"lms/migrations"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestSQLite returns an empty SQLite database in a temporary directory.
+func newTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "lms.db"), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testMigrations is a small set of migrations; 003 has no down file.
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"001_create_authors.up.sql":   {Data: []byte("CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+		"001_create_authors.down.sql": {Data: []byte("DROP TABLE authors;")},
+		"002_create_books.up.sql": {Data: []byte(`CREATE TABLE books (
+			id INTEGER PRIMARY KEY,
+			author_id INTEGER NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+			title TEXT NOT NULL
+		);`)},
+		"002_create_books.down.sql": {Data: []byte("DROP TABLE books;")},
+		"003_add_isbn.up.sql":       {Data: []byte("ALTER TABLE books ADD COLUMN isbn TEXT;")},
+	}
+}
+
+// migrationsUpTo returns the migrations of fsys up to version.
+func migrationsUpTo(t *testing.T, fsys fs.FS, version int64) fstest.MapFS {
+	t.Helper()
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := fstest.MapFS{}
+	for _, file := range files {
+		var v int64
+		if _, err := fmt.Sscanf(file, "%d_", &v); err != nil {
+			t.Fatal(err)
+		}
+		if v > version {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub[file] = &fstest.MapFile{Data: data}
+	}
+	return sub
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+// appliedVersions returns the versions GetMigrationStatus reports as applied.
+func appliedVersions(t *testing.T, db *sql.DB, fsys fs.FS) []int64 {
+	t.Helper()
+	statuses, err := database.GetMigrationStatus(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestApplyMigrations(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db, fsys); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("applied %v, want [1 2 3]", got)
+	}
+	// Applying again finds nothing to do.
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO authors (id, name) VALUES (1, 'Ann')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO books (author_id, title, isbn) VALUES (1, 'Go', '978')"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["002_create_books.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE books (id INTEGER PRIMARY KEY);")}
+	fsys["004_create_reviews.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE reviews (id INTEGER PRIMARY KEY);")}
+	err := database.ApplyMigrations(db, fsys)
+	if !errors.Is(err, database.ErrChecksumMismatch) {
+		t.Fatalf("got error %v, want ErrChecksumMismatch", err)
+	}
+	if tableExists(t, db, "reviews") {
+		t.Error("a migration was applied after the edited one")
+	}
+
+	statuses, err := database.GetMigrationStatus(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if want := s.Version == 2; s.Modified != want {
+			t.Errorf("migration %d: Modified = %v, want %v", s.Version, s.Modified, want)
+		}
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+	// The first statement works and the second fails: neither is kept.
+	fsys["002_create_books.up.sql"] = &fstest.MapFile{Data: []byte(`
+		CREATE TABLE books (id INTEGER PRIMARY KEY);
+		INSERT INTO missing_table VALUES (1);`)}
+
+	if err := database.ApplyMigrations(db, fsys); err == nil || !strings.Contains(err.Error(), "002_create_books") {
+		t.Fatalf("got error %v, want one naming 002_create_books", err)
+	}
+	if tableExists(t, db, "books") {
+		t.Error("the failed migration left its table behind")
+	}
+	if got := appliedVersions(t, db, fsys); fmt.Sprint(got) != "[1]" {
+		t.Errorf("applied %v, want [1]", got)
+	}
+}
+
+func TestDanglingReferencesAreRefused(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+	// Foreign keys are off while migrations run, so they are checked at
+	// the end instead.
+	fsys["003_add_isbn.up.sql"] = &fstest.MapFile{Data: []byte("INSERT INTO books (author_id, title) VALUES (42, 'Orphan');")}
+
+	err := database.ApplyMigrations(db, fsys)
+	if err == nil || !strings.Contains(err.Error(), "missing rows") {
+		t.Fatalf("got error %v, want one about missing rows", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM books").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d books, want the insert rolled back", n)
+	}
+}
+
+func TestRollbackMigrations(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+	delete(fsys, "003_add_isbn.up.sql")
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.RollbackMigrations(db, fsys, 1); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, db, "books") || !tableExists(t, db, "authors") {
+		t.Error("rolling back to 1 didn't drop just the books table")
+	}
+	if got := appliedVersions(t, db, fsys); fmt.Sprint(got) != "[1]" {
+		t.Errorf("applied %v, want [1]", got)
+	}
+
+	// The rolled back migration can be applied again.
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RollbackMigrations(db, fsys, 0); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, db, "authors") || tableExists(t, db, "books") {
+		t.Error("rolling back to 0 left tables behind")
+	}
+	if got := appliedVersions(t, db, fsys); len(got) != 0 {
+		t.Errorf("applied %v, want none", got)
+	}
+}
+
+func TestRollbackNeedsDownFiles(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := testMigrations()
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	err := database.RollbackMigrations(db, fsys, 0)
+	if !errors.Is(err, database.ErrMissingDownMigration) {
+		t.Fatalf("got error %v, want ErrMissingDownMigration", err)
+	}
+	// Nothing is reverted, as 003 is the first to go.
+	if got := appliedVersions(t, db, fsys); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("applied %v, want [1 2 3]", got)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"no version", fstest.MapFS{"create_users.up.sql": {Data: []byte("SELECT 1;")}}},
+		{"bad version", fstest.MapFS{"x01_create_users.up.sql": {Data: []byte("SELECT 1;")}}},
+		{"down without up", fstest.MapFS{"001_create_users.down.sql": {Data: []byte("SELECT 1;")}}},
+		{"conflicting names", fstest.MapFS{
+			"001_create_users.up.sql":  {Data: []byte("SELECT 1;")},
+			"001_create_people.up.sql": {Data: []byte("SELECT 1;")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := database.LoadMigrations(tt.files); err == nil {
+				t.Error("LoadMigrations succeeded")
+			}
+		})
+	}
+}
+
+// TestAdoptLegacySchema checks that databases created before
+// schema_migrations existed, which have every table up to 006, have those
+// migrations recorded instead of run again, and get the later ones.
+func TestAdoptLegacySchema(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := migrations.SQLite()
+
+	// The old runner executed every script, without recording them.
+	legacy, err := database.LoadMigrations(migrationsUpTo(t, fsys, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range legacy {
+		if _, err := db.Exec(m.Up); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO users (username, password_hash, role) VALUES ('ann', 'hash', 'admin')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := database.GetMigrationStatus(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.Modified {
+			t.Errorf("migration %d: Applied = %v, Modified = %v", s.Version, s.Applied, s.Modified)
+		}
+	}
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE username = 'ann'").Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+	if role != "admin" {
+		t.Errorf("ann is %s, want admin", role)
+	}
+}
+
+// TestRebuildKeepsReferences checks that 015, which rebuilds the users
+// table, keeps the rows of the tables that refer to it, and that their
+// foreign keys still work afterwards.
+func TestRebuildKeepsReferences(t *testing.T) {
+	db := newTestSQLite(t)
+	fsys := migrations.SQLite()
+	if err := database.ApplyMigrations(db, migrationsUpTo(t, fsys, 14)); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"INSERT INTO users (id, username, password_hash, role, email) VALUES (1, 'ann', 'hash', 'student', 'ann@example.com')",
+		"INSERT INTO courses (id, title) VALUES (1, 'Go')",
+		"INSERT INTO enrollments (user_id, course_id) VALUES (1, 1)",
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (1, 'ci', 'h', 'read', CURRENT_TIMESTAMP)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := database.ApplyMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if count("enrollments") != 1 || count("api_tokens") != 1 {
+		t.Fatal("the rows referring to users were lost")
+	}
+	if _, err := db.Exec("UPDATE users SET role = 'instructor' WHERE id = 1"); err != nil {
+		t.Errorf("the new role is refused: %v", err)
+	}
+
+	// The references follow the new table: deleting the user cascades,
+	// and rows can't refer to missing users.
+	if _, err := db.Exec("INSERT INTO enrollments (user_id, course_id) VALUES (2, 1)"); err == nil {
+		t.Error("an enrollment of a missing user was accepted")
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if count("enrollments") != 0 || count("api_tokens") != 0 {
+		t.Error("deleting the user didn't cascade")
+	}
+}
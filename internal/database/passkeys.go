@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/models"
+	"time"
+)
+
+// ErrPasskeyExists is returned when a credential is registered twice.
+var ErrPasskeyExists = errors.New("passkey is already registered")
+
+// passkeyColumns are the columns scanned by scanPasskey.
+const passkeyColumns = "id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at"
+
+// scanPasskey scans a row selected with passkeyColumns.
+func scanPasskey(row interface{ Scan(...any) error }) (*models.Passkey, error) {
+	p := &models.Passkey{}
+	var signCount int64
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.PublicKey, &signCount, &p.Name, &p.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	p.LastUsedAt = lastUsedAt.Time
+	return p, nil
+}
+
+// CreatePasskey stores a new passkey for the user. It returns
+// ErrPasskeyExists if the credential is already registered.
+func (s *SQLStore) CreatePasskey(ctx context.Context, userID int64, credentialID, publicKey []byte, signCount uint32, name string) (*models.Passkey, error) {
+	id, err := s.insert(ctx,
+		"INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, name) VALUES (?, ?, ?, ?, ?)",
+		userID, credentialID, publicKey, int64(signCount), name,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrPasskeyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Passkey{
+		ID:           id,
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    signCount,
+		Name:         name,
+		CreatedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// GetPasskeysForUser retrieves the passkeys of a user, oldest first.
+func (s *SQLStore) GetPasskeysForUser(ctx context.Context, userID int64) ([]*models.Passkey, error) {
+	rows, err := s.query(ctx, "SELECT "+passkeyColumns+" FROM passkeys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*models.Passkey
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+// GetPasskeyByCredentialID retrieves the passkey with the given credential ID.
+func (s *SQLStore) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*models.Passkey, error) {
+	row := s.queryRow(ctx, "SELECT "+passkeyColumns+" FROM passkeys WHERE credential_id = ?", credentialID)
+	return scanPasskey(row)
+}
+
+// UsePasskey records a login with the passkey and its new signature count.
+func (s *SQLStore) UsePasskey(ctx context.Context, id int64, signCount uint32, now time.Time) error {
+	result, err := s.exec(ctx, "UPDATE passkeys SET sign_count = ?, last_used_at = ? WHERE id = ?", int64(signCount), now, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePasskey deletes a passkey of the user. It returns sql.ErrNoRows if
+// the user has no passkey with that ID.
+func (s *SQLStore) DeletePasskey(ctx context.Context, userID, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM passkeys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
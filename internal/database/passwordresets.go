@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"time"
+)
+
+// CreatePasswordReset stores a new password reset token for a user.
+// Earlier tokens of the user stop working.
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.exec(ctx, "DELETE FROM password_resets WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx,
+			"INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+			userID, tokenHash, expiresAt,
+		)
+		return err
+	})
+}
+
+// GetPasswordReset retrieves a password reset by the hash of its token.
+func (s *SQLStore) GetPasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	pr := &models.PasswordReset{}
+	var usedAt sql.NullTime
+	row := s.queryRow(ctx, "SELECT id, user_id, token_hash, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?", tokenHash)
+	if err := row.Scan(&pr.ID, &pr.UserID, &pr.TokenHash, &pr.CreatedAt, &pr.ExpiresAt, &usedAt); err != nil {
+		return nil, err
+	}
+	pr.UsedAt = usedAt.Time
+	return pr, nil
+}
+
+// UsePasswordReset marks the token as used and returns the ID of its user.
+// It returns sql.ErrNoRows if the token doesn't exist, has expired or has
+// already been used, so each token works only once.
+func (s *SQLStore) UsePasswordReset(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+	var userID int64
+	err := s.writeRow(ctx,
+		"UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id",
+		now, tokenHash, now,
+	).Scan(&userID)
+	return userID, err
+}
+
+// DeleteExpiredPasswordResets deletes the tokens that expired before the
+// given time and returns how many were deleted.
+func (s *SQLStore) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.exec(ctx, "DELETE FROM password_resets WHERE expires_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,335 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserStore manages user accounts.
+type UserStore interface {
+	CreateUser(ctx context.Context, username, password, role string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	GetAllUsers(ctx context.Context) ([]*models.User, error)
+	AuthenticateUser(ctx context.Context, username, password string) (*models.User, error)
+	SetUserPassword(ctx context.Context, id int64, password string) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	SetUserEmail(ctx context.Context, id int64, email string) error
+	UpdateUserProfile(ctx context.Context, id int64, fullName, displayName, timezone string) error
+	SetUserRole(ctx context.Context, id int64, role string) error
+	SetUsername(ctx context.Context, id int64, username string) error
+	DeactivateUser(ctx context.Context, id int64, now time.Time) error
+	HoldForVerification(ctx context.Context, id int64) error
+	ReactivateUser(ctx context.Context, id int64) error
+	CountUserRecords(ctx context.Context, id int64) (*UserRecords, error)
+	DeleteUser(ctx context.Context, id int64) error
+}
+
+// CourseStore manages courses, their lessons and enrollments.
+type CourseStore interface {
+	CreateCourse(ctx context.Context, title, description string) (*models.Course, error)
+	GetCourse(ctx context.Context, id int64) (*models.Course, error)
+	GetAllCourses(ctx context.Context) ([]*models.Course, error)
+	CreateLesson(ctx context.Context, courseID int64, title string, position int) (*models.Lesson, error)
+	GetLesson(ctx context.Context, id int64) (*models.Lesson, error)
+	GetLessonsForCourse(ctx context.Context, courseID int64) ([]*models.Lesson, error)
+	EnrollStudentInCourse(ctx context.Context, userID, courseID int64) error
+	GetEnrolledCoursesForStudent(ctx context.Context, userID int64) ([]*models.Course, error)
+	GetEnrolledStudents(ctx context.Context, courseID int64) ([]*models.User, error)
+}
+
+// CourseStaffStore manages the instructors and teaching assistants of courses.
+type CourseStaffStore interface {
+	AddCourseStaff(ctx context.Context, courseID, userID int64, role string) error
+	GetCourseStaff(ctx context.Context, courseID int64) ([]*models.CourseStaff, error)
+	GetCourseStaffMember(ctx context.Context, courseID, userID int64) (*models.CourseStaff, error)
+	GetStaffCourses(ctx context.Context, userID int64) ([]*models.Course, error)
+	RemoveCourseStaff(ctx context.Context, courseID, userID int64) error
+}
+
+// ContentStore manages the videos, texts and MCQs attached to lessons.
+type ContentStore interface {
+	CreateVideo(ctx context.Context, lessonID int64, title, url string) (*models.Video, error)
+	GetVideoByLessonID(ctx context.Context, lessonID int64) (*models.Video, error)
+	CreateText(ctx context.Context, lessonID int64, title, content string) (*models.Text, error)
+	GetTextByLessonID(ctx context.Context, lessonID int64) (*models.Text, error)
+	CreateMCQ(ctx context.Context, lessonID int64, question string, options []string, correctOptionIndex int) (*models.MCQ, error)
+	GetMCQByLessonID(ctx context.Context, lessonID int64) (*models.MCQ, error)
+	GetMCQByID(ctx context.Context, id int64) (*models.MCQ, error)
+}
+
+// ProgressStore records what students have completed and answered.
+type ProgressStore interface {
+	MarkLessonAsComplete(ctx context.Context, userID, lessonID int64) error
+	IsLessonComplete(ctx context.Context, userID, lessonID int64) (bool, error)
+	GetCompletedLessonsForUser(ctx context.Context, userID, courseID int64) (map[int64]bool, error)
+	IsCourseComplete(ctx context.Context, userID, courseID int64) (bool, error)
+	SubmitMCQ(ctx context.Context, userID, mcqID int64, selectedOptionIndex int) (*models.MCQSubmission, error)
+}
+
+// CertificateStore issues and looks up certificates.
+type CertificateStore interface {
+	CreateCertificate(ctx context.Context, userID, courseID int64) (*models.Certificate, error)
+	GetCertificateDetailsByToken(ctx context.Context, token string) (*CertificateDetails, error)
+}
+
+// LoginThrottleStore records failed logins, keyed by username or client IP.
+type LoginThrottleStore interface {
+	GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, now, forgetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	DeleteLoginThrottle(ctx context.Context, key string) error
+	DeleteStaleLoginThrottles(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PasswordResetStore manages the tokens of password reset links.
+type PasswordResetStore interface {
+	CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error)
+	UsePasswordReset(ctx context.Context, tokenHash string, now time.Time) (int64, error)
+	DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error)
+}
+
+// EmailVerificationStore manages the tokens of email verification links.
+type EmailVerificationStore interface {
+	CreateEmailVerification(ctx context.Context, userID int64, email, tokenHash string, expiresAt time.Time) error
+	UseEmailVerification(ctx context.Context, tokenHash string, now time.Time) (int64, error)
+	DeleteExpiredEmailVerifications(ctx context.Context, before time.Time) (int64, error)
+}
+
+// TwoFactorStore manages authenticator app secrets and recovery codes.
+type TwoFactorStore interface {
+	GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error)
+	SetPendingTOTP(ctx context.Context, userID int64, secret string) error
+	ConfirmTOTP(ctx context.Context, userID, step int64, codeHashes []string, now time.Time) error
+	UseTOTPStep(ctx context.Context, userID, step int64) error
+	DeleteTOTP(ctx context.Context, userID int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+}
+
+// PasskeyStore manages the WebAuthn credentials of users.
+type PasskeyStore interface {
+	CreatePasskey(ctx context.Context, userID int64, credentialID, publicKey []byte, signCount uint32, name string) (*models.Passkey, error)
+	GetPasskeysForUser(ctx context.Context, userID int64) ([]*models.Passkey, error)
+	GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*models.Passkey, error)
+	UsePasskey(ctx context.Context, id int64, signCount uint32, now time.Time) error
+	DeletePasskey(ctx context.Context, userID, id int64) error
+}
+
+// IdentityStore manages the links between users and their accounts at
+// external identity providers.
+type IdentityStore interface {
+	CreateUserIdentity(ctx context.Context, userID int64, issuer, subject, login string) (*models.UserIdentity, error)
+	GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error)
+	GetUserIdentitiesForUser(ctx context.Context, userID int64) ([]*models.UserIdentity, error)
+	GetUserIdentitiesByIssuer(ctx context.Context, issuer string) ([]*models.UserIdentity, error)
+	UseUserIdentity(ctx context.Context, id int64, login string, now time.Time) error
+	DeleteUserIdentity(ctx context.Context, userID, id int64) error
+}
+
+// APITokenStore manages the personal access tokens of users.
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, userID int64, name, tokenHash string, scopes []string, twoFactorVerified bool, expiresAt time.Time) (*models.APIToken, error)
+	GetAPITokensForUser(ctx context.Context, userID int64) ([]*models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	UseAPIToken(ctx context.Context, id int64, now time.Time) error
+	DeleteAPIToken(ctx context.Context, userID, id int64) error
+}
+
+// UserSessionStore records the logged-in sessions of users, so that they
+// can be listed and signed out by user.
+type UserSessionStore interface {
+	CreateUserSession(ctx context.Context, session *models.UserSession) error
+	GetUserSession(ctx context.Context, id string) (*models.UserSession, error)
+	GetUserSessionsForUser(ctx context.Context, userID int64) ([]*models.UserSession, error)
+	TouchUserSession(ctx context.Context, id, userAgent, ip string, now time.Time) error
+	DeleteUserSession(ctx context.Context, userID int64, id string) error
+	DeleteUserSessionsForUser(ctx context.Context, userID int64) error
+	DeleteExpiredUserSessions(ctx context.Context, signedInBefore, seenBefore time.Time) (int64, error)
+}
+
+// GroupStore manages groups of users and the courses their members are
+// enrolled in.
+type GroupStore interface {
+	CreateGroup(ctx context.Context, name string) (*models.Group, error)
+	GetGroup(ctx context.Context, id int64) (*models.Group, error)
+	GetAllGroups(ctx context.Context) ([]*models.Group, error)
+	RenameGroup(ctx context.Context, id int64, name string) error
+	DeleteGroup(ctx context.Context, id int64) error
+	GetGroupMembers(ctx context.Context, groupID int64) ([]*models.User, error)
+	GetGroupsForUser(ctx context.Context, userID int64) ([]*models.Group, error)
+	AddGroupMember(ctx context.Context, groupID, userID int64) error
+	RemoveGroupMember(ctx context.Context, groupID, userID int64) error
+	GetGroupCourses(ctx context.Context, groupID int64) ([]*models.Course, error)
+	AddGroupCourse(ctx context.Context, groupID, courseID int64) error
+	RemoveGroupCourse(ctx context.Context, groupID, courseID int64) error
+}
+
+// AuditStore records what admins do.
+type AuditStore interface {
+	AddAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+	GetAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error)
+}
+
+// InvitationStore manages the invitation links admins hand out.
+type InvitationStore interface {
+	CreateInvitation(ctx context.Context, inv *models.Invitation) error
+	GetInvitations(ctx context.Context) ([]*models.Invitation, error)
+	GetInvitationByHash(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	AcceptInvitation(ctx context.Context, tokenHash string, userID int64, now time.Time) error
+	DeleteInvitation(ctx context.Context, id int64) error
+}
+
+// Transactor runs a group of store operations atomically.
+type Transactor interface {
+	// WithTx calls fn with a Store bound to a new transaction. The transaction
+	// is committed if fn returns nil and rolled back otherwise, including when
+	// ctx is cancelled. Calling WithTx on a Store passed to fn reuses its
+	// transaction.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+// Store is the complete storage backend used by the application.
+//
+// Lookups of a single record return sql.ErrNoRows when nothing matches,
+// except for the username and email lookups which return ErrUserNotFound.
+type Store interface {
+	UserStore
+	CourseStore
+	CourseStaffStore
+	ContentStore
+	ProgressStore
+	CertificateStore
+	LoginThrottleStore
+	PasswordResetStore
+	EmailVerificationStore
+	TwoFactorStore
+	PasskeyStore
+	IdentityStore
+	APITokenStore
+	UserSessionStore
+	GroupStore
+	AuditStore
+	InvitationStore
+	Transactor
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SQLStore implements Store on top of a SQLite or PostgreSQL database.
+// Queries are written with ? placeholders and rewritten for the dialect.
+type SQLStore struct {
+	db      *sql.DB
+	writer  *sql.DB // Pool used for writes and transactions; db if there is no separate one.
+	read    querier // db, or the transaction this store is bound to.
+	write   querier // writer, or the transaction this store is bound to.
+	inTx    bool
+	dialect Dialect
+}
+
+// NewSQLStore creates a new SQLStore using db.
+//
+// If writer is not nil, statements that modify the database and all
+// transactions run on it instead of db. With SQLite it should be the pool
+// returned by NewWriteDB, so that writes are serialised.
+func NewSQLStore(db, writer *sql.DB) *SQLStore {
+	if writer == nil {
+		writer = db
+	}
+	return &SQLStore{db: db, writer: writer, read: db, write: writer, dialect: DialectOf(db)}
+}
+
+// Make sure SQLStore satisfies the interface.
+var _ Store = (*SQLStore)(nil)
+
+// WithTx implements Transactor.
+func (s *SQLStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		return fn(tx)
+	})
+}
+
+// withTx is WithTx for use inside the package, where the concrete type is handier.
+func (s *SQLStore) withTx(ctx context.Context, fn func(tx *SQLStore) error) error {
+	// Already in a transaction: join it.
+	if s.inTx {
+		return fn(s)
+	}
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	if err := fn(&SQLStore{db: s.db, writer: s.writer, read: tx, write: tx, inTx: true, dialect: s.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.write.ExecContext(ctx, rebind(s.dialect, query), args...)
+}
+
+func (s *SQLStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.read.QueryContext(ctx, rebind(s.dialect, query), args...)
+}
+
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.read.QueryRowContext(ctx, rebind(s.dialect, query), args...)
+}
+
+// writeRow runs a statement that modifies the database and returns a row,
+// such as one with a RETURNING clause.
+func (s *SQLStore) writeRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.write.QueryRowContext(ctx, rebind(s.dialect, query), args...)
+}
+
+// insert runs an INSERT statement and returns the ID of the new row.
+// PostgreSQL has no LastInsertId, so the ID is returned by the statement itself.
+func (s *SQLStore) insert(ctx context.Context, query string, args ...any) (int64, error) {
+	var id int64
+	if s.dialect == Postgres {
+		err := s.writeRow(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// rebind replaces the ? placeholders in query with $1, $2... for PostgreSQL.
+// Our queries never contain a literal question mark.
+func rebind(dialect Dialect, query string) string {
+	if dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
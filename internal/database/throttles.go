@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"time"
+)
+
+// GetLoginThrottle returns the failed login record for key.
+func (s *SQLStore) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	t := &models.LoginThrottle{}
+	var lockedUntil sql.NullTime
+	err := s.queryRow(ctx, "SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key = ?", key).
+		Scan(&t.Key, &t.Failures, &t.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	t.LockedUntil = lockedUntil.Time
+	return t, nil
+}
+
+// RecordLoginFailure counts a failed login for key at now and returns the
+// number of failures so far. Failures older than forgetBefore are forgotten.
+func (s *SQLStore) RecordLoginFailure(ctx context.Context, key string, now, forgetBefore time.Time) (int, error) {
+	// A single upsert, so concurrent failures are all counted.
+	var failures int
+	err := s.writeRow(ctx, `
+		INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`,
+		key, now, forgetBefore,
+	).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin refuses logins for key until the given time.
+func (s *SQLStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.exec(ctx, "UPDATE login_throttles SET locked_until = ? WHERE key = ?", until, key)
+	return err
+}
+
+// DeleteLoginThrottle forgets the failed logins for key, which also unlocks it.
+func (s *SQLStore) DeleteLoginThrottle(ctx context.Context, key string) error {
+	_, err := s.exec(ctx, "DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// DeleteStaleLoginThrottles deletes the records whose last failure is older
+// than before and returns how many were deleted.
+func (s *SQLStore) DeleteStaleLoginThrottles(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.exec(ctx, "DELETE FROM login_throttles WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
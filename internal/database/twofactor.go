@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"time"
+)
+
+// GetTOTP retrieves the authenticator app secret of a user, confirmed or not.
+func (s *SQLStore) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	t := &models.TOTP{}
+	var confirmedAt sql.NullTime
+	row := s.queryRow(ctx, "SELECT user_id, secret, created_at, confirmed_at, last_used_step FROM user_totp WHERE user_id = ?", userID)
+	if err := row.Scan(&t.UserID, &t.Secret, &t.CreatedAt, &confirmedAt, &t.LastUsedStep); err != nil {
+		return nil, err
+	}
+	t.ConfirmedAt = confirmedAt.Time
+	return t, nil
+}
+
+// SetPendingTOTP stores a new secret for the user to confirm, replacing
+// any earlier pending one. It fails if the user already has a confirmed
+// secret, which must be deleted first.
+func (s *SQLStore) SetPendingTOTP(ctx context.Context, userID int64, secret string) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.exec(ctx, "DELETE FROM user_totp WHERE user_id = ? AND confirmed_at IS NULL", userID); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, "INSERT INTO user_totp (user_id, secret) VALUES (?, ?)", userID, secret)
+		return err
+	})
+}
+
+// ConfirmTOTP enables the pending secret of the user, recording step as
+// used, and replaces their recovery codes with the given hashes.
+// It returns sql.ErrNoRows if there is no pending secret or the step was
+// already used.
+func (s *SQLStore) ConfirmTOTP(ctx context.Context, userID, step int64, codeHashes []string, now time.Time) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		result, err := tx.exec(ctx,
+			"UPDATE user_totp SET confirmed_at = ?, last_used_step = ? WHERE user_id = ? AND confirmed_at IS NULL AND last_used_step < ?",
+			now, step, userID, step,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		return tx.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+	})
+}
+
+// UseTOTPStep records that a code of the given step was accepted for the
+// user. It returns sql.ErrNoRows if the user has no confirmed secret or a
+// code of this step or a later one was already accepted, so codes can't
+// be replayed.
+func (s *SQLStore) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	result, err := s.exec(ctx,
+		"UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?",
+		step, userID, step,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTOTP turns off two-factor authentication for the user, deleting
+// their secret and recovery codes.
+func (s *SQLStore) DeleteTOTP(ctx context.Context, userID int64) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.exec(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID)
+		return err
+	})
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores
+// the given hashes instead.
+func (s *SQLStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.exec(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		for _, h := range codeHashes {
+			if _, err := tx.exec(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode deletes the recovery code with the given hash. It returns
+// sql.ErrNoRows if the user has no such code, so each code works only once.
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	result, err := s.exec(ctx, "DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func (s *SQLStore) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"lms/internal/models"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -11,29 +13,58 @@ import (
 // ErrUserNotFound is returned when a user is not found in the database.
 var ErrUserNotFound = errors.New("user not found")
 
-// CreateUser hashes the password and inserts a new user into the database.
-// It returns the newly created user.
-func CreateUser(db *sql.DB, username, password, role string) (*models.User, error) {
-	// Hash the password using bcrypt.
+// ErrUsernameTaken is returned when a username belongs to another user.
+var ErrUsernameTaken = errors.New("username is already in use")
+
+// ErrEmailTaken is returned when an email address belongs to another user.
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// userColumns are the columns scanned by scanUser. The password hash is
+// only selected where it is needed.
+const userColumns = "id, username, role, COALESCE(email, ''), email_verified_at, full_name, display_name, timezone, deactivated_at, awaiting_verification"
+
+// scanUser scans a row selected with userColumns, followed by dest.
+func scanUser(row interface{ Scan(...any) error }, dest ...any) (*models.User, error) {
+	user := &models.User{}
+	var verifiedAt, deactivatedAt sql.NullTime
+	err := row.Scan(append([]any{&user.ID, &user.Username, &user.Role, &user.Email, &verifiedAt, &user.FullName, &user.DisplayName, &user.Timezone, &deactivatedAt, &user.AwaitingVerification}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = verifiedAt.Time
+	user.DeactivatedAt = deactivatedAt.Time
+	return user, nil
+}
+
+// hashPassword hashes a password using bcrypt.
+func hashPassword(password string) (string, error) {
 	// The second argument is the cost of hashing. DefaultCost is a good value.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// CreateUser hashes the password and inserts a new user into the database.
+// It returns the newly created user, or ErrUsernameTaken if the username
+// is in use.
+func (s *SQLStore) CreateUser(ctx context.Context, username, password, role string) (*models.User, error) {
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		return nil, err
 	}
 
-	// Insert the new user into the database.
-	result, err := db.Exec(
+	// Insert the new user into the database and get its ID.
+	id, err := s.insert(ctx,
 		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
 		username,
-		string(hashedPassword),
+		hashedPassword,
 		role,
 	)
-	if err != nil {
-		return nil, err
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
 	}
-
-	// Get the ID of the newly inserted user.
-	id, err := result.LastInsertId()
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +73,9 @@ func CreateUser(db *sql.DB, username, password, role string) (*models.User, erro
 	user := &models.User{
 		ID:           id,
 		Username:     username,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		Role:         role,
+		Timezone:     "UTC",
 	}
 
 	return user, nil
@@ -51,11 +83,11 @@ func CreateUser(db *sql.DB, username, password, role string) (*models.User, erro
 
 // GetUserByUsername retrieves a user from the database by their username.
 // It returns ErrUserNotFound if the user does not exist.
-func GetUserByUsername(db *sql.DB, username string) (*models.User, error) {
-	user := &models.User{}
-	row := db.QueryRow("SELECT id, username, password_hash, role FROM users WHERE username = ?", username)
+func (s *SQLStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var passwordHash string
+	row := s.queryRow(ctx, "SELECT "+userColumns+", password_hash FROM users WHERE username = ?", username)
 
-	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role)
+	user, err := scanUser(row, &passwordHash)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Use a custom error to indicate that the user was not found.
@@ -64,14 +96,16 @@ func GetUserByUsername(db *sql.DB, username string) (*models.User, error) {
 		return nil, err
 	}
 
+	user.PasswordHash = passwordHash
 	return user, nil
 }
 
 // AuthenticateUser checks if a user's credentials are valid.
-// It returns the user object on success.
-func AuthenticateUser(db *sql.DB, username, password string) (*models.User, error) {
+// It returns the user object on success. Deactivated users are treated as
+// unknown.
+func (s *SQLStore) AuthenticateUser(ctx context.Context, username, password string) (*models.User, error) {
 	// Retrieve the user from the database.
-	user, err := GetUserByUsername(db, username)
+	user, err := s.GetUserByUsername(ctx, username)
 	if err != nil {
 		return nil, err // This will be ErrUserNotFound or a database error.
 	}
@@ -84,13 +118,16 @@ func AuthenticateUser(db *sql.DB, username, password string) (*models.User, erro
 		return nil, ErrUserNotFound
 	}
 
-	// Passwords match.
+	// Passwords match, but deactivated users may not log in.
+	if !user.Active() {
+		return nil, ErrUserNotFound
+	}
 	return user, nil
 }
 
 // GetAllUsers retrieves all users from the database.
-func GetAllUsers(db *sql.DB) ([]*models.User, error) {
-	rows, err := db.Query("SELECT id, username, role FROM users")
+func (s *SQLStore) GetAllUsers(ctx context.Context) ([]*models.User, error) {
+	rows, err := s.query(ctx, "SELECT "+userColumns+" FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -98,9 +135,9 @@ func GetAllUsers(db *sql.DB) ([]*models.User, error) {
 
 	var users []*models.User
 	for rows.Next() {
-		user := &models.User{}
 		// Note: We are not selecting the password hash for security reasons.
-		if err := rows.Scan(&user.ID, &user.Username, &user.Role); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -109,12 +146,247 @@ func GetAllUsers(db *sql.DB) ([]*models.User, error) {
 }
 
 // GetUserByID retrieves a single user by their ID.
-func GetUserByID(db *sql.DB, id int64) (*models.User, error) {
-	row := db.QueryRow("SELECT id, username, role FROM users WHERE id = ?", id)
-	user := &models.User{}
-	err := row.Scan(&user.ID, &user.Username, &user.Role)
+func (s *SQLStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	row := s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id)
+	user, err := scanUser(row)
 	if err != nil {
 		return nil, err // Could be sql.ErrNoRows
 	}
 	return user, nil
 }
+
+// SetUserPassword hashes a new password and stores it for the given user.
+func (s *SQLStore) SetUserPassword(ctx context.Context, id int64, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.exec(ctx, "UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, id)
+	if err != nil {
+		return err
+	}
+
+	// Report a missing user instead of silently updating nothing.
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetUserByEmail retrieves a user by their email address.
+// It returns ErrUserNotFound if no user has that address.
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE email = ?", email)
+
+	user, err := scanUser(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SetUserEmail stores the email address of the given user. An empty
+// address removes it. Changing the address clears its verification.
+// It returns ErrEmailTaken if another user has it.
+func (s *SQLStore) SetUserEmail(ctx context.Context, id int64, email string) error {
+	var value any
+	if email != "" {
+		value = email
+	}
+
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		_, err := tx.exec(ctx, "UPDATE users SET email_verified_at = NULL WHERE id = ? AND COALESCE(email, '') <> ?", id, email)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.exec(ctx, "UPDATE users SET email = ? WHERE id = ?", value, id)
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// UpdateUserProfile stores the names and timezone of the given user.
+func (s *SQLStore) UpdateUserProfile(ctx context.Context, id int64, fullName, displayName, timezone string) error {
+	result, err := s.exec(ctx,
+		"UPDATE users SET full_name = ?, display_name = ?, timezone = ? WHERE id = ?",
+		fullName, displayName, timezone, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserRole changes the role of the given user.
+func (s *SQLStore) SetUserRole(ctx context.Context, id int64, role string) error {
+	result, err := s.exec(ctx, "UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUsername changes the username of the given user. It returns
+// ErrUsernameTaken if another user has it.
+func (s *SQLStore) SetUsername(ctx context.Context, id int64, username string) error {
+	result, err := s.exec(ctx, "UPDATE users SET username = ? WHERE id = ?", username, id)
+	if isUniqueViolation(err) {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeactivateUser bars the given user from logging in. Deactivating a user
+// again keeps the original time.
+func (s *SQLStore) DeactivateUser(ctx context.Context, id int64, now time.Time) error {
+	result, err := s.exec(ctx, "UPDATE users SET deactivated_at = COALESCE(deactivated_at, ?) WHERE id = ?", now, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// HoldForVerification bars the given user from logging in until they
+// verify their email address.
+func (s *SQLStore) HoldForVerification(ctx context.Context, id int64) error {
+	result, err := s.exec(ctx, "UPDATE users SET awaiting_verification = TRUE WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ReactivateUser lets a deactivated user log in again, as well as one
+// awaiting the verification of their email address.
+func (s *SQLStore) ReactivateUser(ctx context.Context, id int64) error {
+	result, err := s.exec(ctx, "UPDATE users SET deactivated_at = NULL, awaiting_verification = FALSE WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UserRecords counts the records of a user that are deleted with them.
+type UserRecords struct {
+	Enrollments  int
+	Completions  int // Completed lessons.
+	Submissions  int // Answers to MCQs.
+	Certificates int
+	CourseStaff  int // Courses they teach.
+	APITokens    int
+	Passkeys     int
+}
+
+// CountUserRecords counts the records that deleting the given user would
+// delete too.
+func (s *SQLStore) CountUserRecords(ctx context.Context, id int64) (*UserRecords, error) {
+	records := &UserRecords{}
+	err := s.queryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM enrollments WHERE user_id = ?),
+			(SELECT COUNT(*) FROM lesson_completions WHERE user_id = ?),
+			(SELECT COUNT(*) FROM mcq_submissions WHERE user_id = ?),
+			(SELECT COUNT(*) FROM certificates WHERE user_id = ?),
+			(SELECT COUNT(*) FROM course_staff WHERE user_id = ?),
+			(SELECT COUNT(*) FROM api_tokens WHERE user_id = ?),
+			(SELECT COUNT(*) FROM passkeys WHERE user_id = ?)`,
+		id, id, id, id, id, id, id,
+	).Scan(&records.Enrollments, &records.Completions, &records.Submissions, &records.Certificates,
+		&records.CourseStaff, &records.APITokens, &records.Passkeys)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeleteUser deletes the given user. Their enrollments, progress,
+// certificates and logins go with them, through the foreign keys.
+func (s *SQLStore) DeleteUser(ctx context.Context, id int64) error {
+	result, err := s.exec(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
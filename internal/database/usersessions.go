@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"lms/internal/models"
+	"time"
+)
+
+// userSessionQuery selects the columns scanned by scanUserSession.
+const userSessionQuery = `
+	SELECT s.id, s.user_id, s.user_agent, s.ip, s.impersonator_id, COALESCE(i.username, ''),
+		s.signed_in_at, s.last_seen_at
+	FROM user_sessions s
+	LEFT JOIN users i ON i.id = s.impersonator_id`
+
+// scanUserSession scans a row selected with userSessionQuery.
+func scanUserSession(row interface{ Scan(...any) error }) (*models.UserSession, error) {
+	s := &models.UserSession{}
+	var impersonatorID sql.NullInt64
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &impersonatorID, &s.ImpersonatorName,
+		&s.SignedInAt, &s.LastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ImpersonatorID = impersonatorID.Int64
+	return s, nil
+}
+
+// CreateUserSession records a session that has just logged in.
+func (s *SQLStore) CreateUserSession(ctx context.Context, session *models.UserSession) error {
+	_, err := s.exec(ctx,
+		`INSERT INTO user_sessions (id, user_id, user_agent, ip, impersonator_id, signed_in_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.UserAgent, session.IP, nullID(session.ImpersonatorID),
+		session.SignedInAt, session.LastSeenAt,
+	)
+	return err
+}
+
+// GetUserSession retrieves a session by its ID.
+func (s *SQLStore) GetUserSession(ctx context.Context, id string) (*models.UserSession, error) {
+	return scanUserSession(s.queryRow(ctx, userSessionQuery+" WHERE s.id = ?", id))
+}
+
+// GetUserSessionsForUser retrieves the sessions of a user, the most
+// recently used first.
+func (s *SQLStore) GetUserSessionsForUser(ctx context.Context, userID int64) ([]*models.UserSession, error) {
+	rows, err := s.query(ctx, userSessionQuery+" WHERE s.user_id = ? ORDER BY s.last_seen_at DESC, s.signed_in_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.UserSession
+	for rows.Next() {
+		session, err := scanUserSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchUserSession records the latest request of a session. It returns
+// sql.ErrNoRows if the session has been signed out.
+func (s *SQLStore) TouchUserSession(ctx context.Context, id, userAgent, ip string, now time.Time) error {
+	result, err := s.exec(ctx,
+		"UPDATE user_sessions SET user_agent = ?, ip = ?, last_seen_at = ? WHERE id = ?",
+		userAgent, ip, now, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSession signs out a session of the user. It returns
+// sql.ErrNoRows if the user has no session with that ID.
+func (s *SQLStore) DeleteUserSession(ctx context.Context, userID int64, id string) error {
+	result, err := s.exec(ctx, "DELETE FROM user_sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessionsForUser signs out all the sessions of a user.
+func (s *SQLStore) DeleteUserSessionsForUser(ctx context.Context, userID int64) error {
+	_, err := s.exec(ctx, "DELETE FROM user_sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteExpiredUserSessions deletes the sessions that logged in before
+// signedInBefore or were last used before seenBefore, which the session
+// store has expired, and returns how many were deleted.
+func (s *SQLStore) DeleteExpiredUserSessions(ctx context.Context, signedInBefore, seenBefore time.Time) (int64, error) {
+	result, err := s.exec(ctx,
+		"DELETE FROM user_sessions WHERE signed_in_at < ? OR last_seen_at < ?",
+		signedInBefore, seenBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
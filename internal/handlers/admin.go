@@ -3,9 +3,12 @@ package handlers
 import (
 	"fmt"
 	"lms/internal/database"
+	"lms/internal/middleware"
 	"lms/internal/models"
+	"lms/internal/rbac"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -33,15 +36,27 @@ func (h *Handlers) CreateCourse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = database.CreateCourse(h.DB, title, description)
+	// Instructors become the instructor of the courses they create, so
+	// that they can author them. Admins can author any course already.
+	var course *models.Course
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		course, err = tx.CreateCourse(r.Context(), title, description)
+		if err != nil {
+			return err
+		}
+		if middleware.Role(h.SessionManager, r) == rbac.RoleAdmin {
+			return nil
+		}
+		userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+		return tx.AddCourseStaff(r.Context(), course.ID, userID, rbac.StaffInstructor)
+	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Redirect to an admin dashboard or course list page.
-	// For now, let's redirect to a placeholder admin page.
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	// Redirect to the new course, where its lessons are added.
+	http.Redirect(w, r, fmt.Sprintf("/admin/courses/%d", course.ID), http.StatusSeeOther)
 }
 
 func (h *Handlers) ShowCourseAdmin(w http.ResponseWriter, r *http.Request) {
@@ -53,7 +68,7 @@ func (h *Handlers) ShowCourseAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the course from the database.
-	course, err := database.GetCourse(h.DB, courseID)
+	course, err := h.Courses.GetCourse(r.Context(), courseID)
 	if err != nil {
 		// Handle case where course is not found
 		http.Error(w, "Course not found", http.StatusNotFound)
@@ -61,7 +76,35 @@ func (h *Handlers) ShowCourseAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the lessons for the course.
-	lessons, err := database.GetLessonsForCourse(h.DB, courseID)
+	lessons, err := h.Courses.GetLessonsForCourse(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	staff, err := h.Staff.GetCourseStaff(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Show how far each student has got.
+	students, err := h.Courses.GetEnrolledStudents(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	completed := make(map[int64]int)
+	for _, student := range students {
+		lessonsDone, err := h.Progress.GetCompletedLessonsForUser(r.Context(), student.ID, courseID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		completed[student.ID] = len(lessonsDone)
+	}
+
+	can, err := h.coursePermissions(r, courseID)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -70,6 +113,13 @@ func (h *Handlers) ShowCourseAdmin(w http.ResponseWriter, r *http.Request) {
 	td := h.newTemplateData(r)
 	td.Data["Course"] = course
 	td.Data["Lessons"] = lessons
+	td.Data["Staff"] = staff
+	td.Data["Students"] = students
+	td.Data["Completed"] = completed
+	td.Data["CanEdit"] = can(rbac.CourseEdit)
+	td.Data["CanEnroll"] = can(rbac.EnrollmentManage)
+	td.Data["CanIssue"] = can(rbac.CertificateIssue)
+	td.Data["CanManageStaff"] = can(rbac.StaffManage)
 
 	h.render(w, r, "admin_course_detail.page.tmpl", td)
 }
@@ -104,7 +154,7 @@ func (h *Handlers) CreateLesson(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create the lesson in the database.
-	_, err = database.CreateLesson(h.DB, courseID, title, position)
+	_, err = h.Courses.CreateLesson(r.Context(), courseID, title, position)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -121,16 +171,30 @@ func (h *Handlers) ShowLessonAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lesson, err := h.Courses.GetLesson(r.Context(), lessonID)
+	if err != nil {
+		http.Error(w, "Lesson not found", http.StatusNotFound)
+		return
+	}
+
+	can, err := h.coursePermissions(r, lesson.CourseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Fetch existing content to display it.
-	video, _ := database.GetVideoByLessonID(h.DB, lessonID)
-	text, _ := database.GetTextByLessonID(h.DB, lessonID)
-	mcq, _ := database.GetMCQByLessonID(h.DB, lessonID)
+	video, _ := h.Contents.GetVideoByLessonID(r.Context(), lessonID)
+	text, _ := h.Contents.GetTextByLessonID(r.Context(), lessonID)
+	mcq, _ := h.Contents.GetMCQByLessonID(r.Context(), lessonID)
 
 	// We ignore errors here because content might not exist, and that's okay.
 	// The template will handle the nil cases.
 
 	td := h.newTemplateData(r)
 	td.Data["LessonID"] = lessonID
+	td.Data["CourseID"] = lesson.CourseID
+	td.Data["CanEdit"] = can(rbac.CourseEdit)
 	td.Data["Video"] = video
 	td.Data["Text"] = text
 	td.Data["MCQ"] = mcq
@@ -163,7 +227,7 @@ func (h *Handlers) AddContent(w http.ResponseWriter, r *http.Request) {
 		// In a real app, you'd handle updates instead of just creating.
 		// For now, we'll just create, which will fail if content already exists due to UNIQUE constraint.
 		// A better approach would be an "upsert".
-		_, err = database.CreateVideo(h.DB, lessonID, title, url)
+		_, err = h.Contents.CreateVideo(r.Context(), lessonID, title, url)
 
 	case "text":
 		title := r.PostForm.Get("textTitle")
@@ -172,7 +236,7 @@ func (h *Handlers) AddContent(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Title and content are required for text", http.StatusBadRequest)
 			return
 		}
-		_, err = database.CreateText(h.DB, lessonID, title, content)
+		_, err = h.Contents.CreateText(r.Context(), lessonID, title, content)
 
 	case "mcq":
 		question := r.PostForm.Get("mcqQuestion")
@@ -184,7 +248,7 @@ func (h *Handlers) AddContent(w http.ResponseWriter, r *http.Request) {
 		}
 		correctOptionIndex, _ := strconv.Atoi(r.PostForm.Get("correctOption"))
 
-		_, err = database.CreateMCQ(h.DB, lessonID, question, options, correctOptionIndex)
+		_, err = h.Contents.CreateMCQ(r.Context(), lessonID, question, options, correctOptionIndex)
 
 	default:
 		http.Error(w, "Invalid content type", http.StatusBadRequest)
@@ -203,7 +267,7 @@ func (h *Handlers) AddContent(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := database.GetAllUsers(h.DB)
+	users, err := h.Users.GetAllUsers(r.Context())
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -223,21 +287,21 @@ func (h *Handlers) ShowUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user details
-	user, err := database.GetUserByID(h.DB, userID)
+	user, err := h.Users.GetUserByID(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Get enrolled courses
-	enrolledCourses, err := database.GetEnrolledCoursesForStudent(h.DB, userID)
+	enrolledCourses, err := h.Courses.GetEnrolledCoursesForStudent(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	// Get all courses to populate the enrollment form
-	allCourses, err := database.GetAllCourses(h.DB)
+	allCourses, err := h.Courses.GetAllCourses(r.Context())
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -260,6 +324,54 @@ func (h *Handlers) ShowUser(w http.ResponseWriter, r *http.Request) {
 	td.Data["EnrolledCourses"] = enrolledCourses
 	td.Data["AvailableCourses"] = availableCourses
 
+	// Show whether the account is locked after failed logins.
+	if h.Throttle != nil {
+		throttle, err := h.Throttle.Status(r.Context(), user.Username)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if throttle != nil {
+			td.Data["FailedLogins"] = throttle.Failures
+			if throttle.LockedUntil.After(time.Now()) {
+				td.Data["LockedUntil"] = throttle.LockedUntil
+			}
+		}
+	}
+
+	groups, err := h.Groups.GetGroupsForUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	td.Data["Groups"] = groups
+
+	apiTokens, err := h.APITokens.GetAPITokensForUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	td.Data["APITokens"] = apiTokens
+	td.Data["Now"] = time.Now()
+
+	// Show whether the user logs in with a second factor.
+	twoFactor, err := h.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	td.Data["TwoFactor"] = twoFactor
+	td.Data["Roles"] = userRoles
+	td.Data["IsCurrentUser"] = h.isCurrentUser(r, user.ID)
+	td.Data["CanImpersonate"] = h.impersonationProblem(r, user) == ""
+
+	sessions, err := h.userSessions(r, user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	td.Data["Sessions"] = sessions
+
 	h.render(w, r, "admin_user_detail.page.tmpl", td)
 }
 
@@ -277,7 +389,7 @@ func (h *Handlers) GenerateCertificate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// An admin can override completion status and generate a certificate.
-	_, err = database.CreateCertificate(h.DB, userID, courseID)
+	_, err = h.Certificates.CreateCertificate(r.Context(), userID, courseID)
 	if err != nil {
 		// This might fail if a certificate already exists (UNIQUE constraint on token).
 		// A more robust implementation would handle this, but for now, an error is acceptable.
@@ -307,7 +419,7 @@ func (h *Handlers) EnrollUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.EnrollStudentInCourse(h.DB, userID, courseID)
+	err = h.Courses.EnrollStudentInCourse(r.Context(), userID, courseID)
 	if err != nil {
 		http.Error(w, "Failed to enroll user", http.StatusInternalServerError)
 		return
@@ -316,3 +428,28 @@ func (h *Handlers) EnrollUser(w http.ResponseWriter, r *http.Request) {
 	// Redirect back to the user detail page.
 	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
 }
+
+// UnlockUser clears the failed logins of a user, lifting any lockout.
+func (h *Handlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if h.Throttle != nil {
+		if err := h.Throttle.Unlock(r.Context(), user.Username); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Redirect back to the user detail page.
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/middleware"
+	"lms/internal/models"
+	"lms/internal/token"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiTokenLifetimes are the number of days an API token can be valid for.
+// Tokens always expire, so forgotten ones stop working eventually.
+var apiTokenLifetimes = []int{7, 30, 90, 365}
+
+// APITokenSettings displays the API tokens of the logged-in user, with a
+// form to make a new one.
+func (h *Handlers) APITokenSettings(w http.ResponseWriter, r *http.Request) {
+	h.renderAPITokens(w, r, http.StatusOK, h.newTemplateData(r))
+}
+
+// CreateAPIToken makes a new API token for the logged-in user and shows it
+// once. Only its hash is stored.
+func (h *Handlers) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	isAdmin := h.SessionManager.GetString(r.Context(), "userRole") == "admin"
+
+	td := h.newTemplateData(r)
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	days, _ := strconv.Atoi(r.PostForm.Get("lifetime"))
+	scopes := r.PostForm["scope"]
+	switch {
+	case name == "":
+		td.Data["Error"] = "Please name the token after what it is for."
+	case utf8.RuneCountInString(name) > maxNameLength:
+		td.Data["Error"] = fmt.Sprintf("Token names must be at most %d characters long.", maxNameLength)
+	case !slices.Contains(apiTokenLifetimes, days):
+		td.Data["Error"] = "Please choose when the token expires."
+	case len(scopes) == 0:
+		td.Data["Error"] = "Please choose what the token may be used for."
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case models.ScopeRead, models.ScopeWrite:
+		case models.ScopeAdmin:
+			if !isAdmin {
+				td.Data["Error"] = "Only admins can make tokens for the admin pages."
+			} else if h.RequireAdmin2FA && !h.SessionManager.GetBool(r.Context(), "twoFactorVerified") {
+				td.Data["Error"] = "Tokens for the admin pages require two-factor authentication."
+			}
+		default:
+			td.Data["Error"] = fmt.Sprintf("Unknown scope %q.", scope)
+		}
+	}
+	if td.Data["Error"] != nil {
+		h.renderAPITokens(w, r, http.StatusBadRequest, td)
+		return
+	}
+
+	// Keep the scopes in a fixed order, without duplicates.
+	var granted []string
+	for _, scope := range []string{models.ScopeRead, models.ScopeWrite, models.ScopeAdmin} {
+		if slices.Contains(scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	secret := middleware.APITokenPrefix + token.New()
+	expiresAt := time.Now().UTC().AddDate(0, 0, days)
+	verified := h.SessionManager.GetBool(r.Context(), "twoFactorVerified")
+	_, err = h.APITokens.CreateAPIToken(r.Context(), userID, name, token.Hash(secret), granted, verified, expiresAt)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td.Flash = fmt.Sprintf("API token %q made.", name)
+	td.Data["NewToken"] = secret
+	h.renderAPITokens(w, r, http.StatusOK, td)
+}
+
+// DeleteAPIToken revokes an API token of the logged-in user.
+func (h *Handlers) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	err = h.APITokens.DeleteAPIToken(r.Context(), userID, tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The API token has been revoked.")
+	http.Redirect(w, r, "/profile/api-tokens", http.StatusSeeOther)
+}
+
+// AdminDeleteAPIToken revokes an API token of any user.
+func (h *Handlers) AdminDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.APITokens.DeleteAPIToken(r.Context(), userID, tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The API token has been revoked.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// renderAPITokens renders the API tokens page of the logged-in user.
+func (h *Handlers) renderAPITokens(w http.ResponseWriter, r *http.Request, status int, td *TemplateData) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	tokens, err := h.APITokens.GetAPITokensForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td.Data["Tokens"] = tokens
+	td.Data["Lifetimes"] = apiTokenLifetimes
+	td.Data["Now"] = time.Now()
+	h.renderStatus(w, r, status, "api_tokens.page.tmpl", td)
+}
@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"context"
+	"lms/internal/middleware"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"lms/internal/token"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createAPIToken gives user an API token and returns its secret.
+func (a *testApp) createAPIToken(user *models.User, scopes []string, twoFactorVerified bool, expiresAt time.Time) string {
+	a.t.Helper()
+	secret := middleware.APITokenPrefix + token.New()
+	_, err := a.store.CreateAPIToken(context.Background(), user.ID, "script", token.Hash(secret), scopes, twoFactorVerified, expiresAt)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return secret
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	app := newTestApp(t)
+	ann := app.createUser("ann", rbac.RoleStudent)
+	future := time.Now().Add(time.Hour)
+	read := app.createAPIToken(ann, []string{models.ScopeRead}, false, future)
+	expired := app.createAPIToken(ann, []string{models.ScopeRead}, false, time.Now().Add(-time.Minute))
+	bob := app.createUser("bob", rbac.RoleStudent)
+	deactivated := app.createAPIToken(bob, []string{models.ScopeRead}, false, future)
+	if err := app.store.DeactivateUser(context.Background(), bob.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		secret string
+		want   int
+	}{
+		{"read", http.MethodGet, "/profile", read, http.StatusOK},
+		{"write without the scope", http.MethodPost, "/logout", read, http.StatusForbidden},
+		{"expired", http.MethodGet, "/profile", expired, http.StatusUnauthorized},
+		{"deactivated user", http.MethodGet, "/profile", deactivated, http.StatusUnauthorized},
+		{"unknown", http.MethodGet, "/profile", middleware.APITokenPrefix + token.New(), http.StatusUnauthorized},
+		{"not a token", http.MethodGet, "/profile", "secret", http.StatusUnauthorized},
+		{"student on the admin pages", http.MethodGet, "/admin/users", read, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, app.server.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+tt.secret)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.want)
+			}
+			// Token requests don't get a session of their own.
+			if cookies := res.Cookies(); len(cookies) != 0 {
+				t.Errorf("got cookies %v", cookies)
+			}
+			if tt.want == http.StatusUnauthorized && !strings.Contains(res.Header.Get("WWW-Authenticate"), "invalid_token") {
+				t.Errorf("WWW-Authenticate = %q", res.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+var newTokenPattern = regexp.MustCompile(`<code>(` + middleware.APITokenPrefix + `[^<]+)</code>`)
+
+func TestAPITokenSecondFactor(t *testing.T) {
+	app := newTestApp(t)
+	ann := app.createUser("ann", rbac.RoleAdmin)
+
+	// makeToken makes an admin token on the API tokens page of c.
+	makeToken := func(c *testClient) string {
+		t.Helper()
+		res := c.post("/profile/api-tokens", url.Values{
+			"name":     {"script"},
+			"lifetime": {"7"},
+			"scope":    {models.ScopeRead, models.ScopeAdmin},
+		})
+		m := newTokenPattern.FindStringSubmatch(res.body)
+		if res.status != http.StatusOK || m == nil {
+			t.Fatalf("make token: got status %d and no token", res.status)
+		}
+		return m[1]
+	}
+	adminPages := func(secret string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.server.URL+"/admin/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// A token made in a session that didn't use a second factor, before
+	// the admin pages required one.
+	c := app.newClient()
+	c.login("ann")
+	unverified := makeToken(c)
+	if got := adminPages(unverified); got != http.StatusOK {
+		t.Errorf("token without a second factor, not required: got %d, want 200", got)
+	}
+
+	app.mw.RequireAdmin2FA = true
+	app.h.RequireAdmin2FA = true
+	if got := adminPages(unverified); got != http.StatusForbidden {
+		t.Errorf("token without a second factor, required: got %d, want 403", got)
+	}
+	if res := c.post("/profile/api-tokens", url.Values{
+		"name": {"script"}, "lifetime": {"7"}, "scope": {models.ScopeAdmin},
+	}); res.status != http.StatusBadRequest {
+		t.Errorf("admin token without a second factor, required: got %d, want 400", res.status)
+	}
+
+	secret := app.enableTOTP(ann)
+	verified := app.newClient()
+	verified.loginTwoFactor("ann", secret)
+	if got := adminPages(makeToken(verified)); got != http.StatusOK {
+		t.Errorf("token made after a second factor: got %d, want 200", got)
+	}
+
+	// Tokens without the admin scope stay off the admin pages.
+	read := app.createAPIToken(ann, []string{models.ScopeRead}, true, time.Now().Add(time.Hour))
+	if got := adminPages(read); got != http.StatusForbidden {
+		t.Errorf("token without the admin scope: got %d, want 403", got)
+	}
+}
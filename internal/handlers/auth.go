@@ -1,12 +1,49 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"lms/internal/database"
+	"lms/internal/middleware"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net"
 	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 func (h *Handlers) RegisterForm(w http.ResponseWriter, r *http.Request) {
 	td := h.newTemplateData(r)
+
+	// People with an invitation register with the link they were given.
+	if tok := r.URL.Query().Get("invite"); tok != "" {
+		inv, problem, err := h.pendingInvitation(r.Context(), tok)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			td.Data["Error"] = problem
+			h.renderStatus(w, r, http.StatusBadRequest, "register.page.tmpl", td)
+			return
+		}
+		td.Data["Invitation"] = inv
+		td.Data["InviteToken"] = tok
+	} else if !h.canRegister() {
+		td.Data["Error"] = h.registrationClosed()
+		h.renderStatus(w, r, http.StatusForbidden, "register.page.tmpl", td)
+		return
+	} else if h.RegistrationMode == "domain" {
+		td.Data["AllowedDomains"] = strings.Join(h.AllowedDomains, ", ")
+	}
+
 	h.render(w, r, "register.page.tmpl", td)
 }
 
@@ -27,20 +64,133 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For now, all new users are students.
-	_, err = database.CreateUser(h.DB, username, password, "student")
+	// Without an invitation, registration may be limited by the mode.
+	var inv *models.Invitation
+	tok := r.PostForm.Get("invite")
+	if tok != "" {
+		var problem string
+		inv, problem, err = h.pendingInvitation(r.Context(), tok)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+	} else if !h.canRegister() {
+		http.Error(w, h.registrationClosed(), http.StatusForbidden)
+		return
+	}
+
+	// The email address is optional; it is needed to reset a forgotten password.
+	// Invitations sent by email are for that address.
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	if inv != nil && inv.Email != "" {
+		email = inv.Email
+	}
+	if email != "" && !validEmail(email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	// Anyone can type an address at an allowed domain, so those who register
+	// with one can only log in once they have shown it is theirs.
+	held := inv == nil && h.RegistrationMode == "domain"
+	if held && !h.emailDomainAllowed(email) {
+		http.Error(w, "Register with an email address at "+strings.Join(h.AllowedDomains, " or "), http.StatusForbidden)
+		return
+	}
+	// The full name is printed on certificates and can be set later.
+	fullName := strings.TrimSpace(r.PostForm.Get("full_name"))
+	if utf8.RuneCountInString(fullName) > maxNameLength {
+		http.Error(w, "Full name is too long", http.StatusBadRequest)
+		return
+	}
+
+	// New users are students, unless their invitation says otherwise.
+	role := "student"
+	if inv != nil {
+		role = inv.Role
+	}
+	var user *models.User
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		created, err := tx.CreateUser(r.Context(), username, password, role)
+		if err != nil {
+			return err
+		}
+		user = created
+		user.FullName = fullName
+		if err := tx.UpdateUserProfile(r.Context(), user.ID, user.FullName, user.DisplayName, user.Timezone); err != nil {
+			return err
+		}
+		if email != "" {
+			user.Email = email
+			if err := tx.SetUserEmail(r.Context(), user.ID, email); err != nil {
+				return err
+			}
+		}
+		if held {
+			user.AwaitingVerification = true
+			if err := tx.HoldForVerification(r.Context(), user.ID); err != nil {
+				return err
+			}
+		}
+		if inv == nil {
+			return nil
+		}
+
+		// Use up the invitation and enroll the user in its courses.
+		if err := tx.AcceptInvitation(r.Context(), token.Hash(tok), user.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+		for _, courseID := range inv.CourseIDs {
+			if err := tx.EnrollStudentInCourse(r.Context(), user.ID, courseID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Someone else registered with the invitation meanwhile.
+		http.Error(w, "This invitation has already been used.", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrUsernameTaken) {
+		http.Error(w, "That username is already taken", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrEmailTaken) {
+		http.Error(w, "That email address is already in use", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		// This could be a unique constraint violation if the username is taken.
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	// Ask the user to prove they own the email address, unless their
+	// invitation was sent to it.
+	if email != "" && (inv == nil || inv.Email == "") {
+		flash := fmt.Sprintf("Your account has been created. We have sent a link to %s to verify your email address.", email)
+		if held {
+			flash = fmt.Sprintf("Your account has been created. Open the link we have sent to %s to verify your email address before you log in.", email)
+		}
+		if err := h.sendEmailVerification(r.Context(), user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			flash = "Your account has been created. Verify your email address from your profile page."
+			if held {
+				flash = "Your account has been created, but we couldn't send the link to verify your email address. Please ask an admin to activate it."
+			}
+		}
+		h.SessionManager.Put(r.Context(), "flash", flash)
+	}
+
 	// Redirect to the login page after successful registration.
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 func (h *Handlers) LoginForm(w http.ResponseWriter, r *http.Request) {
-	td := h.newTemplateData(r)
+	td := h.loginTemplateData(r)
 	h.render(w, r, "login.page.tmpl", td)
 }
 
@@ -53,26 +203,89 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 
 	username := r.PostForm.Get("username")
 	password := r.PostForm.Get("password")
+	ip := clientIP(r)
+
+	// Refuse to check the password while the username or IP is locked out.
+	if h.Throttle != nil {
+		wait, err := h.Throttle.Check(r.Context(), username, ip)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+			td := h.loginTemplateData(r)
+			td.Data["Error"] = fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second))
+			h.renderStatus(w, r, http.StatusTooManyRequests, "login.page.tmpl", td)
+			return
+		}
+	}
 
-	user, err := database.AuthenticateUser(h.DB, username, password)
+	user, err := h.Auth.Authenticate(r.Context(), username, password)
 	if err != nil {
-		// If authentication fails, redirect back to the login page.
-		// You might want to show an error message.
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		// Attempts that couldn't be checked, e.g. because the directory is
+		// down, count too: local passwords may still have been tried.
+		if h.Throttle != nil {
+			if err := h.Throttle.Failure(r.Context(), username, ip); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		td := h.loginTemplateData(r)
+		if !errors.Is(err, database.ErrUserNotFound) {
+			log.Printf("Failed to check the password of %q: %v", username, err)
+			td.Data["Error"] = "The login service is unavailable. Please try again later."
+			h.renderStatus(w, r, http.StatusServiceUnavailable, "login.page.tmpl", td)
+			return
+		}
+		// If authentication fails, show the login page again.
+		td.Data["Error"] = "Invalid username or password."
+		h.renderStatus(w, r, http.StatusUnauthorized, "login.page.tmpl", td)
 		return
 	}
 
-	// Authentication successful. Store the user ID and role in the session.
-	h.SessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
-	h.SessionManager.Put(r.Context(), "userRole", user.Role)
+	// Users with two-factor authentication must also enter a code. Their
+	// failed logins are only forgotten once they have.
+	enabled, err := h.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		if err := h.startTwoFactorLogin(r.Context(), user.ID); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	if h.Throttle != nil {
+		if err := h.Throttle.Success(r.Context(), username); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Authentication successful. Store the user ID and role in a new session.
+	if err := h.startSession(r, user, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
 	// Redirect to a dashboard page. For now, let's redirect to "/".
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
-	// Destroy the session data.
-	err := h.SessionManager.Destroy(r.Context())
+	// Logging out also ends an impersonation.
+	if err := h.auditImpersonationStop(r, "logged out"); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Destroy the session data. The next request starts a session with a new token.
+	err := h.destroySession(r.Context())
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -80,3 +293,105 @@ func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
 	// Redirect to the login page.
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
+
+// CSRFFailure is shown when a form is submitted without a valid CSRF token.
+func (h *Handlers) CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	td := h.newTemplateData(r)
+	h.renderStatus(w, r, http.StatusForbidden, "forbidden.page.tmpl", td)
+}
+
+// identityKeys are the session keys that belong to whoever is logged in on
+// the session, rather than to the browser. startSession clears them, so
+// nothing carries over from the previous user, such as their second factor.
+var identityKeys = []string{
+	"csrfToken",
+	"sessionID",
+	"twoFactorVerified",
+	"twoFactorUserID",
+	"twoFactorStartedAt",
+	"impersonatorID",
+	"impersonatorName",
+	"impersonatorTwoFactorVerified",
+	"impersonatedName",
+	"impersonationReadOnly",
+	"passkeyRegisterChallenge",
+	"ssoLinkUserID",
+}
+
+// startSession logs user in on the session of r, as impersonated by the
+// admin impersonatorID if it isn't 0. The session token is renewed first,
+// so a token planted in the browser beforehand (session fixation) is
+// worthless, and the CSRF token and the rest of the identityKeys are
+// cleared for the same reason; callers put back what the login proved.
+//
+// The session is recorded as a new one on the sessions page right away, so
+// that it can be signed out before its next request.
+func (h *Handlers) startSession(r *http.Request, user *models.User, impersonatorID int64) error {
+	ctx := r.Context()
+	if err := h.SessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	if err := h.forgetSession(ctx); err != nil {
+		return err
+	}
+	for _, key := range identityKeys {
+		h.SessionManager.Remove(ctx, key)
+	}
+
+	session := middleware.NewUserSession(r, user.ID, impersonatorID)
+	if err := h.UserSessions.CreateUserSession(ctx, session); err != nil {
+		return err
+	}
+	h.SessionManager.Put(ctx, "sessionID", session.ID)
+	h.SessionManager.Put(ctx, "authenticatedUserID", user.ID)
+	h.SessionManager.Put(ctx, "userRole", user.Role)
+	return nil
+}
+
+// endSessions logs a user out of all their sessions, for example when
+// they are deactivated. The sessions are destroyed on their next request by
+// the TrackSession middleware. Sessions waiting for a two-factor code
+// aren't logged in yet: they expire after twoFactorLoginTimeout, and
+// RequireAuthentication turns deactivated users away if they complete it.
+func (h *Handlers) endSessions(ctx context.Context, userID int64) error {
+	return h.UserSessions.DeleteUserSessionsForUser(ctx, userID)
+}
+
+// forgetSession deletes the record of the current session, which then no
+// longer shows on the sessions page, before it ends or changes hands.
+func (h *Handlers) forgetSession(ctx context.Context) error {
+	id := h.SessionManager.GetString(ctx, "sessionID")
+	if id == "" {
+		return nil
+	}
+	err := h.UserSessions.DeleteUserSession(ctx, h.SessionManager.GetInt64(ctx, "authenticatedUserID"), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// destroySession forgets the current session and destroys its data.
+func (h *Handlers) destroySession(ctx context.Context) error {
+	if err := h.forgetSession(ctx); err != nil {
+		return err
+	}
+	return h.SessionManager.Destroy(ctx)
+}
+
+// validEmail reports whether email is a bare address such as "ann@example.com",
+// rather than one with a display name.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// clientIP returns the address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// The RealIP middleware sets RemoteAddr to a bare address.
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"lms/internal/backup"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListBackups shows the stored backups and the forms to create one.
+func (h *Handlers) ListBackups(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		http.Error(w, "Backups are only supported for SQLite", http.StatusNotFound)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	if h.Backups.Dir() != "" {
+		backups, err := h.Backups.List()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		td.Data["Dir"] = h.Backups.Dir()
+		td.Data["Backups"] = backups
+	}
+
+	h.render(w, r, "admin_backups.page.tmpl", td)
+}
+
+// CreateBackup takes a backup into the backup directory.
+func (h *Handlers) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		http.Error(w, "Backups are only supported for SQLite", http.StatusNotFound)
+		return
+	}
+
+	f, err := h.Backups.Create(r.Context())
+	if errors.Is(err, backup.ErrNoDir) {
+		http.Error(w, "No backup directory is configured", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("backup: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Backup written to %s.", f.Name)
+
+	http.Redirect(w, r, "/admin/backups", http.StatusSeeOther)
+}
+
+// DownloadBackup sends a stored backup.
+func (h *Handlers) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		http.Error(w, "Backups are only supported for SQLite", http.StatusNotFound)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	f, err := h.Backups.Open(name)
+	if errors.Is(err, backup.ErrNotFound) || errors.Is(err, backup.ErrNoDir) {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// DownloadSnapshot sends a fresh gzip-compressed snapshot of the database.
+func (h *Handlers) DownloadSnapshot(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		http.Error(w, "Backups are only supported for SQLite", http.StatusNotFound)
+		return
+	}
+
+	name := fmt.Sprintf("lms-snapshot-%s.db.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	// Once the body has started the status can't be changed, so a failure
+	// part-way only shows up as a truncated download.
+	if err := h.Backups.Snapshot(r.Context(), w); err != nil {
+		log.Printf("snapshot: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListGroups shows the groups provisioned over SCIM.
+func (h *Handlers) ListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.Groups.GetAllGroups(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Count the members of each group.
+	memberCounts := make(map[int64]int)
+	for _, g := range groups {
+		members, err := h.Groups.GetGroupMembers(r.Context(), g.ID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		memberCounts[g.ID] = len(members)
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Groups"] = groups
+	td.Data["MemberCounts"] = memberCounts
+	td.Data["SCIMEnabled"] = h.SCIMToken != ""
+
+	h.render(w, r, "admin_groups_list.page.tmpl", td)
+}
+
+// ShowGroup shows the members of a group and the courses they are enrolled in.
+func (h *Handlers) ShowGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(chi.URLParam(r, "groupID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.Groups.GetGroup(r.Context(), groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	members, err := h.Groups.GetGroupMembers(r.Context(), groupID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	courses, err := h.Groups.GetGroupCourses(r.Context(), groupID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Offer the courses the group isn't enrolled in yet.
+	allCourses, err := h.Courses.GetAllCourses(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	groupCourses := make(map[int64]bool)
+	for _, course := range courses {
+		groupCourses[course.ID] = true
+	}
+	var availableCourses []*models.Course
+	for _, course := range allCourses {
+		if !groupCourses[course.ID] {
+			availableCourses = append(availableCourses, course)
+		}
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Group"] = group
+	td.Data["Members"] = members
+	td.Data["Courses"] = courses
+	td.Data["AvailableCourses"] = availableCourses
+
+	h.render(w, r, "admin_group_detail.page.tmpl", td)
+}
+
+// AddGroupCourse enrolls the members of a group, present and future, in a course.
+func (h *Handlers) AddGroupCourse(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(chi.URLParam(r, "groupID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	courseID, err := strconv.ParseInt(r.PostForm.Get("courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.Groups.GetGroup(r.Context(), groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	course, err := h.Courses.GetCourse(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Course not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Groups.AddGroupCourse(r.Context(), group.ID, course.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("The members of %s are now enrolled in %s.", group.Name, course.Title))
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", groupID), http.StatusSeeOther)
+}
+
+// RemoveGroupCourse stops enrolling the members of a group in a course.
+// The members already enrolled keep their enrollment and progress.
+func (h *Handlers) RemoveGroupCourse(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(chi.URLParam(r, "groupID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	courseID, err := strconv.ParseInt(chi.URLParam(r, "courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Groups.RemoveGroupCourse(r.Context(), groupID, courseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Course not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "New members will no longer be enrolled in the course. Current members stay enrolled.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", groupID), http.StatusSeeOther)
+}
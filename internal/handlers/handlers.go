@@ -1,30 +1,89 @@
 package handlers
 
 import (
-	"database/sql"
 	"html/template"
+	"io/fs"
+	"lms/internal/auth"
+	"lms/internal/backup"
+	"lms/internal/database"
+	"lms/internal/mail"
+	"lms/internal/throttle"
+	"lms/internal/webauthn"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
 
 // Handlers struct holds dependencies for handlers.
 type Handlers struct {
-	DB             *sql.DB
-	SessionManager *scs.SessionManager
-	TemplateCache  map[string]*template.Template
+	Users              database.UserStore
+	Courses            database.CourseStore
+	Staff              database.CourseStaffStore
+	Contents           database.ContentStore
+	Progress           database.ProgressStore
+	Certificates       database.CertificateStore
+	PasswordResets     database.PasswordResetStore
+	EmailVerifications database.EmailVerificationStore
+	TwoFactor          database.TwoFactorStore
+	Passkeys           database.PasskeyStore
+	Identities         database.IdentityStore
+	APITokens          database.APITokenStore
+	Groups             database.GroupStore
+	Audit              database.AuditStore
+	Invitations        database.InvitationStore
+	UserSessions       database.UserSessionStore
+	Tx                 database.Transactor
+	Auth               auth.Authenticator     // Checks the passwords of logins.
+	Backups            *backup.Manager        // nil when the database doesn't support backups.
+	Throttle           *throttle.Limiter      // nil disables the limits on failed logins.
+	Mailer             mail.Mailer            // nil disables email; admins can still pass on reset links.
+	WebAuthn           *webauthn.RelyingParty // nil disables passkeys.
+	SSO                *SingleSignOn          // nil disables single sign-on.
+	SCIMToken          string                 // Bearer token of the SCIM API; empty disables it.
+	SCIMManageAdmins   bool                   // The SCIM API may change the password and email of admins.
+	PublicURL          string                 // Prepended to the links sent in emails.
+	ResetTokenTTL      time.Duration          // How long a password reset link stays valid.
+	VerifyTokenTTL     time.Duration          // How long an email verification link stays valid.
+	RequireAdmin2FA    bool                   // Admins may not turn two-factor authentication off.
+	RegistrationMode   string                 // Who may register: "open", "invite", "domain" or "closed".
+	AllowedDomains     []string               // Email domains that may register in the "domain" mode.
+	InvitationTTL      time.Duration          // How long an invitation link stays valid.
+	SessionManager     *scs.SessionManager
+	TemplateCache      map[string]*template.Template
 }
 
 // NewHandlers creates a new Handlers struct.
-func NewHandlers(db *sql.DB, sessionManager *scs.SessionManager) (*Handlers, error) {
+func NewHandlers(store database.Store, sessionManager *scs.SessionManager, templates fs.FS) (*Handlers, error) {
 	// Initialize a new template cache.
-	cache, err := NewTemplateCache()
+	cache, err := NewTemplateCache(templates)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Handlers{
-		DB:             db,
-		SessionManager: sessionManager,
-		TemplateCache:  cache,
+		Users:              store,
+		Courses:            store,
+		Staff:              store,
+		Contents:           store,
+		Progress:           store,
+		Certificates:       store,
+		PasswordResets:     store,
+		EmailVerifications: store,
+		TwoFactor:          store,
+		Passkeys:           store,
+		Identities:         store,
+		APITokens:          store,
+		Groups:             store,
+		Audit:              store,
+		Invitations:        store,
+		UserSessions:       store,
+		Tx:                 store,
+		Auth:               auth.NewLocal(store),
+		ResetTokenTTL:      time.Hour,
+		VerifyTokenTTL:     48 * time.Hour,
+		RegistrationMode:   "open",
+		InvitationTTL:      7 * 24 * time.Hour,
+		SessionManager:     sessionManager,
+		TemplateCache:      cache,
 	}, nil
 }
@@ -0,0 +1,711 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"lms/internal/database/memstore"
+	"lms/internal/handlers"
+	"lms/internal/middleware"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"lms/internal/token"
+	"lms/internal/totp"
+	"lms/web"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	scsmem "github.com/alexedwards/scs/v2/memstore"
+	"github.com/go-chi/chi/v5"
+)
+
+// testApp is the application running on the in-memory store, with the
+// routes of cmd/lms that the tests need.
+type testApp struct {
+	t      *testing.T
+	store  *memstore.Store
+	h      *handlers.Handlers
+	mw     *middleware.Middleware
+	server *httptest.Server
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	store := memstore.New()
+	sessionManager := scs.New()
+	sessionManager.Store = scsmem.New()
+
+	h, err := handlers.NewHandlers(store, sessionManager, web.Templates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := middleware.NewMiddleware(sessionManager)
+	mw.APITokens = store
+	mw.Users = store
+	mw.Courses = store
+	mw.Staff = store
+	mw.UserSessions = store
+
+	r := chi.NewRouter()
+	r.Use(mw.LoadSession)
+	r.Use(mw.VerifyCSRF(http.HandlerFunc(h.CSRFFailure)))
+	r.Use(mw.TrackSession)
+	r.Get("/", h.Dashboard)
+	r.Get("/register", h.RegisterForm)
+	r.Post("/register", h.Register)
+	r.Get("/login", h.LoginForm)
+	r.Post("/login", h.Login)
+	r.Get("/login/sso", h.LoginSSO)
+	r.Get("/login/sso/callback", h.SSOCallback)
+	r.Get("/login/two-factor", h.LoginTwoFactorForm)
+	r.Post("/login/two-factor", h.LoginTwoFactor)
+	r.Post("/logout", h.Logout)
+	r.Get("/forgot-password", h.ForgotPasswordForm)
+	r.Post("/forgot-password", h.ForgotPassword)
+	r.Get("/reset-password", h.ResetPasswordForm)
+	r.Get("/verify-email", h.VerifyEmail)
+	r.Post("/reset-password", h.ResetPassword)
+	r.With(mw.RequireAuthentication).Post("/impersonation/stop", h.StopImpersonation)
+	r.With(mw.RequireAuthentication).Get("/profile", h.Profile)
+	r.With(mw.RequireAuthentication).Get("/profile/sessions", h.Sessions)
+	r.With(mw.RequireAuthentication).Post("/profile/sessions/revoke-all", h.RevokeAllSessions)
+	r.With(mw.RequireAuthentication).Post("/profile/sessions/{sessionID}/revoke", h.RevokeSession)
+	r.With(mw.RequireAuthentication, mw.RequireSession).Get("/profile/api-tokens", h.APITokenSettings)
+	r.With(mw.RequireAuthentication, mw.RequireSession).Post("/profile/api-tokens", h.CreateAPIToken)
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(mw.RequireAuthentication)
+		r.With(mw.RequirePermission(rbac.CourseCreate)).Get("/courses/new", h.CreateCourseForm)
+		r.With(mw.RequireCoursePermission(rbac.CourseView)).Get("/courses/{courseID}", h.ShowCourseAdmin)
+		r.With(mw.RequireCoursePermission(rbac.StaffManage)).Post("/courses/{courseID}/staff", h.AddCourseStaff)
+		r.With(mw.RequireAdmin).Get("/users", h.ListUsers)
+		r.With(mw.RequireAdmin, mw.RequireSession).Post("/users/{userID}/impersonate", h.StartImpersonation)
+	})
+
+	app := &testApp{t: t, store: store, h: h, mw: mw, server: httptest.NewServer(r)}
+	t.Cleanup(app.server.Close)
+	return app
+}
+
+// createUser adds a user with the password "secret".
+func (a *testApp) createUser(username, role string) *models.User {
+	a.t.Helper()
+	user, err := a.store.CreateUser(context.Background(), username, "secret", role)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return user
+}
+
+// enableTOTP turns on two-factor authentication for user with a new
+// authenticator app secret, which it returns.
+func (a *testApp) enableTOTP(user *models.User) string {
+	a.t.Helper()
+	secret := totp.GenerateSecret()
+	if err := a.store.SetPendingTOTP(context.Background(), user.ID, secret); err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.store.ConfirmTOTP(context.Background(), user.ID, 1, nil, time.Now()); err != nil {
+		a.t.Fatal(err)
+	}
+	return secret
+}
+
+// verifyEmail gives user the email address, verified as if they had
+// followed the link they were sent.
+func (a *testApp) verifyEmail(user *models.User, email string) {
+	a.t.Helper()
+	ctx := context.Background()
+	if err := a.store.SetUserEmail(ctx, user.ID, email); err != nil {
+		a.t.Fatal(err)
+	}
+	hash := token.Hash(token.New())
+	if err := a.store.CreateEmailVerification(ctx, user.ID, email, hash, time.Now().Add(time.Hour)); err != nil {
+		a.t.Fatal(err)
+	}
+	if _, err := a.store.UseEmailVerification(ctx, hash, time.Now()); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// testClient is a browser with its own cookies. It doesn't follow
+// redirects, so that tests can check where they lead.
+type testClient struct {
+	app    *testApp
+	client *http.Client
+}
+
+func (a *testApp) newClient() *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return &testClient{app: a, client: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// response is what a request returned.
+type response struct {
+	status   int
+	location string
+	body     string
+}
+
+func (c *testClient) do(req *http.Request) response {
+	c.app.t.Helper()
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.app.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.app.t.Fatal(err)
+	}
+	return response{status: res.StatusCode, location: res.Header.Get("Location"), body: string(body)}
+}
+
+func (c *testClient) get(path string) response {
+	c.app.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, c.app.server.URL+path, nil)
+	if err != nil {
+		c.app.t.Fatal(err)
+	}
+	return c.do(req)
+}
+
+var csrfPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// post submits a form with the CSRF token of the client's session, which
+// is taken from the login page.
+func (c *testClient) post(path string, form url.Values) response {
+	c.app.t.Helper()
+	m := csrfPattern.FindStringSubmatch(c.get("/login").body)
+	if m == nil {
+		c.app.t.Fatal("no CSRF token on the login page")
+	}
+	form.Set("csrf_token", m[1])
+	return c.postRaw(path, form)
+}
+
+// postRaw submits a form as it is.
+func (c *testClient) postRaw(path string, form url.Values) response {
+	c.app.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.app.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.app.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+// login logs the client in as username, failing the test if it can't.
+func (c *testClient) login(username string) {
+	c.app.t.Helper()
+	res := c.post("/login", url.Values{"username": {username}, "password": {"secret"}})
+	if res.status != http.StatusSeeOther || res.location != "/" {
+		c.app.t.Fatalf("login as %s: got %d to %q, want 303 to /", username, res.status, res.location)
+	}
+}
+
+// loginTwoFactor logs the client in as username with the code of the
+// authenticator app secret, failing the test if it can't.
+func (c *testClient) loginTwoFactor(username, secret string) {
+	c.app.t.Helper()
+	res := c.post("/login", url.Values{"username": {username}, "password": {"secret"}})
+	if res.status != http.StatusSeeOther || res.location != "/login/two-factor" {
+		c.app.t.Fatalf("login as %s: got %d to %q, want 303 to /login/two-factor", username, res.status, res.location)
+	}
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		c.app.t.Fatal(err)
+	}
+	res = c.post("/login/two-factor", url.Values{"code": {code}})
+	if res.status != http.StatusSeeOther || res.location != "/" {
+		c.app.t.Fatalf("two-factor login as %s: got %d to %q, want 303 to /", username, res.status, res.location)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser("ann", rbac.RoleStudent)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"right password", "ann", "secret", http.StatusSeeOther},
+		{"wrong password", "ann", "wrong", http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized},
+		{"no password", "ann", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.newClient()
+			res := c.post("/login", url.Values{"username": {tt.username}, "password": {tt.password}})
+			if res.status != tt.status {
+				t.Fatalf("got status %d, want %d", res.status, tt.status)
+			}
+			if tt.status != http.StatusSeeOther {
+				if !strings.Contains(res.body, "Invalid username or password.") {
+					t.Error("the login page doesn't explain the failure")
+				}
+				if got := c.get("/profile"); got.status != http.StatusSeeOther {
+					t.Errorf("profile after a failed login: got status %d, want a redirect", got.status)
+				}
+				return
+			}
+			if got := c.get("/profile"); got.status != http.StatusOK {
+				t.Errorf("profile after logging in: got status %d, want 200", got.status)
+			}
+		})
+	}
+}
+
+func TestLoginRenewsSession(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser("ann", rbac.RoleStudent)
+	c := app.newClient()
+
+	c.get("/login")
+	before := c.client.Jar.Cookies(mustParseURL(t, app.server.URL))
+	c.login("ann")
+	after := c.client.Jar.Cookies(mustParseURL(t, app.server.URL))
+	if len(before) == 0 || len(after) == 0 {
+		t.Fatal("no session cookie")
+	}
+	if before[0].Value == after[0].Value {
+		t.Error("the session token wasn't renewed on login")
+	}
+
+	if res := c.post("/logout", url.Values{}); res.status != http.StatusSeeOther {
+		t.Fatalf("logout: got status %d", res.status)
+	}
+	if res := c.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("profile after logging out: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+}
+
+func TestLoginClearsPreviousUser(t *testing.T) {
+	app := newTestApp(t)
+	app.mw.RequireAdmin2FA = true
+	ann := app.createUser("ann", rbac.RoleAdmin)
+	secret := app.enableTOTP(ann)
+	app.createUser("bob", rbac.RoleAdmin)
+	c := app.newClient()
+
+	c.loginTwoFactor("ann", secret)
+	if res := c.get("/admin/users"); res.status != http.StatusOK {
+		t.Fatalf("admin pages after two-factor login: got %d, want 200", res.status)
+	}
+
+	// Bob logging in on Ann's browser doesn't inherit her second factor.
+	c.login("bob")
+	if res := c.get("/admin/users"); res.status != http.StatusSeeOther || res.location != "/profile/two-factor" {
+		t.Errorf("admin pages as bob: got %d to %q, want 303 to /profile/two-factor", res.status, res.location)
+	}
+}
+
+func TestImpersonationKeepsAdminSecondFactor(t *testing.T) {
+	app := newTestApp(t)
+	app.mw.RequireAdmin2FA = true
+	ann := app.createUser("ann", rbac.RoleAdmin)
+	secret := app.enableTOTP(ann)
+	carl := app.createUser("carl", rbac.RoleStudent)
+	c := app.newClient()
+
+	c.loginTwoFactor("ann", secret)
+	path := "/admin/users/" + strconv.FormatInt(carl.ID, 10) + "/impersonate"
+	if res := c.post(path, url.Values{}); res.status != http.StatusSeeOther || res.location != "/" {
+		t.Fatalf("impersonate: got %d to %q, want 303 to /", res.status, res.location)
+	}
+	if res := c.get("/admin/users"); res.status != http.StatusForbidden {
+		t.Errorf("admin pages while impersonating: got %d, want 403", res.status)
+	}
+
+	// Ann gets her second factor back, and nothing of Carl's session stays.
+	if res := c.post("/impersonation/stop", url.Values{}); res.status != http.StatusSeeOther {
+		t.Fatalf("stop impersonating: got status %d", res.status)
+	}
+	if res := c.get("/admin/users"); res.status != http.StatusOK {
+		t.Errorf("admin pages after impersonating: got %d, want 200", res.status)
+	}
+	if res := c.post("/impersonation/stop", url.Values{}); res.status != http.StatusBadRequest {
+		t.Errorf("stop impersonating again: got %d, want 400", res.status)
+	}
+}
+
+func TestLoginRequiresCSRFToken(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser("ann", rbac.RoleStudent)
+	c := app.newClient()
+	c.get("/login")
+
+	for _, token := range []string{"", "forged"} {
+		form := url.Values{"username": {"ann"}, "password": {"secret"}}
+		if token != "" {
+			form.Set("csrf_token", token)
+		}
+		if res := c.postRaw("/login", form); res.status != http.StatusForbidden {
+			t.Errorf("token %q: got status %d, want 403", token, res.status)
+		}
+	}
+	if res := c.get("/profile"); res.status != http.StatusSeeOther {
+		t.Errorf("profile: got status %d, want a redirect to the login page", res.status)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		form    url.Values
+		status  int
+		created bool
+		held    bool // Can't log in until the email address is verified.
+	}{
+		{
+			name:    "open",
+			mode:    "open",
+			form:    url.Values{"username": {"ann"}, "password": {"secret"}},
+			status:  http.StatusSeeOther,
+			created: true,
+		},
+		{
+			name:    "open with email",
+			mode:    "open",
+			form:    url.Values{"username": {"ann"}, "password": {"secret"}, "email": {"ann@example.com"}},
+			status:  http.StatusSeeOther,
+			created: true,
+		},
+		{
+			name:   "no password",
+			mode:   "open",
+			form:   url.Values{"username": {"ann"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid email",
+			mode:   "open",
+			form:   url.Values{"username": {"ann"}, "password": {"secret"}, "email": {"Ann <ann@example.com>"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "taken username",
+			mode:   "open",
+			form:   url.Values{"username": {"taken"}, "password": {"secret"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invite only",
+			mode:   "invite",
+			form:   url.Values{"username": {"ann"}, "password": {"secret"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "closed",
+			mode:   "closed",
+			form:   url.Values{"username": {"ann"}, "password": {"secret"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "domain without email",
+			mode:   "domain",
+			form:   url.Values{"username": {"ann"}, "password": {"secret"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "domain with another domain",
+			mode:   "domain",
+			form:   url.Values{"username": {"ann"}, "password": {"secret"}, "email": {"ann@example.org"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "domain with an allowed domain",
+			mode:    "domain",
+			form:    url.Values{"username": {"ann"}, "password": {"secret"}, "email": {"ann@Example.com"}},
+			status:  http.StatusSeeOther,
+			created: true,
+			held:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.h.RegistrationMode = tt.mode
+			app.h.AllowedDomains = []string{"example.com"}
+			app.createUser("taken", rbac.RoleStudent)
+
+			c := app.newClient()
+			res := c.post("/register", tt.form)
+			if res.status != tt.status {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.status, res.body)
+			}
+
+			_, err := app.store.GetUserByUsername(context.Background(), "ann")
+			if created := err == nil; created != tt.created {
+				t.Fatalf("user created: %v, want %v", created, tt.created)
+			}
+			if !tt.created {
+				return
+			}
+			user, err := app.store.GetUserByUsername(context.Background(), "ann")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.AwaitingVerification != tt.held {
+				t.Errorf("awaiting verification: %v, want %v", user.AwaitingVerification, tt.held)
+			}
+			if !tt.held {
+				c.login("ann")
+			}
+			if user.Role != rbac.RoleStudent {
+				t.Errorf("got role %q, want student", user.Role)
+			}
+		})
+	}
+}
+
+var verifyLinkPattern = regexp.MustCompile(`https://lms\.example\.com(/verify-email\?token=\S+)`)
+
+func TestRegisterByDomainNeedsVerification(t *testing.T) {
+	app := newTestApp(t)
+	mailer := &recordingMailer{}
+	app.h.Mailer = mailer
+	app.h.PublicURL = "https://lms.example.com"
+	app.h.RegistrationMode = "domain"
+	app.h.AllowedDomains = []string{"example.com"}
+
+	// Anyone can claim an address at the domain, so it doesn't let them in
+	// by itself.
+	c := app.newClient()
+	form := url.Values{"username": {"ann"}, "password": {"secret"}, "email": {"ann@example.com"}}
+	if res := c.post("/register", form); res.status != http.StatusSeeOther {
+		t.Fatalf("register: got status %d, want 303", res.status)
+	}
+	res := c.post("/login", url.Values{"username": {"ann"}, "password": {"secret"}})
+	if res.status == http.StatusSeeOther {
+		t.Fatalf("login before verifying: got 303 to %q, want a refusal", res.location)
+	}
+
+	sent := mailer.messages()
+	if len(sent) != 1 || sent[0].To != "ann@example.com" {
+		t.Fatalf("sent %v, want one email to ann@example.com", sent)
+	}
+	m := verifyLinkPattern.FindStringSubmatch(sent[0].Body)
+	if m == nil {
+		t.Fatalf("no verification link in %q", sent[0].Body)
+	}
+	if res := c.get(m[1]); res.status != http.StatusSeeOther {
+		t.Fatalf("verify: got status %d, want 303", res.status)
+	}
+	c.login("ann")
+}
+
+func TestRegisterFormHidesClosedRegistration(t *testing.T) {
+	for mode, status := range map[string]int{
+		"open":   http.StatusOK,
+		"domain": http.StatusOK,
+		"invite": http.StatusForbidden,
+		"closed": http.StatusForbidden,
+	} {
+		t.Run(mode, func(t *testing.T) {
+			app := newTestApp(t)
+			app.h.RegistrationMode = mode
+			app.h.AllowedDomains = []string{"example.com"}
+			c := app.newClient()
+
+			if res := c.get("/register"); res.status != status {
+				t.Errorf("register form: got status %d, want %d", res.status, status)
+			}
+			link := strings.Contains(c.get("/").body, `href="/register"`)
+			if want := status == http.StatusOK; link != want {
+				t.Errorf("register link shown: %v, want %v", link, want)
+			}
+		})
+	}
+}
+
+func TestRBACGuards(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.createUser("admin", rbac.RoleAdmin)
+	app.createUser("teacher", rbac.RoleInstructor)
+	app.createUser("student", rbac.RoleStudent)
+	ta := app.createUser("ta", rbac.RoleStudent)
+	course, err := app.store.CreateCourse(ctx, "Go", "Learn Go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.AddCourseStaff(ctx, course.ID, ta.ID, rbac.StaffTA); err != nil {
+		t.Fatal(err)
+	}
+
+	coursePage := "/admin/courses/" + strconv.FormatInt(course.ID, 10)
+	tests := []struct {
+		user   string // Empty for anonymous visitors.
+		method string
+		path   string
+		status int
+	}{
+		{"", http.MethodGet, "/profile", http.StatusSeeOther},
+		{"", http.MethodGet, "/admin/users", http.StatusSeeOther},
+		{"", http.MethodGet, "/admin/courses/new", http.StatusSeeOther},
+		{"student", http.MethodGet, "/profile", http.StatusOK},
+		{"student", http.MethodGet, "/admin/users", http.StatusForbidden},
+		{"student", http.MethodGet, "/admin/courses/new", http.StatusForbidden},
+		{"student", http.MethodGet, coursePage, http.StatusForbidden},
+		{"teacher", http.MethodGet, "/admin/users", http.StatusForbidden},
+		{"teacher", http.MethodGet, "/admin/courses/new", http.StatusOK},
+		{"teacher", http.MethodGet, coursePage, http.StatusForbidden},
+		{"ta", http.MethodGet, coursePage, http.StatusOK},
+		{"ta", http.MethodPost, coursePage + "/staff", http.StatusForbidden},
+		{"ta", http.MethodGet, "/admin/courses/999", http.StatusForbidden},
+		{"admin", http.MethodGet, "/admin/users", http.StatusOK},
+		{"admin", http.MethodGet, "/admin/courses/new", http.StatusOK},
+		{"admin", http.MethodGet, coursePage, http.StatusOK},
+	}
+	for _, tt := range tests {
+		name := tt.user
+		if name == "" {
+			name = "anonymous"
+		}
+		t.Run(name+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			c := app.newClient()
+			if tt.user != "" {
+				c.login(tt.user)
+			}
+			var res response
+			if tt.method == http.MethodPost {
+				res = c.post(tt.path, url.Values{})
+			} else {
+				res = c.get(tt.path)
+			}
+			if res.status != tt.status {
+				t.Errorf("got status %d, want %d", res.status, tt.status)
+			}
+			if res.status == http.StatusSeeOther && res.location != "/login" {
+				t.Errorf("redirected to %q, want /login", res.location)
+			}
+		})
+	}
+}
+
+func TestRBACGuardsFollowRoleChanges(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	user := app.createUser("ann", rbac.RoleAdmin)
+	c := app.newClient()
+	c.login("ann")
+
+	if res := c.get("/admin/users"); res.status != http.StatusOK {
+		t.Fatalf("as an admin: got status %d, want 200", res.status)
+	}
+	if err := app.store.SetUserRole(ctx, user.ID, rbac.RoleStudent); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.get("/admin/users"); res.status != http.StatusForbidden {
+		t.Errorf("after losing the admin role: got status %d, want 403", res.status)
+	}
+
+	if err := app.store.DeactivateUser(ctx, user.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("after being deactivated: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	ann := app.createUser("ann", rbac.RoleStudent)
+	bob := app.createUser("bob", rbac.RoleStudent)
+
+	// Sessions are recorded when they log in.
+	loginSession := func(c *testClient, user *models.User) string {
+		t.Helper()
+		before, err := app.store.GetUserSessionsForUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.login(user.Username)
+		after, err := app.store.GetUserSessionsForUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after) != len(before)+1 {
+			t.Fatalf("got %d sessions after logging in, want %d", len(after), len(before)+1)
+		}
+		return after[0].ID
+	}
+	phone, laptop, other := app.newClient(), app.newClient(), app.newClient()
+	phoneID := loginSession(phone, ann)
+	laptopID := loginSession(laptop, ann)
+	bobID := loginSession(other, bob)
+
+	res := laptop.get("/profile/sessions")
+	if res.status != http.StatusOK || !strings.Contains(res.body, phoneID) || !strings.Contains(res.body, laptopID) {
+		t.Fatalf("sessions page: got status %d, want both of ann's sessions", res.status)
+	}
+	if strings.Contains(res.body, bobID) {
+		t.Error("the sessions page shows another user's session")
+	}
+
+	if res := laptop.post("/profile/sessions/"+bobID+"/revoke", url.Values{}); res.status != http.StatusNotFound {
+		t.Errorf("revoking another user's session: got status %d, want 404", res.status)
+	}
+	if res := laptop.post("/profile/sessions/"+phoneID+"/revoke", url.Values{}); res.status != http.StatusSeeOther {
+		t.Fatalf("revoke: got status %d", res.status)
+	}
+	if res := phone.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("revoked session: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+	if res := laptop.get("/profile"); res.status != http.StatusOK {
+		t.Errorf("current session: got status %d, want 200", res.status)
+	}
+
+	if res := laptop.post("/profile/sessions/revoke-all", url.Values{}); res.status != http.StatusSeeOther {
+		t.Fatalf("revoke all: got status %d", res.status)
+	}
+	if res := laptop.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("after signing out everywhere: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+	if sessions, err := app.store.GetUserSessionsForUser(ctx, ann.ID); err != nil || len(sessions) != 0 {
+		t.Errorf("got %d sessions after signing out everywhere (%v), want none", len(sessions), err)
+	}
+	if res := other.get("/profile"); res.status != http.StatusOK {
+		t.Errorf("another user's session: got status %d, want 200", res.status)
+	}
+
+	// A session can be signed out before it makes another request.
+	phone.login("ann")
+	if err := app.store.DeleteUserSessionsForUser(ctx, ann.ID); err != nil {
+		t.Fatal(err)
+	}
+	if res := phone.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("session signed out right after logging in: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -4,41 +4,43 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
-	"path/filepath"
+	"path"
 )
 
-func NewTemplateCache() (map[string]*template.Template, error) {
+// NewTemplateCache parses every page template in fsys along with the layout and components.
+func NewTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
 	// Get all the page templates
-	pages, err := filepath.Glob("./web/templates/*.page.tmpl")
+	pages, err := fs.Glob(fsys, "*.page.tmpl")
 	if err != nil {
 		return nil, err
 	}
 
 	for _, page := range pages {
-		name := filepath.Base(page)
+		name := path.Base(page)
 
 		// Create a new template set for each page.
 		// The name of the template set is the page's filename.
-		ts, err := template.New(name).ParseFiles("./web/templates/base.layout.tmpl")
+		ts, err := template.New(name).ParseFS(fsys, "base.layout.tmpl")
 		if err != nil {
 			return nil, err
 		}
 
 		// Parse any component templates (partials)
 		// It's okay if there are no components yet.
-		matches, _ := filepath.Glob("./web/templates/components/*.tmpl")
+		matches, _ := fs.Glob(fsys, "components/*.tmpl")
 		if len(matches) > 0 {
-			ts, err = ts.ParseGlob("./web/templates/components/*.tmpl")
+			ts, err = ts.ParseFS(fsys, "components/*.tmpl")
 			if err != nil {
 				return nil, err
 			}
 		}
 
 		// Parse the page template itself
-		ts, err = ts.ParseFiles(page)
+		ts, err = ts.ParseFS(fsys, page)
 		if err != nil {
 			return nil, err
 		}
@@ -53,7 +55,18 @@ func NewTemplateCache() (map[string]*template.Template, error) {
 type TemplateData struct {
 	IsAuthenticated bool
 	UserRole        string
+	CSRFToken       string // Embedded in every form by the "csrf" component.
+	Flash           string // One-off message shown at the top of the next page.
 	Data            map[string]interface{}
+
+	// Impersonating is the username of the user an admin is viewing the
+	// site as, shown in a banner with a button to stop.
+	Impersonating         string
+	ImpersonationReadOnly bool // Changes are blocked while impersonating.
+
+	// CanRegister shows the links to the registration page, which are
+	// hidden when only invited people may register.
+	CanRegister bool
 }
 
 // newTemplateData creates a new TemplateData struct with default values.
@@ -61,12 +74,24 @@ func (h *Handlers) newTemplateData(r *http.Request) *TemplateData {
 	return &TemplateData{
 		IsAuthenticated: h.SessionManager.Exists(r.Context(), "authenticatedUserID"),
 		UserRole:        h.SessionManager.GetString(r.Context(), "userRole"),
+		CSRFToken:       h.SessionManager.GetString(r.Context(), "csrfToken"),
+		Flash:           h.SessionManager.PopString(r.Context(), "flash"),
 		Data:            make(map[string]interface{}),
+
+		Impersonating:         h.SessionManager.GetString(r.Context(), "impersonatedName"),
+		ImpersonationReadOnly: h.SessionManager.GetBool(r.Context(), "impersonationReadOnly"),
+
+		CanRegister: h.canRegister(),
 	}
 }
 
 // render renders a template from the cache.
 func (h *Handlers) render(w http.ResponseWriter, r *http.Request, name string, td *TemplateData) {
+	h.renderStatus(w, r, http.StatusOK, name, td)
+}
+
+// renderStatus renders a template from the cache with the given status code.
+func (h *Handlers) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, td *TemplateData) {
 	ts, ok := h.TemplateCache[name]
 	if !ok {
 		http.Error(w, fmt.Sprintf("The template %s does not exist", name), http.StatusInternalServerError)
@@ -82,5 +107,6 @@ func (h *Handlers) render(w http.ResponseWriter, r *http.Request, name string, t
 		return
 	}
 
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
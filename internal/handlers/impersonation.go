@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"lms/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// auditLogLength is how many of the latest audit log entries are shown.
+const auditLogLength = 200
+
+// StartImpersonation lets an admin see the site as a user sees it, to help
+// with their problems. The session becomes the user's, remembering the
+// admin so that they can go back, and the start is recorded in the audit
+// log. Changes can be blocked for the whole impersonation.
+func (h *Handlers) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if problem := h.impersonationProblem(r, user); problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	adminID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	admin, err := h.Users.GetUserByID(r.Context(), adminID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	readOnly := r.PostForm.Get("read_only") == "1"
+	details := "changes allowed"
+	if readOnly {
+		details = "changes blocked"
+	}
+	err = h.Audit.AddAuditEntry(r.Context(), &models.AuditEntry{
+		ActorID:    admin.ID,
+		ActorName:  admin.Username,
+		Action:     models.AuditImpersonationStart,
+		TargetID:   user.ID,
+		TargetName: user.Username,
+		Details:    fmt.Sprintf("%s, from %s", details, clientIP(r)),
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin %d (%s) is impersonating user %d (%s), %s", admin.ID, admin.Username, user.ID, user.Username, details)
+
+	// The impersonated user hasn't passed a second factor, but the admin
+	// gets theirs back when the impersonation ends.
+	adminVerified := h.SessionManager.GetBool(r.Context(), "twoFactorVerified")
+	if err := h.startSession(r, user, admin.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionManager.Put(r.Context(), "impersonatorID", admin.ID)
+	h.SessionManager.Put(r.Context(), "impersonatorTwoFactorVerified", adminVerified)
+	h.SessionManager.Put(r.Context(), "impersonatorName", admin.Username)
+	h.SessionManager.Put(r.Context(), "impersonatedName", user.Username)
+	h.SessionManager.Put(r.Context(), "impersonationReadOnly", readOnly)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// StopImpersonation ends an impersonation and gives the session back to
+// the admin, who lands on the page of the user they impersonated.
+func (h *Handlers) StopImpersonation(w http.ResponseWriter, r *http.Request) {
+	adminID := h.SessionManager.GetInt64(r.Context(), "impersonatorID")
+	if adminID == 0 {
+		http.Error(w, "You aren't impersonating anyone", http.StatusBadRequest)
+		return
+	}
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+
+	if err := h.auditImpersonationStop(r, "ended by the admin"); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The admin may have lost their role or been deactivated meanwhile.
+	admin, err := h.Users.GetUserByID(r.Context(), adminID)
+	if err != nil || !admin.Active() || admin.Role != "admin" {
+		if err := h.destroySession(r.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	adminVerified := h.SessionManager.GetBool(r.Context(), "impersonatorTwoFactorVerified")
+	if err := h.startSession(r, admin, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if adminVerified {
+		h.SessionManager.Put(r.Context(), "twoFactorVerified", true)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// AuditLog shows the latest entries of the audit log.
+func (h *Handlers) AuditLog(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.Audit.GetAuditEntries(r.Context(), auditLogLength)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Entries"] = entries
+	td.Data["Limit"] = auditLogLength
+	h.render(w, r, "admin_audit_log.page.tmpl", td)
+}
+
+// auditImpersonationStop records the end of the impersonation in the
+// session, if there is one.
+func (h *Handlers) auditImpersonationStop(r *http.Request, details string) error {
+	adminID := h.SessionManager.GetInt64(r.Context(), "impersonatorID")
+	if adminID == 0 {
+		return nil
+	}
+	err := h.Audit.AddAuditEntry(r.Context(), &models.AuditEntry{
+		ActorID:    adminID,
+		ActorName:  h.SessionManager.GetString(r.Context(), "impersonatorName"),
+		Action:     models.AuditImpersonationStop,
+		TargetID:   h.SessionManager.GetInt64(r.Context(), "authenticatedUserID"),
+		TargetName: h.SessionManager.GetString(r.Context(), "impersonatedName"),
+		Details:    details,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Admin %d stopped impersonating user %d, %s", adminID, h.SessionManager.GetInt64(r.Context(), "authenticatedUserID"), details)
+	return nil
+}
+
+// impersonationProblem returns why the logged-in admin can't impersonate
+// user, if they can't. Admins aren't impersonated, so that impersonation
+// never gives anyone more than what they already have.
+func (h *Handlers) impersonationProblem(r *http.Request, user *models.User) string {
+	switch {
+	case h.isCurrentUser(r, user.ID):
+		return "You can't impersonate yourself"
+	case user.Role == "admin":
+		return "Admins can't be impersonated"
+	case !user.Active():
+		return "Deactivated users can't be impersonated"
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/mail"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxInvitationsAtOnce limits how many addresses can be pasted at once.
+const maxInvitationsAtOnce = 500
+
+// ListInvitations lists the invitations with their status, below the form
+// for inviting more people.
+func (h *Handlers) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := h.Invitations.GetInvitations(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	courses, err := h.Courses.GetAllCourses(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	courseTitles := make(map[int64]string, len(courses))
+	for _, c := range courses {
+		courseTitles[c.ID] = c.Title
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Invitations"] = invitations
+	td.Data["Courses"] = courses
+	td.Data["CourseTitles"] = courseTitles
+	td.Data["Roles"] = userRoles
+	td.Data["Mode"] = h.RegistrationMode
+	td.Data["Now"] = time.Now()
+	h.render(w, r, "admin_invitations.page.tmpl", td)
+}
+
+// CreateInvitations invites the pasted email addresses to register with
+// the chosen role and courses, emailing each of them a link. Without
+// addresses, a single link is created for the admin to hand out.
+func (h *Handlers) CreateInvitations(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	role := r.PostForm.Get("role")
+	if !rbac.ValidRole(role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	var courseIDs []int64
+	for _, value := range r.PostForm["course_id"] {
+		courseID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid course ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := h.Courses.GetCourse(r.Context(), courseID); err != nil {
+			http.Error(w, "Course not found", http.StatusBadRequest)
+			return
+		}
+		courseIDs = append(courseIDs, courseID)
+	}
+
+	// Addresses may be separated by spaces, commas, semicolons or lines,
+	// as they are when copied from a spreadsheet or an email client.
+	emails := strings.FieldsFunc(r.PostForm.Get("emails"), func(c rune) bool {
+		return unicode.IsSpace(c) || c == ',' || c == ';'
+	})
+	if len(emails) > maxInvitationsAtOnce {
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Invite at most %d people at once.", maxInvitationsAtOnce))
+		http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+		return
+	}
+
+	adminID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	expiresAt := time.Now().UTC().Add(h.InvitationTTL)
+	invite := func(email string) (*models.Invitation, string, error) {
+		tok := token.New()
+		inv := &models.Invitation{
+			TokenHash: token.Hash(tok),
+			Email:     email,
+			Role:      role,
+			CourseIDs: courseIDs,
+			CreatedBy: adminID,
+			ExpiresAt: expiresAt,
+		}
+		if err := h.Invitations.CreateInvitation(r.Context(), inv); err != nil {
+			return nil, "", err
+		}
+		return inv, h.PublicURL + "/register?invite=" + url.QueryEscape(tok), nil
+	}
+
+	if len(emails) == 0 {
+		_, link, err := invite("")
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf(
+			"Invitation created. Send this link to the person you are inviting; it works once, until %s UTC: %s",
+			expiresAt.Format("2006-01-02 15:04"), link))
+		http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+		return
+	}
+
+	var sent int
+	var skipped, unsent []string
+	seen := make(map[string]bool)
+	for _, email := range emails {
+		if seen[strings.ToLower(email)] {
+			continue
+		}
+		seen[strings.ToLower(email)] = true
+
+		if !validEmail(email) {
+			skipped = append(skipped, email+" (invalid address)")
+			continue
+		}
+		_, err := h.Users.GetUserByEmail(r.Context(), email)
+		if err == nil {
+			skipped = append(skipped, email+" (already has an account)")
+			continue
+		}
+		if !errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		inv, link, err := invite(email)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.sendInvitation(r.Context(), inv, link); err != nil {
+			log.Printf("Failed to send invitation %d: %v", inv.ID, err)
+			unsent = append(unsent, email+": "+link)
+			continue
+		}
+		sent++
+	}
+	log.Printf("Admin %d invited %d people as %s", adminID, sent+len(unsent), role)
+
+	flash := fmt.Sprintf("Invitations sent: %d.", sent)
+	if len(skipped) > 0 {
+		flash += " Skipped " + strings.Join(skipped, ", ") + "."
+	}
+	if len(unsent) > 0 {
+		flash += " These invitations could not be emailed; send the links yourself: " + strings.Join(unsent, ", ")
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+// RevokeInvitation deletes an invitation that hasn't been accepted, so that
+// its link stops working.
+func (h *Handlers) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := strconv.ParseInt(chi.URLParam(r, "invitationID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Invitations.DeleteInvitation(r.Context(), invitationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Invitation not found or already accepted", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The invitation has been revoked.")
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+// sendInvitation emails the link of an invitation to its address.
+func (h *Handlers) sendInvitation(ctx context.Context, inv *models.Invitation, link string) error {
+	if h.Mailer == nil {
+		return errNoMailer
+	}
+
+	body := fmt.Sprintf(`Hello,
+
+You have been invited to create an account on the LMS. To register,
+open this link before %s UTC:
+
+%s
+
+If you weren't expecting this invitation, you can ignore this email.
+`, inv.ExpiresAt.Format("2006-01-02 15:04"), link)
+
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      inv.Email,
+		Subject: "You're invited to the LMS",
+		Body:    body,
+	})
+}
+
+// pendingInvitation returns the invitation with the token tok, or why it
+// can't be used to register.
+func (h *Handlers) pendingInvitation(ctx context.Context, tok string) (*models.Invitation, string, error) {
+	if h.RegistrationMode == "closed" {
+		return nil, "Registration is closed.", nil
+	}
+
+	inv, err := h.Invitations.GetInvitationByHash(ctx, token.Hash(tok))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "This invitation link is not valid.", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	switch inv.Status(time.Now()) {
+	case models.InvitationAccepted:
+		return nil, "This invitation has already been used.", nil
+	case models.InvitationExpired:
+		return nil, "This invitation has expired. Ask for a new one.", nil
+	}
+	return inv, "", nil
+}
+
+// canRegister reports whether people may register without an invitation.
+func (h *Handlers) canRegister() bool {
+	return h.RegistrationMode == "open" || h.RegistrationMode == "domain"
+}
+
+// registrationClosed explains why people can't register without an
+// invitation.
+func (h *Handlers) registrationClosed() string {
+	if h.RegistrationMode == "invite" {
+		return "Registration is by invitation only."
+	}
+	return "Registration is closed."
+}
+
+// emailDomainAllowed reports whether email is at one of the domains that
+// may register in the domain mode.
+func (h *Handlers) emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range h.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/webauthn"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SecuritySettings displays the passkeys and linked single sign-on
+// accounts of the logged-in user, with buttons to add more, and links to
+// the other login settings.
+func (h *Handlers) SecuritySettings(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	passkeys, err := h.Passkeys.GetPasskeysForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	identities, err := h.Identities.GetUserIdentitiesForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	twoFactor, err := h.twoFactorEnabled(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Passkeys"] = passkeys
+	td.Data["Identities"] = identities
+	td.Data["TwoFactor"] = twoFactor
+	if h.SSO != nil {
+		td.Data["SSOName"] = h.SSO.Name
+	}
+
+	// Options for the browser to create a passkey. The challenge is kept
+	// in the session until the passkey is added.
+	if h.WebAuthn != nil {
+		challenge := webauthn.NewChallenge()
+		h.SessionManager.Put(r.Context(), "passkeyRegisterChallenge", challenge)
+
+		var existing [][]byte
+		for _, p := range passkeys {
+			existing = append(existing, p.CredentialID)
+		}
+		td.Data["PasskeyOptions"] = h.WebAuthn.CreationOptions(challenge, webauthn.User{
+			Handle:      userHandle(user.ID),
+			Name:        user.Username,
+			DisplayName: user.Name(),
+		}, existing)
+	}
+
+	h.render(w, r, "security.page.tmpl", td)
+}
+
+// AddPasskey registers the passkey the browser created for the logged-in user.
+func (h *Handlers) AddPasskey(w http.ResponseWriter, r *http.Request) {
+	if h.WebAuthn == nil {
+		http.Error(w, "Passkeys are not available", http.StatusNotFound)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	challenge := h.SessionManager.PopString(r.Context(), "passkeyRegisterChallenge")
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Passkey names must be at most %d characters long.", maxNameLength))
+		http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+		return
+	}
+
+	cred, err := h.WebAuthn.VerifyRegistration(challenge, formBytes(r, "client_data"), formBytes(r, "attestation_object"))
+	if err != nil {
+		log.Printf("Passkey registration failed for user %d: %v", userID, err)
+		h.SessionManager.Put(r.Context(), "flash", "The passkey could not be added. Please try again.")
+		http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+		return
+	}
+
+	_, err = h.Passkeys.CreatePasskey(r.Context(), userID, cred.ID, cred.PublicKey, cred.SignCount, name)
+	if errors.Is(err, database.ErrPasskeyExists) {
+		h.SessionManager.Put(r.Context(), "flash", "That passkey is already registered.")
+		http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Passkey %q added. You can now use it to log in.", name))
+	http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+}
+
+// DeletePasskey removes a passkey of the logged-in user.
+func (h *Handlers) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	passkeyID, err := strconv.ParseInt(chi.URLParam(r, "passkeyID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	err = h.Passkeys.DeletePasskey(r.Context(), userID, passkeyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The passkey has been removed.")
+	http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+}
+
+// LoginPasskey logs in the owner of the passkey the browser signed the
+// login challenge with. Passkeys verify the user with a PIN or biometric,
+// so they also count as two-factor authentication.
+func (h *Handlers) LoginPasskey(w http.ResponseWriter, r *http.Request) {
+	if h.WebAuthn == nil {
+		http.Error(w, "Passkeys are not available", http.StatusNotFound)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	challenge := h.SessionManager.PopString(r.Context(), "passkeyLoginChallenge")
+
+	passkey, err := h.Passkeys.GetPasskeyByCredentialID(r.Context(), formBytes(r, "credential_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		td := h.loginTemplateData(r)
+		td.Data["Error"] = "That passkey is not registered. Log in with your password and add it from your security settings."
+		h.renderStatus(w, r, http.StatusUnauthorized, "login.page.tmpl", td)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	signCount, err := h.WebAuthn.VerifyAssertion(challenge, passkey.PublicKey, passkey.SignCount, webauthn.Assertion{
+		ClientDataJSON:    formBytes(r, "client_data"),
+		AuthenticatorData: formBytes(r, "authenticator_data"),
+		Signature:         formBytes(r, "signature"),
+	})
+	// The authenticator may send the handle of the account the passkey
+	// was created for, which must be its owner.
+	if handle := formBytes(r, "user_handle"); err == nil && len(handle) > 0 && !bytes.Equal(handle, userHandle(passkey.UserID)) {
+		err = errors.New("user handle does not match the passkey")
+	}
+	if err != nil {
+		log.Printf("Passkey login failed for user %d: %v", passkey.UserID, err)
+		td := h.loginTemplateData(r)
+		td.Data["Error"] = "The passkey could not be verified. Please try again."
+		h.renderStatus(w, r, http.StatusUnauthorized, "login.page.tmpl", td)
+		return
+	}
+
+	if err := h.Passkeys.UsePasskey(r.Context(), passkey.ID, signCount, time.Now().UTC()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), passkey.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !user.Active() {
+		td := h.loginTemplateData(r)
+		td.Data["Error"] = "Your account has been deactivated."
+		h.renderStatus(w, r, http.StatusForbidden, "login.page.tmpl", td)
+		return
+	}
+
+	if err := h.startSession(r, user, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionManager.Put(r.Context(), "twoFactorVerified", true)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// loginTemplateData returns the template data of the login page, with a
+// new challenge for logging in with a passkey and the name of the single
+// sign-on provider.
+func (h *Handlers) loginTemplateData(r *http.Request) *TemplateData {
+	td := h.newTemplateData(r)
+	if h.SSO != nil {
+		td.Data["SSOName"] = h.SSO.Name
+	}
+	if h.WebAuthn != nil {
+		challenge := webauthn.NewChallenge()
+		h.SessionManager.Put(r.Context(), "passkeyLoginChallenge", challenge)
+		td.Data["PasskeyOptions"] = h.WebAuthn.RequestOptions(challenge)
+	}
+	return td
+}
+
+// userHandle returns the WebAuthn user handle of a user: their ID, which
+// tells nothing about them.
+func userHandle(userID int64) []byte {
+	return []byte(strconv.FormatInt(userID, 10))
+}
+
+// formBytes decodes a base64url form value, as sent by passkeys.js. It
+// returns nil if the value is missing or invalid, which fails verification.
+func formBytes(r *http.Request, name string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(r.PostForm.Get(name))
+	if err != nil {
+		return nil
+	}
+	return b
+}
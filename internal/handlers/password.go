@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/mail"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ForgotPasswordForm displays the form for requesting a password reset link.
+func (h *Handlers) ForgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	td := h.newTemplateData(r)
+	h.render(w, r, "forgot_password.page.tmpl", td)
+}
+
+// ForgotPassword emails a password reset link to the user with the given
+// username or email address. The response is the same whether or not the
+// user exists, so the form can't be used to discover accounts.
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	login := strings.TrimSpace(r.PostForm.Get("login"))
+	if login == "" {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Enter your username or email address."
+		h.renderStatus(w, r, http.StatusBadRequest, "forgot_password.page.tmpl", td)
+		return
+	}
+
+	// Usernames can't be looked up by email and vice versa.
+	var user *models.User
+	if strings.Contains(login, "@") {
+		user, err = h.Users.GetUserByEmail(r.Context(), login)
+	} else {
+		user, err = h.Users.GetUserByUsername(r.Context(), login)
+	}
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only users with an email address can receive the link.
+	if user != nil && user.Email != "" && h.Mailer != nil {
+		link, err := h.createPasswordReset(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// Don't reveal that the user exists if the email can't be sent.
+		if err := h.sendPasswordReset(r.Context(), user, link); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Sent"] = true
+	h.render(w, r, "forgot_password.page.tmpl", td)
+}
+
+// ResetPasswordForm displays the form for choosing a new password, if the
+// token in the link is still valid.
+func (h *Handlers) ResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+
+	td := h.newTemplateData(r)
+	td.Data["Token"] = tok
+
+	valid, err := h.validPasswordReset(r.Context(), tok)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		td.Data["Invalid"] = true
+		h.renderStatus(w, r, http.StatusBadRequest, "reset_password.page.tmpl", td)
+		return
+	}
+
+	h.render(w, r, "reset_password.page.tmpl", td)
+}
+
+// ResetPassword sets a new password for the user of a reset token and uses
+// up the token. The user is logged out everywhere and their API tokens are
+// revoked.
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	tok := r.PostForm.Get("token")
+	password := r.PostForm.Get("password")
+	confirm := r.PostForm.Get("confirm_password")
+
+	td := h.newTemplateData(r)
+	td.Data["Token"] = tok
+
+	// Basic validation
+	if password == "" || password != confirm {
+		td.Data["Error"] = "Enter the same new password twice."
+		h.renderStatus(w, r, http.StatusBadRequest, "reset_password.page.tmpl", td)
+		return
+	}
+
+	// Use up the token and change the password together, so a failure
+	// leaves the token valid for another try. Whoever knew the old password
+	// may have logged in or made API tokens with it, so those go too.
+	var user *models.User
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		userID, err := tx.UsePasswordReset(r.Context(), token.Hash(tok), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := tx.SetUserPassword(r.Context(), userID, password); err != nil {
+			return err
+		}
+		if err := deleteAPITokens(r.Context(), tx, userID); err != nil {
+			return err
+		}
+		if err := tx.DeleteUserSessionsForUser(r.Context(), userID); err != nil {
+			return err
+		}
+		user, err = tx.GetUserByID(r.Context(), userID)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		td.Data["Invalid"] = true
+		h.renderStatus(w, r, http.StatusBadRequest, "reset_password.page.tmpl", td)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The user has proved they own the account, so lift any lockout.
+	if h.Throttle != nil {
+		if err := h.Throttle.Unlock(r.Context(), user.Username); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// This session has ended too if the user was logged in on it. Destroy
+	// it now, rather than on the next request, so the flash survives.
+	if h.isCurrentUser(r, user.ID) {
+		if err := h.SessionManager.Destroy(r.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "Your password has been changed. You can now log in.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// AdminSendPasswordReset creates a password reset link for a user. It is
+// emailed to the user if they have an email address, and otherwise shown
+// to the admin to pass on.
+//
+// A forced reset also replaces the current password with a random one,
+// logs the user out and revokes their API tokens, so that the account is
+// only usable again through the link.
+func (h *Handlers) AdminSendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	force := r.PostForm.Get("force") == "1"
+	if force {
+		if h.isCurrentUser(r, user.ID) {
+			http.Error(w, "You can't force a password reset of your own account", http.StatusBadRequest)
+			return
+		}
+		err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+			if err := tx.SetUserPassword(r.Context(), user.ID, token.New()); err != nil {
+				return err
+			}
+			return deleteAPITokens(r.Context(), tx, user.ID)
+		})
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.endSessions(r.Context(), user.ID); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	link, err := h.createPasswordReset(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var flash string
+	if user.Email != "" && h.Mailer != nil {
+		if err := h.sendPasswordReset(r.Context(), user, link); err != nil {
+			http.Error(w, "Failed to send email: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		flash = fmt.Sprintf("A password reset link was sent to %s.", user.Email)
+	} else {
+		flash = fmt.Sprintf("%s has no email address. Send them this password reset link: %s", user.Username, link)
+	}
+	if force {
+		flash = fmt.Sprintf("The password of %s no longer works and they have been logged out. %s", user.Username, flash)
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+
+	// Redirect back to the user detail page.
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// deleteAPITokens revokes all the API tokens of a user, for when their
+// password is replaced.
+func deleteAPITokens(ctx context.Context, tx database.Store, userID int64) error {
+	apiTokens, err := tx.GetAPITokensForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, t := range apiTokens {
+		if err := tx.DeleteAPIToken(ctx, userID, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPasswordReset stores a new reset token for the user and returns
+// the link that uses it.
+func (h *Handlers) createPasswordReset(ctx context.Context, userID int64) (string, error) {
+	tok := token.New()
+	expiresAt := time.Now().UTC().Add(h.ResetTokenTTL)
+	if err := h.PasswordResets.CreatePasswordReset(ctx, userID, token.Hash(tok), expiresAt); err != nil {
+		return "", err
+	}
+	return h.PublicURL + "/reset-password?token=" + url.QueryEscape(tok), nil
+}
+
+// sendPasswordReset emails the reset link to the user.
+func (h *Handlers) sendPasswordReset(ctx context.Context, user *models.User, link string) error {
+	body := fmt.Sprintf(`Hello %s,
+
+Someone asked to reset the password of your LMS account. To choose a new
+password, open this link within %s:
+
+%s
+
+If you didn't ask for this, you can ignore this email. Your password
+stays the same.
+`, user.Username, humanDuration(h.ResetTokenTTL), link)
+
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your LMS password",
+		Body:    body,
+	})
+}
+
+// validPasswordReset reports whether tok is an unused, unexpired reset token.
+func (h *Handlers) validPasswordReset(ctx context.Context, tok string) (bool, error) {
+	if tok == "" {
+		return false, nil
+	}
+	pr, err := h.PasswordResets.GetPasswordReset(ctx, token.Hash(tok))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pr.UsedAt.IsZero() && pr.ExpiresAt.After(time.Now()), nil
+}
+
+// humanDuration formats d in whole hours or minutes, e.g. "1 hour".
+func humanDuration(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
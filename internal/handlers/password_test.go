@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"context"
+	"lms/internal/mail"
+	"lms/internal/rbac"
+	"lms/internal/throttle"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMailer keeps the emails it is asked to send.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) messages() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.sent...)
+}
+
+var resetLinkPattern = regexp.MustCompile(`https://lms\.example\.com/reset-password\?token=(\S+)`)
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	mailer := &recordingMailer{}
+	app.h.Mailer = mailer
+	app.h.PublicURL = "https://lms.example.com"
+	app.h.Throttle = throttle.NewLimiter(app.store, throttle.Policy{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 100,
+		LockoutBase:      time.Hour,
+		LockoutMax:       time.Hour,
+	})
+	ctx := context.Background()
+	ann := app.createUser("ann", rbac.RoleStudent)
+	if err := app.store.SetUserEmail(ctx, ann.ID, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.store.CreateAPIToken(ctx, ann.ID, "script", "hash", []string{"read"}, false, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ann is logged in on one browser, and someone locked her out on another.
+	loggedIn := app.newClient()
+	loggedIn.login("ann")
+	c := app.newClient()
+	for range 3 {
+		c.post("/login", url.Values{"username": {"ann"}, "password": {"guess"}})
+	}
+	if res := c.post("/login", url.Values{"username": {"ann"}, "password": {"secret"}}); res.status != http.StatusTooManyRequests {
+		t.Fatalf("login after 3 failures: got %d, want 429", res.status)
+	}
+
+	// Unknown users get the same answer, and no email.
+	if res := c.post("/forgot-password", url.Values{"login": {"nobody@example.com"}}); res.status != http.StatusOK {
+		t.Fatalf("forgot password for an unknown user: got %d, want 200", res.status)
+	}
+	if res := c.post("/forgot-password", url.Values{"login": {"ann@example.com"}}); res.status != http.StatusOK {
+		t.Fatalf("forgot password: got %d, want 200", res.status)
+	}
+	sent := mailer.messages()
+	if len(sent) != 1 || sent[0].To != "ann@example.com" {
+		t.Fatalf("sent %+v, want one email to ann@example.com", sent)
+	}
+	m := resetLinkPattern.FindStringSubmatch(sent[0].Body)
+	if m == nil {
+		t.Fatalf("no reset link in %q", sent[0].Body)
+	}
+	tok := m[1]
+
+	if res := c.get("/reset-password?token=" + url.QueryEscape(tok)); res.status != http.StatusOK {
+		t.Fatalf("reset form: got %d, want 200", res.status)
+	}
+	res := c.post("/reset-password", url.Values{"token": {tok}, "password": {"new"}, "confirm_password": {"other"}})
+	if res.status != http.StatusBadRequest {
+		t.Errorf("mismatched passwords: got %d, want 400", res.status)
+	}
+	res = c.post("/reset-password", url.Values{"token": {tok}, "password": {"new"}, "confirm_password": {"new"}})
+	if res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Fatalf("reset: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+	if res := c.get("/login"); !strings.Contains(res.body, "Your password has been changed") {
+		t.Error("no flash after the reset")
+	}
+
+	// The link works once, and the old sessions and API tokens are gone.
+	res = c.post("/reset-password", url.Values{"token": {tok}, "password": {"again"}, "confirm_password": {"again"}})
+	if res.status != http.StatusBadRequest {
+		t.Errorf("reusing the link: got %d, want 400", res.status)
+	}
+	if res := loggedIn.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("profile on the old session: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+	tokens, err := app.store.GetAPITokensForUser(ctx, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("%d API tokens left after the reset", len(tokens))
+	}
+
+	// The lockout was lifted, and only the new password works.
+	if res := c.post("/login", url.Values{"username": {"ann"}, "password": {"secret"}}); res.status != http.StatusUnauthorized {
+		t.Errorf("login with the old password: got %d, want 401", res.status)
+	}
+	if res := c.post("/login", url.Values{"username": {"ann"}, "password": {"new"}}); res.status != http.StatusSeeOther || res.location != "/" {
+		t.Errorf("login with the new password: got %d to %q, want 303 to /", res.status, res.location)
+	}
+}
+
+func TestPasswordResetEndsCurrentSession(t *testing.T) {
+	app := newTestApp(t)
+	mailer := &recordingMailer{}
+	app.h.Mailer = mailer
+	app.h.PublicURL = "https://lms.example.com"
+	ann := app.createUser("ann", rbac.RoleStudent)
+	if err := app.store.SetUserEmail(context.Background(), ann.ID, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ann resets her password on the browser she is logged in on.
+	c := app.newClient()
+	c.login("ann")
+	c.post("/forgot-password", url.Values{"login": {"ann"}})
+	sent := mailer.messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	tok := resetLinkPattern.FindStringSubmatch(sent[0].Body)[1]
+	res := c.post("/reset-password", url.Values{"token": {tok}, "password": {"new"}, "confirm_password": {"new"}})
+	if res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Fatalf("reset: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+	if res := c.get("/login"); !strings.Contains(res.body, "Your password has been changed") {
+		t.Error("no flash after the reset")
+	}
+	if res := c.get("/profile"); res.status != http.StatusSeeOther || res.location != "/login" {
+		t.Errorf("profile after the reset: got %d to %q, want 303 to /login", res.status, res.location)
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/mail"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxNameLength limits the names users can enter on their profile.
+const maxNameLength = 200
+
+// Profile displays the profile of the logged-in user.
+func (h *Handlers) Profile(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["User"] = user
+	h.render(w, r, "profile.page.tmpl", td)
+}
+
+// UpdateProfile saves the names, timezone and email address of the
+// logged-in user. A new email address is sent a verification link.
+func (h *Handlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Show the form again with what was entered.
+	form := *user
+	form.FullName = strings.TrimSpace(r.PostForm.Get("full_name"))
+	form.DisplayName = strings.TrimSpace(r.PostForm.Get("display_name"))
+	form.Timezone = strings.TrimSpace(r.PostForm.Get("timezone"))
+	form.Email = strings.TrimSpace(r.PostForm.Get("email"))
+	if form.Timezone == "" {
+		form.Timezone = "UTC"
+	}
+
+	var problem string
+	switch {
+	case utf8.RuneCountInString(form.FullName) > maxNameLength || utf8.RuneCountInString(form.DisplayName) > maxNameLength:
+		problem = fmt.Sprintf("Names must be at most %d characters long.", maxNameLength)
+	case !validTimezone(form.Timezone):
+		problem = "Unknown timezone. Use a name such as Europe/Paris or America/New_York."
+	case form.Email != "" && !validEmail(form.Email):
+		problem = "Invalid email address."
+	}
+
+	if problem == "" {
+		err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+			if err := tx.UpdateUserProfile(r.Context(), userID, form.FullName, form.DisplayName, form.Timezone); err != nil {
+				return err
+			}
+			if form.Email == user.Email {
+				return nil
+			}
+			return tx.SetUserEmail(r.Context(), userID, form.Email)
+		})
+		if errors.Is(err, database.ErrEmailTaken) {
+			problem = "That email address is already in use."
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if problem != "" {
+		td := h.newTemplateData(r)
+		td.Data["User"] = &form
+		td.Data["Error"] = problem
+		h.renderStatus(w, r, http.StatusBadRequest, "profile.page.tmpl", td)
+		return
+	}
+
+	// The address is saved even if the email can't be sent; the user can
+	// ask for another link from this page.
+	flash := "Your profile has been saved."
+	if form.Email != user.Email && form.Email != "" {
+		if err := h.sendEmailVerification(r.Context(), &form); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+			flash += " The verification email could not be sent; try again later."
+		} else {
+			flash += fmt.Sprintf(" We have sent a verification link to %s.", form.Email)
+		}
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+// ResendEmailVerification sends a new verification link to the email
+// address of the logged-in user.
+func (h *Handlers) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user.Email == "" || !user.EmailVerifiedAt.IsZero() {
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+
+	flash := fmt.Sprintf("We have sent a new verification link to %s.", user.Email)
+	if err := h.sendEmailVerification(r.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		flash = "The verification email could not be sent; try again later."
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+// VerifyEmail marks the email address a verification link was sent to as
+// verified.
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+
+	_, err := h.EmailVerifications.UseEmailVerification(r.Context(), token.Hash(tok), time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		td := h.newTemplateData(r)
+		h.renderStatus(w, r, http.StatusBadRequest, "verify_email.page.tmpl", td)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "Thank you, your email address has been verified.")
+	if h.SessionManager.Exists(r.Context(), "authenticatedUserID") {
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// errNoMailer is returned when an email is needed but none can be sent.
+var errNoMailer = errors.New("no mailer is configured")
+
+// sendEmailVerification emails a link that verifies the user's address.
+func (h *Handlers) sendEmailVerification(ctx context.Context, user *models.User) error {
+	if h.Mailer == nil {
+		return errNoMailer
+	}
+
+	tok := token.New()
+	expiresAt := time.Now().UTC().Add(h.VerifyTokenTTL)
+	if err := h.EmailVerifications.CreateEmailVerification(ctx, user.ID, user.Email, token.Hash(tok), expiresAt); err != nil {
+		return err
+	}
+
+	link := h.PublicURL + "/verify-email?token=" + url.QueryEscape(tok)
+	body := fmt.Sprintf(`Hello %s,
+
+Please confirm that this is your email address by opening this link
+within %s:
+
+%s
+
+If you didn't create an LMS account or change your email address, you
+can ignore this email.
+`, user.Name(), humanDuration(h.VerifyTokenTTL), link)
+
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+}
+
+// validTimezone reports whether name is an IANA timezone such as "Europe/Paris".
+func validTimezone(name string) bool {
+	// LoadLocation also accepts "Local", which means nothing to the user.
+	if name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
@@ -0,0 +1,979 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/models"
+	"lms/internal/scim"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The SCIM 2.0 API (RFC 7644) lets an identity platform create, update and
+// deactivate users, and manage groups whose members are enrolled in the
+// group's courses.
+//
+// Deleting a user deactivates them and removes them from their groups,
+// rather than deleting their progress and certificates: the resource can
+// still be read, with "active" false.
+
+const (
+	// maxSCIMBody limits the size of request bodies.
+	maxSCIMBody = 1 << 20
+	// maxSCIMResults is the default and largest page size of queries.
+	maxSCIMResults = 100
+)
+
+// RequireSCIMToken lets through the requests with the SCIM bearer token.
+func (h *Handlers) RequireSCIMToken(next http.Handler) http.Handler {
+	// Compare digests so that the comparison takes the same time whatever
+	// the length of the token sent.
+	want := sha256.Sum256([]byte(h.SCIMToken))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, sent, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		got := sha256.Sum256([]byte(sent))
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			log.Printf("SCIM request from %s refused: invalid bearer token", clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, scim.Errorf(http.StatusUnauthorized, "", "A valid bearer token is required."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SCIMServiceProviderConfig describes the parts of SCIM we support.
+func (h *Handlers) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          map[string]any{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": maxSCIMResults},
+		"changePassword": map[string]any{"supported": true},
+		"sort":           map[string]any{"supported": false},
+		"etag":           map[string]any{"supported": false},
+		"authenticationSchemes": []any{map[string]any{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token set in scim.token, sent in the Authorization header.",
+			"primary":     true,
+		}},
+		"meta": map[string]any{
+			"resourceType": "ServiceProviderConfig",
+			"location":     h.PublicURL + "/scim/v2/ServiceProviderConfig",
+		},
+	})
+}
+
+// --- Users ---
+
+// ListSCIMUsers returns the users matching the filter, a page at a time.
+func (h *Handlers) ListSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.Users.GetAllUsers(r.Context())
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	// Look up the groups of all users at once.
+	groups, err := h.Groups.GetAllGroups(r.Context())
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	groupsByUser := map[int64][]*models.Group{}
+	for _, g := range groups {
+		members, err := h.Groups.GetGroupMembers(r.Context(), g.ID)
+		if err != nil {
+			h.scimFail(w, err)
+			return
+		}
+		for _, m := range members {
+			groupsByUser[m.ID] = append(groupsByUser[m.ID], g)
+		}
+	}
+
+	resources := make([]map[string]any, len(users))
+	for i, u := range users {
+		resources[i] = h.scimUser(u, groupsByUser[u.ID])
+	}
+	h.writeSCIMList(w, r, resources)
+}
+
+// GetSCIMUser returns a user.
+func (h *Handlers) GetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.scimUserByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	res, err := h.loadSCIMUser(r.Context(), user)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, excludeAttributes(res, r))
+}
+
+// CreateSCIMUser creates a student. Without a password, they can only log
+// in once they have reset it, or with single sign-on.
+func (h *Handlers) CreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	body, err := readSCIM(w, r)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	in, err := parseSCIMUser(body)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+
+	var user *models.User
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		password := token.New()
+		if in.Password != nil {
+			password = *in.Password
+		}
+		user, err = tx.CreateUser(r.Context(), in.UserName, password, "student")
+		if err != nil {
+			return err
+		}
+		in.Password = nil
+		return applySCIMUser(r.Context(), tx, user, in)
+	})
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	log.Printf("SCIM: created user %d (%s)", user.ID, user.Username)
+
+	res, err := h.loadSCIMUser(r.Context(), user)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	w.Header().Set("Location", h.scimLocation("Users", user.ID))
+	writeSCIM(w, http.StatusCreated, res)
+}
+
+// ReplaceSCIMUser sets the attributes of a user. Attributes left out are
+// left unchanged, while null clears them.
+func (h *Handlers) ReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	body, err := readSCIM(w, r)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	in, err := parseSCIMUser(body)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	user, err := h.scimUserByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	h.updateSCIMUser(w, r, user, in)
+}
+
+// PatchSCIMUser applies PATCH operations to a user.
+func (h *Handlers) PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	if err := readSCIMInto(w, r, &req); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	user, err := h.scimUserByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	res, err := h.loadSCIMUser(r.Context(), user)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+
+	// Apply the operations to the current resource, and then save it whole.
+	res = normalizeSCIM(res)
+	if err := req.Apply(res); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	in, err := parseSCIMUser(res)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	h.updateSCIMUser(w, r, user, in)
+}
+
+// updateSCIMUser saves the attributes of a user, and responds with the
+// updated user.
+func (h *Handlers) updateSCIMUser(w http.ResponseWriter, r *http.Request, user *models.User, in *scimUserInput) {
+	// The password and email address of an admin would let the platform
+	// take over the account, so they are theirs to change unless allowed.
+	emailChanged := in.Email != nil && *in.Email != user.Email
+	if user.Role == "admin" && !h.SCIMManageAdmins && (in.Password != nil || emailChanged) {
+		log.Printf("SCIM: refused to change the password or email address of admin %d (%s)", user.ID, user.Username)
+		writeSCIMError(w, scim.Errorf(http.StatusForbidden, "", "The password and email address of admins can't be changed through SCIM."))
+		return
+	}
+
+	err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		if in.UserName != user.Username {
+			if err := tx.SetUsername(r.Context(), user.ID, in.UserName); err != nil {
+				return err
+			}
+			user.Username = in.UserName
+		}
+		return applySCIMUser(r.Context(), tx, user, in)
+	})
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	// Deactivated users, and users whose password was replaced, are logged
+	// out. Done on every such request, so that a retry after a failure logs
+	// them out too.
+	if !user.Active() || in.Password != nil {
+		if err := h.endSessions(r.Context(), user.ID); err != nil {
+			h.scimFail(w, err)
+			return
+		}
+	}
+
+	res, err := h.loadSCIMUser(r.Context(), user)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, res)
+}
+
+// DeleteSCIMUser deactivates a user and removes them from their groups.
+func (h *Handlers) DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.scimUserByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		if err := tx.DeactivateUser(r.Context(), user.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+		groups, err := tx.GetGroupsForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if err := tx.RemoveGroupMember(r.Context(), g.ID, user.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	if err := h.endSessions(r.Context(), user.ID); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	log.Printf("SCIM: deactivated user %d (%s)", user.ID, user.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimUserInput holds the attributes of a User resource sent by a client.
+// Nil fields were not sent.
+type scimUserInput struct {
+	UserName    string
+	FullName    *string
+	DisplayName *string
+	Email       *string
+	Active      *bool
+	Timezone    *string
+	Password    *string
+}
+
+// parseSCIMUser reads and checks the attributes of a User resource. The
+// read-only attributes, such as id and groups, are ignored.
+func parseSCIMUser(res map[string]any) (*scimUserInput, error) {
+	in := &scimUserInput{}
+	userName, err := scimString(res, "userName")
+	if err != nil {
+		return nil, err
+	}
+	if userName == nil || *userName == "" {
+		return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "userName is required")
+	}
+	in.UserName = *userName
+
+	// The full name is printed on certificates. Clients send it whole, or
+	// in parts, or both.
+	if v, ok := scim.Lookup(res, "name"); ok {
+		name, isMap := v.(map[string]any)
+		if v != nil && !isMap {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "name must be an object")
+		}
+		full, err := scimString(name, "formatted")
+		if err != nil {
+			return nil, err
+		}
+		if full == nil || *full == "" {
+			var parts []string
+			for _, attr := range []string{"honorificPrefix", "givenName", "middleName", "familyName", "honorificSuffix"} {
+				part, err := scimString(name, attr)
+				if err != nil {
+					return nil, err
+				}
+				if part != nil && *part != "" {
+					parts = append(parts, *part)
+				}
+			}
+			joined := strings.Join(parts, " ")
+			full = &joined
+		}
+		in.FullName = full
+	}
+	if in.DisplayName, err = scimString(res, "displayName"); err != nil {
+		return nil, err
+	}
+	for _, name := range []*string{in.FullName, in.DisplayName} {
+		if name != nil && utf8.RuneCountInString(*name) > maxNameLength {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "names must be at most %d characters long", maxNameLength)
+		}
+	}
+
+	// Users have a single email address: the primary one, or else the first.
+	if v, ok := scim.Lookup(res, "emails"); ok {
+		email := ""
+		emails, _ := v.([]any)
+		if v != nil && emails == nil {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "emails must be an array")
+		}
+		for i, e := range emails {
+			m, ok := e.(map[string]any)
+			if !ok {
+				return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "emails must hold objects")
+			}
+			value, err := scimString(m, "value")
+			if err != nil {
+				return nil, err
+			}
+			primary, err := scimBool(m, "primary")
+			if err != nil {
+				return nil, err
+			}
+			if value != nil && *value != "" && (i == 0 || email == "" || (primary != nil && *primary)) {
+				email = *value
+			}
+		}
+		if email != "" && !validEmail(email) {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "invalid email address %q", email)
+		}
+		in.Email = &email
+	}
+
+	if in.Active, err = scimBool(res, "active"); err != nil {
+		return nil, err
+	}
+	if in.Timezone, err = scimString(res, "timezone"); err != nil {
+		return nil, err
+	}
+	if in.Timezone != nil {
+		if *in.Timezone == "" {
+			*in.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(*in.Timezone); err != nil {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "unknown timezone %q", *in.Timezone)
+		}
+	}
+	if in.Password, err = scimString(res, "password"); err != nil {
+		return nil, err
+	}
+	if in.Password != nil && *in.Password == "" {
+		in.Password = nil
+	}
+	return in, nil
+}
+
+// applySCIMUser saves the attributes of a user other than the username.
+func applySCIMUser(ctx context.Context, tx database.Store, user *models.User, in *scimUserInput) error {
+	profile := *user
+	if in.FullName != nil {
+		profile.FullName = *in.FullName
+	}
+	if in.DisplayName != nil {
+		profile.DisplayName = *in.DisplayName
+	}
+	if in.Timezone != nil {
+		profile.Timezone = *in.Timezone
+	}
+	if profile.FullName != user.FullName || profile.DisplayName != user.DisplayName || profile.Timezone != user.Timezone {
+		if err := tx.UpdateUserProfile(ctx, user.ID, profile.FullName, profile.DisplayName, profile.Timezone); err != nil {
+			return err
+		}
+		user.FullName, user.DisplayName, user.Timezone = profile.FullName, profile.DisplayName, profile.Timezone
+	}
+
+	if in.Email != nil && *in.Email != user.Email {
+		if err := tx.SetUserEmail(ctx, user.ID, *in.Email); err != nil {
+			return err
+		}
+		user.Email = *in.Email
+	}
+	// A new password also revokes the API tokens, which the old one could
+	// have created.
+	if in.Password != nil {
+		if err := tx.SetUserPassword(ctx, user.ID, *in.Password); err != nil {
+			return err
+		}
+		if err := deleteAPITokens(ctx, tx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case in.Active == nil || *in.Active == user.Active():
+	case *in.Active:
+		if err := tx.ReactivateUser(ctx, user.ID); err != nil {
+			return err
+		}
+		user.DeactivatedAt = time.Time{}
+		user.AwaitingVerification = false
+		log.Printf("SCIM: reactivated user %d (%s)", user.ID, user.Username)
+	default:
+		user.DeactivatedAt = time.Now().UTC()
+		if err := tx.DeactivateUser(ctx, user.ID, user.DeactivatedAt); err != nil {
+			return err
+		}
+		log.Printf("SCIM: deactivated user %d (%s)", user.ID, user.Username)
+	}
+	return nil
+}
+
+// scimUserByID returns the user with an ID from a URL.
+func (h *Handlers) scimUserByID(ctx context.Context, id string) (*models.User, error) {
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, scim.Errorf(http.StatusNotFound, "", "User %s not found", id)
+	}
+	user, err := h.Users.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, scim.Errorf(http.StatusNotFound, "", "User %s not found", id)
+	}
+	return user, err
+}
+
+// loadSCIMUser returns the User resource of a user.
+func (h *Handlers) loadSCIMUser(ctx context.Context, user *models.User) (map[string]any, error) {
+	groups, err := h.Groups.GetGroupsForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return h.scimUser(user, groups), nil
+}
+
+// scimUser returns the User resource of a user in the given groups.
+func (h *Handlers) scimUser(user *models.User, groups []*models.Group) map[string]any {
+	res := map[string]any{
+		"schemas":  []any{scim.SchemaUser},
+		"id":       strconv.FormatInt(user.ID, 10),
+		"userName": user.Username,
+		"active":   user.Active(),
+		"timezone": user.Timezone,
+		"meta": map[string]any{
+			"resourceType": "User",
+			"location":     h.scimLocation("Users", user.ID),
+		},
+	}
+	if user.FullName != "" {
+		res["name"] = map[string]any{"formatted": user.FullName}
+	}
+	if user.DisplayName != "" {
+		res["displayName"] = user.DisplayName
+	}
+	if user.Email != "" {
+		res["emails"] = []any{map[string]any{"value": user.Email, "type": "work", "primary": true}}
+	}
+	if len(groups) > 0 {
+		var refs []any
+		for _, g := range groups {
+			refs = append(refs, map[string]any{
+				"value":   strconv.FormatInt(g.ID, 10),
+				"display": g.Name,
+				"$ref":    h.scimLocation("Groups", g.ID),
+			})
+		}
+		res["groups"] = refs
+	}
+	return res
+}
+
+// --- Groups ---
+
+// ListSCIMGroups returns the groups matching the filter, a page at a time.
+func (h *Handlers) ListSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.Groups.GetAllGroups(r.Context())
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	resources := make([]map[string]any, len(groups))
+	for i, g := range groups {
+		if resources[i], err = h.loadSCIMGroup(r.Context(), g); err != nil {
+			h.scimFail(w, err)
+			return
+		}
+	}
+	h.writeSCIMList(w, r, resources)
+}
+
+// GetSCIMGroup returns a group.
+func (h *Handlers) GetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.scimGroupByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	res, err := h.loadSCIMGroup(r.Context(), group)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, excludeAttributes(res, r))
+}
+
+// CreateSCIMGroup creates a group.
+func (h *Handlers) CreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	body, err := readSCIM(w, r)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	name, members, err := parseSCIMGroup(body)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+
+	var group *models.Group
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		group, err = tx.CreateGroup(r.Context(), name)
+		if err != nil {
+			return err
+		}
+		return applySCIMGroup(r.Context(), tx, group, name, members)
+	})
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	log.Printf("SCIM: created group %d (%s)", group.ID, group.Name)
+
+	res, err := h.loadSCIMGroup(r.Context(), group)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	w.Header().Set("Location", h.scimLocation("Groups", group.ID))
+	writeSCIM(w, http.StatusCreated, res)
+}
+
+// ReplaceSCIMGroup sets the name and the members of a group. The members
+// are left unchanged if they are left out.
+func (h *Handlers) ReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	body, err := readSCIM(w, r)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	name, members, err := parseSCIMGroup(body)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	group, err := h.scimGroupByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	h.updateSCIMGroup(w, r, group, name, members)
+}
+
+// PatchSCIMGroup applies PATCH operations to a group, typically to add or
+// remove members.
+func (h *Handlers) PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	if err := readSCIMInto(w, r, &req); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	group, err := h.scimGroupByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	res, err := h.loadSCIMGroup(r.Context(), group)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+
+	res = normalizeSCIM(res)
+	if err := req.Apply(res); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	name, members, err := parseSCIMGroup(res)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	h.updateSCIMGroup(w, r, group, name, members)
+}
+
+// updateSCIMGroup saves the name and members of a group, and responds
+// with the updated group.
+func (h *Handlers) updateSCIMGroup(w http.ResponseWriter, r *http.Request, group *models.Group, name string, members []int64) {
+	err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		return applySCIMGroup(r.Context(), tx, group, name, members)
+	})
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+
+	res, err := h.loadSCIMGroup(r.Context(), group)
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, res)
+}
+
+// DeleteSCIMGroup deletes a group. Its members stay enrolled in its courses.
+func (h *Handlers) DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.scimGroupByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	if err := h.Groups.DeleteGroup(r.Context(), group.ID); err != nil {
+		h.scimFail(w, err)
+		return
+	}
+	log.Printf("SCIM: deleted group %d (%s)", group.ID, group.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseSCIMGroup reads the name and the IDs of the members of a Group
+// resource. The members are nil if they were not sent.
+func parseSCIMGroup(res map[string]any) (string, []int64, error) {
+	name, err := scimString(res, "displayName")
+	if err != nil {
+		return "", nil, err
+	}
+	if name == nil || *name == "" {
+		return "", nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "displayName is required")
+	}
+	if utf8.RuneCountInString(*name) > maxNameLength {
+		return "", nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "displayName must be at most %d characters long", maxNameLength)
+	}
+
+	v, ok := scim.Lookup(res, "members")
+	if !ok {
+		return *name, nil, nil
+	}
+	list, _ := v.([]any)
+	if m, single := v.(map[string]any); single {
+		list = []any{m}
+	} else if v != nil && list == nil {
+		return "", nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "members must be an array")
+	}
+	members := []int64{}
+	for _, elem := range list {
+		m, _ := elem.(map[string]any)
+		value, _ := scim.Lookup(m, "value")
+		id, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+		if err != nil {
+			return "", nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "invalid member %v", elem)
+		}
+		members = append(members, id)
+	}
+	return *name, members, nil
+}
+
+// applySCIMGroup renames a group, and sets its members unless members is nil.
+func applySCIMGroup(ctx context.Context, tx database.Store, group *models.Group, name string, members []int64) error {
+	if name != group.Name {
+		if err := tx.RenameGroup(ctx, group.ID, name); err != nil {
+			return err
+		}
+		group.Name = name
+	}
+	if members == nil {
+		return nil
+	}
+
+	current, err := tx.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	keep := map[int64]bool{}
+	for _, id := range members {
+		keep[id] = true
+	}
+	for _, u := range current {
+		if keep[u.ID] {
+			delete(keep, u.ID)
+			continue
+		}
+		if err := tx.RemoveGroupMember(ctx, group.ID, u.ID); err != nil {
+			return err
+		}
+	}
+	// Those left are the new members.
+	for id := range keep {
+		if _, err := tx.GetUserByID(ctx, id); errors.Is(err, sql.ErrNoRows) {
+			return scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "no user has the ID %d", id)
+		} else if err != nil {
+			return err
+		}
+		if err := tx.AddGroupMember(ctx, group.ID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scimGroupByID returns the group with an ID from a URL.
+func (h *Handlers) scimGroupByID(ctx context.Context, id string) (*models.Group, error) {
+	groupID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, scim.Errorf(http.StatusNotFound, "", "Group %s not found", id)
+	}
+	group, err := h.Groups.GetGroup(ctx, groupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, scim.Errorf(http.StatusNotFound, "", "Group %s not found", id)
+	}
+	return group, err
+}
+
+// loadSCIMGroup returns the Group resource of a group.
+func (h *Handlers) loadSCIMGroup(ctx context.Context, group *models.Group) (map[string]any, error) {
+	members, err := h.Groups.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]any{
+		"schemas":     []any{scim.SchemaGroup},
+		"id":          strconv.FormatInt(group.ID, 10),
+		"displayName": group.Name,
+		"meta": map[string]any{
+			"resourceType": "Group",
+			"created":      group.CreatedAt.UTC().Format(time.RFC3339),
+			"location":     h.scimLocation("Groups", group.ID),
+		},
+	}
+	if len(members) > 0 {
+		var refs []any
+		for _, u := range members {
+			refs = append(refs, map[string]any{
+				"value":   strconv.FormatInt(u.ID, 10),
+				"display": u.Username,
+				"type":    "User",
+				"$ref":    h.scimLocation("Users", u.ID),
+			})
+		}
+		res["members"] = refs
+	}
+	return res, nil
+}
+
+// --- Helpers ---
+
+// scimLocation returns the URL of a resource.
+func (h *Handlers) scimLocation(resourceType string, id int64) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%d", h.PublicURL, resourceType, id)
+}
+
+// writeSCIMList responds to a query with the page of the resources
+// matching its filter that it asks for.
+func (h *Handlers) writeSCIMList(w http.ResponseWriter, r *http.Request, resources []map[string]any) {
+	q := r.URL.Query()
+	if f := q.Get("filter"); f != "" {
+		filter, err := scim.ParseFilter(f)
+		if err != nil {
+			h.scimFail(w, err)
+			return
+		}
+		var matched []map[string]any
+		for _, res := range resources {
+			if filter.Match(res) {
+				matched = append(matched, res)
+			}
+		}
+		resources = matched
+	}
+
+	// startIndex counts from 1.
+	start, count := 1, maxSCIMResults
+	if s := q.Get("startIndex"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			h.scimFail(w, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "invalid startIndex %q", s))
+			return
+		}
+		start = max(n, 1)
+	}
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			h.scimFail(w, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "invalid count %q", s))
+			return
+		}
+		count = min(max(n, 0), maxSCIMResults)
+	}
+
+	from := min(start-1, len(resources))
+	to := min(from+count, len(resources))
+	page := resources[from:to]
+	for i, res := range page {
+		page[i] = excludeAttributes(res, r)
+	}
+	writeSCIM(w, http.StatusOK, scim.ListResponse(len(resources), start, page))
+}
+
+// excludeAttributes removes the attributes listed in the excludedAttributes
+// parameter from a resource, such as the members of large groups.
+func excludeAttributes(res map[string]any, r *http.Request) map[string]any {
+	excluded := r.URL.Query().Get("excludedAttributes")
+	if excluded == "" {
+		return res
+	}
+	for _, attr := range strings.Split(excluded, ",") {
+		attr = strings.TrimSpace(attr)
+		for k := range res {
+			// The id and schemas are always returned.
+			if strings.EqualFold(k, attr) && k != "id" && k != "schemas" {
+				delete(res, k)
+			}
+		}
+	}
+	return res
+}
+
+// readSCIM reads a resource from the body of a request.
+func readSCIM(w http.ResponseWriter, r *http.Request) (map[string]any, error) {
+	var res map[string]any
+	if err := readSCIMInto(w, r, &res); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidSyntax, "the body must be a JSON object")
+	}
+	return res, nil
+}
+
+// readSCIMInto decodes the JSON body of a request into dest.
+func readSCIMInto(w http.ResponseWriter, r *http.Request, dest any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSCIMBody)).Decode(dest); err != nil {
+		return scim.Errorf(http.StatusBadRequest, scim.ErrInvalidSyntax, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// normalizeSCIM returns a copy of a resource with the types it would have
+// if it had been read from JSON, so that PATCH operations can be applied to it.
+func normalizeSCIM(res map[string]any) map[string]any {
+	b, _ := json.Marshal(res)
+	var copied map[string]any
+	json.Unmarshal(b, &copied)
+	return copied
+}
+
+// scimString returns a string attribute, nil if it is not set, or "" if it
+// is null.
+func scimString(res map[string]any, name string) (*string, error) {
+	v, ok := scim.Lookup(res, name)
+	if !ok {
+		return nil, nil
+	}
+	s := ""
+	if v != nil {
+		str, ok := v.(string)
+		if !ok {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "%s must be a string", name)
+		}
+		s = strings.TrimSpace(str)
+	}
+	return &s, nil
+}
+
+// scimBool returns a boolean attribute, or nil if it is not set. Some
+// clients send booleans as the strings "True" and "False".
+func scimBool(res map[string]any, name string) (*bool, error) {
+	v, ok := scim.Lookup(res, name)
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case bool:
+		return &v, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.ToLower(v)); err == nil {
+			return &b, nil
+		}
+	}
+	return nil, scim.Errorf(http.StatusBadRequest, scim.ErrInvalidValue, "%s must be a boolean", name)
+}
+
+// writeSCIM writes a JSON response.
+func writeSCIM(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeSCIMError(w http.ResponseWriter, err *scim.Error) {
+	writeSCIM(w, err.Status, err.Body())
+}
+
+// scimFail responds with the SCIM error for err. Unexpected errors are
+// logged, and hidden from the client.
+func (h *Handlers) scimFail(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, database.ErrUsernameTaken):
+		scimErr = scim.Errorf(http.StatusConflict, scim.ErrUniqueness, "The userName is already in use.")
+	case errors.Is(err, database.ErrEmailTaken):
+		scimErr = scim.Errorf(http.StatusConflict, scim.ErrUniqueness, "The email address is already in use.")
+	case errors.Is(err, database.ErrGroupExists):
+		scimErr = scim.Errorf(http.StatusConflict, scim.ErrUniqueness, "A group with that displayName already exists.")
+	default:
+		log.Printf("SCIM request failed: %v", err)
+		scimErr = scim.Errorf(http.StatusInternalServerError, "", "Internal Server Error")
+	}
+	writeSCIMError(w, scimErr)
+}
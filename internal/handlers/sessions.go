@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// sessionInfo describes a logged-in session, as recorded by the
+// TrackSession middleware.
+type sessionInfo struct {
+	ID         string
+	Device     string
+	UserAgent  string
+	IP         string
+	SignedInAt time.Time
+	LastSeenAt time.Time
+	// Current is set for the session of the request.
+	Current bool
+	// Impersonator is the admin using the session, if any.
+	Impersonator string
+}
+
+// Sessions shows where the logged-in user is signed in, with buttons to
+// sign out of each session or of all of them.
+func (h *Handlers) Sessions(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	sessions, err := h.userSessions(r, userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Admins viewing the site as the user aren't the user's business.
+	sessions = slices.DeleteFunc(sessions, func(s sessionInfo) bool {
+		return s.Impersonator != ""
+	})
+
+	td := h.newTemplateData(r)
+	td.Data["Sessions"] = sessions
+	h.render(w, r, "sessions.page.tmpl", td)
+}
+
+// RevokeSession signs the logged-in user out of one of their sessions.
+// Revoking the current session is the same as logging out.
+func (h *Handlers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	sessionID := chi.URLParam(r, "sessionID")
+
+	if sessionID == h.SessionManager.GetString(r.Context(), "sessionID") {
+		if err := h.destroySession(r.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Sessions of admins impersonating the user aren't theirs to end.
+	session, err := h.UserSessions.GetUserSession(r.Context(), sessionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if session == nil || session.UserID != userID || session.ImpersonatorID != 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	// The session is destroyed on its next request by TrackSession.
+	if err := h.UserSessions.DeleteUserSession(r.Context(), userID, sessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The session has been signed out.")
+	http.Redirect(w, r, "/profile/sessions", http.StatusSeeOther)
+}
+
+// RevokeAllSessions signs the logged-in user out everywhere, including
+// the current session.
+func (h *Handlers) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	if err := h.endSessions(r.Context(), userID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// The current session would otherwise be saved again at the end of
+	// the request.
+	if err := h.SessionManager.Destroy(r.Context()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// EndUserSessions lets an admin sign a user out everywhere, for example
+// when their account may have been taken over.
+func (h *Handlers) EndUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if h.isCurrentUser(r, user.ID) {
+		http.Error(w, "Sign yourself out from your own sessions page", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.endSessions(r.Context(), user.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s has been signed out everywhere.", user.Username))
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// userSessions returns the logged-in sessions of a user, the most recently
+// used first. Sessions waiting for a two-factor code aren't logged in yet,
+// and are left out.
+func (h *Handlers) userSessions(r *http.Request, userID int64) ([]sessionInfo, error) {
+	records, err := h.UserSessions.GetUserSessionsForUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentID := h.SessionManager.GetString(r.Context(), "sessionID")
+	var sessions []sessionInfo
+	for _, rec := range records {
+		sessions = append(sessions, sessionInfo{
+			ID:           rec.ID,
+			Device:       describeUserAgent(rec.UserAgent),
+			UserAgent:    rec.UserAgent,
+			IP:           rec.IP,
+			SignedInAt:   rec.SignedInAt,
+			LastSeenAt:   rec.LastSeenAt,
+			Current:      rec.ID == currentID,
+			Impersonator: rec.ImpersonatorName,
+		})
+	}
+	return sessions, nil
+}
+
+// describeUserAgent turns a User-Agent header into a short description
+// such as "Firefox on Windows". It only tells the common browsers apart,
+// which is enough for users to recognise their devices.
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	// Several browsers also claim to be the ones they are based on, so
+	// the order matters.
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, s := range []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/models"
+	"lms/internal/oidc"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SingleSignOn configures logging in with an OpenID Connect provider.
+type SingleSignOn struct {
+	Provider      *oidc.Provider
+	Name          string // Shown on the login page.
+	UsernameClaim string // Claim new users get their username from.
+	// LinkExisting links a provider account, on its first login, to the
+	// user whose verified email address is the account's verified email.
+	// Admins are never linked this way.
+	LinkExisting bool
+	// TrustAMR lets a second factor used with the provider, as reported
+	// in the "amr" claim, stand in for the user's own.
+	TrustAMR bool
+	// RoleClaim, if set, decides the role of users at each login: those
+	// whose claim contains one of AdminValues are admins.
+	RoleClaim   string
+	AdminValues []string
+}
+
+// Errors of the first login with a provider account.
+var (
+	errSSONoUsername    = errors.New("no username in the claims")
+	errSSOUsernameTaken = errors.New("username belongs to a user who hasn't linked the account")
+)
+
+// LoginSSO sends the user to the identity provider to log in.
+func (h *Handlers) LoginSSO(w http.ResponseWriter, r *http.Request) {
+	h.startSSO(w, r, 0)
+}
+
+// LinkSSO sends the logged-in user to the identity provider to link their
+// account there to this one.
+func (h *Handlers) LinkSSO(w http.ResponseWriter, r *http.Request) {
+	h.startSSO(w, r, h.SessionManager.GetInt64(r.Context(), "authenticatedUserID"))
+}
+
+// startSSO redirects to the provider's login page. The state, nonce and
+// PKCE verifier are kept in the session, along with the user to link the
+// account to, if any.
+func (h *Handlers) startSSO(w http.ResponseWriter, r *http.Request, linkUserID int64) {
+	if h.SSO == nil {
+		http.Error(w, "Single sign-on is not available", http.StatusNotFound)
+		return
+	}
+
+	state, nonce, verifier := token.New(), token.New(), token.New()
+	target, err := h.SSO.Provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		h.ssoFailed(w, r, http.StatusBadGateway, "The login service is unavailable. Please try again later.")
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "ssoState", state)
+	h.SessionManager.Put(r.Context(), "ssoNonce", nonce)
+	h.SessionManager.Put(r.Context(), "ssoVerifier", verifier)
+	h.SessionManager.Put(r.Context(), "ssoLinkUserID", linkUserID)
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// SSOCallback is where the provider sends the user back. It logs in the
+// user the provider account is linked to, linking or creating one on the
+// account's first login, or links the account to the logged-in user.
+func (h *Handlers) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if h.SSO == nil {
+		http.Error(w, "Single sign-on is not available", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	state := h.SessionManager.PopString(ctx, "ssoState")
+	nonce := h.SessionManager.PopString(ctx, "ssoNonce")
+	verifier := h.SessionManager.PopString(ctx, "ssoVerifier")
+	linkUserID := h.SessionManager.GetInt64(ctx, "ssoLinkUserID")
+	h.SessionManager.Remove(ctx, "ssoLinkUserID")
+
+	// The state ties the response to the login started in this browser.
+	q := r.URL.Query()
+	if state == "" || q.Get("state") != state {
+		h.ssoFailed(w, r, http.StatusBadRequest, "The login has expired. Please try again.")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		log.Printf("Single sign-on refused by the provider: %s %s", e, q.Get("error_description"))
+		h.ssoFailed(w, r, http.StatusUnauthorized, fmt.Sprintf("The login with %s was cancelled or refused.", h.SSO.Name))
+		return
+	}
+
+	claims, err := h.SSO.Provider.Exchange(ctx, q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		h.ssoFailed(w, r, http.StatusBadGateway, fmt.Sprintf("The login with %s failed. Please try again.", h.SSO.Name))
+		return
+	}
+	issuer, subject := h.SSO.Provider.Issuer, claims.String("sub")
+	login := claims.String(h.SSO.UsernameClaim)
+
+	if linkUserID != 0 {
+		h.linkIdentity(w, r, linkUserID, issuer, subject, login)
+		return
+	}
+
+	user, identity, err := h.ssoUser(ctx, claims)
+	if errors.Is(err, errSSONoUsername) {
+		log.Printf("Single sign-on failed: no %q claim for %s", h.SSO.UsernameClaim, subject)
+		h.ssoFailed(w, r, http.StatusForbidden, fmt.Sprintf("Your %s account has no username. Ask an administrator for help.", h.SSO.Name))
+		return
+	}
+	if errors.Is(err, errSSOUsernameTaken) {
+		h.ssoFailed(w, r, http.StatusForbidden, fmt.Sprintf("An account named %q already exists. Log in with your password and link your %s account from your security settings.", login, h.SSO.Name))
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !user.Active() {
+		h.ssoFailed(w, r, http.StatusForbidden, "Your account has been deactivated.")
+		return
+	}
+
+	if err := h.Identities.UseUserIdentity(ctx, identity.ID, login, time.Now().UTC()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.applySSORole(ctx, user, claims); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Providers that made the user use a second factor say so in the
+	// "amr" claim (RFC 8176), if they are trusted to. Otherwise users with
+	// two-factor authentication must still enter a code.
+	mfa := h.SSO.TrustAMR && slices.Contains(claims.Strings("amr"), "mfa")
+	if !mfa {
+		enabled, err := h.twoFactorEnabled(ctx, user.ID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			if err := h.startTwoFactorLogin(ctx, user.ID); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := h.startSession(r, user, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if mfa {
+		h.SessionManager.Put(ctx, "twoFactorVerified", true)
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// UnlinkSSO removes a linked provider account of the logged-in user.
+func (h *Handlers) UnlinkSSO(w http.ResponseWriter, r *http.Request) {
+	identityID, err := strconv.ParseInt(chi.URLParam(r, "identityID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+
+	// The directory entry a user logs in with stays linked.
+	identities, err := h.Identities.GetUserIdentitiesForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, i := range identities {
+		if i.ID == identityID && i.FromDirectory() {
+			http.Error(w, "Directory accounts cannot be unlinked", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.Identities.DeleteUserIdentity(r.Context(), userID, identityID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Linked account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The account has been unlinked.")
+	http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+}
+
+// linkIdentity links the provider account to the logged-in user, who
+// started linking it from their security settings.
+func (h *Handlers) linkIdentity(w http.ResponseWriter, r *http.Request, userID int64, issuer, subject, login string) {
+	// Only link to the user who asked, in case they logged out meanwhile.
+	if h.SessionManager.GetInt64(r.Context(), "authenticatedUserID") != userID {
+		h.ssoFailed(w, r, http.StatusBadRequest, "The login has expired. Please try again.")
+		return
+	}
+
+	flash := fmt.Sprintf("Your %s account is now linked. You can use it to log in.", h.SSO.Name)
+	_, err := h.Identities.CreateUserIdentity(r.Context(), userID, issuer, subject, login)
+	if errors.Is(err, database.ErrIdentityLinked) {
+		flash = fmt.Sprintf("That %s account is already linked to a user.", h.SSO.Name)
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/profile/security", http.StatusSeeOther)
+}
+
+// ssoUser returns the user the provider account in claims is linked to.
+// On the account's first login it is linked to the user with the same
+// verified email if LinkExisting is set, or else to a new student. A user
+// with the same username must link the account themselves.
+func (h *Handlers) ssoUser(ctx context.Context, claims oidc.Claims) (*models.User, *models.UserIdentity, error) {
+	issuer, subject := h.SSO.Provider.Issuer, claims.String("sub")
+	login := claims.String(h.SSO.UsernameClaim)
+
+	identity, err := h.Identities.GetUserIdentity(ctx, issuer, subject)
+	if err == nil {
+		user, err := h.Users.GetUserByID(ctx, identity.UserID)
+		return user, identity, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	var user *models.User
+	err = h.Tx.WithTx(ctx, func(tx database.Store) error {
+		existing, err := h.ssoUserByEmail(ctx, tx, claims)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			user = existing
+			log.Printf("Linked the %s account %s of user %d by their verified email", issuer, subject, user.ID)
+			identity, err = tx.CreateUserIdentity(ctx, user.ID, issuer, subject, login)
+			return err
+		}
+
+		if login == "" {
+			return errSSONoUsername
+		}
+		_, err = tx.GetUserByUsername(ctx, login)
+		switch {
+		case err == nil:
+			return errSSOUsernameTaken
+
+		case errors.Is(err, database.ErrUserNotFound):
+			// The random password can't be guessed, so the user logs in with
+			// the provider unless they reset it.
+			user, err = tx.CreateUser(ctx, login, token.New(), "student")
+			if err != nil {
+				return err
+			}
+			user.FullName = claims.String("name")
+			if err := tx.UpdateUserProfile(ctx, user.ID, user.FullName, user.DisplayName, user.Timezone); err != nil {
+				return err
+			}
+			// Take the email address unless another user has it.
+			email := claims.String("email")
+			if email != "" && validEmail(email) {
+				_, err := tx.GetUserByEmail(ctx, email)
+				if errors.Is(err, database.ErrUserNotFound) {
+					if err := tx.SetUserEmail(ctx, user.ID, email); err != nil {
+						return err
+					}
+					user.Email = email
+				} else if err != nil {
+					return err
+				}
+			}
+			log.Printf("Created user %d (%s) on their first login with %s", user.ID, login, issuer)
+
+		default:
+			return err
+		}
+
+		identity, err = tx.CreateUserIdentity(ctx, user.ID, issuer, subject, login)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, identity, nil
+}
+
+// ssoUserByEmail returns the user to link the provider account in claims to
+// by email, or nil if there is none: both the provider and the user must
+// have verified the address, and the user mustn't be an admin, whom a
+// provider account could otherwise take over.
+func (h *Handlers) ssoUserByEmail(ctx context.Context, tx database.Store, claims oidc.Claims) (*models.User, error) {
+	email := claims.String("email")
+	if !h.SSO.LinkExisting || email == "" || !claims.Bool("email_verified") {
+		return nil, nil
+	}
+	user, err := tx.GetUserByEmail(ctx, email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerifiedAt.IsZero() || user.Role == "admin" {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// applySSORole sets the role of user from their claims, if the provider
+// decides roles.
+func (h *Handlers) applySSORole(ctx context.Context, user *models.User, claims oidc.Claims) error {
+	if h.SSO.RoleClaim == "" {
+		return nil
+	}
+
+	// The claim only decides who is an admin: other users keep their role,
+	// so that instructors stay instructors.
+	role := user.Role
+	if role == "admin" {
+		role = "student"
+	}
+	for _, v := range claims.Strings(h.SSO.RoleClaim) {
+		if slices.Contains(h.SSO.AdminValues, v) {
+			role = "admin"
+		}
+	}
+	if role == user.Role {
+		return nil
+	}
+
+	if err := h.Users.SetUserRole(ctx, user.ID, role); err != nil {
+		return err
+	}
+	log.Printf("User %d is now %s, as set by their %q claim", user.ID, role, h.SSO.RoleClaim)
+	user.Role = role
+	return nil
+}
+
+// ssoFailed shows the login page with an error.
+func (h *Handlers) ssoFailed(w http.ResponseWriter, r *http.Request, status int, message string) {
+	td := h.loginTemplateData(r)
+	td.Data["Error"] = message
+	h.renderStatus(w, r, status, "login.page.tmpl", td)
+}
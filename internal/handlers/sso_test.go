@@ -0,0 +1,360 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"lms/internal/handlers"
+	"lms/internal/oidc"
+	"lms/internal/rbac"
+	"lms/internal/token"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "lms"
+	testClientSecret = "s3cret"
+)
+
+// testIdP is an OpenID Connect provider. Instead of asking who is logging
+// in, it issues codes for the claims a test gives it.
+type testIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]idpLogin
+}
+
+// idpLogin is a login the provider issued a code for.
+type idpLogin struct {
+	claims    map[string]any
+	nonce     string
+	challenge string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{t: t, key: key, codes: make(map[string]idpLogin)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// authorize checks the authorization request the app sent the browser to,
+// and returns the callback URL the provider would send it back to after
+// logging in with claims.
+func (idp *testIdP) authorize(authURL string, claims map[string]any) string {
+	idp.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != testClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("state") == "" || q.Get("nonce") == "" {
+		idp.t.Fatalf("bad authorization request %s", authURL)
+	}
+
+	code := token.New()
+	idp.mu.Lock()
+	idp.codes[code] = idpLogin{claims: claims, nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+
+	callback, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	callback.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	return callback.RequestURI()
+}
+
+// token exchanges a code for an ID token, once, if the client proves it
+// started the login with the PKCE verifier.
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostFormValue("code")
+	idp.mu.Lock()
+	login, ok := idp.codes[code]
+	delete(idp.codes, code)
+	idp.mu.Unlock()
+	if !ok || oidc.Challenge(r.PostFormValue("code_verifier")) != login.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": login.nonce,
+	}
+	// Tests may override the claims above, the nonce for one.
+	for k, v := range login.claims {
+		claims[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(claims)})
+}
+
+// sign returns an RS256 JWT with the claims.
+func (idp *testIdP) sign(claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// newSSOApp returns a test app whose users can log in with a test provider.
+func newSSOApp(t *testing.T) (*testApp, *testIdP) {
+	t.Helper()
+	app := newTestApp(t)
+	idp := newTestIdP(t)
+	app.h.SSO = &handlers.SingleSignOn{
+		Provider: oidc.NewProvider(idp.server.URL, testClientID, testClientSecret,
+			app.server.URL+"/login/sso/callback", []string{"openid", "email", "profile"}),
+		Name:          "Example ID",
+		UsernameClaim: "preferred_username",
+	}
+	return app, idp
+}
+
+// ssoLogin logs in with the provider as the account with claims, and
+// returns the response to the callback.
+func (c *testClient) ssoLogin(idp *testIdP, claims map[string]any) response {
+	c.app.t.Helper()
+	res := c.get("/login/sso")
+	if res.status != http.StatusSeeOther {
+		c.app.t.Fatalf("start single sign-on: got status %d", res.status)
+	}
+	return c.get(idp.authorize(res.location, claims))
+}
+
+func TestSSOLogin(t *testing.T) {
+	app, idp := newSSOApp(t)
+	ctx := context.Background()
+	c := app.newClient()
+
+	claims := map[string]any{"sub": "u-1", "preferred_username": "dana", "name": "Dana Scully", "email": "dana@example.com"}
+	if res := c.ssoLogin(idp, claims); res.status != http.StatusSeeOther || res.location != "/" {
+		t.Fatalf("first login: got %d to %q, want 303 to /", res.status, res.location)
+	}
+	dana, err := app.store.GetUserByUsername(ctx, "dana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dana.Role != rbac.RoleStudent || dana.FullName != "Dana Scully" || dana.Email != "dana@example.com" {
+		t.Errorf("created %+v", dana)
+	}
+	if res := c.get("/profile"); res.status != http.StatusOK {
+		t.Errorf("profile after logging in: got status %d, want 200", res.status)
+	}
+
+	// The next login finds the same user, even under a new username.
+	other := app.newClient()
+	claims["preferred_username"] = "dana.scully"
+	if res := other.ssoLogin(idp, claims); res.status != http.StatusSeeOther || res.location != "/" {
+		t.Fatalf("second login: got %d to %q, want 303 to /", res.status, res.location)
+	}
+	if _, err := app.store.GetUserByUsername(ctx, "dana.scully"); err == nil {
+		t.Error("the second login created another user")
+	}
+}
+
+func TestSSOCallbackChecks(t *testing.T) {
+	app, idp := newSSOApp(t)
+	claims := map[string]any{"sub": "u-1", "preferred_username": "dana"}
+
+	t.Run("state", func(t *testing.T) {
+		c := app.newClient()
+		res := c.get("/login/sso")
+		callback, err := url.Parse(idp.authorize(res.location, claims))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := callback.Query()
+		q.Set("state", "forged")
+		if res := c.get("/login/sso/callback?" + q.Encode()); res.status != http.StatusBadRequest {
+			t.Errorf("forged state: got %d, want 400", res.status)
+		}
+
+		// The state is used up by the failed attempt too.
+		if res := c.get(callback.RequestURI()); res.status != http.StatusBadRequest {
+			t.Errorf("callback after a failed attempt: got %d, want 400", res.status)
+		}
+		// Another browser can't complete the login.
+		if res := app.newClient().get(callback.RequestURI()); res.status != http.StatusBadRequest {
+			t.Errorf("callback in another browser: got %d, want 400", res.status)
+		}
+	})
+
+	t.Run("nonce", func(t *testing.T) {
+		withNonce := map[string]any{"sub": "u-1", "preferred_username": "dana", "nonce": "replayed"}
+		if res := app.newClient().ssoLogin(idp, withNonce); res.status != http.StatusBadGateway {
+			t.Errorf("ID token with another nonce: got %d, want 502", res.status)
+		}
+	})
+
+	t.Run("code", func(t *testing.T) {
+		// A code that leaks from one browser fails in another, which has
+		// its own state but not the PKCE verifier the code was made for.
+		c, thief := app.newClient(), app.newClient()
+		callback, err := url.Parse(idp.authorize(c.get("/login/sso").location, claims))
+		if err != nil {
+			t.Fatal(err)
+		}
+		thiefAuth, err := url.Parse(thief.get("/login/sso").location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := callback.Query()
+		q.Set("state", thiefAuth.Query().Get("state"))
+		if res := thief.get("/login/sso/callback?" + q.Encode()); res.status != http.StatusBadGateway {
+			t.Errorf("code exchanged with another verifier: got %d, want 502", res.status)
+		}
+		// A code works once.
+		if res := c.get(callback.RequestURI()); res.status != http.StatusBadGateway {
+			t.Errorf("used code: got %d, want 502", res.status)
+		}
+	})
+
+	if _, err := app.store.GetUserByUsername(context.Background(), "dana"); err == nil {
+		t.Error("a failed login created the user")
+	}
+}
+
+func TestSSOLinksVerifiedEmail(t *testing.T) {
+	app, idp := newSSOApp(t)
+	app.h.SSO.LinkExisting = true
+	ann := app.createUser("ann", rbac.RoleStudent)
+	app.verifyEmail(ann, "ann@example.com")
+	root := app.createUser("root", rbac.RoleAdmin)
+	app.verifyEmail(root, "root@example.com")
+	unverified := app.createUser("uma", rbac.RoleStudent)
+	if err := app.store.SetUserEmail(context.Background(), unverified.ID, "uma@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   string // The user logged in, or "" if refused.
+	}{
+		{"verified by both", map[string]any{"sub": "a", "preferred_username": "ann.x", "email": "ann@example.com", "email_verified": true}, "ann"},
+		{"not verified by the provider", map[string]any{"sub": "b", "preferred_username": "ann", "email": "ann@example.com", "email_verified": false}, ""},
+		{"not verified by the user", map[string]any{"sub": "c", "preferred_username": "uma", "email": "uma@example.com", "email_verified": true}, ""},
+		{"admin", map[string]any{"sub": "d", "preferred_username": "root", "email": "root@example.com", "email_verified": true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.newClient()
+			res := c.ssoLogin(idp, tt.claims)
+			if tt.want == "" {
+				// The username is taken, so no user is created either.
+				if res.status != http.StatusForbidden {
+					t.Errorf("got %d to %q, want 403", res.status, res.location)
+				}
+				return
+			}
+			if res.status != http.StatusSeeOther || res.location != "/" {
+				t.Fatalf("got %d to %q, want 303 to /", res.status, res.location)
+			}
+			identities, err := app.store.GetUserIdentitiesForUser(context.Background(), ann.ID)
+			if err != nil || len(identities) != 1 {
+				t.Errorf("ann has %d linked accounts (%v), want 1", len(identities), err)
+			}
+		})
+	}
+}
+
+func TestSSOTrustAMR(t *testing.T) {
+	app, idp := newSSOApp(t)
+	app.mw.RequireAdmin2FA = true
+	ann := app.createUser("ann", rbac.RoleAdmin)
+	app.enableTOTP(ann)
+	if _, err := app.store.CreateUserIdentity(context.Background(), ann.ID, idp.server.URL, "a", "ann"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		trust    bool
+		amr      []string
+		location string
+	}{
+		{"trusted mfa", true, []string{"pwd", "mfa"}, "/"},
+		{"trusted without mfa", true, []string{"pwd"}, "/login/two-factor"},
+		{"untrusted mfa", false, []string{"pwd", "mfa"}, "/login/two-factor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.h.SSO.TrustAMR = tt.trust
+			c := app.newClient()
+			res := c.ssoLogin(idp, map[string]any{"sub": "a", "preferred_username": "ann", "amr": tt.amr})
+			if res.status != http.StatusSeeOther || res.location != tt.location {
+				t.Fatalf("got %d to %q, want 303 to %s", res.status, res.location, tt.location)
+			}
+			// The provider's second factor counts for the admin pages.
+			if tt.location == "/" {
+				if res := c.get("/admin/users"); res.status != http.StatusOK {
+					t.Errorf("admin pages: got %d, want 200", res.status)
+				}
+			}
+		})
+	}
+}
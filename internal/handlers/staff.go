@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/middleware"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// staffRoleNames describe the roles of course staff.
+var staffRoleNames = map[string]string{
+	rbac.StaffInstructor: "instructor",
+	rbac.StaffTA:         "teaching assistant",
+}
+
+// coursePermissions returns a function that reports whether the logged-in
+// user has a permission in a course, either through their role or as a
+// member of its staff.
+func (h *Handlers) coursePermissions(r *http.Request, courseID int64) (func(rbac.Permission) bool, error) {
+	role := middleware.Role(h.SessionManager, r)
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	staffRole, err := rbac.StaffRole(r.Context(), h.Staff, courseID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return func(perm rbac.Permission) bool {
+		return rbac.RoleCan(role, perm) || rbac.StaffCan(staffRole, perm)
+	}, nil
+}
+
+// ListCourses shows the courses the logged-in user can manage: every course
+// for admins, and the courses they are on the staff of for everyone else.
+func (h *Handlers) ListCourses(w http.ResponseWriter, r *http.Request) {
+	var courses []*models.Course
+	var err error
+	if rbac.RoleCan(middleware.Role(h.SessionManager, r), rbac.CourseView) {
+		courses, err = h.Courses.GetAllCourses(r.Context())
+	} else {
+		userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+		courses, err = h.Staff.GetStaffCourses(r.Context(), userID)
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	td.Data["Courses"] = courses
+	td.Data["CanCreate"] = rbac.RoleCan(middleware.Role(h.SessionManager, r), rbac.CourseCreate)
+
+	h.render(w, r, "admin_courses_list.page.tmpl", td)
+}
+
+// EnrollInCourse enrolls a user, given by username, in a course.
+func (h *Handlers) EnrollInCourse(w http.ResponseWriter, r *http.Request) {
+	courseID, err := strconv.ParseInt(chi.URLParam(r, "courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	course, err := h.Courses.GetCourse(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Course not found", http.StatusNotFound)
+		return
+	}
+
+	redirect := fmt.Sprintf("/admin/courses/%d", courseID)
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	user, err := h.Users.GetUserByUsername(r.Context(), username)
+	if err != nil {
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("There is no user called %q.", username))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	students, err := h.Courses.GetEnrolledStudents(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if slices.ContainsFunc(students, func(u *models.User) bool { return u.ID == user.ID }) {
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is already enrolled in %s.", user.Username, course.Title))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if err := h.Courses.EnrollStudentInCourse(r.Context(), user.ID, courseID); err != nil {
+		http.Error(w, "Failed to enroll user", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is now enrolled in %s.", user.Username, course.Title))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// IssueCertificate issues a certificate of a course to one of its students,
+// whether or not they have completed it.
+func (h *Handlers) IssueCertificate(w http.ResponseWriter, r *http.Request) {
+	courseID, err := strconv.ParseInt(chi.URLParam(r, "courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Staff may only issue certificates to the students of their course.
+	students, err := h.Courses.GetEnrolledStudents(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(students, func(u *models.User) bool { return u.ID == userID })
+	if i < 0 {
+		http.Error(w, "Student not found", http.StatusNotFound)
+		return
+	}
+
+	cert, err := h.Certificates.CreateCertificate(r.Context(), userID, courseID)
+	if err != nil {
+		http.Error(w, "Failed to generate certificate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Certificate issued to %s: /certificates/%s", students[i].Username, cert.Token))
+	http.Redirect(w, r, fmt.Sprintf("/admin/courses/%d", courseID), http.StatusSeeOther)
+}
+
+// AddCourseStaff puts a user, given by username, on the staff of a course,
+// or changes their role on it.
+func (h *Handlers) AddCourseStaff(w http.ResponseWriter, r *http.Request) {
+	courseID, err := strconv.ParseInt(chi.URLParam(r, "courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	role := r.PostForm.Get("role")
+	if !rbac.ValidStaffRole(role) {
+		http.Error(w, "Invalid staff role", http.StatusBadRequest)
+		return
+	}
+
+	course, err := h.Courses.GetCourse(r.Context(), courseID)
+	if err != nil {
+		http.Error(w, "Course not found", http.StatusNotFound)
+		return
+	}
+
+	redirect := fmt.Sprintf("/admin/courses/%d", courseID)
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	user, err := h.Users.GetUserByUsername(r.Context(), username)
+	if err != nil {
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("There is no user called %q.", username))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if err := h.Staff.AddCourseStaff(r.Context(), courseID, user.ID, role); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is now on the staff of %s as %s.", user.Username, course.Title, staffRoleNames[role]))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// RemoveCourseStaff takes a user off the staff of a course.
+func (h *Handlers) RemoveCourseStaff(w http.ResponseWriter, r *http.Request) {
+	courseID, err := strconv.ParseInt(chi.URLParam(r, "courseID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Staff.RemoveCourseStaff(r.Context(), courseID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Staff member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", "The staff member has been removed from the course.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/courses/%d", courseID), http.StatusSeeOther)
+}
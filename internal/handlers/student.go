@@ -24,15 +24,30 @@ func (h *Handlers) Dashboard(w http.ResponseWriter, r *http.Request) {
 
 		// For students, show their enrolled courses.
 		userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
-		enrolledCourses, err := database.GetEnrolledCoursesForStudent(h.DB, userID)
+		user, err := h.Users.GetUserByID(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		td.Data["User"] = user
+
+		enrolledCourses, err := h.Courses.GetEnrolledCoursesForStudent(r.Context(), userID)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 		td.Data["Courses"] = enrolledCourses
+
+		// Instructors and teaching assistants also see the courses they teach.
+		staffCourses, err := h.Staff.GetStaffCourses(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		td.Data["StaffCourses"] = staffCourses
 	} else {
 		// For guests, show all available courses.
-		allCourses, err := database.GetAllCourses(h.DB)
+		allCourses, err := h.Courses.GetAllCourses(r.Context())
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
@@ -53,7 +68,7 @@ func (h *Handlers) ShowCourse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the course from the database.
-	course, err := database.GetCourse(h.DB, courseID)
+	course, err := h.Courses.GetCourse(r.Context(), courseID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Course not found", http.StatusNotFound)
@@ -64,7 +79,7 @@ func (h *Handlers) ShowCourse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the lessons for the course.
-	lessons, err := database.GetLessonsForCourse(h.DB, courseID)
+	lessons, err := h.Courses.GetLessonsForCourse(r.Context(), courseID)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -79,7 +94,7 @@ func (h *Handlers) ShowCourse(w http.ResponseWriter, r *http.Request) {
 	// If the user is authenticated, check their completed lessons.
 	if h.SessionManager.Exists(r.Context(), "authenticatedUserID") {
 		userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
-		completedLessons, err := database.GetCompletedLessonsForUser(h.DB, userID, courseID)
+		completedLessons, err := h.Progress.GetCompletedLessonsForUser(r.Context(), userID, courseID)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
@@ -116,7 +131,7 @@ func (h *Handlers) SubmitMCQ(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Submit the MCQ answer.
-	submission, err := database.SubmitMCQ(h.DB, userID, mcqID, selectedOption)
+	submission, err := h.Progress.SubmitMCQ(r.Context(), userID, mcqID, selectedOption)
 	if err != nil {
 		// Could be a unique constraint violation if already submitted.
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -124,7 +139,7 @@ func (h *Handlers) SubmitMCQ(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the lesson ID for the redirect.
-	mcq, err := database.GetMCQByID(h.DB, mcqID)
+	mcq, err := h.Contents.GetMCQByID(r.Context(), mcqID)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -150,7 +165,7 @@ func (h *Handlers) ShowLesson(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the lesson itself to get the title, etc.
-	lesson, err := database.GetLesson(h.DB, lessonID)
+	lesson, err := h.Courses.GetLesson(r.Context(), lessonID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Lesson not found", http.StatusNotFound)
@@ -167,26 +182,26 @@ func (h *Handlers) ShowLesson(w http.ResponseWriter, r *http.Request) {
 	// Only show content to authenticated users.
 	if h.SessionManager.Exists(r.Context(), "authenticatedUserID") {
 		// Fetch the content for the lesson.
-		video, errVideo := database.GetVideoByLessonID(h.DB, lessonID)
+		video, errVideo := h.Contents.GetVideoByLessonID(r.Context(), lessonID)
 		if errVideo != nil && errVideo != sql.ErrNoRows {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		text, errText := database.GetTextByLessonID(h.DB, lessonID)
+		text, errText := h.Contents.GetTextByLessonID(r.Context(), lessonID)
 		if errText != nil && errText != sql.ErrNoRows {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		mcq, errMCQ := database.GetMCQByLessonID(h.DB, lessonID)
+		mcq, errMCQ := h.Contents.GetMCQByLessonID(r.Context(), lessonID)
 		if errMCQ != nil && errMCQ != sql.ErrNoRows {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
-		isComplete, err := database.IsLessonComplete(h.DB, userID, lessonID)
+		isComplete, err := h.Progress.IsLessonComplete(r.Context(), userID, lessonID)
 		if err != nil {
 			isComplete = false // Default to not complete on error
 		}
@@ -209,37 +224,36 @@ func (h *Handlers) MarkLessonComplete(w http.ResponseWriter, r *http.Request) {
 
 	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
 
-	err = database.MarkLessonAsComplete(h.DB, userID, lessonID)
-	if err != nil {
-		// This might fail if the lesson is already marked as complete (UNIQUE constraint)
-		// For now, we'll just log it. A more robust solution would handle this gracefully.
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
+	// Record the completion and issue any certificate in one transaction, so a
+	// crash or cancelled request can't leave a finished course without its certificate.
+	err = h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+		err := tx.MarkLessonAsComplete(r.Context(), userID, lessonID)
+		if err != nil {
+			// This might fail if the lesson is already marked as complete (UNIQUE constraint)
+			return err
+		}
 
-	// Get the lesson to find the course ID for the completion check and redirect.
-	lesson, err := database.GetLesson(h.DB, lessonID)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
+		// Get the lesson to find the course ID for the completion check.
+		lesson, err := tx.GetLesson(r.Context(), lessonID)
+		if err != nil {
+			return err
+		}
 
-	// Check if the course is now complete.
-	isComplete, err := database.IsCourseComplete(h.DB, userID, lesson.CourseID)
+		// Check if the course is now complete.
+		isComplete, err := tx.IsCourseComplete(r.Context(), userID, lesson.CourseID)
+		if err != nil || !isComplete {
+			return err
+		}
+
+		// If the course is complete, generate a certificate.
+		_, err = tx.CreateCertificate(r.Context(), userID, lesson.CourseID)
+		return err
+	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	if isComplete {
-		// If the course is complete, generate a certificate.
-		// We should probably check if a certificate already exists first.
-		_, err := database.CreateCertificate(h.DB, userID, lesson.CourseID)
-		if err != nil {
-			// This might fail if a certificate already exists. We can ignore this for now.
-		}
-	}
-
 	// On success, return an HTML snippet to be swapped in.
 	w.Header().Set("Content-Type", "text/html")
 	fmt.Fprint(w, `<div class="text-green-500 font-bold">✓ Completed</div>`)
@@ -248,7 +262,7 @@ func (h *Handlers) MarkLessonComplete(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) ViewCertificate(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
 
-	details, err := database.GetCertificateDetailsByToken(h.DB, token)
+	details, err := h.Certificates.GetCertificateDetailsByToken(r.Context(), token)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Certificate not found", http.StatusNotFound)
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"lms/internal/models"
+	"lms/internal/qr"
+	"lms/internal/token"
+	"lms/internal/totp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// twoFactorIssuer is the name authenticator apps show next to the codes.
+const twoFactorIssuer = "LMS"
+
+// recoveryCodeCount is the number of recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+// twoFactorLoginTimeout is how long a user has to enter a code after
+// entering their password.
+const twoFactorLoginTimeout = 5 * time.Minute
+
+// LoginTwoFactorForm asks for the code of a user whose password was correct.
+func (h *Handlers) LoginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if h.pendingTwoFactorUserID(r.Context()) == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	h.render(w, r, "login_two_factor.page.tmpl", td)
+}
+
+// LoginTwoFactor logs in the user whose password was correct if they enter
+// a code from their authenticator app or one of their recovery codes.
+// Wrong codes count as failed logins.
+func (h *Handlers) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.pendingTwoFactorUserID(r.Context())
+	if userID == 0 {
+		h.SessionManager.Put(r.Context(), "flash", "Your login has expired. Please log in again.")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ip := clientIP(r)
+
+	// The same limits as for passwords stop the codes being guessed.
+	if h.Throttle != nil {
+		wait, err := h.Throttle.Check(r.Context(), user.Username, ip)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+			td := h.newTemplateData(r)
+			td.Data["Error"] = fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second))
+			h.renderStatus(w, r, http.StatusTooManyRequests, "login_two_factor.page.tmpl", td)
+			return
+		}
+	}
+
+	code := r.PostForm.Get("code")
+	ok, err := h.checkTOTP(r.Context(), userID, code)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	usedRecoveryCode := false
+	if !ok {
+		ok, err = h.checkRecoveryCode(r.Context(), userID, code)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		usedRecoveryCode = ok
+	}
+
+	if !ok {
+		if h.Throttle != nil {
+			if err := h.Throttle.Failure(r.Context(), user.Username, ip); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Invalid code."
+		h.renderStatus(w, r, http.StatusUnauthorized, "login_two_factor.page.tmpl", td)
+		return
+	}
+
+	if h.Throttle != nil {
+		if err := h.Throttle.Success(r.Context(), user.Username); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.SessionManager.Remove(r.Context(), "twoFactorUserID")
+	h.SessionManager.Remove(r.Context(), "twoFactorStartedAt")
+	if err := h.startSession(r, user, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionManager.Put(r.Context(), "twoFactorVerified", true)
+
+	// Warn the user before they run out of recovery codes.
+	if usedRecoveryCode {
+		left, err := h.TwoFactor.CountRecoveryCodes(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("You logged in with a recovery code. You have %d left; you can make new ones on your two-factor authentication page.", left))
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// TwoFactorSettings displays the two-factor authentication settings of the
+// logged-in user.
+func (h *Handlers) TwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	t, err := h.getTOTP(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// A session logged in before two-factor authentication was turned on,
+	// for example on another device, must enter a code first.
+	if t != nil && t.Enabled() && !h.SessionManager.GetBool(r.Context(), "twoFactorVerified") {
+		if err := h.startTwoFactorLogin(r.Context(), userID); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	h.renderTwoFactor(w, r, http.StatusOK, t, td)
+}
+
+// SetUpTwoFactor creates a new secret for the logged-in user to add to
+// their authenticator app. It replaces a secret that wasn't confirmed.
+func (h *Handlers) SetUpTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	t, err := h.getTOTP(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if t == nil || !t.Enabled() {
+		if err := h.TwoFactor.SetPendingTOTP(r.Context(), userID, totp.GenerateSecret()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/profile/two-factor", http.StatusSeeOther)
+}
+
+// ConfirmTwoFactor turns on two-factor authentication once the user has
+// entered a code from their app, and shows their recovery codes.
+func (h *Handlers) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	t, err := h.getTOTP(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil || t.Enabled() {
+		http.Redirect(w, r, "/profile/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	now := time.Now().UTC()
+	codes, hashes := newRecoveryCodes()
+	step, ok := totp.Validate(t.Secret, r.PostForm.Get("code"), now)
+	if ok {
+		err = h.TwoFactor.ConfirmTOTP(r.Context(), userID, step, hashes, now)
+		if errors.Is(err, sql.ErrNoRows) {
+			ok = false
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "That code is not right. Check that the clock of your device is correct and try again."
+		h.renderTwoFactor(w, r, http.StatusBadRequest, t, td)
+		return
+	}
+
+	// This session has just proved it has the app.
+	h.SessionManager.Put(r.Context(), "twoFactorVerified", true)
+
+	t.ConfirmedAt = now
+	td := h.newTemplateData(r)
+	td.Flash = "Two-factor authentication is on."
+	td.Data["RecoveryCodes"] = codes
+	h.renderTwoFactor(w, r, http.StatusOK, t, td)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged-in
+// user after they enter a code from their app.
+func (h *Handlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	t, ok := h.verifiedTOTP(w, r, userID)
+	if !ok {
+		return
+	}
+
+	ok, err = h.checkTOTP(r.Context(), userID, r.PostForm.Get("code"))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Invalid code."
+		h.renderTwoFactor(w, r, http.StatusBadRequest, t, td)
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := h.TwoFactor.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td := h.newTemplateData(r)
+	td.Flash = "Your old recovery codes no longer work."
+	td.Data["RecoveryCodes"] = codes
+	h.renderTwoFactor(w, r, http.StatusOK, t, td)
+}
+
+// DisableTwoFactor turns off two-factor authentication for the logged-in
+// user after they enter a code from their app. Admins can't turn it off
+// when it is required.
+func (h *Handlers) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := h.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+	t, ok := h.verifiedTOTP(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if h.twoFactorRequired(r) {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Admins must use two-factor authentication."
+		h.renderTwoFactor(w, r, http.StatusBadRequest, t, td)
+		return
+	}
+
+	ok, err = h.checkTOTP(r.Context(), userID, r.PostForm.Get("code"))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Invalid code."
+		h.renderTwoFactor(w, r, http.StatusBadRequest, t, td)
+		return
+	}
+
+	if err := h.TwoFactor.DeleteTOTP(r.Context(), userID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionManager.Remove(r.Context(), "twoFactorVerified")
+
+	h.SessionManager.Put(r.Context(), "flash", "Two-factor authentication is off.")
+	http.Redirect(w, r, "/profile/two-factor", http.StatusSeeOther)
+}
+
+// AdminResetTwoFactor turns off two-factor authentication for a user who
+// lost their app and their recovery codes. They can set it up again after
+// logging in with their password.
+func (h *Handlers) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.TwoFactor.DeleteTOTP(r.Context(), user.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Two-factor authentication has been turned off for %s.", user.Username))
+
+	// Redirect back to the user detail page.
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// renderTwoFactor renders the two-factor settings page for t, which is nil
+// if the user hasn't started setting it up.
+func (h *Handlers) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, t *models.TOTP, td *TemplateData) {
+	td.Data["TOTP"] = t
+	td.Data["Required"] = h.twoFactorRequired(r)
+
+	switch {
+	case t == nil:
+	case t.Enabled():
+		left, err := h.TwoFactor.CountRecoveryCodes(r.Context(), t.UserID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		td.Data["RecoveryCodesLeft"] = left
+	default:
+		// Show the secret as a QR code for the app to scan, and as text to
+		// type in.
+		user, err := h.Users.GetUserByID(r.Context(), t.UserID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		code, err := qr.Encode(totp.URI(twoFactorIssuer, user.Username, t.Secret))
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		td.Data["QRCode"] = template.HTML(code.SVG())
+		td.Data["Secret"] = groupSecret(t.Secret)
+	}
+
+	h.renderStatus(w, r, status, "two_factor.page.tmpl", td)
+}
+
+// verifiedTOTP returns the enabled secret of the user for the settings
+// that need one. Otherwise it sends the user back to the settings page,
+// which asks sessions that haven't entered a code yet for one, and
+// returns false.
+func (h *Handlers) verifiedTOTP(w http.ResponseWriter, r *http.Request, userID int64) (*models.TOTP, bool) {
+	t, err := h.getTOTP(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if t == nil || !t.Enabled() || !h.SessionManager.GetBool(r.Context(), "twoFactorVerified") {
+		http.Redirect(w, r, "/profile/two-factor", http.StatusSeeOther)
+		return nil, false
+	}
+	return t, true
+}
+
+// twoFactorRequired reports whether the logged-in user must keep
+// two-factor authentication on.
+func (h *Handlers) twoFactorRequired(r *http.Request) bool {
+	return h.RequireAdmin2FA && h.SessionManager.GetString(r.Context(), "userRole") == "admin"
+}
+
+// getTOTP returns the secret of the user, or nil if they have none.
+func (h *Handlers) getTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	t, err := h.TwoFactor.GetTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// twoFactorEnabled reports whether the user must enter a code to log in.
+func (h *Handlers) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	t, err := h.getTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return t != nil && t.Enabled(), nil
+}
+
+// startTwoFactorLogin remembers that the user entered their password, so
+// that LoginTwoFactor can log them in once they enter a code. The session
+// token is renewed as it is for a full login.
+func (h *Handlers) startTwoFactorLogin(ctx context.Context, userID int64) error {
+	if err := h.SessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	h.SessionManager.Put(ctx, "twoFactorUserID", userID)
+	h.SessionManager.Put(ctx, "twoFactorStartedAt", time.Now().Unix())
+	return nil
+}
+
+// pendingTwoFactorUserID returns the ID of the user who entered their
+// password on this session, or 0 if nobody did or it was too long ago.
+func (h *Handlers) pendingTwoFactorUserID(ctx context.Context) int64 {
+	userID := h.SessionManager.GetInt64(ctx, "twoFactorUserID")
+	startedAt := time.Unix(h.SessionManager.GetInt64(ctx, "twoFactorStartedAt"), 0)
+	if userID != 0 && time.Since(startedAt) < twoFactorLoginTimeout {
+		return userID
+	}
+	h.SessionManager.Remove(ctx, "twoFactorUserID")
+	h.SessionManager.Remove(ctx, "twoFactorStartedAt")
+	return 0
+}
+
+// checkTOTP reports whether code is a current code from the user's app.
+// An accepted code is used up, so it can't be entered again.
+func (h *Handlers) checkTOTP(ctx context.Context, userID int64, code string) (bool, error) {
+	t, err := h.getTOTP(ctx, userID)
+	if err != nil || t == nil || !t.Enabled() {
+		return false, err
+	}
+	step, ok := totp.Validate(t.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+	err = h.TwoFactor.UseTOTPStep(ctx, userID, step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkRecoveryCode reports whether code is an unused recovery code of the
+// user, and uses it up.
+func (h *Handlers) checkRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	code = token.NormalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+	err := h.TwoFactor.UseRecoveryCode(ctx, userID, token.Hash(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCodes returns a new set of recovery codes and their hashes.
+func newRecoveryCodes() (codes, hashes []string) {
+	for range recoveryCodeCount {
+		code := token.RecoveryCode()
+		codes = append(codes, code)
+		hashes = append(hashes, token.Hash(code))
+	}
+	return codes, hashes
+}
+
+// groupSecret splits a secret into groups of four characters, which are
+// easier to type into an app.
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
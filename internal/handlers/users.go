@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"lms/internal/database"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// userRoles are the roles admins can give users, in the order offered.
+var userRoles = []string{rbac.RoleStudent, rbac.RoleInstructor, rbac.RoleAdmin}
+
+// CreateUserForm displays the form for creating a new account.
+func (h *Handlers) CreateUserForm(w http.ResponseWriter, r *http.Request) {
+	td := h.newTemplateData(r)
+	td.Data["User"] = &models.User{Role: rbac.RoleStudent}
+	td.Data["Roles"] = userRoles
+	h.render(w, r, "admin_create_user.page.tmpl", td)
+}
+
+// CreateUser creates an account. Without a password, the account gets a
+// random one and the user a link to choose their own.
+func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Show the form again with what was entered.
+	form := &models.User{
+		Username: strings.TrimSpace(r.PostForm.Get("username")),
+		Email:    strings.TrimSpace(r.PostForm.Get("email")),
+		FullName: strings.TrimSpace(r.PostForm.Get("full_name")),
+		Role:     r.PostForm.Get("role"),
+		Timezone: "UTC",
+	}
+	password := r.PostForm.Get("password")
+
+	problem := validateAccount(form)
+	var user *models.User
+	if problem == "" {
+		err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+			var err error
+			if password == "" {
+				user, err = tx.CreateUser(r.Context(), form.Username, token.New(), form.Role)
+			} else {
+				user, err = tx.CreateUser(r.Context(), form.Username, password, form.Role)
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.UpdateUserProfile(r.Context(), user.ID, form.FullName, "", form.Timezone); err != nil {
+				return err
+			}
+			if form.Email == "" {
+				return nil
+			}
+			user.Email = form.Email
+			return tx.SetUserEmail(r.Context(), user.ID, form.Email)
+		})
+		switch {
+		case errors.Is(err, database.ErrUsernameTaken):
+			problem = "That username is already taken."
+		case errors.Is(err, database.ErrEmailTaken):
+			problem = "That email address is already in use."
+		case err != nil:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if problem != "" {
+		td := h.newTemplateData(r)
+		td.Data["User"] = form
+		td.Data["Roles"] = userRoles
+		td.Data["Error"] = problem
+		h.renderStatus(w, r, http.StatusBadRequest, "admin_create_user.page.tmpl", td)
+		return
+	}
+
+	// Users without a password choose one with a reset link. The account
+	// exists by now, so a link that can't be emailed is shown instead.
+	flash := fmt.Sprintf("The account %s has been created.", user.Username)
+	if password == "" {
+		link, err := h.createPasswordReset(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if user.Email == "" || h.Mailer == nil {
+			flash += fmt.Sprintf(" Send them this link to choose a password: %s", link)
+		} else if err := h.sendPasswordReset(r.Context(), user, link); err != nil {
+			log.Printf("Failed to send a password reset link to user %d: %v", user.ID, err)
+			flash += fmt.Sprintf(" The email could not be sent. Send them this link to choose a password: %s", link)
+		} else {
+			flash += fmt.Sprintf(" A link to choose a password was sent to %s.", user.Email)
+		}
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+}
+
+// UpdateUser saves the account details and role of a user.
+func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	form := *user
+	form.Username = strings.TrimSpace(r.PostForm.Get("username"))
+	form.Email = strings.TrimSpace(r.PostForm.Get("email"))
+	form.FullName = strings.TrimSpace(r.PostForm.Get("full_name"))
+	form.DisplayName = strings.TrimSpace(r.PostForm.Get("display_name"))
+	form.Timezone = strings.TrimSpace(r.PostForm.Get("timezone"))
+	form.Role = r.PostForm.Get("role")
+	if form.Timezone == "" {
+		form.Timezone = "UTC"
+	}
+
+	problem := validateAccount(&form)
+	if problem == "" && form.Role != user.Role && h.isCurrentUser(r, user.ID) {
+		// Admins can't lock themselves out of the admin pages.
+		problem = "You can't change your own role."
+	}
+	if problem == "" {
+		err := h.Tx.WithTx(r.Context(), func(tx database.Store) error {
+			if form.Username != user.Username {
+				if err := tx.SetUsername(r.Context(), user.ID, form.Username); err != nil {
+					return err
+				}
+			}
+			if form.Email != user.Email {
+				if err := tx.SetUserEmail(r.Context(), user.ID, form.Email); err != nil {
+					return err
+				}
+			}
+			if form.Role != user.Role {
+				if err := tx.SetUserRole(r.Context(), user.ID, form.Role); err != nil {
+					return err
+				}
+			}
+			return tx.UpdateUserProfile(r.Context(), user.ID, form.FullName, form.DisplayName, form.Timezone)
+		})
+		switch {
+		case errors.Is(err, database.ErrUsernameTaken):
+			problem = "That username is already taken."
+		case errors.Is(err, database.ErrEmailTaken):
+			problem = "That email address is already in use."
+		case err != nil:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Role changes reach the user's sessions on their next request, through
+	// RequireAuthentication.
+	flash := fmt.Sprintf("The account of %s has been saved.", form.Username)
+	if problem != "" {
+		flash = "The account was not saved: " + problem
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// DeactivateUser suspends a user: they are logged out and can't log in, or
+// use their API tokens, until they are reactivated.
+func (h *Handlers) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if h.isCurrentUser(r, user.ID) {
+		http.Error(w, "You can't deactivate your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Users.DeactivateUser(r.Context(), user.ID, time.Now().UTC()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.endSessions(r.Context(), user.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s has been deactivated and logged out.", user.Username))
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// ReactivateUser lets a deactivated user log in again, or one who hasn't
+// verified their email address log in at all.
+func (h *Handlers) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Users.ReactivateUser(r.Context(), user.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	flash := fmt.Sprintf("%s has been reactivated and can log in again.", user.Username)
+	if user.DeactivatedAt.IsZero() {
+		flash = fmt.Sprintf("%s has been activated and can log in.", user.Username)
+	}
+	h.SessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+}
+
+// DeleteUserForm asks to confirm the deletion of a user, listing what is
+// deleted with them.
+func (h *Handlers) DeleteUserForm(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if h.isCurrentUser(r, user.ID) {
+		http.Error(w, "You can't delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	h.renderDeleteUser(w, r, http.StatusOK, user, h.newTemplateData(r))
+}
+
+// DeleteUser deletes a user for good, with their enrollments, progress and
+// certificates, once the admin has typed their username to confirm.
+func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if h.isCurrentUser(r, user.ID) {
+		http.Error(w, "You can't delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(r.PostForm.Get("confirm")) != user.Username {
+		td := h.newTemplateData(r)
+		td.Data["Error"] = "Type the username exactly to confirm."
+		h.renderDeleteUser(w, r, http.StatusBadRequest, user, td)
+		return
+	}
+
+	err = h.Users.DeleteUser(r.Context(), user.ID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.endSessions(r.Context(), user.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("The account %s has been deleted.", user.Username))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// renderDeleteUser renders the page that confirms the deletion of user.
+func (h *Handlers) renderDeleteUser(w http.ResponseWriter, r *http.Request, status int, user *models.User, td *TemplateData) {
+	records, err := h.Users.CountUserRecords(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	td.Data["User"] = user
+	td.Data["Records"] = records
+	h.renderStatus(w, r, status, "admin_delete_user.page.tmpl", td)
+}
+
+// isCurrentUser reports whether userID is the logged-in user.
+func (h *Handlers) isCurrentUser(r *http.Request, userID int64) bool {
+	return h.SessionManager.GetInt64(r.Context(), "authenticatedUserID") == userID
+}
+
+// validateAccount checks the account details entered by an admin, and
+// returns what is wrong with them, if anything.
+func validateAccount(user *models.User) string {
+	switch {
+	case user.Username == "":
+		return "Please enter a username."
+	case utf8.RuneCountInString(user.Username) > maxNameLength:
+		return fmt.Sprintf("Usernames must be at most %d characters long.", maxNameLength)
+	case !rbac.ValidRole(user.Role):
+		return "Please choose a role."
+	case utf8.RuneCountInString(user.FullName) > maxNameLength || utf8.RuneCountInString(user.DisplayName) > maxNameLength:
+		return fmt.Sprintf("Names must be at most %d characters long.", maxNameLength)
+	case !validTimezone(user.Timezone):
+		return "Unknown timezone. Use a name such as Europe/Paris or America/New_York."
+	case user.Email != "" && !validEmail(user.Email):
+		return "Invalid email address."
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAP (RFC 4511 section 5.1). Tags of the protocol
+// operations and filters are built from a class and a number.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxMessageSize bounds the messages read from the server.
+const maxMessageSize = 16 << 20
+
+// element is a decoded BER element: its tag and the bytes of its content.
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// appendLength appends a BER definite length.
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	var buf []byte
+	for ; n > 0; n >>= 8 {
+		buf = append([]byte{byte(n)}, buf...)
+	}
+	b = append(b, 0x80|byte(len(buf)))
+	return append(b, buf...)
+}
+
+// encode returns an element with the given tag and content.
+func encode(tag byte, content []byte) []byte {
+	b := appendLength([]byte{tag}, len(content))
+	return append(b, content...)
+}
+
+// encodeConstructed returns an element holding the encoded children.
+func encodeConstructed(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return encode(tag, content)
+}
+
+// encodeInt returns an INTEGER or ENUMERATED in the fewest bytes.
+func encodeInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		// Stop once the rest is the sign extension of the first byte.
+		if (v >= -0x80 && v < 0x80) || len(b) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return encode(tag, b)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readElement reads one element from r. LDAP tags all fit in one byte.
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	if tag&0x1f == 0x1f {
+		return element{}, errors.New("ldap: unsupported multi-byte tag")
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	n := int(first)
+	if first >= 0x80 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return element{}, fmt.Errorf("ldap: unsupported length encoding 0x%02x", first)
+		}
+		n = 0
+		for range size {
+			b, err := r.ReadByte()
+			if err != nil {
+				return element{}, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > maxMessageSize {
+		return element{}, fmt.Errorf("ldap: message of %d bytes is too large", n)
+	}
+
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, content: content}, nil
+}
+
+// children decodes the elements inside a constructed element.
+func (e element) children() ([]element, error) {
+	var elems []element
+	rest := e.content
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, errors.New("ldap: truncated element")
+		}
+		tag, first := rest[0], rest[1]
+		rest = rest[2:]
+
+		n := int(first)
+		if first >= 0x80 {
+			size := int(first & 0x7f)
+			if size == 0 || size > 4 || len(rest) < size {
+				return nil, errors.New("ldap: invalid element length")
+			}
+			n = 0
+			for _, b := range rest[:size] {
+				n = n<<8 | int(b)
+			}
+			rest = rest[size:]
+		}
+		if n < 0 || n > len(rest) {
+			return nil, errors.New("ldap: truncated element")
+		}
+		elems = append(elems, element{tag: tag, content: rest[:n]})
+		rest = rest[n:]
+	}
+	return elems, nil
+}
+
+// int decodes an INTEGER or ENUMERATED.
+func (e element) int() (int64, error) {
+	if len(e.content) == 0 || len(e.content) > 8 {
+		return 0, errors.New("ldap: invalid integer")
+	}
+	v := int64(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choices (RFC 4511 section 4.5.1.7).
+const (
+	filterAnd       = classContext | constructed | 0
+	filterOr        = classContext | constructed | 1
+	filterNot       = classContext | constructed | 2
+	filterEqual     = classContext | constructed | 3
+	filterSubstring = classContext | constructed | 4
+	filterGreater   = classContext | constructed | 5
+	filterLess      = classContext | constructed | 6
+	filterPresent   = classContext | 7
+	filterApprox    = classContext | constructed | 8
+)
+
+// EscapeFilter escapes the characters of s that are special in a filter
+// value, so that user input can't change the meaning of the filter.
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// CompileFilter checks a filter in the string form of RFC 4515, such as
+// "(&(objectClass=person)(uid=ann))". Extensible matches are not supported.
+func CompileFilter(filter string) error {
+	_, err := compileFilter(filter)
+	return err
+}
+
+// compileFilter encodes a filter in the string form for a search request.
+func compileFilter(filter string) ([]byte, error) {
+	p := &filterParser{s: filter}
+	b, err := p.filter()
+	if err == nil && p.pos != len(p.s) {
+		err = p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("ldap: invalid filter %q: %s", p.s, fmt.Sprintf(format, args...))
+}
+
+// filter parses "(" filtercomp ")".
+func (p *filterParser) filter() ([]byte, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] != '(' {
+		return nil, p.errorf("expected ( at offset %d", p.pos)
+	}
+	p.pos++
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	var b []byte
+	var err error
+	switch p.s[p.pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if p.s[p.pos] == '|' {
+			tag = filterOr
+		}
+		p.pos++
+		var subs [][]byte
+		for p.pos < len(p.s) && p.s[p.pos] == '(' {
+			sub, err := p.filter()
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, sub)
+		}
+		if len(subs) == 0 {
+			return nil, p.errorf("empty filter list")
+		}
+		b = encodeConstructed(tag, subs...)
+	case '!':
+		p.pos++
+		var sub []byte
+		sub, err = p.filter()
+		b = encodeConstructed(filterNot, sub)
+	default:
+		b, err = p.item()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+		return nil, p.errorf("expected ) at offset %d", p.pos)
+	}
+	p.pos++
+	return b, nil
+}
+
+// item parses a comparison such as "uid=ann", "mail=*" or "cn=A*n".
+func (p *filterParser) item() ([]byte, error) {
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		return nil, p.errorf("unexpected end")
+	}
+	item := p.s[p.pos : p.pos+end]
+	p.pos += end
+
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, p.errorf("expected attribute=value in %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEqual)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreater, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLess, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	case ':':
+		return nil, p.errorf("extensible matches are not supported")
+	}
+	if attr == "" || strings.ContainsAny(attr, "()&|!*\\") {
+		return nil, p.errorf("invalid attribute %q", attr)
+	}
+
+	if tag == filterEqual && value == "*" {
+		return encodeString(filterPresent, attr), nil
+	}
+	if tag == filterEqual && strings.Contains(value, "*") {
+		return p.substrings(attr, value)
+	}
+
+	v, err := p.unescape(value)
+	if err != nil {
+		return nil, err
+	}
+	return encodeConstructed(tag, encodeString(tagOctetString, attr), encodeString(tagOctetString, v)), nil
+}
+
+// substrings encodes a value with wildcards, such as "A*n*".
+func (p *filterParser) substrings(attr, value string) ([]byte, error) {
+	parts := strings.Split(value, "*")
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := p.unescape(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			tag = classContext | 0 // initial
+		case len(parts) - 1:
+			tag = classContext | 2 // final
+		}
+		subs = append(subs, encodeString(tag, v))
+	}
+	if len(subs) == 0 {
+		return nil, p.errorf("no value in %q", attr+"="+value)
+	}
+	return encodeConstructed(filterSubstring,
+		encodeString(tagOctetString, attr),
+		encodeConstructed(tagSequence, subs...),
+	), nil
+}
+
+// unescape decodes the \XX escapes of a filter value.
+func (p *filterParser) unescape(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", p.errorf("truncated escape in %q", value)
+		}
+		c, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", p.errorf("invalid escape in %q", value)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
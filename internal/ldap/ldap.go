@@ -0,0 +1,391 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511): enough to look up
+// users in a directory and check their passwords with simple binds, over
+// plain TCP, TLS (ldaps://) or StartTLS.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operations (RFC 4511 section 4.2 onwards).
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+)
+
+// oidStartTLS names the StartTLS extended operation (RFC 4511 section 4.14).
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// Result codes the callers care about.
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+// Error is a result other than success returned by the server.
+type Error struct {
+	Op      string
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: %s failed with result %d", e.Op, e.Code)
+	}
+	return fmt.Sprintf("ldap: %s failed with result %d: %s", e.Op, e.Code, e.Message)
+}
+
+// IsResult reports whether err is an Error with the given result code.
+func IsResult(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// Scope is how deep a search looks below its base.
+type Scope int
+
+const (
+	ScopeBase    Scope = 0 // Only the base entry.
+	ScopeOne     Scope = 1 // The entries directly below the base.
+	ScopeSubtree Scope = 2 // The base and everything below it.
+)
+
+// SearchRequest describes a search.
+type SearchRequest struct {
+	BaseDN     string
+	Scope      Scope
+	Filter     string // In the string form of RFC 4515.
+	Attributes []string
+	SizeLimit  int // 0 for no limit.
+}
+
+// Entry is an entry returned by a search.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of an attribute, whose name is compared
+// without regard to case.
+func (e *Entry) Values(attr string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Value returns the first value of an attribute, or "" if it has none.
+func (e *Entry) Value(attr string) string {
+	if v := e.Values(attr); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// EqualDN reports whether two distinguished names are the same, ignoring
+// case and the spaces around the separators, which directories differ on:
+// "CN=Admins, DC=example" equals "cn=admins,dc=example".
+func EqualDN(a, b string) bool {
+	return normalizeDN(a) == normalizeDN(b)
+}
+
+func normalizeDN(dn string) string {
+	dn = strings.ToLower(strings.TrimSpace(dn))
+	var b []byte
+	escaped := 0 // Length of b up to the last escaped character, kept as is.
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\' && i+1 < len(dn):
+			b = append(b, c, dn[i+1])
+			escaped = len(b)
+			i++
+		case c == ',' || c == '=':
+			for len(b) > escaped && b[len(b)-1] == ' ' {
+				b = b[:len(b)-1]
+			}
+			b = append(b, c)
+			for i+1 < len(dn) && dn[i+1] == ' ' {
+				i++
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// Conn is a connection to a directory server. Its methods must not be
+// called concurrently.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	host    string
+	lastID  int64
+	timeout time.Duration
+}
+
+// Dial connects to the server at rawURL, an ldap:// or ldaps:// URL. For
+// ldaps, tlsConfig may be nil to verify the server with the system roots.
+// Each operation on the connection must complete within timeout.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+	port := "389"
+	if u.Scheme == "ldaps" {
+		port = "636"
+	} else if u.Scheme != "ldap" {
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	host := u.Hostname()
+
+	d := &net.Dialer{Timeout: timeout}
+	nc, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+	c := &Conn{conn: nc, r: bufio.NewReader(nc), host: host, timeout: timeout}
+
+	if u.Scheme == "ldaps" {
+		if err := c.handshake(tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// StartTLS upgrades a plain connection to TLS.
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	op := encodeConstructed(opExtendedRequest, encodeString(classContext|0, oidStartTLS))
+	err := c.roundTrip(op, func(resp element) (bool, error) {
+		if resp.tag != opExtendedResponse {
+			return false, fmt.Errorf("ldap: unexpected response 0x%02x to StartTLS", resp.tag)
+		}
+		return true, result("StartTLS", resp)
+	})
+	if err != nil {
+		return err
+	}
+	return c.handshake(tlsConfig)
+}
+
+// handshake runs a TLS handshake on the connection.
+func (c *Conn) handshake(tlsConfig *tls.Config) error {
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = c.host
+	}
+
+	tc := tls.Client(c.conn, cfg)
+	tc.SetDeadline(time.Now().Add(c.timeout))
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("ldap: TLS handshake failed: %w", err)
+	}
+	c.conn = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// Bind authenticates the connection as dn with a password. An empty
+// password is refused unless dn is empty too: servers treat a bind with
+// a name and no password as an anonymous one, which always succeeds
+// (RFC 4513 section 5.1.2).
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" && dn != "" {
+		return &Error{Op: "bind", Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	op := encodeConstructed(opBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	)
+	return c.roundTrip(op, func(resp element) (bool, error) {
+		if resp.tag != opBindResponse {
+			return false, fmt.Errorf("ldap: unexpected response 0x%02x to bind", resp.tag)
+		}
+		return true, result("bind", resp)
+	})
+}
+
+// Search returns the entries matching req. Referrals to other servers
+// are ignored.
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrs [][]byte
+	for _, a := range req.Attributes {
+		attrs = append(attrs, encodeString(tagOctetString, a))
+	}
+
+	op := encodeConstructed(opSearchRequest,
+		encodeString(tagOctetString, req.BaseDN),
+		encodeInt(tagEnumerated, int64(req.Scope)),
+		encodeInt(tagEnumerated, 0), // Never dereference aliases.
+		encodeInt(tagInteger, int64(req.SizeLimit)),
+		encodeInt(tagInteger, int64(c.timeout/time.Second)),
+		encodeBool(false),
+		filter,
+		encodeConstructed(tagSequence, attrs...),
+	)
+
+	var entries []*Entry
+	err = c.roundTrip(op, func(resp element) (bool, error) {
+		switch resp.tag {
+		case opSearchEntry:
+			e, err := parseEntry(resp)
+			if err != nil {
+				return false, err
+			}
+			entries = append(entries, e)
+			return false, nil
+		case opSearchReference:
+			return false, nil
+		case opSearchDone:
+			return true, result("search", resp)
+		}
+		return false, fmt.Errorf("ldap: unexpected response 0x%02x to search", resp.tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Close tells the server we are done and closes the connection.
+func (c *Conn) Close() error {
+	c.lastID++
+	msg := encodeConstructed(tagSequence, encodeInt(tagInteger, c.lastID), encode(opUnbindRequest, nil))
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(msg)
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and passes each response to it to handle,
+// until handle reports the last one.
+func (c *Conn) roundTrip(op []byte, handle func(resp element) (bool, error)) error {
+	c.lastID++
+	id := c.lastID
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(encodeConstructed(tagSequence, encodeInt(tagInteger, id), op)); err != nil {
+		return fmt.Errorf("ldap: %w", err)
+	}
+
+	for {
+		msg, err := readElement(c.r)
+		if err != nil {
+			return fmt.Errorf("ldap: %w", err)
+		}
+		parts, err := msg.children()
+		if err != nil {
+			return err
+		}
+		if msg.tag != tagSequence || len(parts) < 2 {
+			return errors.New("ldap: invalid message")
+		}
+		msgID, err := parts[0].int()
+		if err != nil {
+			return err
+		}
+		// Message ID 0 is an unsolicited notification, which the server
+		// sends before closing the connection.
+		if msgID == 0 {
+			return fmt.Errorf("ldap: server closed the connection: %w", result("connection", parts[1]))
+		}
+		if msgID != id {
+			continue
+		}
+
+		done, err := handle(parts[1])
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// result returns the LDAPResult in resp as an error, or nil for success.
+func result(op string, resp element) error {
+	parts, err := resp.children()
+	if err != nil {
+		return err
+	}
+	if len(parts) < 3 {
+		return fmt.Errorf("ldap: invalid %s result", op)
+	}
+	code, err := parts[0].int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{Op: op, Code: int(code), Message: string(parts[2].content)}
+}
+
+// parseEntry decodes a SearchResultEntry.
+func parseEntry(resp element) (*Entry, error) {
+	parts, err := resp.children()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 2 {
+		return nil, errors.New("ldap: invalid search entry")
+	}
+
+	e := &Entry{DN: string(parts[0].content), Attributes: map[string][]string{}}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		typeAndValues, err := a.children()
+		if err != nil {
+			return nil, err
+		}
+		if len(typeAndValues) != 2 {
+			return nil, errors.New("ldap: invalid attribute in search entry")
+		}
+		values, err := typeAndValues[1].children()
+		if err != nil {
+			return nil, err
+		}
+		name := string(typeAndValues[0].content)
+		for _, v := range values {
+			e.Attributes[name] = append(e.Attributes[name], string(v.content))
+		}
+	}
+	return e, nil
+}
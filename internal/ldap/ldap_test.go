@@ -0,0 +1,302 @@
+package ldap
+
+import (
+	"context"
+	"lms/internal/ldap/ldaptest"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testEntries = []ldaptest.Entry{
+	{DN: "cn=lms,ou=services,dc=example,dc=com", Password: "service"},
+	{
+		DN: "uid=ann,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"ann"},
+			"cn":          {"Ann Smith"},
+			"mail":        {"ann@example.com"},
+		},
+		Password: "secret",
+	},
+	{
+		DN: "uid=bob,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+		},
+		Password: "hunter2",
+	},
+}
+
+func dial(t *testing.T, url string) *Conn {
+	t.Helper()
+	c, err := Dial(context.Background(), url, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestBind(t *testing.T) {
+	srv := ldaptest.NewServer(testEntries...)
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		dn       string
+		password string
+		wantCode int // -1 for success.
+	}{
+		{"service account", "cn=lms,ou=services,dc=example,dc=com", "service", -1},
+		{"user", "uid=ann,ou=people,dc=example,dc=com", "secret", -1},
+		{"DN spacing and case", "UID=ann, OU=People, DC=example, DC=com", "secret", -1},
+		{"anonymous", "", "", -1},
+		{"wrong password", "uid=ann,ou=people,dc=example,dc=com", "Secret", ResultInvalidCredentials},
+		{"other user's password", "uid=ann,ou=people,dc=example,dc=com", "hunter2", ResultInvalidCredentials},
+		{"unknown DN", "uid=eve,ou=people,dc=example,dc=com", "secret", ResultInvalidCredentials},
+		// Servers would treat it as an anonymous bind and accept it.
+		{"empty password", "uid=ann,ou=people,dc=example,dc=com", "", ResultInvalidCredentials},
+	}
+	// One connection for all, as binds change its identity but not its state.
+	c := dial(t, srv.URL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Bind(tt.dn, tt.password)
+			if tt.wantCode < 0 {
+				if err != nil {
+					t.Fatalf("Bind: %v", err)
+				}
+				return
+			}
+			if !IsResult(err, tt.wantCode) {
+				t.Fatalf("got error %v, want result %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := ldaptest.NewServer(testEntries...)
+	defer srv.Close()
+	c := dial(t, srv.URL)
+
+	tests := []struct {
+		name     string
+		req      SearchRequest
+		wantDNs  []string
+		wantCode int // -1 for success.
+	}{
+		{
+			name:     "one user",
+			req:      SearchRequest{BaseDN: "ou=people,dc=example,dc=com", Scope: ScopeSubtree, Filter: "(&(objectClass=person)(uid=ann))"},
+			wantDNs:  []string{"uid=ann,ou=people,dc=example,dc=com"},
+			wantCode: -1,
+		},
+		{
+			name:     "no match",
+			req:      SearchRequest{BaseDN: "ou=people,dc=example,dc=com", Scope: ScopeSubtree, Filter: "(uid=eve)"},
+			wantCode: -1,
+		},
+		{
+			name:     "escaped value",
+			req:      SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeSubtree, Filter: "(uid=" + EscapeFilter("*") + ")"},
+			wantCode: -1,
+		},
+		{
+			name:     "or",
+			req:      SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeSubtree, Filter: "(|(uid=ann)(uid=bob))"},
+			wantDNs:  []string{"uid=ann,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+			wantCode: -1,
+		},
+		{
+			name:     "base",
+			req:      SearchRequest{BaseDN: "uid=bob,ou=people,dc=example,dc=com", Scope: ScopeBase, Filter: "(objectClass=*)"},
+			wantDNs:  []string{"uid=bob,ou=people,dc=example,dc=com"},
+			wantCode: -1,
+		},
+		{
+			name:     "missing base",
+			req:      SearchRequest{BaseDN: "uid=eve,ou=people,dc=example,dc=com", Scope: ScopeBase, Filter: "(objectClass=*)"},
+			wantCode: ResultNoSuchObject,
+		},
+		{
+			name:     "size limit",
+			req:      SearchRequest{BaseDN: "ou=people,dc=example,dc=com", Scope: ScopeSubtree, Filter: "(objectClass=person)", SizeLimit: 1},
+			wantCode: ResultSizeLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := c.Search(&tt.req)
+			if tt.wantCode >= 0 {
+				if !IsResult(err, tt.wantCode) {
+					t.Fatalf("got error %v, want result %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dns []string
+			for _, e := range entries {
+				dns = append(dns, e.DN)
+			}
+			if !slices.Equal(dns, tt.wantDNs) {
+				t.Errorf("got %q, want %q", dns, tt.wantDNs)
+			}
+		})
+	}
+}
+
+func TestSearchAttributes(t *testing.T) {
+	srv := ldaptest.NewServer(testEntries...)
+	defer srv.Close()
+	c := dial(t, srv.URL)
+
+	entries, err := c.Search(&SearchRequest{
+		BaseDN:     "dc=example,dc=com",
+		Scope:      ScopeSubtree,
+		Filter:     "(uid=ann)",
+		Attributes: []string{"CN", "mail"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if got := e.Value("cn"); got != "Ann Smith" {
+		t.Errorf("cn = %q, want Ann Smith", got)
+	}
+	if got := e.Values("MAIL"); !slices.Equal(got, []string{"ann@example.com"}) {
+		t.Errorf("mail = %q", got)
+	}
+	if got := e.Value("uid"); got != "" {
+		t.Errorf("got uid %q, which wasn't requested", got)
+	}
+}
+
+func TestDirectoryDown(t *testing.T) {
+	srv := ldaptest.NewServer(testEntries...)
+	url := srv.URL
+	c := dial(t, url)
+	srv.Close()
+
+	if err := c.Bind("uid=ann,ou=people,dc=example,dc=com", "secret"); err == nil {
+		t.Error("Bind succeeded on a closed connection")
+	}
+	if _, err := Dial(context.Background(), url, nil, time.Second); err == nil {
+		t.Error("Dial succeeded with the server down")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// A server that accepts connections but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			<-done
+			c.Close()
+		}
+	}()
+
+	c, err := Dial(context.Background(), "ldap://"+ln.Addr().String(), nil, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	if err := c.Bind("uid=ann,ou=people,dc=example,dc=com", "secret"); err == nil {
+		t.Fatal("Bind succeeded without an answer")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Bind gave up after %v, want about 100ms", d)
+	}
+}
+
+func TestDialURL(t *testing.T) {
+	for _, url := range []string{"http://localhost", "ldap://[::1", "localhost:389"} {
+		if _, err := Dial(context.Background(), url, nil, time.Second); err == nil {
+			t.Errorf("Dial(%q) succeeded", url)
+		}
+	}
+}
+
+func TestEqualDN(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"cn=admins,dc=example", "cn=admins,dc=example", true},
+		{"CN=Admins, DC=example", "cn=admins,dc=example", true},
+		{" cn = admins , dc = example ", "cn=admins,dc=example", true},
+		{`cn=a\, b,dc=example`, `cn=a\, b,dc=example`, true},
+		{`cn=a\ ,dc=example`, `cn=a,dc=example`, false},
+		{"cn=admins,dc=example", "cn=admins,dc=example,dc=com", false},
+		{"cn=admins", "cn=staff", false},
+	}
+	for _, tt := range tests {
+		if got := EqualDN(tt.a, tt.b); got != tt.want {
+			t.Errorf("EqualDN(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	valid := []string{
+		"(uid=ann)",
+		"(&(objectClass=person)(uid=ann))",
+		"(|(uid=ann)(!(uid=bob)))",
+		"(mail=*)",
+		"(cn=A*n*h)",
+		"(uidNumber>=1000)",
+		`(cn=a\2ab)`,
+	}
+	for _, f := range valid {
+		if err := CompileFilter(f); err != nil {
+			t.Errorf("CompileFilter(%q): %v", f, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"uid=ann",
+		"(uid=ann",
+		"(uid=ann))",
+		"(&)",
+		"(=ann)",
+		"(u(id=ann)",
+		"(uid:dn:=ann)",
+		`(cn=a\2)`,
+		`(cn=a\zz)`,
+		"(cn=**)",
+	}
+	for _, f := range invalid {
+		if err := CompileFilter(f); err == nil {
+			t.Errorf("CompileFilter(%q) succeeded", f)
+		}
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	got := EscapeFilter(`a*b(c)\d` + "\x00")
+	want := `a\2ab\28c\29\5cd\00`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// An escaped username can't widen the filter.
+	if err := CompileFilter("(uid=" + EscapeFilter("*)(uid=*") + ")"); err != nil {
+		t.Errorf("escaped filter: %v", err)
+	}
+}
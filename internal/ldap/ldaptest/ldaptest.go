@@ -0,0 +1,409 @@
+// Package ldaptest runs an in-memory LDAP server for tests. It answers
+// simple binds and searches, which is all package ldap sends, over plain
+// TCP on a local port.
+package ldaptest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Result codes the server answers with (RFC 4511 appendix A).
+const (
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultSizeLimitExceeded  = 4
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+)
+
+// BER tags of the messages the server reads and writes.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	opBindRequest      = 0x60
+	opBindResponse     = 0x61
+	opUnbindRequest    = 0x42
+	opSearchRequest    = 0x63
+	opSearchEntry      = 0x64
+	opSearchDone       = 0x65
+	opExtendedRequest  = 0x77
+	opExtendedResponse = 0x78
+
+	filterAnd     = 0xa0
+	filterOr      = 0xa1
+	filterNot     = 0xa2
+	filterEqual   = 0xa3
+	filterPresent = 0x87
+)
+
+// Entry is an entry of the directory.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+	// Password is the password the entry binds with. Entries without one
+	// can't bind.
+	Password string
+}
+
+// Server is an LDAP server holding its entries in memory.
+type Server struct {
+	// URL is the ldap:// URL of the server.
+	URL string
+
+	ln      net.Listener
+	mu      sync.Mutex
+	entries []Entry
+	conns   map[net.Conn]bool // nil once the server is closed.
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server with the given entries. Close it when done.
+func NewServer(entries ...Entry) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		URL:     "ldap://" + ln.Addr().String(),
+		ln:      ln,
+		entries: entries,
+		conns:   map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetEntries replaces the entries of the directory.
+func (s *Server) SetEntries(entries ...Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+// Close stops the server and closes its connections. Clients then get
+// errors as from a directory that is down.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.conns == nil {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// handle answers the requests on a connection until the client unbinds.
+func (s *Server) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readElement(r)
+		if err != nil {
+			return
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id := parts[0].content
+		var responses [][]byte
+		switch op := parts[1]; op.tag {
+		case opBindRequest:
+			responses = append(responses, s.bind(op))
+		case opSearchRequest:
+			responses = s.search(op)
+		case opExtendedRequest:
+			responses = append(responses, ldapResult(opExtendedResponse, resultProtocolError, "extended operations are not supported"))
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+		for _, resp := range responses {
+			if _, err := c.Write(encode(tagSequence, encode(tagInteger, id), resp)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind answers a simple bind.
+func (s *Server) bind(op element) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts, err := op.children()
+	if err != nil || len(parts) < 3 {
+		return ldapResult(opBindResponse, resultProtocolError, "invalid bind request")
+	}
+	dn, password := string(parts[1].content), string(parts[2].content)
+	if dn == "" && password == "" {
+		return ldapResult(opBindResponse, resultSuccess, "")
+	}
+	for _, e := range s.entries {
+		if equalDN(e.DN, dn) && e.Password != "" && e.Password == password {
+			return ldapResult(opBindResponse, resultSuccess, "")
+		}
+	}
+	return ldapResult(opBindResponse, resultInvalidCredentials, "invalid credentials")
+}
+
+// search answers a search with the matching entries and the result.
+// One-level searches look through the whole subtree.
+func (s *Server) search(op element) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts, err := op.children()
+	if err != nil || len(parts) < 8 {
+		return [][]byte{ldapResult(opSearchDone, resultProtocolError, "invalid search request")}
+	}
+	base := string(parts[0].content)
+	scope, _ := parts[1].int()
+	sizeLimit, _ := parts[3].int()
+	filter := parts[6]
+	requested, _ := parts[7].children()
+
+	var responses [][]byte
+	found := false
+	for _, e := range s.entries {
+		switch {
+		case equalDN(e.DN, base):
+			found = true
+		case scope == 0 || !strings.HasSuffix(normalizeDN(e.DN), ","+normalizeDN(base)):
+			continue
+		}
+		if !match(filter, e) {
+			continue
+		}
+		if sizeLimit > 0 && len(responses) == int(sizeLimit) {
+			return append(responses, ldapResult(opSearchDone, resultSizeLimitExceeded, ""))
+		}
+		responses = append(responses, searchEntry(e, requested))
+	}
+	if scope == 0 && !found {
+		return [][]byte{ldapResult(opSearchDone, resultNoSuchObject, "no such object")}
+	}
+	return append(responses, ldapResult(opSearchDone, resultSuccess, ""))
+}
+
+// searchEntry encodes the requested attributes of e, or all of them if
+// none are requested.
+func searchEntry(e Entry, requested []element) []byte {
+	var attrs [][]byte
+	for name, values := range e.Attributes {
+		wanted := len(requested) == 0
+		for _, r := range requested {
+			wanted = wanted || strings.EqualFold(string(r.content), name)
+		}
+		if !wanted {
+			continue
+		}
+		var vals [][]byte
+		for _, v := range values {
+			vals = append(vals, encode(tagOctetString, []byte(v)))
+		}
+		attrs = append(attrs, encode(tagSequence, encode(tagOctetString, []byte(name)), encode(tagSet, vals...)))
+	}
+	return encode(opSearchEntry, encode(tagOctetString, []byte(e.DN)), encode(tagSequence, attrs...))
+}
+
+// match reports whether e matches a filter. Attribute names and values are
+// compared without regard to case; substring, ordering and approximate
+// filters match nothing.
+func match(filter element, e Entry) bool {
+	children, err := filter.children()
+	switch filter.tag {
+	case filterAnd:
+		for _, c := range children {
+			if !match(c, e) {
+				return false
+			}
+		}
+		return err == nil
+	case filterOr:
+		for _, c := range children {
+			if match(c, e) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return err == nil && len(children) == 1 && !match(children[0], e)
+	case filterEqual:
+		if err != nil || len(children) != 2 {
+			return false
+		}
+		for _, v := range values(e, string(children[0].content)) {
+			if strings.EqualFold(v, string(children[1].content)) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return strings.EqualFold(string(filter.content), "objectClass") || len(values(e, string(filter.content))) > 0
+	}
+	return false
+}
+
+// values returns the values of an attribute of e.
+func values(e Entry, attr string) []string {
+	for name, v := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return v
+		}
+	}
+	return nil
+}
+
+// ldapResult encodes an LDAPResult with the given protocol operation tag.
+func ldapResult(tag byte, code int, message string) []byte {
+	return encode(tag, encode(tagEnumerated, []byte{byte(code)}), encode(tagOctetString, nil), encode(tagOctetString, []byte(message)))
+}
+
+func equalDN(a, b string) bool {
+	return normalizeDN(a) == normalizeDN(b)
+}
+
+// normalizeDN lowercases a DN and removes the spaces around its
+// separators. Unlike ldap.EqualDN it ignores escaping, which test entries
+// don't need.
+func normalizeDN(dn string) string {
+	var parts []string
+	for _, rdn := range strings.Split(strings.ToLower(dn), ",") {
+		k, v, _ := strings.Cut(rdn, "=")
+		parts = append(parts, strings.TrimSpace(k)+"="+strings.TrimSpace(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// element is a decoded BER element.
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// encode returns an element with the given tag, holding the concatenated
+// contents.
+func encode(tag byte, contents ...[]byte) []byte {
+	var content []byte
+	for _, c := range contents {
+		content = append(content, c...)
+	}
+	b := []byte{tag}
+	if n := len(content); n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		b = append(append(b, 0x80|byte(len(length))), length...)
+	}
+	return append(b, content...)
+}
+
+// readElement reads one element from r.
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return element{}, err
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, content: content}, nil
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	size := int(first & 0x7f)
+	if size == 0 || size > 3 {
+		return 0, errors.New("ldaptest: unsupported length")
+	}
+	n := 0
+	for range size {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
+
+// children decodes the elements inside a constructed element.
+func (e element) children() ([]element, error) {
+	var elems []element
+	r := bufio.NewReader(strings.NewReader(string(e.content)))
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return elems, nil
+		}
+		c, err := readElement(r)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, c)
+	}
+}
+
+// int decodes a small non-negative INTEGER or ENUMERATED.
+func (e element) int() (int64, error) {
+	if len(e.content) == 0 || len(e.content) > 4 {
+		return 0, errors.New("ldaptest: invalid integer")
+	}
+	var v int64
+	for _, b := range e.content {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
@@ -0,0 +1,116 @@
+// Package lifecycle runs background workers and stops them in order on shutdown.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrWorkersRunning is returned by Shutdown when its context expires before
+// the workers have returned. Resources the workers use must then be left
+// open.
+var ErrWorkersRunning = errors.New("workers still running")
+
+// Manager owns the background workers of the application.
+//
+// Workers started with Go or Every receive a context that is cancelled when
+// Shutdown is called. Shutdown waits for them to return, then runs the hooks
+// registered with OnStop in reverse order. Resources the workers depend on,
+// such as the database, should be closed after Shutdown returns.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	hooks []hook
+}
+
+// hook is a named function run during shutdown.
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// New creates a new Manager.
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a new goroutine until it returns or Shutdown is called.
+// fn must return promptly once its context is cancelled.
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := fn(m.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("worker %s: %v", name, err)
+		}
+	}()
+}
+
+// Every runs fn every interval until Shutdown is called.
+// Errors are logged and do not stop the schedule.
+func (m *Manager) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	m.Go(name, func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if err := fn(ctx); err != nil {
+					log.Printf("worker %s: %v", name, err)
+				}
+			}
+		}
+	})
+}
+
+// OnStop registers fn to be called by Shutdown after the workers have returned.
+// Hooks run in reverse order of registration.
+func (m *Manager) OnStop(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Shutdown cancels the workers, waits for them to return and runs the stop hooks.
+// If ctx expires first, Shutdown stops waiting and returns an error wrapping
+// both ErrWorkersRunning and ctx's error.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.cancel()
+
+	// Wait for the workers in a goroutine so the wait can be abandoned.
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrWorkersRunning, ctx.Err())
+	}
+
+	m.mu.Lock()
+	hooks := m.hooks
+	m.hooks = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
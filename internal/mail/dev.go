@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// FileMailer writes each email to a .eml file in a directory instead of
+// sending it. It is meant for development and tests.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer creates a new FileMailer.
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+// Send implements Mailer.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(m.dir, time.Now().UTC().Format("20060102T150405Z")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// LogMailer writes emails to the log instead of sending them. The log then
+// contains password reset links, so it must not be used in production.
+type LogMailer struct {
+	from string
+}
+
+// NewLogMailer creates a new LogMailer.
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+// Send implements Mailer.
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s from %s: %s\n%s", msg.To, m.from, msg.Subject, msg.Body)
+	return nil
+}
+
+// Make sure the mailers satisfy the interface.
+var (
+	_ Mailer = (*SMTPMailer)(nil)
+	_ Mailer = (*FileMailer)(nil)
+	_ Mailer = (*LogMailer)(nil)
+)
@@ -0,0 +1,232 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	To:      "ann@example.com",
+	Subject: "Réinitialiser le mot de passe",
+	Body:    "Hello Ann,\n\nOpen https://lms.example.com/reset-password?token=abc=def to continue.\n",
+}
+
+// parse reads back a message written by format.
+func parse(t *testing.T, data []byte) (*mail.Message, string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ReadMessage leaves the body quoted-printable.
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, string(body)
+}
+
+func TestFormat(t *testing.T) {
+	data, err := format("LMS <lms@example.com>", testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, body := parse(t, data)
+
+	if got := msg.Header.Get("To"); got != "ann@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := msg.Header.Get("From"); got != `"LMS" <lms@example.com>` {
+		t.Errorf("From = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != testMessage.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, testMessage.Subject)
+	}
+	if got := msg.Header.Get("Message-ID"); !strings.HasSuffix(got, "@example.com>") {
+		t.Errorf("Message-ID = %q", got)
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Line breaks are sent as CRLF, as SMTP requires.
+	if want := strings.ReplaceAll(testMessage.Body, "\n", "\r\n"); string(decoded) != want {
+		t.Errorf("body = %q, want %q", decoded, want)
+	}
+}
+
+func TestFormatRejectsBadAddresses(t *testing.T) {
+	for _, to := range []string{"", "not an address", "ann@example.com\r\nBcc: eve@example.com"} {
+		msg := testMessage
+		msg.To = to
+		if _, err := format("lms@example.com", msg); err == nil {
+			t.Errorf("format accepted recipient %q", to)
+		}
+	}
+	if _, err := format("", testMessage); err == nil {
+		t.Error("format accepted an empty sender")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFileMailer(dir, "lms@example.com")
+	for range 2 {
+		if err := m.Send(context.Background(), testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("wrote %d files, want 2", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := parse(t, data); msg.Header.Get("To") != testMessage.To {
+		t.Errorf("To = %q", msg.Header.Get("To"))
+	}
+}
+
+// smtpServer is a minimal SMTP server that accepts one message per
+// connection and sends what it received on its channel.
+type smtpServer struct {
+	ln       net.Listener
+	received chan smtpMessage
+}
+
+type smtpMessage struct {
+	from, to string
+	data     string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpServer{ln: ln, received: make(chan smtpMessage, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var msg smtpMessage
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		switch strings.ToUpper(strings.Fields(cmd + " ")[0]) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg.from = cmd[len("MAIL FROM:"):]
+			reply("250 OK")
+		case "RCPT":
+			msg.to = cmd[len("RCPT TO:"):]
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msg.data = data.String()
+			s.received <- msg
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	s := newSMTPServer(t)
+	m := NewSMTPMailer(s.ln.Addr().String(), "", "", "LMS <lms@example.com>")
+	if err := m.Send(context.Background(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-s.received
+	if got.from != "<lms@example.com>" || got.to != "<ann@example.com>" {
+		t.Errorf("envelope from %s to %s", got.from, got.to)
+	}
+	if msg, _ := parse(t, []byte(got.data)); msg.Header.Get("To") != testMessage.To {
+		t.Errorf("To = %q", msg.Header.Get("To"))
+	}
+}
+
+func TestSMTPMailerTimesOut(t *testing.T) {
+	// A server that accepts the connection but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	m := NewSMTPMailer(ln.Addr().String(), "", "", "lms@example.com")
+	err = m.Send(ctx, testMessage)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Send returned %v, want a timeout", err)
+	}
+}
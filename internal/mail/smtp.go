@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"time"
+)
+
+// Timeouts for sending a message when the caller's context has no deadline,
+// so that an unresponsive server can't hold up the sender forever.
+const (
+	dialTimeout = 10 * time.Second
+	sendTimeout = time.Minute
+)
+
+// SMTPMailer sends email through an SMTP server. It uses STARTTLS when the
+// server offers it, and authenticates when a username is set.
+type SMTPMailer struct {
+	addr     string // host:port
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a new SMTPMailer.
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{addr: addr, username: username, password: password, from: from}
+}
+
+// Send implements Mailer.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return err
+	}
+
+	// Dial ourselves so that ctx, or the default timeouts, bound the whole
+	// conversation.
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sendTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection, except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, host)); err != nil {
+			return err
+		}
+	}
+
+	// format has already checked both addresses.
+	from, _ := netmail.ParseAddress(m.from)
+	to, _ := netmail.ParseAddress(msg.To)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// APITokenPrefix starts every API token, so that they are easy to recognise,
+// for example by secret scanners.
+const APITokenPrefix = "lms_"
+
+// apiTokenUseInterval is how often the last use of a token is recorded, so
+// that scripts making many requests don't cause a write for each of them.
+const apiTokenUseInterval = time.Minute
+
+// LoadSession loads the session of the request and saves it afterwards,
+// like the session manager's LoadAndSave.
+//
+// Requests with an API token in an "Authorization: Bearer" header get a
+// session of their own instead, which holds the token's user as if they had
+// logged in, and which is neither saved nor sent back as a cookie. Such
+// requests aren't exposed to cross-site request forgery, since browsers
+// don't add the header by themselves, so VerifyCSRF lets them through.
+// Invalid, expired and revoked tokens are refused rather than ignored.
+func (m *Middleware) LoadSession(next http.Handler) http.Handler {
+	loadAndSave := m.SessionManager.LoadAndSave(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := bearerToken(r)
+		if !ok || m.APITokens == nil {
+			loadAndSave.ServeHTTP(w, r)
+			return
+		}
+
+		apiToken, user, err := m.authenticateToken(r, secret)
+		if err != nil {
+			log.Printf("Failed to authenticate an API token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if apiToken == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+			return
+		}
+
+		// Requests that only read need the read scope, and all others the
+		// write scope.
+		scope := models.ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = models.ScopeRead
+		}
+		if !apiToken.HasScope(scope) {
+			insufficientScope(w, scope)
+			return
+		}
+
+		// A session that is never saved. It has no token, so it can't be
+		// loaded by a later request either.
+		ctx, err := m.SessionManager.Load(r.Context(), "")
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		m.SessionManager.Put(ctx, "authenticatedUserID", user.ID)
+		m.SessionManager.Put(ctx, "userRole", user.Role)
+		m.SessionManager.Put(ctx, "apiTokenID", apiToken.ID)
+		m.SessionManager.Put(ctx, "apiTokenScopes", strings.Join(apiToken.Scopes, " "))
+		// The token passed two-factor authentication if the session it
+		// was made in had.
+		m.SessionManager.Put(ctx, "twoFactorVerified", apiToken.TwoFactorVerified)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateToken returns the API token with the given secret and its
+// user, or nil if the token is unknown, has expired or belongs to a
+// deactivated user.
+func (m *Middleware) authenticateToken(r *http.Request, secret string) (*models.APIToken, *models.User, error) {
+	if !strings.HasPrefix(secret, APITokenPrefix) {
+		return nil, nil, nil
+	}
+
+	apiToken, err := m.APITokens.GetAPITokenByHash(r.Context(), token.Hash(secret))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	if !now.Before(apiToken.ExpiresAt) {
+		return nil, nil, nil
+	}
+
+	user, err := m.Users.GetUserByID(r.Context(), apiToken.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.Active() {
+		return nil, nil, nil
+	}
+
+	if now.Sub(apiToken.LastUsedAt) >= apiTokenUseInterval {
+		if err := m.APITokens.UseAPIToken(r.Context(), apiToken.ID, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return apiToken, user, nil
+}
+
+// RequireSession keeps requests authenticated with an API token out of the
+// pages that manage logins, such as the API tokens themselves, so that a
+// leaked token can't be used to make more or to take over the account.
+// Admins impersonating a user are kept out too: the logins are the user's.
+func (m *Middleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.SessionManager.GetInt64(r.Context(), "apiTokenID") != 0 {
+			http.Error(w, "This page can't be used with an API token", http.StatusForbidden)
+			return
+		}
+		if m.SessionManager.GetInt64(r.Context(), "impersonatorID") != 0 {
+			http.Error(w, "This page can't be used while you impersonate a user", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenAllows reports whether the API token the request is authenticated
+// with has the scope. Requests authenticated with a session cookie are
+// allowed everything.
+func tokenAllows(sessionManager *scs.SessionManager, r *http.Request, scope string) bool {
+	if sessionManager.GetInt64(r.Context(), "apiTokenID") == 0 {
+		return true
+	}
+	scopes := strings.Fields(sessionManager.GetString(r.Context(), "apiTokenScopes"))
+	return slices.Contains(scopes, scope)
+}
+
+// insufficientScope refuses a request whose API token lacks scope.
+func insufficientScope(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+	http.Error(w, fmt.Sprintf("The API token needs the %s scope", scope), http.StatusForbidden)
+}
+
+// bearerToken returns the token in the Authorization header of the request.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	secret = strings.TrimSpace(secret)
+	return secret, secret != ""
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import "net/http"
+
+// BlockImpersonatedWrites refuses the requests that would change anything
+// while an admin impersonates a user with changes blocked. Ending the
+// impersonation and logging out are still allowed.
+func (m *Middleware) BlockImpersonatedWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/impersonation/stop" || r.URL.Path == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.SessionManager.GetBool(r.Context(), "impersonationReadOnly") {
+			http.Error(w, "Changes are blocked while you impersonate this user", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,6 +1,11 @@
 package middleware
 
 import (
+	"database/sql"
+	"errors"
+	"lms/internal/database"
+	"lms/internal/models"
+	"log"
 	"net/http"
 
 	"github.com/alexedwards/scs/v2"
@@ -9,6 +14,19 @@ import (
 // Middleware struct holds dependencies for middleware.
 type Middleware struct {
 	SessionManager *scs.SessionManager
+	// RequireAdmin2FA keeps admins out of the admin pages until their
+	// session has passed two-factor authentication.
+	RequireAdmin2FA bool
+	// APITokens and Users authenticate the requests of scripts that send
+	// an API token. A nil APITokens disables API tokens.
+	APITokens database.APITokenStore
+	Users     database.UserStore
+	// Courses and Staff decide who may manage each course.
+	Courses database.CourseStore
+	Staff   database.CourseStaffStore
+	// UserSessions records where users are signed in. A nil UserSessions
+	// disables session tracking.
+	UserSessions database.UserSessionStore
 }
 
 // NewMiddleware creates a new Middleware struct.
@@ -19,6 +37,10 @@ func NewMiddleware(sessionManager *scs.SessionManager) *Middleware {
 }
 
 // RequireAuthentication is a middleware that requires a user to be authenticated.
+//
+// The user is looked up on every request, so that users who have been
+// deactivated or deleted are logged out at once, and role changes take
+// effect without logging in again.
 func (m *Middleware) RequireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if the user is authenticated by looking for a user ID in the session.
@@ -29,6 +51,26 @@ func (m *Middleware) RequireAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
+		if m.Users != nil {
+			user, err := m.Users.GetUserByID(r.Context(), userID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to look up user %d: %v", userID, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if user == nil || !user.Active() {
+				if err := m.SessionManager.Destroy(r.Context()); err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			if user.Role != m.SessionManager.GetString(r.Context(), "userRole") {
+				m.SessionManager.Put(r.Context(), "userRole", user.Role)
+			}
+		}
+
 		// If the user is authenticated, call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
@@ -46,7 +88,35 @@ func (m *Middleware) RequireAdmin(next http.Handler) http.Handler {
 			return
 		}
 
+		// Requests with an API token also need the admin scope.
+		if !tokenAllows(m.SessionManager, r, models.ScopeAdmin) {
+			insufficientScope(w, models.ScopeAdmin)
+			return
+		}
+
+		if !m.checkAdmin2FA(w, r) {
+			return
+		}
+
 		// If the user is an admin, call the next handler.
 		next.ServeHTTP(w, r)
 	})
 }
+
+// checkAdmin2FA sends admins who haven't used a second factor to set one up,
+// if the admin pages require it, and reports whether they may go on. The
+// two-factor page asks for a code instead if they already have one.
+func (m *Middleware) checkAdmin2FA(w http.ResponseWriter, r *http.Request) bool {
+	if m.RequireAdmin2FA && !m.SessionManager.GetBool(r.Context(), "twoFactorVerified") {
+		// Scripts can't set up a second factor; their admin has to make a
+		// new token after using one.
+		if m.SessionManager.GetInt64(r.Context(), "apiTokenID") != 0 {
+			http.Error(w, "The admin pages require an API token made after two-factor authentication", http.StatusForbidden)
+			return false
+		}
+		m.SessionManager.Put(r.Context(), "flash", "The admin pages require two-factor authentication.")
+		http.Redirect(w, r, "/profile/two-factor", http.StatusSeeOther)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"lms/internal/models"
+	"lms/internal/rbac"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+)
+
+// Role returns the role the request acts with. It is the role of the user,
+// except that admins using an API token without the admin scope act as
+// students.
+func Role(sessionManager *scs.SessionManager, r *http.Request) string {
+	role := sessionManager.GetString(r.Context(), "userRole")
+	if role == rbac.RoleAdmin && !tokenAllows(sessionManager, r, models.ScopeAdmin) {
+		return rbac.RoleStudent
+	}
+	return role
+}
+
+// RequirePermission is a middleware that requires the user's role to have a
+// permission that isn't tied to a course, such as creating courses.
+// It should be used after RequireAuthentication.
+func (m *Middleware) RequirePermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := Role(m.SessionManager, r)
+			if !rbac.RoleCan(role, perm) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if role == rbac.RoleAdmin && !m.checkAdmin2FA(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireCoursePermission is a middleware that requires the user to have a
+// permission in the course of the request, either as a member of its staff
+// or through their role. The course is given by the courseID URL parameter,
+// or by the lessonID parameter for the pages of a lesson.
+// It should be used after RequireAuthentication.
+func (m *Middleware) RequireCoursePermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			courseID, status := m.courseOf(r)
+			if status != 0 {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+
+			// Staff have their permissions in their own courses.
+			userID := m.SessionManager.GetInt64(r.Context(), "authenticatedUserID")
+			staffRole, err := rbac.StaffRole(r.Context(), m.Staff, courseID, userID)
+			if err != nil {
+				log.Printf("Failed to look up the staff role of user %d: %v", userID, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if rbac.StaffCan(staffRole, perm) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Admins have them in every course.
+			role := Role(m.SessionManager, r)
+			if !rbac.RoleCan(role, perm) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if role == rbac.RoleAdmin && !m.checkAdmin2FA(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// courseOf returns the ID of the course the request is about, or the status
+// to respond with if there is none.
+func (m *Middleware) courseOf(r *http.Request) (int64, int) {
+	if param := chi.URLParam(r, "courseID"); param != "" {
+		courseID, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return 0, http.StatusBadRequest
+		}
+		return courseID, 0
+	}
+
+	lessonID, err := strconv.ParseInt(chi.URLParam(r, "lessonID"), 10, 64)
+	if err != nil {
+		return 0, http.StatusBadRequest
+	}
+	lesson, err := m.Courses.GetLesson(r.Context(), lessonID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, http.StatusNotFound
+	}
+	if err != nil {
+		log.Printf("Failed to look up lesson %d: %v", lessonID, err)
+		return 0, http.StatusInternalServerError
+	}
+	return lesson.CourseID, 0
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"lms/internal/models"
+	"lms/internal/token"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// sessionSeenInterval is how often the last activity of a session is
+// recorded, so that browsing doesn't write to the database on every request.
+const sessionSeenInterval = time.Minute
+
+// maxUserAgentLength keeps clients from storing large headers.
+const maxUserAgentLength = 256
+
+// TrackSession records where and when each logged-in session is used in
+// the user_sessions table, so that users can see where they are signed in
+// and sign out remotely.
+//
+// Logging in creates the row, and puts its random ID in the "sessionID" of
+// the session, which identifies the row without revealing the session
+// token. Sessions that were logged in before rows were kept get one on
+// their next request. The browser's address and user agent are updated when
+// they change, and the last activity at most every sessionSeenInterval. A
+// session whose row has been deleted has been signed out: it is destroyed,
+// and the request goes on without a user.
+// It must be used after LoadSession.
+func (m *Middleware) TrackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := m.SessionManager.GetInt64(ctx, "authenticatedUserID")
+
+		// Sessions of API tokens aren't saved; see LoadSession.
+		if m.UserSessions == nil || userID == 0 || m.SessionManager.GetInt64(ctx, "apiTokenID") != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := m.SessionManager.GetString(ctx, "sessionID")
+		if id == "" {
+			session := NewUserSession(r, userID, m.SessionManager.GetInt64(ctx, "impersonatorID"))
+			if err := m.UserSessions.CreateUserSession(ctx, session); err != nil {
+				log.Printf("Failed to record session of user %d: %v", userID, err)
+			} else {
+				m.SessionManager.Put(ctx, "sessionID", session.ID)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := m.UserSessions.GetUserSession(ctx, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up session of user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if session == nil || session.UserID != userID {
+			if err := m.SessionManager.Destroy(ctx); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now().UTC()
+		userAgent, ip := requestClient(r)
+		if session.UserAgent != userAgent || session.IP != ip || now.Sub(session.LastSeenAt) >= sessionSeenInterval {
+			err := m.UserSessions.TouchUserSession(ctx, id, userAgent, ip, now)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to record activity of session of user %d: %v", userID, err)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewUserSession returns the record of a session of userID, with a new
+// random ID, that starts with request r. impersonatorID is the admin
+// impersonating the user, if any.
+func NewUserSession(r *http.Request, userID, impersonatorID int64) *models.UserSession {
+	now := time.Now().UTC()
+	userAgent, ip := requestClient(r)
+	return &models.UserSession{
+		ID:             token.New(),
+		UserID:         userID,
+		UserAgent:      userAgent,
+		IP:             ip,
+		ImpersonatorID: impersonatorID,
+		SignedInAt:     now,
+		LastSeenAt:     now,
+	}
+}
+
+// requestClient returns the user agent, shortened if need be, and the
+// address of the client that sent r.
+func requestClient(r *http.Request) (userAgent, ip string) {
+	userAgent = r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent, clientIP(r)
+}
+
+// clientIP returns the address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// The RealIP middleware sets RemoteAddr to a bare address.
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -24,4 +24,16 @@ type Certificate struct {
 	CourseID  int64
 	Token     string
 	IssuedAt  time.Time
+	// StudentName is the name of the user when the certificate was issued.
+	StudentName string
+}
+
+// CourseStaff is a user who helps run a course, as one of its instructors
+// or teaching assistants.
+type CourseStaff struct {
+	CourseID  int64
+	UserID    int64
+	Username  string
+	Role      string // "instructor" or "ta"
+	CreatedAt time.Time
 }
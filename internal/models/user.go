@@ -1,9 +1,235 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 // User represents a user in the database.
 type User struct {
 	ID           int64
 	Username     string
 	PasswordHash string
-	Role         string // "student" or "admin"
+	Role         string // "student", "instructor" or "admin"
+	Email        string // Empty if the user has none.
+	// EmailVerifiedAt is when the user proved they own Email. Zero if they
+	// haven't, and reset whenever the address changes.
+	EmailVerifiedAt time.Time
+	FullName        string // Legal name, printed on certificates.
+	DisplayName     string // Name shown in the interface.
+	Timezone        string // IANA name such as "Europe/Paris".
+	// DeactivatedAt is when the user was barred from logging in. Zero for
+	// active users.
+	DeactivatedAt time.Time
+	// AwaitingVerification is set on users who registered with an address
+	// at an allowed domain, until they verify it.
+	AwaitingVerification bool
+}
+
+// Active reports whether the user may log in.
+func (u *User) Active() bool {
+	return u.DeactivatedAt.IsZero() && !u.AwaitingVerification
+}
+
+// Name returns the name to greet the user by.
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
+
+// CertificateName returns the name to print on the user's certificates.
+func (u *User) CertificateName() string {
+	if u.FullName != "" {
+		return u.FullName
+	}
+	return u.Username
+}
+
+// Group is a named set of users, such as a team or a cohort, which are
+// enrolled in the group's courses.
+type Group struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// PasswordReset is a request to reset a user's password. The token sent to
+// the user is not stored, only its hash.
+type PasswordReset struct {
+	ID        int64
+	UserID    int64
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time // Zero until the token has been used.
+}
+
+// EmailVerification is a link sent to a user to prove they own an email
+// address. Only the hash of its token is stored.
+type EmailVerification struct {
+	ID        int64
+	UserID    int64
+	Email     string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// TOTP is the authenticator app secret of a user with two-factor
+// authentication. It is stored in clear because it is needed to check codes.
+type TOTP struct {
+	UserID      int64
+	Secret      string // Base32, as shown to the user.
+	CreatedAt   time.Time
+	ConfirmedAt time.Time // Zero while the user hasn't entered a code yet.
+	// LastUsedStep is the period of the last code accepted, so that each
+	// code works only once.
+	LastUsedStep int64
+}
+
+// Enabled reports whether the secret has been confirmed and is required at login.
+func (t *TOTP) Enabled() bool {
+	return !t.ConfirmedAt.IsZero()
+}
+
+// Passkey is a WebAuthn credential a user can log in with instead of
+// their password.
+type Passkey struct {
+	ID           int64
+	UserID       int64
+	CredentialID []byte
+	PublicKey    []byte // COSE key.
+	SignCount    uint32
+	Name         string // Chosen by the user, e.g. "Work laptop".
+	CreatedAt    time.Time
+	LastUsedAt   time.Time // Zero if it has never been used to log in.
+}
+
+// UserIdentity links a user to their account at an external identity
+// provider, so they can log in there instead of with a password.
+type UserIdentity struct {
+	ID          int64
+	UserID      int64
+	Issuer      string // The provider, e.g. "https://login.example.com".
+	Subject     string // The provider's ID for the account.
+	Login       string // The account's username at the provider, for display.
+	CreatedAt   time.Time
+	LastLoginAt time.Time // Zero if it has never been used to log in.
+}
+
+// FromDirectory reports whether the identity links the user to an LDAP
+// directory entry, which the user's password is checked against, rather
+// than to an account they log in with elsewhere.
+func (i *UserIdentity) FromDirectory() bool {
+	return strings.HasPrefix(i.Issuer, "ldap://") || strings.HasPrefix(i.Issuer, "ldaps://")
+}
+
+// APIToken is a personal access token a user's scripts and integrations
+// send in an "Authorization: Bearer" header instead of logging in. Only the
+// hash of the token is stored.
+type APIToken struct {
+	ID                int64
+	UserID            int64
+	Name              string // Chosen by the user, e.g. "Grade export".
+	TokenHash         string
+	Scopes            []string // What the token may be used for; see ScopeRead.
+	TwoFactorVerified bool     // Made in a session that had passed two-factor authentication.
+	CreatedAt         time.Time
+	ExpiresAt         time.Time
+	LastUsedAt        time.Time // Zero if it has never been used.
+}
+
+// The scopes of API tokens.
+const (
+	ScopeRead  = "read"  // GET and HEAD requests.
+	ScopeWrite = "write" // Other requests, such as submitting answers.
+	ScopeAdmin = "admin" // The admin pages, for admins only.
+)
+
+// HasScope reports whether the token may be used for scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSession is a logged-in browser session of a user, as recorded by the
+// TrackSession middleware.
+type UserSession struct {
+	ID               string // Random, and kept in the session; not its token.
+	UserID           int64
+	UserAgent        string
+	IP               string // Of the last request.
+	ImpersonatorID   int64  // The admin using the session; zero if none.
+	ImpersonatorName string
+	SignedInAt       time.Time
+	LastSeenAt       time.Time
+}
+
+// LoginThrottle counts the recent failed logins for a username or a client IP.
+type LoginThrottle struct {
+	Key           string // "user:<username>" or "ip:<address>"
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time // Zero if logins have never been locked.
+}
+
+// AuditEntry records something an admin has done, such as impersonating a
+// user.
+type AuditEntry struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    int64 // Zero once the actor has been deleted.
+	ActorName  string
+	Action     string // See AuditImpersonationStart.
+	TargetID   int64  // The user acted on; zero if none or deleted.
+	TargetName string
+	Details    string
+}
+
+// The actions recorded in the audit log.
+const (
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationStop  = "impersonation.stop"
+)
+
+// Invitation lets someone register with a role chosen by an admin, and
+// enrolls them in the chosen courses.
+type Invitation struct {
+	ID            int64
+	TokenHash     string
+	Email         string // Where the link was sent; empty if it was handed out.
+	Role          string
+	CourseIDs     []int64
+	CreatedBy     int64 // Zero once the admin has been deleted.
+	CreatedByName string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	AcceptedAt    time.Time // Zero until someone registers with it.
+	UserID        int64     // The user who registered; zero if deleted.
+	Username      string
+}
+
+// The statuses of invitations.
+const (
+	InvitationSent     = "sent"
+	InvitationAccepted = "accepted"
+	InvitationExpired  = "expired"
+)
+
+// Status returns whether the invitation is still waiting to be accepted,
+// has been accepted or has expired, as of now.
+func (i *Invitation) Status(now time.Time) string {
+	switch {
+	case !i.AcceptedAt.IsZero():
+		return InvitationAccepted
+	case !now.Before(i.ExpiresAt):
+		return InvitationExpired
+	}
+	return InvitationSent
 }
@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// leeway allows for the clocks of the provider and the server to differ.
+const leeway = time.Minute
+
+// keysMinRefresh limits how often the signing keys are fetched again for
+// tokens signed with a key we don't know, which happens after a rotation.
+const keysMinRefresh = time.Minute
+
+// keySet holds the signing keys of the provider.
+type keySet struct {
+	keys    []signingKey
+	fetched time.Time
+}
+
+type signingKey struct {
+	id  string // "kid", may be empty.
+	key crypto.PublicKey
+}
+
+// find returns the key with the given ID, or the only key if the token
+// doesn't name one.
+func (ks *keySet) find(kid string) crypto.PublicKey {
+	for _, k := range ks.keys {
+		if k.id == kid || (kid == "" && len(ks.keys) == 1) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// jsonWebKey is a key of the provider's JWK set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or P-256 key of k. Other keys return nil, so
+// that a provider publishing keys we don't use still works.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("oidc: invalid RSA key %q", k.Kid)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 || key.E%2 == 0 {
+			return nil, fmt.Errorf("oidc: weak RSA key %q", k.Kid)
+		}
+		return key, nil
+
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("oidc: invalid P-256 key %q", k.Kid)
+		}
+		// NewPublicKey checks that the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("oidc: invalid P-256 key %q: %v", k.Kid, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, nil
+}
+
+// signingKey returns the key with the given ID, fetching the keys again if
+// it isn't known.
+func (p *Provider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.keys.find(kid); key != nil && time.Since(p.keys.fetched) < metadataTTL {
+		return key, nil
+	}
+	if time.Since(p.keys.fetched) < keysMinRefresh {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("oidc: failed to fetch signing keys: %w", err)
+	}
+	ks := keySet{fetched: time.Now()}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			ks.keys = append(ks.keys, signingKey{id: k.Kid, key: key})
+		}
+	}
+	p.keys = ks
+
+	if key := p.keys.find(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// verifyIDToken checks the signature and claims of an ID token (a JWT)
+// and returns its claims.
+func (p *Provider) verifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: ID token is not a JWT")
+	}
+	header, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	payload, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("oidc: invalid ID token: %w", err)
+	}
+
+	var h struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, fmt.Errorf("oidc: invalid ID token header: %w", err)
+	}
+	key, err := p.signingKey(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("oidc: invalid ID token claims: %w", err)
+	}
+
+	// The token must be for us, from our provider, and made for this login.
+	now := time.Now()
+	exp, _ := c["exp"].(float64)
+	aud := c.Strings("aud")
+	switch {
+	case strings.TrimSuffix(c.String("iss"), "/") != p.Issuer:
+		return nil, fmt.Errorf("oidc: ID token is from issuer %q", c.String("iss"))
+	case !slices.Contains(aud, p.ClientID):
+		return nil, errors.New("oidc: ID token is for another client")
+	case len(aud) > 1 && c.String("azp") != p.ClientID:
+		return nil, errors.New("oidc: ID token is for another party")
+	case now.After(time.Unix(int64(exp), 0).Add(leeway)):
+		return nil, errors.New("oidc: ID token has expired")
+	case nonce == "" || c.String("nonce") != nonce:
+		return nil, errors.New("oidc: wrong nonce in ID token")
+	case c.String("sub") == "":
+		return nil, errors.New("oidc: ID token has no subject")
+	}
+	return c, nil
+}
+
+// verifySignature checks a JWS signature made with the given algorithm.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	sum := sha256.Sum256(signed)
+	ok := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256":
+			ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+		case "PS256":
+			ok = rsa.VerifyPSS(k, crypto.SHA256, sum[:], sig, nil) == nil
+		default:
+			return fmt.Errorf("oidc: algorithm %q does not match an RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			return fmt.Errorf("oidc: algorithm %q does not match a P-256 key", alg)
+		}
+		// JWS signatures are r and s side by side, not ASN.1.
+		if len(sig) == 64 {
+			ok = ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+		}
+	}
+	if !ok {
+		return errors.New("oidc: invalid ID token signature")
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is a provider serving its discovery document and signing keys.
+type testIssuer struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.server.URL,
+			"authorization_endpoint": iss.server.URL + "/authorize",
+			"token_endpoint":         iss.server.URL + "/token",
+			"jwks_uri":               iss.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{
+			{
+				Kty: "RSA", Kid: "rsa", Use: "sig",
+				N: b64(rsaKey.N.Bytes()),
+				E: b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				Kty: "EC", Kid: "ec", Crv: "P-256",
+				X: b64(ecKey.X.FillBytes(make([]byte, 32))),
+				Y: b64(ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+			// Keys for encryption and of other types are ignored.
+			{Kty: "RSA", Kid: "enc", Use: "enc"},
+			{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: "AAAA"},
+		}})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a JWT with the header and claims, signed with the key
+// matching alg. Unknown algorithms get a garbage signature.
+func (iss *testIssuer) sign(t *testing.T, header map[string]any, claims map[string]any) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(h) + "." + b64(c)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch header["alg"] {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, sum[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, iss.rsaKey, crypto.SHA256, sum[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ecKey, sum[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		sig = []byte("not a signature")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now().Unix()
+
+	// claims returns valid claims with the changes applied; nil values
+	// delete a claim.
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":   iss.server.URL,
+			"aud":   "lms",
+			"sub":   "user-1",
+			"exp":   now + 300,
+			"iat":   now,
+			"nonce": "n0nce",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	rs256 := map[string]any{"alg": "RS256", "kid": "rsa"}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr string // Empty if the token is valid.
+	}{
+		{
+			name:  "RS256",
+			token: func() string { return iss.sign(t, rs256, claims(nil)) },
+		},
+		{
+			name:  "PS256",
+			token: func() string { return iss.sign(t, map[string]any{"alg": "PS256", "kid": "rsa"}, claims(nil)) },
+		},
+		{
+			name:  "ES256",
+			token: func() string { return iss.sign(t, map[string]any{"alg": "ES256", "kid": "ec"}, claims(nil)) },
+		},
+		{
+			name: "audience list with azp",
+			token: func() string {
+				return iss.sign(t, rs256, claims(map[string]any{"aud": []string{"lms", "api"}, "azp": "lms"}))
+			},
+		},
+		{
+			name:  "expired within the leeway",
+			token: func() string { return iss.sign(t, rs256, claims(map[string]any{"exp": now - 30})) },
+		},
+		{
+			name:    "not a JWT",
+			token:   func() string { return "abc.def" },
+			wantErr: "not a JWT",
+		},
+		{
+			name:    "invalid base64",
+			token:   func() string { return "a.b.c!" },
+			wantErr: "invalid ID token",
+		},
+		{
+			name:    "alg none",
+			token:   func() string { return iss.sign(t, map[string]any{"alg": "none", "kid": "rsa"}, claims(nil)) },
+			wantErr: "does not match an RSA key",
+		},
+		{
+			name:    "HMAC with the public key",
+			token:   func() string { return iss.sign(t, map[string]any{"alg": "HS256", "kid": "rsa"}, claims(nil)) },
+			wantErr: "does not match an RSA key",
+		},
+		{
+			name:    "RSA algorithm with an EC key",
+			token:   func() string { return iss.sign(t, map[string]any{"alg": "RS256", "kid": "ec"}, claims(nil)) },
+			wantErr: "does not match a P-256 key",
+		},
+		{
+			name: "tampered claims",
+			token: func() string {
+				parts := strings.Split(iss.sign(t, rs256, claims(nil)), ".")
+				forged, _ := json.Marshal(claims(map[string]any{"sub": "admin"}))
+				return parts[0] + "." + b64(forged) + "." + parts[2]
+			},
+			wantErr: "invalid ID token signature",
+		},
+		{
+			name:    "unknown key",
+			token:   func() string { return iss.sign(t, map[string]any{"alg": "RS256", "kid": "other"}, claims(nil)) },
+			wantErr: "unknown signing key",
+		},
+		{
+			name:    "encryption key",
+			token:   func() string { return iss.sign(t, map[string]any{"alg": "RS256", "kid": "enc"}, claims(nil)) },
+			wantErr: "unknown signing key",
+		},
+		{
+			name:    "other issuer",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"iss": "https://evil.example"})) },
+			wantErr: "from issuer",
+		},
+		{
+			name:    "other audience",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"aud": "other"})) },
+			wantErr: "for another client",
+		},
+		{
+			name:    "audience list without azp",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"aud": []string{"lms", "api"}})) },
+			wantErr: "for another party",
+		},
+		{
+			name:    "expired",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"exp": now - 3600})) },
+			wantErr: "expired",
+		},
+		{
+			name:    "no expiry",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"exp": nil})) },
+			wantErr: "expired",
+		},
+		{
+			name:    "wrong nonce",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"nonce": "other"})) },
+			wantErr: "wrong nonce",
+		},
+		{
+			name:    "no nonce",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"nonce": nil})) },
+			wantErr: "wrong nonce",
+		},
+		{
+			name:    "no subject",
+			token:   func() string { return iss.sign(t, rs256, claims(map[string]any{"sub": nil})) },
+			wantErr: "no subject",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProvider(iss.server.URL, "lms", "", "http://lms.example/callback", nil)
+			c, err := p.verifyIDToken(context.Background(), tt.token(), "n0nce")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if c.String("sub") != "user-1" {
+					t.Errorf("got subject %q, want user-1", c.String("sub"))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRequiresNonce(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := NewProvider(iss.server.URL, "lms", "", "http://lms.example/callback", nil)
+	token := iss.sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, map[string]any{
+		"iss": iss.server.URL, "aud": "lms", "sub": "user-1", "exp": time.Now().Unix() + 300,
+	})
+	// A token without a nonce must not pass when the session has none either.
+	if _, err := p.verifyIDToken(context.Background(), token, ""); err == nil {
+		t.Fatal("accepted a token without a nonce")
+	}
+}
+
+func TestJSONWebKeyPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := b64(ecKey.X.FillBytes(make([]byte, 32)))
+	y := b64(ecKey.Y.FillBytes(make([]byte, 32)))
+
+	tests := []struct {
+		name    string
+		key     jsonWebKey
+		want    bool // Whether a key is returned.
+		wantErr bool
+	}{
+		{"RSA", jsonWebKey{Kty: "RSA", N: b64(rsaKey.N.Bytes()), E: "AQAB"}, true, false},
+		{"weak RSA", jsonWebKey{Kty: "RSA", N: b64(weak.N.Bytes()), E: "AQAB"}, false, true},
+		{"even RSA exponent", jsonWebKey{Kty: "RSA", N: b64(rsaKey.N.Bytes()), E: "Ag"}, false, true},
+		{"RSA without exponent", jsonWebKey{Kty: "RSA", N: b64(rsaKey.N.Bytes())}, false, true},
+		{"P-256", jsonWebKey{Kty: "EC", Crv: "P-256", X: x, Y: y}, true, false},
+		{"P-256 point off the curve", jsonWebKey{Kty: "EC", Crv: "P-256", X: x, Y: x}, false, true},
+		{"P-256 short coordinate", jsonWebKey{Kty: "EC", Crv: "P-256", X: x[:10], Y: y}, false, true},
+		{"P-384", jsonWebKey{Kty: "EC", Crv: "P-384", X: x, Y: y}, false, false},
+		{"symmetric", jsonWebKey{Kty: "oct"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.key.publicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if (key != nil) != tt.want {
+				t.Errorf("got key %v, want a key: %v", key, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaims(t *testing.T) {
+	var c Claims
+	if err := json.Unmarshal([]byte(`{
+		"name": "Ann",
+		"groups": ["staff", 3, "admins"],
+		"amr": "pwd",
+		"verified": true,
+		"verified_str": "true",
+		"count": 3
+	}`), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.String("name"); got != "Ann" {
+		t.Errorf("String(name) = %q", got)
+	}
+	if got := c.String("count"); got != "" {
+		t.Errorf("String(count) = %q, want empty", got)
+	}
+	for name, want := range map[string][]string{
+		"groups":  {"staff", "admins"},
+		"amr":     {"pwd"},
+		"missing": nil,
+		"count":   nil,
+	} {
+		if got := c.Strings(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Strings(%s) = %q, want %q", name, got, want)
+		}
+	}
+	for name, want := range map[string]bool{
+		"verified":     true,
+		"verified_str": true,
+		"name":         false,
+		"missing":      false,
+	} {
+		if got := c.Bool(name); got != want {
+			t.Errorf("Bool(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
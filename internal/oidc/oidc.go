@@ -0,0 +1,262 @@
+// Package oidc implements the relying party side of OpenID Connect login
+// with the authorization code flow and PKCE.
+//
+// The provider is configured by its issuer URL; its endpoints and signing
+// keys are discovered from /.well-known/openid-configuration when first
+// needed. ID tokens signed with RS256, PS256 or ES256 are accepted.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxResponseSize bounds the responses read from the provider.
+const maxResponseSize = 1 << 20
+
+// metadataTTL is how long the discovered endpoints are cached.
+const metadataTTL = 24 * time.Hour
+
+// Provider is an OpenID Connect provider the users log in with.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone.
+	RedirectURL  string // Where the provider sends the user back with a code.
+	Scopes       []string
+	Client       *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	metaTime time.Time
+	keys     keySet
+}
+
+// NewProvider creates a Provider. Nothing is fetched until the first login.
+func NewProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// metadata is the part of the discovery document that is used.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discover returns the endpoints of the provider, fetching them if needed.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil && time.Since(p.metaTime) < metadataTTL {
+		return p.meta, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", "", &m); err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+	// The issuer must be the one configured, or tokens from another
+	// provider could be passed off as ours.
+	if strings.TrimSuffix(m.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	p.meta = &m
+	p.metaTime = time.Now()
+	return p.meta, nil
+}
+
+// Challenge returns the PKCE code challenge (S256) of a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to for logging in. state,
+// nonce and verifier must be random, kept in the user's session and passed
+// to Exchange when the user comes back.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the code the provider sent back for the user's claims.
+// The ID token is verified against nonce, and the claims of the userinfo
+// endpoint are added to it when the provider has one.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// client_secret_basic, which providers must support.
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("oidc: invalid token response (%s): %w", resp.Status, err)
+	}
+	if tok.Error != "" {
+		return nil, fmt.Errorf("oidc: token request failed: %s %s", tok.Error, tok.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tok.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token request failed: %s, no ID token", resp.Status)
+	}
+
+	claims, err := p.verifyIDToken(ctx, tok.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some providers only put the profile and groups in the userinfo
+	// response. Its claims don't override the verified ones.
+	if m.UserinfoEndpoint != "" && tok.AccessToken != "" {
+		var info Claims
+		if err := p.getJSON(ctx, m.UserinfoEndpoint, tok.AccessToken, &info); err != nil {
+			return nil, fmt.Errorf("oidc: userinfo request failed: %w", err)
+		}
+		if info.String("sub") != claims.String("sub") {
+			return nil, errors.New("oidc: userinfo is for another user")
+		}
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+	return claims, nil
+}
+
+// getJSON fetches a JSON document, with an access token if one is given.
+func (p *Provider) getJSON(ctx context.Context, u, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// Claims are the claims about the user returned by the provider.
+type Claims map[string]any
+
+// String returns a string claim, or "" if it is missing or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that is a list of strings, such as "groups". A
+// single string is returned as a list of one.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Bool returns a boolean claim. Some providers send "true" as a string.
+func (c Claims) Bool(name string) bool {
+	switch v := c[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,436 @@
+// Package qr draws QR codes, such as the ones authenticator apps scan to
+// set up two-factor authentication.
+//
+// It supports what those codes need and nothing more: byte mode, error
+// correction level M and versions 1 to 10, which hold up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTooLong is returned when the text doesn't fit in the largest supported version.
+var ErrTooLong = errors.New("qr: text too long")
+
+// version describes the layout of one QR code version at level M.
+type version struct {
+	eccPerBlock int
+	blocks      []int // Data codewords of each block, shortest first.
+	alignment   []int // Centres of the alignment patterns on each axis.
+}
+
+// versions are indexed by version number, from ISO/IEC 18004 tables 9 and E.1.
+var versions = [...]version{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+// Code is an encoded QR code.
+type Code struct {
+	size       int
+	modules    [][]bool // [y][x], true for dark.
+	isFunction [][]bool // Modules that are part of the patterns, not data.
+}
+
+// Encode encodes text in the smallest version it fits in.
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	for v := 1; v < len(versions); v++ {
+		if capacityBits(v) >= dataBits(v, len(data)) {
+			return encode(v, data), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, len(data))
+}
+
+// Size returns the number of modules on each side, without the quiet zone.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark reports whether the module at x, y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// SVG returns the code as an SVG image, with the quiet zone around it.
+// Each module is one user unit, so the image scales with its width.
+func (c *Code) SVG() string {
+	const quiet = 4
+	var b strings.Builder
+	n := c.size + 2*quiet
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range c.size {
+		for x := range c.size {
+			if c.modules[y][x] {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// capacityBits returns the number of data bits version v holds.
+func capacityBits(v int) int {
+	n := 0
+	for _, d := range versions[v].blocks {
+		n += d
+	}
+	return n * 8
+}
+
+// countBits returns the length of the character count in byte mode.
+func countBits(v int) int {
+	if v < 10 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits returns the number of bits n bytes take in version v.
+func dataBits(v, n int) int {
+	return 4 + countBits(v) + 8*n
+}
+
+func encode(v int, data []byte) *Code {
+	size := 17 + 4*v
+	c := &Code{size: size, modules: grid(size), isFunction: grid(size)}
+	c.drawFunctionPatterns(v)
+	c.drawCodewords(interleave(v, dataCodewords(v, data)))
+
+	// Use the mask that makes the code easiest to read.
+	best, bestPenalty := 0, -1
+	for mask := range 8 {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // XOR again to undo it.
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c
+}
+
+func grid(size int) [][]bool {
+	g := make([][]bool, size)
+	for i := range g {
+		g[i] = make([]bool, size)
+	}
+	return g
+}
+
+// dataCodewords returns the data bit stream of version v, padded to its capacity.
+func dataCodewords(v int, data []byte) []byte {
+	var bits bitWriter
+	bits.write(0b0100, 4) // Byte mode.
+	bits.write(len(data), countBits(v))
+	for _, b := range data {
+		bits.write(int(b), 8)
+	}
+
+	capacity := capacityBits(v)
+	bits.write(0, min(4, capacity-bits.n)) // Terminator.
+	bits.write(0, (8-bits.n%8)%8)
+	for pad := 0xEC; bits.n < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.write(pad, 8)
+	}
+	return bits.bytes
+}
+
+// interleave adds the error correction codewords to each block and
+// interleaves the blocks in the order they are drawn.
+func interleave(v int, data []byte) []byte {
+	ver := versions[v]
+	gen := rsGenerator(ver.eccPerBlock)
+
+	var dataBlocks, eccBlocks [][]byte
+	for _, n := range ver.blocks {
+		block := data[:n]
+		data = data[n:]
+		dataBlocks = append(dataBlocks, block)
+		eccBlocks = append(eccBlocks, rsRemainder(block, gen))
+	}
+
+	var out []byte
+	longest := ver.blocks[len(ver.blocks)-1]
+	for i := range longest {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := range ver.eccPerBlock {
+		for _, block := range eccBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// the version information, and reserves the format information area.
+func (c *Code) drawFunctionPatterns(v int) {
+	size := c.size
+
+	// Timing patterns.
+	for i := range size {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators, in three corners.
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap the finders.
+	pos := versions[v].alignment
+	for i, cx := range pos {
+		for j, cy := range pos {
+			last := len(pos) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format information; drawFormat fills it in.
+	c.drawFormat(0)
+
+	// Version information, from version 7.
+	if v >= 7 {
+		rem := v
+		for range 12 {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := v<<12 | rem
+		for i := range 18 {
+			dark := bits>>i&1 == 1
+			a, b := size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// drawFormat draws the two copies of the format information for level M
+// and the given mask, along with the dark module.
+func (c *Code) drawFormat(mask int) {
+	data := 0<<3 | mask // Level M is 00.
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	size := c.size
+	for i := range 6 {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := range 8 {
+		c.set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, size-15+i, bit(i))
+	}
+	c.set(8, size-8, true)
+}
+
+// set draws a function module.
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+// drawCodewords fills the data area in the zigzag order of the standard,
+// two columns at a time from the bottom right.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern.
+		}
+		upward := (right+1)&2 == 0
+		for vert := range c.size {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if c.isFunction[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern.
+func (c *Code) applyMask(mask int) {
+	for y := range c.size {
+		for x := range c.size {
+			if c.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, using the four rules of the standard.
+func (c *Code) penalty() int {
+	size := c.size
+	p := 0
+
+	// Rules 1 and 3, on rows and then columns: runs of five or more modules
+	// of the same colour, and patterns that look like finders.
+	for _, transpose := range []bool{false, true} {
+		at := func(i, j int) bool {
+			if transpose {
+				return c.modules[j][i]
+			}
+			return c.modules[i][j]
+		}
+		for i := range size {
+			run := 1
+			for j := 1; j <= size; j++ {
+				if j < size && at(i, j) == at(i, j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+			for j := 0; j+7 <= size; j++ {
+				if !(at(i, j) && !at(i, j+1) && at(i, j+2) && at(i, j+3) && at(i, j+4) && !at(i, j+5) && at(i, j+6)) {
+					continue
+				}
+				if lightRun(at, size, i, j-4, j) || lightRun(at, size, i, j+7, j+11) {
+					p += 40
+				}
+			}
+		}
+	}
+
+	// Rule 2: 2x2 blocks of the same colour.
+	for y := 0; y+1 < size; y++ {
+		for x := 0; x+1 < size; x++ {
+			m := c.modules[y][x]
+			if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+				p += 3
+			}
+		}
+	}
+
+	// Rule 4: the balance of dark and light modules.
+	dark := 0
+	for y := range size {
+		for x := range size {
+			if c.modules[y][x] {
+				dark++
+			}
+		}
+	}
+	percent := dark * 100 / (size * size)
+	p += abs(percent-50) / 5 * 10
+	return p
+}
+
+// lightRun reports whether modules from to to (exclusive) of line i are all
+// light. Modules outside the code count as light, like the quiet zone.
+func lightRun(at func(i, j int) bool, size, i, from, to int) bool {
+	for j := from; j < to; j++ {
+		if j >= 0 && j < size && at(i, j) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	bytes []byte
+	n     int // Number of bits written.
+}
+
+func (w *bitWriter) write(value, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if value>>i&1 == 1 {
+			w.bytes[len(w.bytes)-1] |= 1 << (7 - w.n%8)
+		}
+		w.n++
+	}
+}
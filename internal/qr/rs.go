@@ -0,0 +1,55 @@
+package qr
+
+// Reed-Solomon error correction over GF(256) with the primitive polynomial
+// x^8 + x^4 + x^3 + x^2 + 1 used by QR codes.
+
+// gfMul multiplies two elements of GF(256).
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a&0x80 != 0
+		a <<= 1
+		if carry {
+			a ^= 0x1D
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// rsGenerator returns the coefficients of the generator polynomial of the
+// given degree, highest power first, without the leading 1.
+func rsGenerator(degree int) []byte {
+	gen := make([]byte, degree)
+	gen[degree-1] = 1 // Start with the polynomial 1.
+
+	// Multiply by (x - a^i) for i from 0 to degree-1.
+	root := byte(1)
+	for range degree {
+		for j := range gen {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < len(gen) {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return gen
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, gen []byte) []byte {
+	rem := make([]byte, len(gen))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+	return rem
+}
@@ -0,0 +1,86 @@
+// Package rbac decides what users may do.
+//
+// Admins may do everything, and instructors may create courses. Everything
+// else is granted per course to its staff: the instructors who author it and
+// the teaching assistants (TAs) who help grade it. Any user can be on the
+// staff of a course, whatever their role.
+package rbac
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/database"
+	"slices"
+)
+
+// Permission is something a user may be allowed to do.
+type Permission string
+
+const (
+	CourseCreate     Permission = "course:create"     // Create courses, becoming their instructor.
+	CourseView       Permission = "course:view"       // See the management pages of a course and the progress of its students.
+	CourseEdit       Permission = "course:edit"       // Add lessons and content to a course.
+	EnrollmentManage Permission = "enrollment:manage" // Enroll users in a course.
+	CertificateIssue Permission = "certificate:issue" // Issue certificates to the students of a course.
+	StaffManage      Permission = "staff:manage"      // Choose the staff of a course.
+)
+
+// The roles of users.
+const (
+	RoleStudent    = "student"
+	RoleInstructor = "instructor"
+	RoleAdmin      = "admin"
+)
+
+// The roles of the staff of a course.
+const (
+	StaffInstructor = "instructor"
+	StaffTA         = "ta"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:      {CourseCreate, CourseView, CourseEdit, EnrollmentManage, CertificateIssue, StaffManage},
+	RoleInstructor: {CourseCreate},
+}
+
+var staffPermissions = map[string][]Permission{
+	StaffInstructor: {CourseView, CourseEdit, EnrollmentManage, CertificateIssue},
+	StaffTA:         {CourseView, CertificateIssue},
+}
+
+// ValidRole reports whether role is a role of users.
+func ValidRole(role string) bool {
+	return role == RoleStudent || role == RoleInstructor || role == RoleAdmin
+}
+
+// ValidStaffRole reports whether role is a role of course staff.
+func ValidStaffRole(role string) bool {
+	_, ok := staffPermissions[role]
+	return ok
+}
+
+// RoleCan reports whether users with the role have the permission in
+// every course.
+func RoleCan(role string, perm Permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// StaffCan reports whether course staff with the role have the permission
+// in their course.
+func StaffCan(staffRole string, perm Permission) bool {
+	return slices.Contains(staffPermissions[staffRole], perm)
+}
+
+// StaffRole returns the role of a user on the staff of a course, or "" if
+// they aren't on its staff.
+func StaffRole(ctx context.Context, staff database.CourseStaffStore, courseID, userID int64) (string, error) {
+	member, err := staff.GetCourseStaffMember(ctx, courseID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
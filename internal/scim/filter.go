@@ -0,0 +1,492 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Filter is a parsed filter expression (RFC 7644 section 3.4.2.2), such
+// as `userName eq "ann"` or `emails[type eq "work" and value co "@example.com"]`.
+type Filter interface {
+	// Match reports whether a resource matches the filter.
+	Match(resource map[string]any) bool
+}
+
+// ParseFilter parses a filter expression. Its errors are *Error.
+func ParseFilter(s string) (Filter, error) {
+	p := &filterParser{s: s}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != tokEnd {
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+	return f, nil
+}
+
+// Path is the target of a PATCH operation: an attribute, optionally
+// narrowed to the values of a multi-valued attribute matching a filter,
+// and to one of their sub-attributes.
+type Path struct {
+	Attr   string
+	Filter Filter // nil if the path has no value filter.
+	Sub    string
+}
+
+// ParsePath parses a PATCH path such as "members", "name.givenName" or
+// `emails[type eq "work"].value`. Its errors are *Error.
+func ParsePath(s string) (*Path, error) {
+	s = trimSchema(strings.TrimSpace(s))
+	if s == "" {
+		return nil, Errorf(http.StatusBadRequest, ErrInvalidPath, "empty path")
+	}
+
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		// Extension attributes are named by URNs, which have dots of their own.
+		if strings.HasPrefix(strings.ToLower(s), "urn:") {
+			return &Path{Attr: s}, nil
+		}
+		attr, sub, _ := strings.Cut(s, ".")
+		if attr == "" || strings.Contains(sub, ".") {
+			return nil, Errorf(http.StatusBadRequest, ErrInvalidPath, "invalid path %q", s)
+		}
+		return &Path{Attr: attr, Sub: sub}, nil
+	}
+
+	end := strings.LastIndexByte(s, ']')
+	if open == 0 || end < open {
+		return nil, Errorf(http.StatusBadRequest, ErrInvalidPath, "invalid path %q", s)
+	}
+	filter, err := ParseFilter(s[open+1 : end])
+	if err != nil {
+		return nil, Errorf(http.StatusBadRequest, ErrInvalidPath, "invalid filter in path %q: %s", s, err.(*Error).Detail)
+	}
+	path := &Path{Attr: s[:open], Filter: filter}
+	if rest := s[end+1:]; rest != "" {
+		sub, ok := strings.CutPrefix(rest, ".")
+		if !ok || sub == "" || strings.Contains(sub, ".") {
+			return nil, Errorf(http.StatusBadRequest, ErrInvalidPath, "invalid path %q", s)
+		}
+		path.Sub = sub
+	}
+	return path, nil
+}
+
+type and struct{ left, right Filter }
+
+func (f and) Match(r map[string]any) bool { return f.left.Match(r) && f.right.Match(r) }
+
+type or struct{ left, right Filter }
+
+func (f or) Match(r map[string]any) bool { return f.left.Match(r) || f.right.Match(r) }
+
+type not struct{ f Filter }
+
+func (f not) Match(r map[string]any) bool { return !f.f.Match(r) }
+
+// present matches resources with a value for the attribute: "title pr".
+type present struct{ attr string }
+
+func (f present) Match(r map[string]any) bool {
+	// A complex attribute such as name has no value of its own, but is
+	// present if it has sub-attributes.
+	if v, _ := Lookup(r, f.attr); v != nil {
+		if m, ok := v.(map[string]any); ok {
+			return len(m) > 0
+		}
+	}
+	for _, v := range values(r, f.attr) {
+		if s, ok := v.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// compare matches resources with a value for the attribute that compares
+// to the given one as op says: `userName eq "ann"`.
+type compare struct {
+	attr  string
+	op    string
+	value any // string, bool, float64 or nil.
+}
+
+func (f compare) Match(r map[string]any) bool {
+	vs := values(r, f.attr)
+	if f.op == "ne" {
+		return !compare{f.attr, "eq", f.value}.Match(r)
+	}
+	if f.value == nil {
+		// "eq null" is the same as "not pr".
+		return f.op == "eq" && !present{f.attr}.Match(r)
+	}
+	for _, v := range vs {
+		if f.matches(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f compare) matches(v any) bool {
+	switch want := f.value.(type) {
+	case bool:
+		got, ok := v.(bool)
+		return ok && f.op == "eq" && got == want
+	case float64:
+		got, ok := toFloat(v)
+		if !ok {
+			return false
+		}
+		return order(f.op, compareFloats(got, want))
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return false
+		}
+		// None of our attributes are case exact.
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch f.op {
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		}
+		return order(f.op, strings.Compare(got, want))
+	}
+	return false
+}
+
+// valuePath matches resources with a value of a multi-valued attribute
+// that matches a filter: `emails[type eq "work"]`.
+type valuePath struct {
+	attr   string
+	filter Filter
+}
+
+func (f valuePath) Match(r map[string]any) bool {
+	v, _ := Lookup(r, f.attr)
+	for _, elem := range asList(v) {
+		if m, ok := elem.(map[string]any); ok && f.filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the values of an attribute to compare to. The values of
+// a multi-valued complex attribute such as emails are their "value"
+// sub-attribute.
+func values(r map[string]any, attr string) []any {
+	name, sub, _ := strings.Cut(attr, ".")
+	if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+		name, sub = attr, ""
+	}
+	v, ok := Lookup(r, name)
+	if !ok || v == nil {
+		return nil
+	}
+
+	var vs []any
+	for _, elem := range asList(v) {
+		m, ok := elem.(map[string]any)
+		if !ok {
+			if sub == "" {
+				vs = append(vs, elem)
+			}
+			continue
+		}
+		if sub == "" {
+			sub = "value"
+		}
+		if sv, ok := Lookup(m, sub); ok && sv != nil {
+			vs = append(vs, sv)
+		}
+	}
+	return vs
+}
+
+// asList returns the values of a multi-valued attribute, or a single value
+// as a list of one.
+func asList(v any) []any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	case []map[string]any:
+		l := make([]any, len(v))
+		for i, m := range v {
+			l[i] = m
+		}
+		return l
+	}
+	return []any{v}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// order reports whether a comparison result c satisfies op.
+func order(op string, c int) bool {
+	switch op {
+	case "eq":
+		return c == 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	}
+	return false
+}
+
+const (
+	tokEnd = iota
+	tokWord
+	tokString
+	tokPunct   // ( ) [ ]
+	tokInvalid // A string that isn't valid JSON.
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+type filterParser struct {
+	s    string
+	pos  int
+	peek *token
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return Errorf(http.StatusBadRequest, ErrInvalidFilter, "invalid filter %q: %s", p.s, fmt.Sprintf(format, args...))
+}
+
+// next returns the next token and consumes it.
+func (p *filterParser) next() token {
+	if p.peek != nil {
+		t := *p.peek
+		p.peek = nil
+		return t
+	}
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.s) {
+		return token{kind: tokEnd}
+	}
+
+	start := p.pos
+	switch c := p.s[p.pos]; c {
+	case '(', ')', '[', ']':
+		p.pos++
+		return token{kind: tokPunct, text: string(c)}
+	case '"':
+		p.pos++
+		for p.pos < len(p.s) && p.s[p.pos] != '"' {
+			if p.s[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		p.pos++
+		var s string
+		if p.pos > len(p.s) || json.Unmarshal([]byte(p.s[start:p.pos]), &s) != nil {
+			p.pos = len(p.s)
+			return token{kind: tokInvalid, text: p.s[start:]}
+		}
+		return token{kind: tokString, text: s}
+	}
+	for p.pos < len(p.s) && !strings.ContainsRune(" ()[]\"", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return token{kind: tokWord, text: p.s[start:p.pos]}
+}
+
+// lookahead returns the next token without consuming it.
+func (p *filterParser) lookahead() token {
+	if p.peek == nil {
+		t := p.next()
+		p.peek = &t
+	}
+	return *p.peek
+}
+
+// keyword reports whether the next token is the given keyword, and
+// consumes it if it is.
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.lookahead(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(punct string) error {
+	if t := p.next(); t.kind != tokPunct || t.text != punct {
+		return p.errorf("expected %s", punct)
+	}
+	return nil
+}
+
+// or parses FILTER *("or" FILTER), the lowest precedence.
+func (p *filterParser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+	return left, nil
+}
+
+// and parses FILTER *("and" FILTER).
+func (p *filterParser) and() (Filter, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+	return left, nil
+}
+
+// not parses "not" "(" FILTER ")", or a simpler expression.
+func (p *filterParser) not() (Filter, error) {
+	if !p.keyword("not") {
+		return p.primary()
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return not{f}, nil
+}
+
+// primary parses "(" FILTER ")", a value path or an attribute expression.
+func (p *filterParser) primary() (Filter, error) {
+	t := p.next()
+	if t.kind == tokPunct && t.text == "(" {
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if t.kind != tokWord {
+		if t.kind == tokEnd {
+			return nil, p.errorf("unexpected end")
+		}
+		return nil, p.errorf("expected an attribute, found %q", t.text)
+	}
+	attr := trimSchema(t.text)
+
+	if next := p.lookahead(); next.kind == tokPunct && next.text == "[" {
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return valuePath{attr, f}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokWord {
+		return nil, p.errorf("expected an operator after %s", attr)
+	}
+	switch strings.ToLower(op.text) {
+	case "pr":
+		return present{attr}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, p.errorf("unknown operator %q", op.text)
+	}
+	f := compare{attr: attr, op: strings.ToLower(op.text)}
+
+	v := p.next()
+	switch {
+	case v.kind == tokInvalid:
+		return nil, p.errorf("invalid string %s", v.text)
+	case v.kind == tokString:
+		f.value = v.text
+	case v.kind == tokWord && v.text == "true":
+		f.value = true
+	case v.kind == tokWord && v.text == "false":
+		f.value = false
+	case v.kind == tokWord && v.text == "null":
+		f.value = nil
+	case v.kind == tokWord:
+		n, err := strconv.ParseFloat(v.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid value %q", v.text)
+		}
+		f.value = n
+	default:
+		return nil, p.errorf("expected a value after %s %s", attr, op.text)
+	}
+
+	switch f.value.(type) {
+	case bool, nil:
+		if f.op != "eq" && f.op != "ne" {
+			return nil, p.errorf("%s can't compare %v", f.op, v.text)
+		}
+	case float64:
+		if f.op == "co" || f.op == "sw" || f.op == "ew" {
+			return nil, p.errorf("%s can't compare numbers", f.op)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,189 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// testUser is a user resource as the SCIM handlers render it.
+const testUser = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"id": "42",
+	"userName": "Ann.Smith",
+	"displayName": "Ann",
+	"title": "",
+	"active": true,
+	"loginCount": 7,
+	"name": {"givenName": "Ann", "familyName": "Smith"},
+	"emails": [
+		{"type": "work", "value": "ann@example.com", "primary": true},
+		{"type": "home", "value": "ann@home.example"}
+	]
+}`
+
+func TestFilterMatch(t *testing.T) {
+	var user map[string]any
+	if err := json.Unmarshal([]byte(testUser), &user); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		// Attribute names and string values are compared without regard to case.
+		{`userName eq "ann.smith"`, true},
+		{`USERNAME eq "Ann.Smith"`, true},
+		{`userName eq "bob"`, false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "Ann.Smith"`, true},
+		{`userName ne "bob"`, true},
+		{`userName ne "ann.smith"`, false},
+		{`userName co "n.S"`, true},
+		{`userName sw "ann"`, true},
+		{`userName sw "smith"`, false},
+		{`userName ew "SMITH"`, true},
+		{`userName gt "Amy"`, true},
+		{`userName lt "Amy"`, false},
+		{`userName ge "ann.smith"`, true},
+		{`userName le "ann.smith"`, true},
+
+		{`name.familyName eq "Smith"`, true},
+		{`name.middleName pr`, false},
+		{`name pr`, true},
+		{`displayName pr`, true},
+		{`title pr`, false},
+		{`nickName pr`, false},
+		{`nickName eq null`, true},
+		{`title eq null`, true},
+		{`displayName eq null`, false},
+		{`displayName ne null`, true},
+
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`active ne false`, true},
+		{`active eq "true"`, false},
+		{`loginCount eq 7`, true},
+		{`loginCount gt 6.5`, true},
+		{`loginCount le 6`, false},
+		{`loginCount eq "7"`, false},
+
+		// Multi-valued attributes match if any of their values does.
+		{`emails eq "ann@home.example"`, true},
+		{`emails co "@example.com"`, true},
+		{`emails.type eq "home"`, true},
+		{`emails.type eq "other"`, false},
+		{`emails[type eq "work"]`, true},
+		{`emails[type eq "work" and value ew "@example.com"]`, true},
+		{`emails[type eq "home" and value ew "@example.com"]`, false},
+		{`emails[primary eq true]`, true},
+		{`emails[not (type eq "work")]`, true},
+		{`name[givenName eq "Ann"]`, true},
+
+		// Precedence: not, then and, then or.
+		{`userName eq "bob" or active eq true`, true},
+		{`userName eq "bob" and active eq true`, false},
+		{`userName eq "bob" and active eq true or displayName eq "Ann"`, true},
+		{`userName eq "bob" and (active eq true or displayName eq "Ann")`, false},
+		{`not (userName eq "bob")`, true},
+		{`not (userName eq "bob") and not (active eq false)`, true},
+		{`NOT (userName EQ "bob") AND displayName PR`, true},
+		{`((userName eq "Ann.Smith"))`, true},
+
+		// Strings are JSON strings.
+		{`displayName eq "Ann"`, true},
+		{`userName eq "Ann\"Smith"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(user); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName equals "ann"`,
+		`userName eq ann`,
+		`userName eq "ann`,
+		`userName eq "\x"`,
+		`userName eq "ann" and`,
+		`userName eq "ann" extra`,
+		`(userName eq "ann"`,
+		`userName eq "ann")`,
+		`not userName eq "ann"`,
+		`emails[type eq "work"`,
+		`emails[]`,
+		`[type eq "work"]`,
+		`"ann" eq userName`,
+		`active gt true`,
+		`title co null`,
+		`loginCount sw 7`,
+	}
+	for _, s := range tests {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseFilter(s)
+			var scimErr *Error
+			if !errors.As(err, &scimErr) {
+				t.Fatalf("got error %v, want a *Error", err)
+			}
+			if scimErr.Status != http.StatusBadRequest || scimErr.ScimType != ErrInvalidFilter {
+				t.Errorf("got status %d and type %q, want 400 and %q", scimErr.Status, scimErr.ScimType, ErrInvalidFilter)
+			}
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		attr    string
+		sub     string
+		filter  bool
+		wantErr bool
+	}{
+		{path: "members", attr: "members"},
+		{path: "name.givenName", attr: "name", sub: "givenName"},
+		{path: "urn:ietf:params:scim:schemas:core:2.0:User:userName", attr: "userName"},
+		{path: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", attr: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department"},
+		{path: `emails[type eq "work"]`, attr: "emails", filter: true},
+		{path: `emails[type eq "work"].value`, attr: "emails", sub: "value", filter: true},
+		{path: `members[value eq "2"]`, attr: "members", filter: true},
+		{path: "", wantErr: true},
+		{path: ".value", wantErr: true},
+		{path: "name.given.name", wantErr: true},
+		{path: `[type eq "work"]`, wantErr: true},
+		{path: `emails[type eq "work"`, wantErr: true},
+		{path: `emails[type eq]`, wantErr: true},
+		{path: `emails[type eq "work"]value`, wantErr: true},
+		{path: `emails[type eq "work"].`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p, err := ParsePath(tt.path)
+			if tt.wantErr {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != ErrInvalidPath {
+					t.Fatalf("got error %v, want an %s error", err, ErrInvalidPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Attr != tt.attr || p.Sub != tt.sub || (p.Filter != nil) != tt.filter {
+				t.Errorf("got %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644 section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is one of the operations of a PATCH request.
+type Operation struct {
+	Op    string `json:"op"` // add, remove or replace, in any case.
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Apply applies the operations of a PATCH request to a resource in its
+// JSON form. Removed attributes are set to nil rather than deleted, so that
+// the caller can tell them from attributes that were never set. Its errors
+// are *Error.
+func (req *PatchRequest) Apply(resource map[string]any) error {
+	for _, op := range req.Operations {
+		if err := op.apply(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (op Operation) apply(resource map[string]any) error {
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace":
+	case "remove":
+		if op.Path == "" {
+			return Errorf(http.StatusBadRequest, ErrNoTarget, "remove needs a path")
+		}
+	default:
+		return Errorf(http.StatusBadRequest, ErrInvalidSyntax, "unknown operation %q", op.Op)
+	}
+
+	// Without a path, the value holds the attributes to add or replace.
+	// Some clients name sub-attributes there too, as in "name.givenName".
+	if op.Path == "" {
+		attrs, ok := op.Value.(map[string]any)
+		if !ok {
+			return Errorf(http.StatusBadRequest, ErrInvalidValue, "%s without a path needs an object value", kind)
+		}
+		for name, v := range attrs {
+			if err := (Operation{Op: kind, Path: name, Value: v}).apply(resource); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := ParsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	key, ok := findKey(resource, path.Attr)
+	if !ok {
+		key = path.Attr
+	}
+	if kind == "remove" {
+		return remove(resource, key, path, op.Value)
+	}
+	return set(resource, key, path, kind == "add", op.Value)
+}
+
+// set adds or replaces the value at path, whose attribute is resource[key].
+func set(resource map[string]any, key string, path *Path, add bool, value any) error {
+	current := resource[key]
+
+	switch {
+	case path.Filter != nil:
+		list := asList(current)
+		matched := false
+		for _, elem := range list {
+			m, ok := elem.(map[string]any)
+			if !ok || !path.Filter.Match(m) {
+				continue
+			}
+			matched = true
+			if err := setIn(m, path.Sub, value); err != nil {
+				return err
+			}
+		}
+		if matched {
+			return nil
+		}
+		// Clients set, for example, `emails[type eq "work"].value` of users
+		// who have no work address yet: add one.
+		c, ok := path.Filter.(compare)
+		if !ok || c.op != "eq" || strings.Contains(c.attr, ".") {
+			return Errorf(http.StatusBadRequest, ErrNoTarget, "no value of %s matches the filter", path.Attr)
+		}
+		elem := map[string]any{c.attr: c.value}
+		if err := setIn(elem, path.Sub, value); err != nil {
+			return err
+		}
+		resource[key] = append(list, elem)
+
+	case path.Sub != "":
+		if _, ok := current.([]any); ok {
+			return Errorf(http.StatusBadRequest, ErrInvalidPath, "%s is multi-valued; select values with a filter", path.Attr)
+		}
+		m, ok := current.(map[string]any)
+		if !ok {
+			m = map[string]any{}
+			resource[key] = m
+		}
+		return setIn(m, path.Sub, value)
+
+	default:
+		list, multi := current.([]any)
+		if !multi {
+			// Complex values are merged: sub-attributes not given are kept.
+			m, complex := current.(map[string]any)
+			if _, ok := value.(map[string]any); ok && complex {
+				return setIn(m, "", value)
+			}
+			resource[key] = value
+			return nil
+		}
+		// Adding to a multi-valued attribute appends the new values, while
+		// replacing it replaces them all.
+		if !add {
+			resource[key] = asList(value)
+			return nil
+		}
+		for _, v := range asList(value) {
+			if !containsValue(list, v) {
+				list = append(list, v)
+			}
+		}
+		resource[key] = list
+	}
+	return nil
+}
+
+// setIn sets the sub-attribute sub of a complex value, or merges value into
+// it if sub is empty.
+func setIn(m map[string]any, sub string, value any) error {
+	if sub != "" {
+		key, ok := findKey(m, sub)
+		if !ok {
+			key = sub
+		}
+		m[key] = value
+		return nil
+	}
+	attrs, ok := value.(map[string]any)
+	if !ok {
+		return Errorf(http.StatusBadRequest, ErrInvalidValue, "expected an object, got %v", value)
+	}
+	for name, v := range attrs {
+		key, ok := findKey(m, name)
+		if !ok {
+			key = name
+		}
+		m[key] = v
+	}
+	return nil
+}
+
+// remove removes the value at path, whose attribute is resource[key]. Given
+// a value, it removes only those values of a multi-valued attribute.
+func remove(resource map[string]any, key string, path *Path, value any) error {
+	current, ok := resource[key]
+	if !ok || current == nil {
+		return nil
+	}
+
+	switch {
+	case path.Filter != nil:
+		var kept []any
+		for _, elem := range asList(current) {
+			m, ok := elem.(map[string]any)
+			if !ok || !path.Filter.Match(m) {
+				kept = append(kept, elem)
+				continue
+			}
+			if path.Sub != "" {
+				if k, ok := findKey(m, path.Sub); ok {
+					m[k] = nil
+				}
+				kept = append(kept, elem)
+			}
+		}
+		resource[key] = kept
+
+	case path.Sub != "":
+		m, ok := current.(map[string]any)
+		if !ok {
+			return Errorf(http.StatusBadRequest, ErrInvalidPath, "%s has no sub-attributes to remove", path.Attr)
+		}
+		if k, ok := findKey(m, path.Sub); ok {
+			m[k] = nil
+		}
+
+	default:
+		list, multi := current.([]any)
+		if !multi || value == nil {
+			resource[key] = nil
+			return nil
+		}
+		var kept []any
+		for _, elem := range list {
+			if !containsValue(asList(value), elem) {
+				kept = append(kept, elem)
+			}
+		}
+		resource[key] = kept
+	}
+	return nil
+}
+
+// containsValue reports whether list holds v. Complex values are the same
+// if they have the same "value" sub-attribute, such as the ID of a member.
+func containsValue(list []any, v any) bool {
+	for _, elem := range list {
+		if sameValue(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(a, b any) bool {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if aok && bok {
+		av, aok := Lookup(am, "value")
+		bv, bok := Lookup(bm, "value")
+		if aok && bok {
+			return fmt.Sprint(av) == fmt.Sprint(bv)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,128 @@
+// Package scim implements the protocol parts of SCIM 2.0 (RFC 7643 and
+// RFC 7644) that don't depend on the resources served: filters, PATCH
+// operations, list responses and errors.
+//
+// Resources are handled in their JSON form, as maps of attribute names to
+// values, whose names are compared without regard to case.
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Schema URNs.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// Error types (RFC 7644 section 3.12), sent in the scimType of errors
+// with status 400 or 409.
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrMutability    = "mutability"
+	ErrUniqueness    = "uniqueness"
+)
+
+// Error is an error response.
+type Error struct {
+	Status   int
+	ScimType string // Empty for errors other than 400 and 409.
+	Detail   string
+}
+
+// Errorf returns an Error with a formatted detail.
+func Errorf(status int, scimType, format string, args ...any) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.ScimType == "" {
+		return "scim: " + e.Detail
+	}
+	return fmt.Sprintf("scim: %s: %s", e.ScimType, e.Detail)
+}
+
+// Body returns the JSON body of the error response.
+func (e *Error) Body() map[string]any {
+	body := map[string]any{
+		"schemas": []string{SchemaError},
+		"status":  fmt.Sprint(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		body["scimType"] = e.ScimType
+	}
+	return body
+}
+
+// ListResponse returns the body of a query response holding one page of
+// the results, which start at startIndex (counting from 1).
+func ListResponse(total, startIndex int, page []map[string]any) map[string]any {
+	if page == nil {
+		page = []map[string]any{}
+	}
+	return map[string]any{
+		"schemas":      []string{SchemaListResponse},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(page),
+		"Resources":    page,
+	}
+}
+
+// Lookup returns the value of an attribute of a resource and whether it is
+// set. The name may be a sub-attribute such as "name.givenName".
+func Lookup(resource map[string]any, name string) (any, bool) {
+	attr, sub, _ := strings.Cut(name, ".")
+	key, ok := findKey(resource, attr)
+	if !ok {
+		return nil, false
+	}
+	v := resource[key]
+	if sub == "" {
+		return v, true
+	}
+	complex, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return Lookup(complex, sub)
+}
+
+// findKey returns the key of m that equals name without regard to case.
+func findKey(m map[string]any, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// trimSchema removes the schema URN from an attribute name such as
+// "urn:ietf:params:scim:schemas:core:2.0:User:userName". Attributes of
+// extension schemas keep theirs.
+func trimSchema(name string) string {
+	for _, schema := range []string{SchemaUser, SchemaGroup} {
+		if len(name) > len(schema) && strings.EqualFold(name[:len(schema)+1], schema+":") {
+			return name[len(schema)+1:]
+		}
+	}
+	return name
+}
@@ -0,0 +1,135 @@
+// Package throttle limits password guessing by locking out usernames and
+// client IPs after repeated failed logins.
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"lms/internal/database"
+	"lms/internal/models"
+	"time"
+)
+
+// Policy sets when logins are locked and for how long.
+type Policy struct {
+	// MaxFailures is the number of failed logins allowed for a username
+	// before it is locked. 0 disables the limit.
+	MaxFailures int
+	// MaxFailuresPerIP is the same limit for a client IP. It is higher
+	// because many users can share an address.
+	MaxFailuresPerIP int
+	// LockoutBase is the duration of the first lockout. Each further failure
+	// after it expires doubles the duration, up to LockoutMax.
+	LockoutBase time.Duration
+	// LockoutMax caps the lockout. Failures are forgotten once no new one
+	// has happened for this long.
+	LockoutMax time.Duration
+}
+
+// Limiter applies a Policy using the failures recorded in the store.
+type Limiter struct {
+	store  database.LoginThrottleStore
+	policy Policy
+}
+
+// NewLimiter creates a new Limiter.
+func NewLimiter(store database.LoginThrottleStore, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Check returns how long logins for username from ip must wait, or 0 if
+// they may be attempted now.
+func (l *Limiter) Check(ctx context.Context, username, ip string) (time.Duration, error) {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	for _, key := range []string{userKey(username), ipKey(ip)} {
+		t, err := l.store.GetLoginThrottle(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if t.LockedUntil.After(now) {
+			wait = max(wait, t.LockedUntil.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed login for username from ip and locks either
+// of them once it has too many failures.
+func (l *Limiter) Failure(ctx context.Context, username, ip string) error {
+	now := time.Now().UTC()
+
+	for _, limit := range []struct {
+		key string
+		max int
+	}{
+		{userKey(username), l.policy.MaxFailures},
+		{ipKey(ip), l.policy.MaxFailuresPerIP},
+	} {
+		if limit.max <= 0 {
+			continue
+		}
+
+		failures, err := l.store.RecordLoginFailure(ctx, limit.key, now, now.Add(-l.policy.LockoutMax))
+		if err != nil {
+			return err
+		}
+		if failures >= limit.max {
+			if err := l.store.LockLogin(ctx, limit.key, now.Add(l.lockout(failures-limit.max))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Success forgets the failed logins of username. Those of the IP are kept,
+// so one valid account can't be used to reset the limit for others.
+func (l *Limiter) Success(ctx context.Context, username string) error {
+	return l.store.DeleteLoginThrottle(ctx, userKey(username))
+}
+
+// Status returns the failed logins recorded for username, or nil if there are none.
+func (l *Limiter) Status(ctx context.Context, username string) (*models.LoginThrottle, error) {
+	t, err := l.store.GetLoginThrottle(ctx, userKey(username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// Unlock forgets the failed logins of username so it can log in again.
+func (l *Limiter) Unlock(ctx context.Context, username string) error {
+	return l.store.DeleteLoginThrottle(ctx, userKey(username))
+}
+
+// Cleanup deletes the records of failures that have been forgotten.
+func (l *Limiter) Cleanup(ctx context.Context) (int64, error) {
+	return l.store.DeleteStaleLoginThrottles(ctx, time.Now().UTC().Add(-l.policy.LockoutMax))
+}
+
+// lockout returns the duration of the lockout after the given number of
+// failures past the limit: LockoutBase doubled each time, up to LockoutMax.
+func (l *Limiter) lockout(extra int) time.Duration {
+	d := l.policy.LockoutBase
+	for range extra {
+		if d >= l.policy.LockoutMax {
+			break
+		}
+		d *= 2
+	}
+	return min(d, l.policy.LockoutMax)
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,68 @@
+// Package token generates secrets that are handed to users, such as the
+// links in password reset emails, and stored only as hashes.
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// New returns a random URL-safe token with 256 bits of entropy.
+func New() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Hash returns the hash of token to store in the database. The tokens are
+// random, so a fast unsalted hash is enough.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// recoveryAlphabet leaves out letters and digits that are easily confused.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// RecoveryCode returns a random single-use code such as "k7wq-3nfh-p2xa",
+// short enough to write down and type. It has about 59 bits of entropy.
+func RecoveryCode() string {
+	var code []byte
+	b := make([]byte, 1)
+	for len(code) < 12 {
+		rand.Read(b)
+		// Skip the values that would make some letters more likely.
+		if int(b[0]) >= 256/len(recoveryAlphabet)*len(recoveryAlphabet) {
+			continue
+		}
+		code = append(code, recoveryAlphabet[int(b[0])%len(recoveryAlphabet)])
+	}
+	return groupRecoveryCode(code)
+}
+
+// NormalizeRecoveryCode returns code as RecoveryCode formats it, so that
+// codes typed in capitals or without dashes still match their hash.
+func NormalizeRecoveryCode(code string) string {
+	var b []byte
+	for _, c := range strings.ToLower(code) {
+		if strings.ContainsRune(recoveryAlphabet, c) {
+			b = append(b, byte(c))
+		}
+	}
+	return groupRecoveryCode(b)
+}
+
+// groupRecoveryCode puts a dash between each group of four characters.
+func groupRecoveryCode(b []byte) string {
+	var out strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			out.WriteByte('-')
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
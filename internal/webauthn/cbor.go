@@ -0,0 +1,151 @@
+package webauthn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errCBOR is returned for data that isn't the CBOR authenticators send.
+var errCBOR = errors.New("webauthn: invalid CBOR")
+
+// maxCBORDepth limits the nesting of arrays and maps.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR item (RFC 8949) in data and returns
+// it along with the rest of the data.
+//
+// Only what attestation objects and COSE keys use is supported: integers
+// (as int64), byte and text strings, arrays ([]any), maps (map[any]any
+// with int64 or string keys), booleans and null. Indefinite lengths, tags
+// and floats are rejected.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	// Simple values: false, true and null.
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+	}
+
+	// The argument: the value of an integer, or the length of the rest.
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(data) < n {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		for _, b := range data[:n] {
+			arg = arg<<8 | uint64(b)
+		}
+		data = data[n:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported length encoding", errCBOR)
+	}
+
+	switch major {
+	case 0, 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer out of range", errCBOR)
+		}
+		if major == 1 {
+			return -1 - int64(arg), data, nil
+		}
+		return int64(arg), data, nil
+
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		b := data[:arg]
+		if major == 3 {
+			return string(b), data[arg:], nil
+		}
+		return append([]byte(nil), b...), data[arg:], nil
+
+	case 4:
+		// Each item takes at least a byte, which bounds the allocation.
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			var err error
+			item, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			var err error
+			key, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+			}
+			if _, dup := m[key]; dup {
+				return nil, nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			value, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+}
+
+// cborMap decodes data, which must hold exactly one CBOR map.
+func cborMap(data []byte) (map[any]any, error) {
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errCBOR)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a map", errCBOR)
+	}
+	return m, nil
+}
@@ -0,0 +1,104 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) of the keys we accept.
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+// supportedAlgorithms are offered to authenticators in order of preference.
+var supportedAlgorithms = []int{algES256, algEdDSA, algRS256}
+
+// errKey is returned for public keys that can't be used.
+var errKey = errors.New("webauthn: unsupported public key")
+
+// publicKey verifies signatures made by a credential.
+type publicKey interface {
+	verify(data, sig []byte) bool
+}
+
+// parsePublicKey parses a COSE_Key (RFC 9052) as found in attested
+// credential data: an ES256 (P-256), EdDSA (Ed25519) or RS256 key.
+func parsePublicKey(cose []byte) (publicKey, error) {
+	m, err := cborMap(cose)
+	if err != nil {
+		return nil, err
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == algES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid P-256 key", errKey)
+		}
+		// NewPublicKey checks that the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("%w: %v", errKey, err)
+		}
+		return es256Key{&ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case kty == 1 && alg == algEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key", errKey)
+		}
+		return eddsaKey(x), nil
+
+	case kty == 3 && alg == algRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA exponent", errKey)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 || key.E%2 == 0 {
+			return nil, fmt.Errorf("%w: weak RSA key", errKey)
+		}
+		return rs256Key{key}, nil
+	}
+
+	return nil, fmt.Errorf("%w: key type %d, algorithm %d", errKey, kty, alg)
+}
+
+type es256Key struct{ key *ecdsa.PublicKey }
+
+func (k es256Key) verify(data, sig []byte) bool {
+	sum := sha256.Sum256(data)
+	return ecdsa.VerifyASN1(k.key, sum[:], sig)
+}
+
+type eddsaKey ed25519.PublicKey
+
+func (k eddsaKey) verify(data, sig []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(k), data, sig)
+}
+
+type rs256Key struct{ key *rsa.PublicKey }
+
+func (k rs256Key) verify(data, sig []byte) bool {
+	sum := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(k.key, crypto.SHA256, sum[:], sig) == nil
+}
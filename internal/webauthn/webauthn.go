@@ -0,0 +1,327 @@
+// Package webauthn implements the server side of passkey registration and
+// login (Web Authentication, level 2).
+//
+// It covers what a relying party that asks for no attestation needs:
+// discoverable credentials with user verification, signed with ES256,
+// EdDSA or RS256 keys. Attestation statements are not checked, so any
+// authenticator the browser accepts can be registered.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Timeout is how long the browser waits for the user, in milliseconds.
+const Timeout = 300000
+
+// ErrSignCount is returned when the signature counter of a credential went
+// backwards, which means the authenticator may have been cloned.
+var ErrSignCount = errors.New("webauthn: signature counter did not increase")
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+// maxCredentialIDLength is the longest credential ID the specification allows.
+const maxCredentialIDLength = 1023
+
+// RelyingParty checks ceremonies for one website.
+type RelyingParty struct {
+	ID     string // Domain the credentials are scoped to, e.g. "lms.example.com".
+	Name   string // Shown by the browser when creating a credential.
+	Origin string // Where the pages run, e.g. "https://lms.example.com".
+}
+
+// NewRelyingParty creates a RelyingParty for the site at publicURL.
+func NewRelyingParty(name, publicURL string) (*RelyingParty, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return nil, fmt.Errorf("webauthn: invalid site URL %q", publicURL)
+	}
+	return &RelyingParty{ID: u.Hostname(), Name: name, Origin: u.Scheme + "://" + u.Host}, nil
+}
+
+// NewChallenge returns a random challenge for a ceremony, base64url encoded.
+// It must be kept on the server and used only once.
+func NewChallenge() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// User identifies an account to the authenticator.
+type User struct {
+	Handle      []byte // Opaque ID returned at login; must not identify the person.
+	Name        string // Username, shown to tell accounts apart.
+	DisplayName string
+}
+
+// CreationOptions are the options of navigator.credentials.create().
+// Binary values are base64url strings, which the page decodes.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions returns the options for registering a passkey for user.
+// The IDs of the user's existing credentials are excluded, so the same
+// authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(challenge string, user User, existing [][]byte) CreationOptions {
+	opts := CreationOptions{
+		Challenge: challenge,
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(user.Handle),
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		Timeout:            Timeout,
+		ExcludeCredentials: []credentialDescriptor{},
+		// Passkeys are discoverable, so the user needn't type a username,
+		// and verify the user, so they count as two factors.
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+	for _, alg := range supportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, credentialParameter{Type: "public-key", Alg: alg})
+	}
+	for _, id := range existing {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, credentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(id),
+		})
+	}
+	return opts
+}
+
+// RequestOptions returns the options for logging in with any passkey of
+// the site; the user picks one in the browser.
+func (rp *RelyingParty) RequestOptions(challenge string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout,
+		AllowCredentials: []credentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+// Credential is a newly registered passkey.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key, to pass to VerifyAssertion.
+	SignCount uint32
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create()
+// to the given challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	// We ask for no attestation, so the statement is not checked.
+	att, err := cborMap(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	authData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	ad, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, errors.New("webauthn: no credential in authenticator data")
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{ID: ad.credentialID, PublicKey: ad.publicKey, SignCount: ad.signCount}, nil
+}
+
+// Assertion is the response of navigator.credentials.get().
+type Assertion struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+// VerifyAssertion checks an assertion made in response to the given
+// challenge with the credential that has publicKey and signCount, and
+// returns the new signature count to store. It returns ErrSignCount if the
+// counter went backwards.
+func (rp *RelyingParty) VerifyAssertion(challenge string, publicKey []byte, signCount uint32, a Assertion) (uint32, error) {
+	if err := rp.verifyClientData(a.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := rp.parseAuthenticatorData(a.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(a.ClientDataJSON)
+	signed := append(append([]byte(nil), a.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, a.Signature) {
+		return 0, errors.New("webauthn: invalid signature")
+	}
+
+	// Authenticators that don't count always send 0.
+	if (ad.signCount != 0 || signCount != 0) && ad.signCount <= signCount {
+		return 0, ErrSignCount
+	}
+	return ad.signCount, nil
+}
+
+// clientData is the part of the client data JSON that is checked.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// verifyClientData checks that the browser signed the expected challenge
+// for a ceremony of type typ on one of our pages.
+func (rp *RelyingParty) verifyClientData(data []byte, typ, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	switch {
+	case cd.Type != typ:
+		return fmt.Errorf("webauthn: client data is for %q, not %q", cd.Type, typ)
+	case challenge == "" || cd.Challenge != challenge:
+		return errors.New("webauthn: wrong challenge")
+	case cd.Origin != rp.Origin || cd.CrossOrigin:
+		return fmt.Errorf("webauthn: wrong origin %q", cd.Origin)
+	}
+	return nil
+}
+
+// authenticatorData is the parsed authenticator data.
+type authenticatorData struct {
+	signCount    uint32
+	credentialID []byte // Only when a credential was created.
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses and checks the authenticator data: it must
+// be for our RP ID and the user must have been present and verified.
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, errors.New("webauthn: authenticator data is for another site")
+	}
+	flags := data[32]
+	if flags&flagUserPresent == 0 || flags&flagUserVerified == 0 {
+		return nil, errors.New("webauthn: user was not verified")
+	}
+
+	ad := &authenticatorData{signCount: binary.BigEndian.Uint32(data[33:37])}
+	rest := data[37:]
+
+	// Attested credential data: AAGUID, credential ID and public key.
+	if flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > maxCredentialIDLength || n > len(rest) {
+			return nil, errors.New("webauthn: invalid credential ID length")
+		}
+		ad.credentialID = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		ad.publicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+		rest = after
+	}
+
+	// Extensions aren't used, but must be well-formed.
+	if flags&flagExtensions != 0 {
+		if _, err := cborMap(rest); err != nil {
+			return nil, err
+		}
+		rest = nil
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing authenticator data")
+	}
+	return ad, nil
+}
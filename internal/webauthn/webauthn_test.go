@@ -0,0 +1,446 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Most vectors are from RFC 8949, appendix A.
+	tests := []struct {
+		hex  string
+		want any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"190100", int64(256)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"390100", int64(-257)},
+		{"40", []byte(nil)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6449455446", "IETF"},
+		{"80", []any{}},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"a0", map[any]any{}},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+	}
+	for _, tt := range tests {
+		got, rest, err := decodeCBOR(mustHex(t, tt.hex))
+		if err != nil {
+			t.Errorf("%s: %v", tt.hex, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("%s: %d bytes left", tt.hex, len(rest))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	got, rest, err := decodeCBOR(mustHex(t, "0102"))
+	if err != nil || got != int64(1) || !bytes.Equal(rest, []byte{2}) {
+		t.Errorf("got %v, %x, %v; want 1, 02, nil", got, rest, err)
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"truncated argument", "1901"},
+		{"truncated string", "4401"},
+		{"truncated array", "8301"},
+		{"huge array", "9affffffff"},
+		{"huge map", "bbffffffffffffffff"},
+		{"integer out of range", "1bffffffffffffffff"},
+		{"indefinite length", "5f"},
+		{"reserved length", "1c"},
+		{"tag", "c074"},
+		{"float", "f97c00"},
+		{"undefined", "f7"},
+		{"byte string map key", "a14001"},
+		{"duplicate map key", "a201020103"},
+		{"nested too deeply", strings.Repeat("81", maxCBORDepth+2) + "00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(mustHex(t, tt.hex)); !errors.Is(err, errCBOR) {
+				t.Errorf("got error %v, want errCBOR", err)
+			}
+		})
+	}
+}
+
+func TestCBORMap(t *testing.T) {
+	if _, err := cborMap(mustHex(t, "a10102")); err != nil {
+		t.Errorf("map: %v", err)
+	}
+	if _, err := cborMap(mustHex(t, "a1010203")); err == nil {
+		t.Error("no error for trailing data")
+	}
+	if _, err := cborMap(mustHex(t, "8101")); err == nil {
+		t.Error("no error for an array")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ec := newES256Authenticator(t)
+	ed := newEdDSAAuthenticator(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCOSE := func(k *rsa.PrivateKey, e []byte) []byte {
+		return encodeCBOR(cborMapOf(1, 3, 3, algRS256, -1, k.N.Bytes(), -2, e))
+	}
+	x := ec.key.X.FillBytes(make([]byte, 32))
+
+	tests := []struct {
+		name string
+		cose []byte
+		ok   bool
+	}{
+		{"ES256", ec.cose(), true},
+		{"EdDSA", ed.cose(), true},
+		{"RS256", rsaCOSE(rsaKey, []byte{1, 0, 1}), true},
+		{"weak RS256", rsaCOSE(weak, []byte{1, 0, 1}), false},
+		{"RS256 with an even exponent", rsaCOSE(rsaKey, []byte{2}), false},
+		{"RS256 without exponent", rsaCOSE(rsaKey, nil), false},
+		{"P-256 point off the curve", encodeCBOR(cborMapOf(1, 2, 3, algES256, -1, 1, -2, x, -3, x)), false},
+		{"P-384 curve", encodeCBOR(cborMapOf(1, 2, 3, algES256, -1, 2, -2, x, -3, x)), false},
+		{"short Ed25519 key", encodeCBOR(cborMapOf(1, 1, 3, algEdDSA, -1, 6, -2, []byte{1, 2, 3})), false},
+		{"unsupported algorithm", encodeCBOR(cborMapOf(1, 2, 3, -35)), false},
+		{"not a map", encodeCBOR([]any{1}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePublicKey(tt.cose)
+			if (err == nil) != tt.ok {
+				t.Errorf("got error %v, want ok: %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewRelyingParty(t *testing.T) {
+	rp, err := NewRelyingParty("LMS", "https://lms.example.com:8443/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.ID != "lms.example.com" || rp.Origin != "https://lms.example.com:8443" {
+		t.Errorf("got ID %q and origin %q", rp.ID, rp.Origin)
+	}
+	for _, u := range []string{"", "lms.example.com", "ftp://lms.example.com", "https://"} {
+		if _, err := NewRelyingParty("LMS", u); err == nil {
+			t.Errorf("%q: no error", u)
+		}
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	rp, err := NewRelyingParty("LMS", "https://lms.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newES256Authenticator(t)
+	challenge := NewChallenge()
+
+	tests := []struct {
+		name    string
+		mutate  func(*ceremony)
+		wantErr string // Empty if the registration is valid.
+	}{
+		{"valid", func(*ceremony) {}, ""},
+		{"wrong challenge", func(c *ceremony) { c.challenge = NewChallenge() }, "wrong challenge"},
+		{"assertion instead", func(c *ceremony) { c.typ = "webauthn.get" }, "client data is for"},
+		{"other origin", func(c *ceremony) { c.origin = "https://evil.example" }, "wrong origin"},
+		{"cross origin", func(c *ceremony) { c.crossOrigin = true }, "wrong origin"},
+		{"other site", func(c *ceremony) { c.rpID = "evil.example" }, "another site"},
+		{"user not verified", func(c *ceremony) { c.flags &^= flagUserVerified }, "not verified"},
+		{"no credential", func(c *ceremony) { c.flags &^= flagAttested }, "no credential"},
+		{"trailing data", func(c *ceremony) { c.trailing = []byte{0} }, "trailing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ceremony{
+				typ:       "webauthn.create",
+				challenge: challenge,
+				origin:    rp.Origin,
+				rpID:      rp.ID,
+				flags:     flagUserPresent | flagUserVerified | flagAttested,
+				signCount: 1,
+			}
+			tt.mutate(c)
+			clientDataJSON, authData := c.build(t, a)
+			attestationObject := encodeCBOR(cborMapOf("fmt", "none", "attStmt", cborMapOf(), "authData", authData))
+
+			cred, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cred.ID, a.id) || !bytes.Equal(cred.PublicKey, a.cose()) || cred.SignCount != 1 {
+				t.Errorf("got credential %+v", cred)
+			}
+		})
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	rp, err := NewRelyingParty("LMS", "https://lms.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := NewChallenge()
+
+	tests := []struct {
+		name      string
+		auth      authenticator
+		stored    uint32 // The sign count stored for the credential.
+		mutate    func(*ceremony)
+		wantCount uint32
+		wantErr   string
+	}{
+		{"ES256", newES256Authenticator(t), 4, func(*ceremony) {}, 5, ""},
+		{"EdDSA", newEdDSAAuthenticator(t), 4, func(*ceremony) {}, 5, ""},
+		{"no counter", newES256Authenticator(t), 0, func(c *ceremony) { c.signCount = 0 }, 0, ""},
+		{"counter went backwards", newES256Authenticator(t), 9, func(*ceremony) {}, 0, "signature counter"},
+		{"counter stopped", newES256Authenticator(t), 4, func(c *ceremony) { c.signCount = 0 }, 0, "signature counter"},
+		{"wrong challenge", newES256Authenticator(t), 4, func(c *ceremony) { c.challenge = NewChallenge() }, 0, "wrong challenge"},
+		{"registration instead", newES256Authenticator(t), 4, func(c *ceremony) { c.typ = "webauthn.create" }, 0, "client data is for"},
+		{"other origin", newES256Authenticator(t), 4, func(c *ceremony) { c.origin = "http://lms.example.com" }, 0, "wrong origin"},
+		{"other site", newES256Authenticator(t), 4, func(c *ceremony) { c.rpID = "example.com" }, 0, "another site"},
+		{"user not present", newES256Authenticator(t), 4, func(c *ceremony) { c.flags &^= flagUserPresent }, 0, "not verified"},
+		{"bad signature", newES256Authenticator(t), 4, func(c *ceremony) { c.badSignature = true }, 0, "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ceremony{
+				typ:       "webauthn.get",
+				challenge: challenge,
+				origin:    rp.Origin,
+				rpID:      rp.ID,
+				flags:     flagUserPresent | flagUserVerified,
+				signCount: 5,
+			}
+			tt.mutate(c)
+			clientDataJSON, authData := c.build(t, tt.auth)
+			clientDataHash := sha256.Sum256(clientDataJSON)
+			sig := tt.auth.sign(t, append(append([]byte(nil), authData...), clientDataHash[:]...))
+			if c.badSignature {
+				sig[len(sig)-1] ^= 1
+			}
+
+			count, err := rp.VerifyAssertion(challenge, tt.auth.cose(), tt.stored, Assertion{
+				ClientDataJSON:    clientDataJSON,
+				AuthenticatorData: authData,
+				Signature:         sig,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("got sign count %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+// ceremony describes what a browser and an authenticator send back.
+type ceremony struct {
+	typ          string
+	challenge    string
+	origin       string
+	crossOrigin  bool
+	rpID         string
+	flags        byte
+	signCount    uint32
+	trailing     []byte
+	badSignature bool
+}
+
+// build returns the client data JSON and the authenticator data.
+func (c *ceremony) build(t *testing.T, a authenticator) ([]byte, []byte) {
+	t.Helper()
+	clientDataJSON, err := json.Marshal(map[string]any{
+		"type":        c.typ,
+		"challenge":   c.challenge,
+		"origin":      c.origin,
+		"crossOrigin": c.crossOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	authData := append(rpIDHash[:], c.flags)
+	authData = binary.BigEndian.AppendUint32(authData, c.signCount)
+	if c.flags&flagAttested != 0 {
+		authData = append(authData, make([]byte, 16)...) // AAGUID
+		authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID())))
+		authData = append(authData, a.credentialID()...)
+		authData = append(authData, a.cose()...)
+	}
+	return clientDataJSON, append(authData, c.trailing...)
+}
+
+// authenticator holds the key of a credential.
+type authenticator interface {
+	credentialID() []byte
+	cose() []byte
+	sign(t *testing.T, data []byte) []byte
+}
+
+type es256Authenticator struct {
+	id  []byte
+	key *ecdsa.PrivateKey
+}
+
+func newES256Authenticator(t *testing.T) *es256Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &es256Authenticator{id: []byte("es256-credential"), key: key}
+}
+
+func (a *es256Authenticator) credentialID() []byte { return a.id }
+
+func (a *es256Authenticator) cose() []byte {
+	return encodeCBOR(cborMapOf(1, 2, 3, algES256, -1, 1,
+		-2, a.key.X.FillBytes(make([]byte, 32)),
+		-3, a.key.Y.FillBytes(make([]byte, 32))))
+}
+
+func (a *es256Authenticator) sign(t *testing.T, data []byte) []byte {
+	sum := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+type eddsaAuthenticator struct {
+	key ed25519.PrivateKey
+}
+
+func newEdDSAAuthenticator(t *testing.T) *eddsaAuthenticator {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &eddsaAuthenticator{key: key}
+}
+
+func (a *eddsaAuthenticator) credentialID() []byte { return []byte("eddsa-credential") }
+
+func (a *eddsaAuthenticator) cose() []byte {
+	return encodeCBOR(cborMapOf(1, 1, 3, algEdDSA, -1, 6, -2, []byte(a.key.Public().(ed25519.PublicKey))))
+}
+
+func (a *eddsaAuthenticator) sign(t *testing.T, data []byte) []byte {
+	return ed25519.Sign(a.key, data)
+}
+
+// cborPairs is a CBOR map whose keys are encoded in order.
+type cborPairs []any
+
+// cborMapOf returns a map of the keys and values that alternate in kv.
+func cborMapOf(kv ...any) cborPairs {
+	return cborPairs(kv)
+}
+
+// encodeCBOR encodes the types decodeCBOR returns, with int for integers
+// and cborPairs for maps.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		case n <= 0xffffffff:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []any:
+		b := head(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case cborPairs:
+		b := head(5, uint64(len(v)/2))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
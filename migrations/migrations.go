@@ -0,0 +1,34 @@
+// Package migrations embeds the SQL migration files into the binary.
+//
+// Each backend has its own directory of NNN_description.up.sql and
+// .down.sql files. Both sets use the same version numbers so that a
+// schema version means the same thing whatever the backend.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+// SQLite returns the migrations for the SQLite backend.
+func SQLite() fs.FS {
+	return sub("sqlite")
+}
+
+// Postgres returns the migrations for the PostgreSQL backend.
+func Postgres() fs.FS {
+	return sub("postgres")
+}
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		// Only possible if the directory is not embedded, which the
+		// go:embed directive already guarantees at compile time.
+		panic(err)
+	}
+	return fsys
+}
@@ -0,0 +1,14 @@
+CREATE TABLE courses (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    title TEXT NOT NULL,
+    description TEXT
+);
+
+CREATE TABLE lessons (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    course_id BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    position INTEGER NOT NULL -- To order lessons within a course
+);
+
+CREATE INDEX lessons_course_id_idx ON lessons (course_id);
@@ -0,0 +1,7 @@
+CREATE TABLE certificates (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    course_id BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,38 @@
+-- Video content table
+CREATE TABLE videos (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    lesson_id BIGINT NOT NULL UNIQUE REFERENCES lessons(id) ON DELETE CASCADE, -- Enforces one video per lesson
+    title TEXT NOT NULL,
+    video_url TEXT NOT NULL
+);
+
+-- Text content table
+CREATE TABLE texts (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    lesson_id BIGINT NOT NULL UNIQUE REFERENCES lessons(id) ON DELETE CASCADE, -- Enforces one text content per lesson
+    title TEXT NOT NULL,
+    content TEXT NOT NULL
+);
+
+-- Multiple Choice Question content table
+CREATE TABLE mcqs (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    lesson_id BIGINT NOT NULL UNIQUE REFERENCES lessons(id) ON DELETE CASCADE, -- Enforces one MCQ per lesson
+    question TEXT NOT NULL,
+    -- The options as a JSON array of strings
+    options JSONB NOT NULL CHECK (jsonb_typeof(options) = 'array'),
+    -- The index of the correct option in the JSON array
+    correct_option_index INTEGER NOT NULL
+);
+
+-- Table to store student submissions/scores for MCQs
+CREATE TABLE mcq_submissions (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mcq_id BIGINT NOT NULL REFERENCES mcqs(id) ON DELETE CASCADE,
+    selected_option_index INTEGER NOT NULL,
+    is_correct BOOLEAN NOT NULL,
+    submitted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- A user can only submit an answer for an MCQ once
+    UNIQUE (user_id, mcq_id)
+);
@@ -0,0 +1,2 @@
+DROP INDEX login_throttles_last_failure_at_idx;
+DROP TABLE login_throttles;
@@ -0,0 +1,9 @@
+-- Failed login attempts per username ("user:<name>") and per client IP ("ip:<addr>")
+CREATE TABLE login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX login_throttles_last_failure_at_idx ON login_throttles (last_failure_at);
@@ -0,0 +1,13 @@
+-- Optional email address, used to send password reset links
+ALTER TABLE users ADD COLUMN email TEXT;
+CREATE UNIQUE INDEX users_email_idx ON users (email);
+
+-- Only a SHA-256 hash of each token is stored
+CREATE TABLE password_resets (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
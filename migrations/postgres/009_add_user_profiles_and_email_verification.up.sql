@@ -0,0 +1,21 @@
+-- Profile fields. The full legal name is printed on certificates.
+ALTER TABLE users ADD COLUMN full_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+-- Certificates keep the name they were issued to. Existing ones were
+-- printed with the username.
+ALTER TABLE certificates ADD COLUMN student_name TEXT NOT NULL DEFAULT '';
+UPDATE certificates SET student_name = users.username FROM users WHERE users.id = certificates.user_id;
+
+-- Links that verify an email address. The address is stored so a link
+-- stops working when the user changes their address.
+CREATE TABLE email_verifications (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
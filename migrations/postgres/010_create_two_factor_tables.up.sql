@@ -0,0 +1,21 @@
+-- Authenticator app secrets. A secret is pending until the user confirms
+-- it with a code; only confirmed secrets are asked for at login.
+-- last_used_step is the period of the last accepted code, so a code can't
+-- be used twice.
+CREATE TABLE user_totp (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    confirmed_at TIMESTAMPTZ,
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+-- Single-use codes for logging in without the authenticator app. Only
+-- their hashes are stored, and they are deleted when used.
+CREATE TABLE recovery_codes (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,14 @@
+-- Passkeys (WebAuthn credentials) for logging in without a password.
+-- public_key is the COSE key the credential signs with; sign_count is the
+-- authenticator's signature counter, used to spot cloned keys.
+CREATE TABLE passkeys (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+CREATE INDEX passkeys_user_id_idx ON passkeys (user_id);
@@ -0,0 +1,14 @@
+-- Accounts at external identity providers (OpenID Connect) linked to users.
+-- issuer and subject identify the account at the provider; login is its
+-- username there, shown to the user.
+CREATE TABLE user_identities (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    login TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (issuer, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
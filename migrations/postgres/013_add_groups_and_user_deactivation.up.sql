@@ -0,0 +1,23 @@
+-- Deactivated users can't log in, but keep their progress and certificates.
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMPTZ;
+
+-- Groups of users, provisioned over SCIM. Members of a group are enrolled
+-- in its courses.
+CREATE TABLE user_groups (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE group_members (
+    group_id BIGINT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX group_members_user_id_idx ON group_members (user_id);
+
+CREATE TABLE group_courses (
+    group_id BIGINT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    course_id BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, course_id)
+);
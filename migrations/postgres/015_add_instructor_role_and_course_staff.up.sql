@@ -0,0 +1,13 @@
+-- Instructors can create courses.
+ALTER TABLE users DROP CONSTRAINT users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('student', 'instructor', 'admin'));
+
+-- The instructors and teaching assistants (ta) of each course.
+CREATE TABLE course_staff (
+    course_id BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('instructor', 'ta')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (course_id, user_id)
+);
+CREATE INDEX course_staff_user_id_idx ON course_staff (user_id);
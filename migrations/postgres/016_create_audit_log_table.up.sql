@@ -0,0 +1,13 @@
+-- What admins have done, such as impersonating users. The usernames are
+-- copied so that entries stay readable after the users are deleted.
+CREATE TABLE audit_log (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    actor_name TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    target_name TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE invitation_courses;
+DROP TABLE invitations;
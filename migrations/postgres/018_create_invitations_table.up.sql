@@ -0,0 +1,22 @@
+-- Invitation links that let someone register with a chosen role, enrolled
+-- in the chosen courses. Only a SHA-256 hash of each token is stored; email
+-- is empty for links handed out by the admin rather than emailed.
+CREATE TABLE invitations (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL DEFAULT '',
+    role TEXT NOT NULL CHECK (role IN ('student', 'instructor', 'admin')),
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX invitations_created_at_idx ON invitations (created_at);
+
+-- The courses invited users are enrolled in.
+CREATE TABLE invitation_courses (
+    invitation_id BIGINT NOT NULL REFERENCES invitations(id) ON DELETE CASCADE,
+    course_id BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    PRIMARY KEY (invitation_id, course_id)
+);
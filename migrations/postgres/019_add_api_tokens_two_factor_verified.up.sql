@@ -0,0 +1,4 @@
+-- Whether the session that made the token had passed two-factor
+-- authentication, which the admin pages may require. Tokens made before
+-- this was recorded are treated as not having passed it.
+ALTER TABLE api_tokens ADD COLUMN two_factor_verified BOOLEAN NOT NULL DEFAULT FALSE;
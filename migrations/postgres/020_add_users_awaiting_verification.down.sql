@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN awaiting_verification;
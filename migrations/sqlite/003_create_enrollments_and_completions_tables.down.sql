@@ -0,0 +1,2 @@
+DROP TABLE lesson_completions;
+DROP TABLE enrollments;
@@ -0,0 +1,4 @@
+DROP TABLE mcq_submissions;
+DROP TABLE mcqs;
+DROP TABLE texts;
+DROP TABLE videos;
@@ -0,0 +1,2 @@
+DROP INDEX sessions_expiry_idx;
+DROP TABLE sessions;
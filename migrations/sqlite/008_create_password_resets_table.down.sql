@@ -0,0 +1,3 @@
+DROP TABLE password_resets;
+DROP INDEX users_email_idx;
+ALTER TABLE users DROP COLUMN email;
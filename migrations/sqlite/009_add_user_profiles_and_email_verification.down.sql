@@ -0,0 +1,6 @@
+DROP TABLE email_verifications;
+ALTER TABLE certificates DROP COLUMN student_name;
+ALTER TABLE users DROP COLUMN email_verified_at;
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN display_name;
+ALTER TABLE users DROP COLUMN full_name;
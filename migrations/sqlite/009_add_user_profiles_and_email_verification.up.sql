@@ -0,0 +1,22 @@
+-- Profile fields. The full legal name is printed on certificates.
+ALTER TABLE users ADD COLUMN full_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Certificates keep the name they were issued to. Existing ones were
+-- printed with the username.
+ALTER TABLE certificates ADD COLUMN student_name TEXT NOT NULL DEFAULT '';
+UPDATE certificates SET student_name = (SELECT username FROM users WHERE users.id = certificates.user_id);
+
+-- Links that verify an email address. The address is stored so a link
+-- stops working when the user changes their address.
+CREATE TABLE email_verifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+DROP TABLE group_courses;
+DROP TABLE group_members;
+DROP TABLE user_groups;
+ALTER TABLE users DROP COLUMN deactivated_at;
@@ -0,0 +1,20 @@
+DROP TABLE course_staff;
+
+UPDATE users SET role = 'student' WHERE role = 'instructor';
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL CHECK(role IN ('student', 'admin')),
+    email TEXT,
+    full_name TEXT NOT NULL DEFAULT '',
+    display_name TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    email_verified_at TIMESTAMP,
+    deactivated_at TIMESTAMP
+);
+INSERT INTO users_new (id, username, password_hash, role, email, full_name, display_name, timezone, email_verified_at, deactivated_at)
+    SELECT id, username, password_hash, role, email, full_name, display_name, timezone, email_verified_at, deactivated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE UNIQUE INDEX users_email_idx ON users(email);
@@ -0,0 +1,32 @@
+-- Instructors can create courses. SQLite can't change a CHECK constraint,
+-- so the users table is rebuilt; migrations run with foreign keys off, so
+-- the tables referencing it are kept as they are.
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL CHECK(role IN ('student', 'instructor', 'admin')),
+    email TEXT,
+    full_name TEXT NOT NULL DEFAULT '',
+    display_name TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    email_verified_at TIMESTAMP,
+    deactivated_at TIMESTAMP
+);
+INSERT INTO users_new (id, username, password_hash, role, email, full_name, display_name, timezone, email_verified_at, deactivated_at)
+    SELECT id, username, password_hash, role, email, full_name, display_name, timezone, email_verified_at, deactivated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE UNIQUE INDEX users_email_idx ON users(email);
+
+-- The instructors and teaching assistants (ta) of each course.
+CREATE TABLE course_staff (
+    course_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL CHECK(role IN ('instructor', 'ta')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (course_id, user_id),
+    FOREIGN KEY (course_id) REFERENCES courses(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX course_staff_user_id_idx ON course_staff(user_id);
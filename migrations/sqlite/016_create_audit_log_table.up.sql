@@ -0,0 +1,15 @@
+-- What admins have done, such as impersonating users. The usernames are
+-- copied so that entries stay readable after the users are deleted.
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id INTEGER,
+    actor_name TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_id INTEGER,
+    target_name TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (target_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX audit_log_created_at_idx ON audit_log(created_at);
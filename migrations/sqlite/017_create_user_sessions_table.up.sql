@@ -0,0 +1,17 @@
+-- The logged-in browser sessions of users, so that they can be listed and
+-- signed out by user without going through the session store. The ID is a
+-- random value kept in the session, not its token. A session whose row is
+-- deleted is logged out on its next request.
+CREATE TABLE user_sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    impersonator_id INTEGER,
+    signed_in_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (impersonator_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX user_sessions_user_id_idx ON user_sessions(user_id);
+CREATE INDEX user_sessions_last_seen_at_idx ON user_sessions(last_seen_at);
@@ -0,0 +1,26 @@
+-- Invitation links that let someone register with a chosen role, enrolled
+-- in the chosen courses. Only a SHA-256 hash of each token is stored; email
+-- is empty for links handed out by the admin rather than emailed.
+CREATE TABLE invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL DEFAULT '',
+    role TEXT NOT NULL CHECK(role IN ('student', 'instructor', 'admin')),
+    created_by INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    user_id INTEGER,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX invitations_created_at_idx ON invitations(created_at);
+
+-- The courses invited users are enrolled in.
+CREATE TABLE invitation_courses (
+    invitation_id INTEGER NOT NULL,
+    course_id INTEGER NOT NULL,
+    PRIMARY KEY (invitation_id, course_id),
+    FOREIGN KEY (invitation_id) REFERENCES invitations(id) ON DELETE CASCADE,
+    FOREIGN KEY (course_id) REFERENCES courses(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+-- Users who registered with an address at an allowed domain may not log in
+-- until they have verified it.
+ALTER TABLE users ADD COLUMN awaiting_verification BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,71 @@
+// Passkey registration and login with the WebAuthn browser API.
+//
+// The server puts the options for navigator.credentials in a
+// <script type="application/json"> element next to the form. Binary values
+// are base64url strings both ways; the response is put in the hidden
+// fields of the form, which is then submitted as usual.
+(function () {
+    function decode(s) {
+        s = s.replace(/-/g, "+").replace(/_/g, "/");
+        s += "=".repeat((4 - s.length % 4) % 4);
+        return Uint8Array.from(atob(s), function (c) { return c.charCodeAt(0); });
+    }
+
+    function encode(buf) {
+        var s = String.fromCharCode.apply(null, new Uint8Array(buf));
+        return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    function showError(form, message) {
+        var el = form.querySelector("[data-passkey-error]");
+        el.textContent = message;
+        el.hidden = false;
+    }
+
+    // Attach handler to the form with the given ID, if the page has it.
+    function setUp(id, handler) {
+        var form = document.getElementById(id);
+        if (!form) {
+            return;
+        }
+        if (!window.PublicKeyCredential) {
+            form.hidden = true;
+            return;
+        }
+        form.addEventListener("submit", function (event) {
+            event.preventDefault();
+            var options = JSON.parse(document.getElementById(id + "-options").textContent);
+            handler(form, options).then(function () {
+                form.submit();
+            }, function (err) {
+                // The user cancelling is reported as NotAllowedError.
+                showError(form, err.name === "NotAllowedError"
+                    ? "The passkey request was cancelled or timed out."
+                    : "Your browser could not use a passkey: " + err.message);
+            });
+        });
+    }
+
+    setUp("passkey-register", function (form, options) {
+        options.challenge = decode(options.challenge);
+        options.user.id = decode(options.user.id);
+        options.excludeCredentials.forEach(function (c) { c.id = decode(c.id); });
+        return navigator.credentials.create({ publicKey: options }).then(function (cred) {
+            form.elements.client_data.value = encode(cred.response.clientDataJSON);
+            form.elements.attestation_object.value = encode(cred.response.attestationObject);
+        });
+    });
+
+    setUp("passkey-login", function (form, options) {
+        options.challenge = decode(options.challenge);
+        return navigator.credentials.get({ publicKey: options }).then(function (cred) {
+            form.elements.credential_id.value = encode(cred.rawId);
+            form.elements.client_data.value = encode(cred.response.clientDataJSON);
+            form.elements.authenticator_data.value = encode(cred.response.authenticatorData);
+            form.elements.signature.value = encode(cred.response.signature);
+            if (cred.response.userHandle) {
+                form.elements.user_handle.value = encode(cred.response.userHandle);
+            }
+        });
+    });
+})();
@@ -0,0 +1,32 @@
+// Package web embeds the HTML templates and static assets into the binary.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The all: prefix is needed to include the _-prefixed component templates.
+//
+//go:embed all:templates static
+var files embed.FS
+
+// Templates returns the embedded templates directory.
+func Templates() fs.FS {
+	return sub("templates")
+}
+
+// Static returns the embedded static assets directory.
+func Static() fs.FS {
+	return sub("static")
+}
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		// Only possible if the directory is not embedded, which the
+		// go:embed directive already guarantees at compile time.
+		panic(err)
+	}
+	return fsys
+}